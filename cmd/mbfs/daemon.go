@@ -39,6 +39,7 @@ const (
 	ipfsMountKwd              = "mount-ipfs"
 	ipnsMountKwd              = "mount-ipns"
 	migrateKwd                = "migrate"
+	migrateExternalKwd        = "migrate-external"
 	mountKwd                  = "mount"
 	offlineKwd                = "offline"
 	routingOptionKwd          = "routing"
@@ -163,6 +164,7 @@ Headers.
 		cmdkit.BoolOption(adjustFDLimitKwd, "Check and raise file descriptor limits if needed").WithDefault(true),
 		cmdkit.BoolOption(offlineKwd, "Run offline. Do not connect to the rest of the network but provide local API."),
 		cmdkit.BoolOption(migrateKwd, "If true, assume yes at the migrate prompt. If false, assume no."),
+		cmdkit.BoolOption(migrateExternalKwd, "Run the fs-repo-migrations binary, downloading it if needed, for the migrations that are not built in."),
 		cmdkit.BoolOption(enablePubSubKwd, "Instantiate the ipfs daemon with the experimental pubsub feature enabled."),
 		cmdkit.BoolOption(enableIPNSPubSubKwd, "Enable IPNS record distribution through pubsub; enables pubsub."),
 		cmdkit.BoolOption(enableMultiplexKwd, "Add the experimental 'go-multiplex' stream muxer to libp2p on construction.").WithDefault(true),
@@ -256,16 +258,19 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 
 			if !domigrate {
 				fmt.Println("Not running migrations of fs-repo now.")
-				fmt.Println("Please get fs-repo-migrations from https://dist.ipfs.io")
+				fmt.Println("Run 'ipfs repo migrate' to migrate it.")
 				return fmt.Errorf("fs-repo requires migration")
 			}
 
-			err = migrate.RunMigration(fsrepo.RepoVersion)
+			external, _ := req.Options[migrateExternalKwd].(bool)
+			err = fsrepo.Migrate(cctx.ConfigRoot, migrate.Options{
+				External: external,
+				Out:      os.Stdout,
+			})
 			if err != nil {
 				fmt.Println("The migrations of fs-repo failed:")
 				fmt.Printf("  %s\n", err)
 				fmt.Println("If you think this is a bug, please file an issue and include this whole log output.")
-				fmt.Println("  https://github.com/ipfs/fs-repo-migrations")
 				return err
 			}
 
@@ -336,6 +341,9 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	if node.PNetFingerprint != nil {
 		fmt.Println("Swarm is limited to private network of peers with the swarm key")
 		fmt.Printf("Swarm key fingerprint: %x\n", node.PNetFingerprint)
+		if fp := node.PNet.Status().Secondary; fp != "" {
+			fmt.Printf("Also accepting the swarm key with fingerprint: %s\n", fp)
+		}
 	}
 
 	printSwarmAddrs(node)
@@ -569,6 +577,10 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 		corehttp.CommandsROOption(*cctx),
 	}
 
+	if cfg.Experimental.P2pHttpProxy {
+		opts = append(opts, corehttp.P2PProxyOption())
+	}
+
 	if len(cfg.Gateway.RootRedirect) > 0 {
 		opts = append(opts, corehttp.RedirectOption("", cfg.Gateway.RootRedirect))
 	}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// allowList is the set of peer IDs permitted on the other end of the
+// connection. A nil allowList permits everyone.
+type allowList map[peer.ID]struct{}
+
+// parseAllowList parses the -allow flag. The value is either a comma
+// separated list of peer IDs or '@' followed by the path of a file holding
+// one peer ID per line. Blank lines and lines starting with '#' are ignored.
+func parseAllowList(v string) (allowList, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	var ids []string
+	if strings.HasPrefix(v, "@") {
+		f, err := os.Open(v[1:])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scan := bufio.NewScanner(f)
+		for scan.Scan() {
+			line := strings.TrimSpace(scan.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			ids = append(ids, line)
+		}
+		if err := scan.Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				ids = append(ids, s)
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("allow list %q is empty", v)
+	}
+
+	al := make(allowList, len(ids))
+	for _, s := range ids {
+		id, err := peer.IDB58Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer id in allow list %q: %s", s, err)
+		}
+		al[id] = struct{}{}
+	}
+	return al, nil
+}
+
+// check returns an error if p is not permitted.
+func (al allowList) check(p peer.ID) error {
+	if al == nil {
+		return nil
+	}
+	if _, ok := al[p]; !ok {
+		return fmt.Errorf("remote peer %s is not in the allow list", p.Pretty())
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	smux "mbfs/go-mbfs/gx/QmY9JXR3FupnYAYJWK9aMr9bCpqWKcToQ1tz8DVGTrHpHw/go-stream-muxer"
+	mplex "mbfs/go-mbfs/gx/QmZsejKNkeFSQe5TcmYXJ8iq6qPL1FpsP4eAA8j7RfE7xg/go-smux-multiplex"
+	yamux "mbfs/go-mbfs/gx/Qmdps3CYh5htGQSrPvzg5PHouVexLmtpbuLCqc4vuej8PC/go-smux-yamux"
+)
+
+// stdioAddr binds a channel to stdin/stdout instead of a TCP address.
+const stdioAddr = "-"
+
+// maxChannelName bounds the channel header read from a new stream.
+const maxChannelName = 256
+
+// channel is a named stream type carried over the multiplexed connection.
+type channel struct {
+	name string
+	addr string
+}
+
+// channelFlags collects repeated -chan name=addr flags.
+type channelFlags []channel
+
+func (c *channelFlags) String() string {
+	var s []string
+	for _, ch := range *c {
+		s = append(s, ch.name+"="+ch.addr)
+	}
+	return strings.Join(s, ",")
+}
+
+func (c *channelFlags) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("channel must be name=addr, got %q", v)
+	}
+	name := parts[0]
+	if len(name) > maxChannelName || strings.ContainsAny(name, "\n") {
+		return fmt.Errorf("invalid channel name %q", name)
+	}
+	for _, ch := range *c {
+		if ch.name == name {
+			return fmt.Errorf("channel %q given twice", name)
+		}
+		if ch.addr == stdioAddr && parts[1] == stdioAddr {
+			return errors.New("only one channel can be bound to stdio")
+		}
+	}
+	*c = append(*c, channel{name: name, addr: parts[1]})
+	return nil
+}
+
+func muxTransport(name string) (smux.Transport, error) {
+	switch name {
+	case "yamux":
+		return yamux.DefaultTransport, nil
+	case "mplex":
+		return mplex.DefaultTransport, nil
+	default:
+		return nil, fmt.Errorf("unknown stream multiplexer %q (want yamux or mplex)", name)
+	}
+}
+
+// muxcat runs the multiplexed mode over c. The dialing side opens a stream
+// for every local connection (or for stdio) and announces the channel name;
+// the listening side accepts those streams and connects each to the address
+// configured for its channel. With no channels configured, a single "stdio"
+// channel is used on both ends.
+func muxcat(t smux.Transport, c net.Conn, dialer bool, chans channelFlags) error {
+	if len(chans) == 0 {
+		chans = channelFlags{{name: "stdio", addr: stdioAddr}}
+	}
+
+	mc, err := t.NewConn(c, !dialer)
+	if err != nil {
+		return err
+	}
+	defer mc.Close()
+
+	done := make(chan error, 2)
+	if dialer {
+		for _, ch := range chans {
+			if ch.addr == stdioAddr {
+				name := ch.name
+				s, err := openChannel(mc, name)
+				if err != nil {
+					return err
+				}
+				go func() {
+					pipeStdio(name, s)
+					done <- nil
+				}()
+				continue
+			}
+
+			l, err := net.Listen("tcp", ch.addr)
+			if err != nil {
+				return err
+			}
+			defer l.Close()
+			out("channel %s: listening at %s", ch.name, l.Addr())
+			go forwardChannel(mc, ch.name, l)
+		}
+
+		// the listening side never opens streams, so AcceptStream only
+		// returns once the connection is gone.
+		go func() {
+			for {
+				s, err := mc.AcceptStream()
+				if err != nil {
+					done <- nil
+					return
+				}
+				s.Reset()
+			}
+		}()
+	} else {
+		go func() {
+			done <- serveChannels(mc, chans)
+		}()
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP, syscall.SIGINT,
+		syscall.SIGTERM, syscall.SIGQUIT)
+
+	select {
+	case err = <-done:
+	case <-sigc:
+	}
+	return err
+}
+
+// openChannel opens a stream on mc and writes the channel header.
+func openChannel(mc smux.Conn, name string) (smux.Stream, error) {
+	s, err := mc.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(s, name+"\n"); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	return s, nil
+}
+
+// forwardChannel accepts connections on l and carries each over a new
+// stream of the named channel.
+func forwardChannel(mc smux.Conn, name string, l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		out("channel %s: accepted connection from %s", name, c.RemoteAddr())
+
+		s, err := openChannel(mc, name)
+		if err != nil {
+			out("channel %s: %s", name, err)
+			c.Close()
+			if mc.IsClosed() {
+				return
+			}
+			continue
+		}
+		go join(c, s)
+	}
+}
+
+// serveChannels accepts streams from the dialing side until the connection
+// is closed.
+func serveChannels(mc smux.Conn, chans channelFlags) error {
+	byName := make(map[string]string, len(chans))
+	for _, ch := range chans {
+		byName[ch.name] = ch.addr
+	}
+
+	for {
+		s, err := mc.AcceptStream()
+		if err != nil {
+			if mc.IsClosed() {
+				return nil
+			}
+			return err
+		}
+		go serveStream(s, byName)
+	}
+}
+
+func serveStream(s smux.Stream, byName map[string]string) {
+	r := bufio.NewReader(io.LimitReader(s, maxChannelName+1))
+	name, err := r.ReadString('\n')
+	if err != nil {
+		out("dropping stream: bad channel header")
+		s.Reset()
+		return
+	}
+	name = strings.TrimSuffix(name, "\n")
+
+	addr, ok := byName[name]
+	if !ok {
+		out("dropping stream for unknown channel %q", name)
+		s.Reset()
+		return
+	}
+
+	// the header fits within the limit, so anything r has buffered past it
+	// is payload that must be forwarded first.
+	rs := &bufferedStream{Stream: s, r: io.MultiReader(r, s)}
+
+	if addr == stdioAddr {
+		pipeStdio(name, rs)
+		return
+	}
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		out("channel %s: %s", name, err)
+		s.Reset()
+		return
+	}
+	out("channel %s: connected to %s", name, c.RemoteAddr())
+	join(c, rs)
+}
+
+// bufferedStream reads from r, which holds data buffered from the stream
+// while parsing the header, before continuing on the stream itself.
+type bufferedStream struct {
+	smux.Stream
+	r io.Reader
+}
+
+func (b *bufferedStream) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
+
+// join copies data both ways between a and b and closes both once either
+// direction finishes.
+func join(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	cp := func(dst io.Writer, src io.Reader) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	a.Close()
+	b.Close()
+}
+
+func pipeStdio(name string, s io.ReadWriteCloser) {
+	out("channel %s: piping stdio", name)
+	done := make(chan struct{}, 2)
+	go func() {
+		n, _ := io.Copy(s, os.Stdin)
+		out("channel %s: sent %d bytes", name, n)
+		done <- struct{}{}
+	}()
+	go func() {
+		n, _ := io.Copy(os.Stdout, s)
+		out("channel %s: received %d bytes", name, n)
+		done <- struct{}{}
+	}()
+	<-done
+	s.Close()
+}
@@ -6,6 +6,11 @@
 //    seccat -l <local address>
 //
 // Address format is: [host]:port
+//
+// Besides piping stdio, seccat can restrict the remote end to a set of
+// peer IDs (-allow), multiplex several named channels over the secure
+// connection (-mux, -chan) and transfer a single file with resumption
+// (-send, -recv).
 package main
 
 import (
@@ -14,6 +19,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/signal"
@@ -22,6 +28,7 @@ import (
 	ci "mbfs/go-mbfs/gx/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
 	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
 	pstoremem "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore/pstoremem"
+	smux "mbfs/go-mbfs/gx/QmY9JXR3FupnYAYJWK9aMr9bCpqWKcToQ1tz8DVGTrHpHw/go-stream-muxer"
 	secio "mbfs/go-mbfs/gx/QmcDSLssuPYMwC71biMiCvWMGg6rBzk8TKf4UJN64M68YK/go-libp2p-secio"
 	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
 	logging "mbfs/go-mbfs/gx/QmcuXC5cxs79ro2cUuHs4HQ2bkDLJUYokwL8aivcX6HW3C/go-log"
@@ -36,10 +43,22 @@ var Usage = func() {
 
 Usage:
 
-  listen: %s [<local address>] <remote address>
-  dial:   %s -l <local address>
+  dial:   %s [<local address>] <remote address>
+  listen: %s -l <local address>
 
 Address format is Go's: [host]:port
+
+Use -key to keep a stable peer ID across runs, and -allow on both ends
+to only accept each other's peer IDs.
+
+Multiplexed mode (-mux yamux|mplex) carries one stream per named channel.
+Each -chan name=addr on the dialing side accepts local connections on addr;
+on the listening side it connects incoming streams for that channel to addr.
+An addr of '-' binds the channel to stdin/stdout.
+
+File transfer: one side runs with -send <file>, the other with
+-recv <file or directory>. Interrupted transfers resume from where they
+stopped, and the result is verified with a sha256 checksum.
 `
 
 	fmt.Fprintf(os.Stderr, text, os.Args[0], os.Args[0])
@@ -52,8 +71,13 @@ type args struct {
 	debug      bool
 	localAddr  string
 	remoteAddr string
-	// keyfile    string
-	keybits int
+	keyfile    string
+	keybits    int
+	allow      string
+	mux        string
+	chans      channelFlags
+	send       string
+	recv       string
 }
 
 func parseArgs() args {
@@ -64,8 +88,13 @@ func parseArgs() args {
 	flag.BoolVar(&a.listen, "l", false, "listen for connections (short)")
 	flag.BoolVar(&a.verbose, "v", true, "verbose")
 	flag.BoolVar(&a.debug, "debug", false, "debugging")
-	// flag.StringVar(&a.keyfile, "key", "", "private key file")
+	flag.StringVar(&a.keyfile, "key", "", "private key file, created if it does not exist")
 	flag.IntVar(&a.keybits, "keybits", 2048, "num bits for generating private key")
+	flag.StringVar(&a.allow, "allow", "", "comma separated peer IDs allowed as remote, or @file with one per line")
+	flag.StringVar(&a.mux, "mux", "", "multiplex channels over the connection (yamux or mplex)")
+	flag.Var(&a.chans, "chan", "named channel as name=addr (repeatable, requires -mux)")
+	flag.StringVar(&a.send, "send", "", "send a file to the remote end")
+	flag.StringVar(&a.recv, "recv", "", "receive a file from the remote end into this path")
 	flag.Usage = Usage
 	flag.Parse()
 	osArgs := flag.Args()
@@ -78,6 +107,16 @@ func parseArgs() args {
 		out("verbose on")
 	}
 
+	if a.send != "" && a.recv != "" {
+		exit("-send and -recv are mutually exclusive")
+	}
+	if a.mux != "" && (a.send != "" || a.recv != "") {
+		exit("file transfer cannot be combined with -mux")
+	}
+	if len(a.chans) > 0 && a.mux == "" {
+		exit("-chan requires -mux")
+	}
+
 	if a.listen {
 		a.localAddr = osArgs[0]
 	} else {
@@ -117,11 +156,11 @@ func setupPeer(a args) (peer.ID, pstore.Peerstore, error) {
 		return "", nil, errors.New("bitsize less than 1024 is considered unsafe")
 	}
 
-	out("generating key pair...")
-	sk, pk, err := ci.GenerateKeyPair(ci.RSA, a.keybits)
+	sk, err := loadOrGenerateKey(a.keyfile, a.keybits)
 	if err != nil {
 		return "", nil, err
 	}
+	pk := sk.GetPublic()
 
 	p, err := peer.IDFromPublicKey(pk)
 	if err != nil {
@@ -132,7 +171,7 @@ func setupPeer(a args) (peer.ID, pstore.Peerstore, error) {
 	ps.AddPrivKey(p, sk)
 	ps.AddPubKey(p, pk)
 
-	out("local peer id: %s", p)
+	out("local peer id: %s", p.Pretty())
 	return p, ps, nil
 }
 
@@ -142,6 +181,19 @@ func connect(args args) error {
 		return err
 	}
 
+	allowed, err := parseAllowList(args.allow)
+	if err != nil {
+		return err
+	}
+
+	var muxer smux.Transport
+	if args.mux != "" {
+		muxer, err = muxTransport(args.mux)
+		if err != nil {
+			return err
+		}
+	}
+
 	var conn net.Conn
 	if args.listen {
 		conn, err = Listen(args.localAddr)
@@ -165,11 +217,62 @@ func connect(args args) error {
 	if err != nil {
 		return err
 	}
-	out("remote peer id: %s", sconn.RemotePeer())
+	out("remote peer id: %s", sconn.RemotePeer().Pretty())
+
+	if err := allowed.check(sconn.RemotePeer()); err != nil {
+		sconn.Close()
+		return err
+	}
+
+	switch {
+	case args.send != "":
+		defer sconn.Close()
+		return sendFile(sconn, args.send)
+	case args.recv != "":
+		defer sconn.Close()
+		return recvFile(sconn, args.recv)
+	case muxer != nil:
+		return muxcat(muxer, sconn, !args.listen, args.chans)
+	}
+
 	netcat(sconn)
 	return nil
 }
 
+// loadOrGenerateKey reads a private key from keyfile. If keyfile is empty a
+// fresh key is generated; if it does not exist yet, the generated key is
+// written there so the peer ID stays the same on later runs.
+func loadOrGenerateKey(keyfile string, bits int) (ci.PrivKey, error) {
+	if keyfile != "" {
+		data, err := ioutil.ReadFile(keyfile)
+		switch {
+		case err == nil:
+			out("using key from %s", keyfile)
+			return ci.UnmarshalPrivateKey(data)
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+	}
+
+	out("generating key pair...")
+	sk, _, err := ci.GenerateKeyPair(ci.RSA, bits)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyfile != "" {
+		data, err := ci.MarshalPrivateKey(sk)
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(keyfile, data, 0600); err != nil {
+			return nil, err
+		}
+		out("saved key to %s", keyfile)
+	}
+	return sk, nil
+}
+
 // Listen listens and accepts one incoming UDT connection on a given port,
 // and pipes all incoming data to os.Stdout.
 func Listen(localAddr string) (net.Conn, error) {
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The transfer protocol is a short exchange of JSON lines around the raw
+// file data:
+//
+//   sender   -> fileHeader
+//   receiver -> resumeOffset
+//   sender   -> file bytes from the offset to the end
+//   receiver -> transferResult
+//
+// The receiver keeps incomplete data in a partial file named after the
+// checksum, so a later run for the same content continues where the
+// previous one stopped.
+
+type fileHeader struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Sum  string `json:"sha256"`
+}
+
+type resumeOffset struct {
+	Offset int64 `json:"offset"`
+}
+
+type transferResult struct {
+	Error string `json:"error,omitempty"`
+}
+
+// maxProtoLine bounds the size of a protocol line.
+const maxProtoLine = 4096
+
+func writeLine(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func readLine(r *bufio.Reader, v interface{}) error {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxProtoLine {
+			return errors.New("protocol line too long")
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	return json.Unmarshal(line, v)
+}
+
+func fileSum(f *os.File, n int64) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sendFile sends the file at path to a receiver on rw.
+func sendFile(rw io.ReadWriter, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+
+	out("hashing %s...", path)
+	sum, err := fileSum(f, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	hdr := fileHeader{Name: filepath.Base(path), Size: fi.Size(), Sum: sum}
+	if err := writeLine(rw, hdr); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(rw)
+	var off resumeOffset
+	if err := readLine(r, &off); err != nil {
+		return err
+	}
+	if off.Offset < 0 || off.Offset > hdr.Size {
+		return fmt.Errorf("receiver asked for invalid offset %d", off.Offset)
+	}
+	if off.Offset > 0 {
+		out("resuming at offset %d", off.Offset)
+	}
+
+	if _, err := f.Seek(off.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.CopyN(rw, f, hdr.Size-off.Offset)
+	if err != nil {
+		return err
+	}
+	out("sent %d bytes", n)
+
+	var res transferResult
+	if err := readLine(r, &res); err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return fmt.Errorf("receiver: %s", res.Error)
+	}
+	out("transfer of %s verified (sha256 %s)", hdr.Name, hdr.Sum)
+	return nil
+}
+
+// recvFile receives a file from a sender on rw. If dest is an existing
+// directory, the file is stored there under the name given by the sender.
+func recvFile(rw io.ReadWriter, dest string) error {
+	r := bufio.NewReader(rw)
+	var hdr fileHeader
+	if err := readLine(r, &hdr); err != nil {
+		return err
+	}
+	if hdr.Size < 0 || len(hdr.Sum) != sha256.Size*2 {
+		return errors.New("invalid file header")
+	}
+	if _, err := hex.DecodeString(hdr.Sum); err != nil {
+		return errors.New("invalid file header")
+	}
+
+	if fi, err := os.Stat(dest); err == nil && fi.IsDir() {
+		name := filepath.Base(hdr.Name)
+		if name == "." || name == ".." || name == string(filepath.Separator) {
+			return fmt.Errorf("invalid file name %q", hdr.Name)
+		}
+		dest = filepath.Join(dest, name)
+	}
+
+	err := recvInto(r, rw, hdr, dest)
+	res := transferResult{}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if werr := writeLine(rw, res); werr != nil && err == nil {
+		err = werr
+	}
+	return err
+}
+
+func recvInto(r io.Reader, w io.Writer, hdr fileHeader, dest string) error {
+	part := partialPath(dest, hdr.Sum)
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := fi.Size()
+	if offset > hdr.Size {
+		offset = 0
+	}
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	if offset > 0 {
+		out("resuming %s at offset %d", hdr.Name, offset)
+	}
+
+	if err := writeLine(w, resumeOffset{Offset: offset}); err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.CopyN(f, r, hdr.Size-offset)
+	out("received %d bytes", n)
+	if err != nil {
+		// keep what we have for the next attempt.
+		return err
+	}
+
+	sum, err := fileSum(f, hdr.Size)
+	if err != nil {
+		return err
+	}
+	if sum != hdr.Sum {
+		os.Remove(part)
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", hdr.Sum, sum)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(part, dest); err != nil {
+		return err
+	}
+	out("received %s (sha256 %s)", dest, sum)
+	return nil
+}
+
+// partialPath is where incomplete data for dest is kept. Including part of
+// the checksum avoids resuming from data that belongs to another file.
+func partialPath(dest, sum string) string {
+	return dest + "." + sum[:16] + ".part"
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func transfer(t *testing.T, src, dest string) (sendErr, recvErr error) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sendFile(a, src)
+	}()
+	recvErr = recvFile(b, dest)
+	return <-errc, recvErr
+}
+
+func TestTransferResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seccat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 300*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(outDir, "src")
+
+	// simulate an earlier, interrupted run.
+	f, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileSum(f, int64(len(data)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(partialPath(dest, sum), data[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serr, rerr := transfer(t, src, outDir)
+	if serr != nil || rerr != nil {
+		t.Fatal(serr, rerr)
+	}
+
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("received data does not match")
+	}
+	if _, err := os.Stat(partialPath(dest, sum)); !os.IsNotExist(err) {
+		t.Fatal("partial file should be gone after a complete transfer")
+	}
+}
+
+func TestTransferCorruptPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seccat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "dest")
+
+	f, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := fileSum(f, int64(len(data)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(partialPath(dest, sum), []byte("THE QUICK"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serr, rerr := transfer(t, src, dest)
+	if rerr == nil || serr == nil {
+		t.Fatal("expected checksum failure on both ends")
+	}
+
+	// the bad partial data is discarded, so the next attempt succeeds.
+	serr, rerr = transfer(t, src, dest)
+	if serr != nil || rerr != nil {
+		t.Fatal(serr, rerr)
+	}
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("received data does not match")
+	}
+}
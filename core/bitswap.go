@@ -0,0 +1,88 @@
+package core
+
+import (
+	"fmt"
+
+	decision "mbfs/go-mbfs/gx/QmXRphxBT4BH2GqGHUSbqULm7wNsxnpA2NrbNaY3DU1Y5K/go-bitswap/decision"
+
+	humanize "mbfs/go-mbfs/gx/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// BitswapLimits converts the Bitswap section of the config to the rate limits
+// and peer classes of the bitswap decision engine.
+func BitswapLimits(cfg config.Bitswap) (decision.Limits, error) {
+	var l decision.Limits
+	var err error
+	if l.SendRate, err = parseRate("Bitswap.SendRate", cfg.SendRate); err != nil {
+		return l, err
+	}
+	if l.RecvRate, err = parseRate("Bitswap.RecvRate", cfg.RecvRate); err != nil {
+		return l, err
+	}
+	if l.Default.SendRate, err = parseRate("Bitswap.PeerSendRate", cfg.PeerSendRate); err != nil {
+		return l, err
+	}
+	if l.Default.RecvRate, err = parseRate("Bitswap.PeerRecvRate", cfg.PeerRecvRate); err != nil {
+		return l, err
+	}
+
+	l.Peers = make(map[peer.ID]*decision.PeerClass)
+	for i, cc := range cfg.Classes {
+		name := cc.Name
+		if name == "" {
+			name = fmt.Sprintf("class %d", i)
+		}
+		c := &decision.PeerClass{Name: name, Priority: cc.Priority}
+		if c.SendRate, err = parseRate(name+" SendRate", cc.SendRate); err != nil {
+			return l, err
+		}
+		if c.RecvRate, err = parseRate(name+" RecvRate", cc.RecvRate); err != nil {
+			return l, err
+		}
+		for _, s := range cc.Peers {
+			id, err := peer.IDB58Decode(s)
+			if err != nil {
+				return l, fmt.Errorf("invalid peer %q in bitswap class %s: %s", s, name, err)
+			}
+			if other, ok := l.Peers[id]; ok {
+				return l, fmt.Errorf("peer %s is in both bitswap classes %s and %s", s, other.Name, name)
+			}
+			l.Peers[id] = c
+		}
+	}
+	return l, nil
+}
+
+// BitswapStrategy returns the strategy set in the Bitswap section of the
+// config.
+func BitswapStrategy(cfg config.Bitswap) (decision.Strategy, error) {
+	switch cfg.Strategy {
+	case "", "nice":
+		return decision.Nice, nil
+	case "debt-ratio":
+		s := &decision.DebtRatio{Grace: decision.DefaultDebtRatioGrace}
+		if cfg.DebtRatioGrace != "" {
+			grace, err := humanize.ParseBytes(cfg.DebtRatioGrace)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Bitswap.DebtRatioGrace %q: %s", cfg.DebtRatioGrace, err)
+			}
+			s.Grace = grace
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown bitswap strategy %q", cfg.Strategy)
+	}
+}
+
+func parseRate(name, s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %s", name, s, err)
+	}
+	return int64(n), nil
+}
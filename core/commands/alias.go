@@ -0,0 +1,290 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	cmdenv "mbfs/go-mbfs/core/commands/cmdenv"
+	namesys "mbfs/go-mbfs/namesys"
+
+	cmds "mbfs/go-mbfs/gx/Qma6uuSyjkecGhMFFLfzyJDPyoDtNJSHJNweDccZhaWkgU/go-ipfs-cmds"
+	cmdkit "mbfs/go-mbfs/gx/Qmde5VP1qUkyQXKCfmEUA7bP64V2HAptbJ7phuPp7jXWwg/go-ipfs-cmdkit"
+)
+
+const (
+	aliasForceOptionName = "force"
+)
+
+// AliasTable maps alias names, without their @, to their targets. It is the
+// format of 'alias export' and 'alias import'.
+type AliasTable struct {
+	Aliases map[string]string
+}
+
+type AliasOutput struct {
+	Name   string
+	Target string
+}
+
+type AliasImportOutput struct {
+	Added    []string
+	Replaced []string
+	Skipped  []string
+}
+
+var AliasCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage local name aliases.",
+		ShortDescription: `
+Aliases are friendly local names for CIDs, IPNS names or other aliases, kept
+in the repo. They resolve as /ipns/@<name> paths wherever IPNS paths do, e.g.
+in 'ipfs cat', 'ipfs get', 'ipfs resolve' and the gateway.
+`,
+		LongDescription: `
+Aliases are friendly local names for CIDs, IPNS names or other aliases, kept
+in the repo. They resolve as /ipns/@<name> paths wherever IPNS paths do, e.g.
+in 'ipfs cat', 'ipfs get', 'ipfs resolve' and the gateway.
+
+Examples:
+
+  > ipfs alias set team /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+  > ipfs alias set latest @team
+  > ipfs cat /ipns/@latest/dataset/README
+  > ipfs alias export > aliases.json
+  > ipfs alias import aliases.json
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"set":    aliasSetCmd,
+		"rm":     aliasRmCmd,
+		"ls":     aliasLsCmd,
+		"export": aliasExportCmd,
+		"import": aliasImportCmd,
+	},
+}
+
+var aliasSetCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Point an alias to a path.",
+		ShortDescription: `
+The target can be an /ipfs/ or /ipns/ path, a CID, or another alias as @name.
+An existing alias is replaced.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", true, false, "The name of the alias."),
+		cmdkit.StringArg("target", true, false, "The path, CID or @alias it points to."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		name, err := namesys.AliasName(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		target, err := namesys.AliasTarget(req.Arguments[1])
+		if err != nil {
+			return err
+		}
+		if err := namesys.NewAliasStore(n.Repo.Datastore()).Set(name, target); err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &AliasOutput{Name: name, Target: target.String()})
+	},
+	Type: AliasOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *AliasOutput) error {
+			_, err := fmt.Fprintf(w, "@%s -> %s\n", out.Name, out.Target)
+			return err
+		}),
+	},
+}
+
+var aliasRmCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Remove aliases.",
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", true, true, "The names of the aliases to remove."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		store := namesys.NewAliasStore(n.Repo.Datastore())
+		removed := make([]string, 0, len(req.Arguments))
+		for _, name := range req.Arguments {
+			if err := store.Remove(name); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			removed = append(removed, name)
+		}
+		return cmds.EmitOnce(res, &stringList{removed})
+	},
+	Type: stringList{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(stringListEncoder),
+	},
+}
+
+var aliasLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the aliases and their targets.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		table, err := aliasTable(env)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, table)
+	},
+	Type: AliasTable{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, table *AliasTable) error {
+			names := make([]string, 0, len(table.Aliases))
+			for name := range table.Aliases {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+			for _, name := range names {
+				fmt.Fprintf(tw, "@%s\t%s\n", name, table.Aliases[name])
+			}
+			return tw.Flush()
+		}),
+	},
+}
+
+var aliasExportCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Write the alias table as JSON, for 'ipfs alias import'.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		table, err := aliasTable(env)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, table)
+	},
+	Type: AliasTable{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, table *AliasTable) error {
+			data, err := json.MarshalIndent(table, "", "  ")
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(w, string(data))
+			return err
+		}),
+	},
+}
+
+var aliasImportCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Add the aliases of a table written by 'ipfs alias export'.",
+		ShortDescription: `
+The aliases of the table are added to the existing ones. An existing alias
+with another target is kept unless --force is given.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.FileArg("file", true, false, "The alias table.").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption(aliasForceOptionName, "f", "Replace the existing aliases with another target."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		force, _ := req.Options[aliasForceOptionName].(bool)
+
+		file, err := req.Files.NextFile()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		var table AliasTable
+		if err := json.NewDecoder(file).Decode(&table); err != nil {
+			return fmt.Errorf("invalid alias table: %s", err)
+		}
+
+		names := make([]string, 0, len(table.Aliases))
+		for name := range table.Aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		store := namesys.NewAliasStore(n.Repo.Datastore())
+		out := &AliasImportOutput{}
+		for _, name := range names {
+			target, err := namesys.AliasTarget(table.Aliases[name])
+			if err != nil {
+				return fmt.Errorf("alias %s: %s", name, err)
+			}
+
+			old, err := store.Get(name)
+			switch {
+			case err == namesys.ErrNoAlias:
+				out.Added = append(out.Added, name)
+			case err != nil:
+				return fmt.Errorf("alias %s: %s", name, err)
+			case old == target:
+				continue
+			case !force:
+				out.Skipped = append(out.Skipped, name)
+				continue
+			default:
+				out.Replaced = append(out.Replaced, name)
+			}
+
+			if err := store.Set(name, target); err != nil {
+				return fmt.Errorf("alias %s: %s", name, err)
+			}
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Type: AliasImportOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *AliasImportOutput) error {
+			for _, name := range out.Added {
+				fmt.Fprintf(w, "added @%s\n", name)
+			}
+			for _, name := range out.Replaced {
+				fmt.Fprintf(w, "replaced @%s\n", name)
+			}
+			for _, name := range out.Skipped {
+				fmt.Fprintf(w, "skipped @%s: it has another target, use --force to replace it\n", name)
+			}
+			return nil
+		}),
+	},
+}
+
+func aliasTable(env cmds.Environment) (*AliasTable, error) {
+	n, err := cmdenv.GetNode(env)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := namesys.NewAliasStore(n.Repo.Datastore()).List()
+	if err != nil {
+		return nil, err
+	}
+	table := &AliasTable{Aliases: make(map[string]string, len(aliases))}
+	for name, target := range aliases {
+		table.Aliases[name] = target.String()
+	}
+	return table, nil
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"fmt"
 	"io"
+	"text/tabwriter"
 
 	cmdenv "mbfs/go-mbfs/core/commands/cmdenv"
 	e "mbfs/go-mbfs/core/commands/e"
@@ -27,6 +28,7 @@ var BitswapCmd = &cmds.Command{
 		"wantlist":  showWantlistCmd,
 		"ledger":    ledgerCmd,
 		"reprovide": reprovideCmd,
+		"strategy":  bitswapStrategyCmd,
 	},
 }
 
@@ -143,7 +145,8 @@ var ledgerCmd = &cmds.Command{
 		ShortDescription: `
 The Bitswap decision engine tracks the number of bytes exchanged between IPFS
 nodes, and stores this information as a collection of ledgers. This command
-prints the ledger associated with a given peer.
+prints the ledger associated with a given peer, along with the class of the
+peer and its rate limits as set in the Bitswap section of the config.
 `,
 	},
 	Arguments: []cmdkit.Argument{
@@ -178,14 +181,87 @@ prints the ledger associated with a given peer.
 				"Debt ratio:\t%f\n"+
 				"Exchanges:\t%d\n"+
 				"Bytes sent:\t%d\n"+
-				"Bytes received:\t%d\n\n",
+				"Bytes received:\t%d\n",
 				out.Peer, out.Value, out.Exchanged,
 				out.Sent, out.Recv)
+			if out.Class != "" {
+				fmt.Fprintf(w, "Class:\t%s\n", out.Class)
+			}
+			if out.Priority != 0 {
+				fmt.Fprintf(w, "Priority:\t%d\n", out.Priority)
+			}
+			if out.SendRate > 0 {
+				fmt.Fprintf(w, "Send rate:\t%s/s\n", humanize.Bytes(uint64(out.SendRate)))
+			}
+			if out.RecvRate > 0 {
+				fmt.Fprintf(w, "Receive rate:\t%s/s\n", humanize.Bytes(uint64(out.RecvRate)))
+			}
+			fmt.Fprintln(w)
 			return nil
 		}),
 	},
 }
 
+// BitswapStrategyOutput is the strategy of the node and the ledgers of the
+// peers it scores
+type BitswapStrategyOutput struct {
+	Strategy string
+	Peers    []*decision.Receipt
+}
+
+var bitswapStrategyCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the bitswap strategy and the scores of the peers.",
+		ShortDescription: `
+The bitswap strategy, set with Bitswap.Strategy in the config, scores the peers
+from their ledger. Within a peer class, the requests of the peers with a higher
+score are served first. This command lists the peers with a ledger, including
+those kept in the datastore from before a restart, by decreasing score.
+`,
+	},
+	Type: BitswapStrategyOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.OnlineMode() {
+			return ErrNotOnline
+		}
+
+		bs, ok := nd.Exchange.(*bitswap.Bitswap)
+		if !ok {
+			return e.TypeErr(bs, nd.Exchange)
+		}
+
+		ledgers, err := bs.Ledgers()
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &BitswapStrategyOutput{
+			Strategy: bs.Strategy().Name(),
+			Peers:    ledgers,
+		})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *BitswapStrategyOutput) error {
+			fmt.Fprintf(w, "Strategy: %s\n", out.Strategy)
+			if len(out.Peers) == 0 {
+				return nil
+			}
+			tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "PEER\tSCORE\tDEBT RATIO\tSENT\tRECEIVED")
+			for _, r := range out.Peers {
+				fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%s\t%s\n", r.Peer, r.Score, r.Value,
+					humanize.Bytes(r.Sent), humanize.Bytes(r.Recv))
+			}
+			return tw.Flush()
+		}),
+	},
+}
+
 var reprovideCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Trigger reprovider.",
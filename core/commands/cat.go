@@ -9,6 +9,7 @@ import (
 	"mbfs/go-mbfs/core/commands/cmdenv"
 	"mbfs/go-mbfs/core/coreapi/interface"
 
+	"mbfs/go-mbfs/gx/QmXRphxBT4BH2GqGHUSbqULm7wNsxnpA2NrbNaY3DU1Y5K/go-bitswap"
 	"mbfs/go-mbfs/gx/Qma6uuSyjkecGhMFFLfzyJDPyoDtNJSHJNweDccZhaWkgU/go-ipfs-cmds"
 	"mbfs/go-mbfs/gx/Qmde5VP1qUkyQXKCfmEUA7bP64V2HAptbJ7phuPp7jXWwg/go-ipfs-cmdkit"
 
@@ -18,6 +19,7 @@ const (
 	progressBarMinSize = 1024 * 1024 * 8 // show progress bar for outputs > 8MiB
 	offsetOptionName   = "offset"
 	lengthOptionName   = "length"
+	priorityOptionName = "priority"
 )
 
 var CatCmd = &cmds.Command{
@@ -32,6 +34,7 @@ var CatCmd = &cmds.Command{
 	Options: []cmdkit.Option{
 		cmdkit.Int64Option(offsetOptionName, "o", "Byte offset to begin reading from."),
 		cmdkit.Int64Option(lengthOptionName, "l", "Maximum number of bytes to read."),
+		cmdkit.IntOption(priorityOptionName, "Priority of the blocks requested from the network, from -10 to 10. Default: 0."),
 		// added by vingo 2018.11.19
 		cmdkit.StringOption(accessKey, "Cat the file with accessKey").WithDefault(""),
 		//////////////////////////
@@ -78,7 +81,12 @@ var CatCmd = &cmds.Command{
 			return err
 		}
 
-		readers, length, err := cat(req.Context, api, req.Arguments, int64(offset), int64(max), []byte(accKey))
+		ctx, err := priorityContext(req)
+		if err != nil {
+			return err
+		}
+
+		readers, length, err := cat(ctx, api, req.Arguments, int64(offset), int64(max), []byte(accKey))
 		if err != nil {
 			return err
 		}
@@ -131,6 +139,19 @@ var CatCmd = &cmds.Command{
 	},
 }
 
+// priorityContext returns the context of req, with the bitswap priority given
+// by the priority option
+func priorityContext(req *cmds.Request) (context.Context, error) {
+	priority, ok := req.Options[priorityOptionName].(int)
+	if !ok {
+		return req.Context, nil
+	}
+	if priority < bitswap.MinPriority || priority > bitswap.MaxPriority {
+		return nil, fmt.Errorf("priority must be between %d and %d", bitswap.MinPriority, bitswap.MaxPriority)
+	}
+	return bitswap.ContextWithPriority(req.Context, priority), nil
+}
+
 func cat(ctx context.Context, api iface.CoreAPI, paths []string, offset int64, max int64, accKey []byte) ([]io.Reader, uint64, error) {
 	readers := make([]io.Reader, 0, len(paths))
 	length := uint64(0)
@@ -69,10 +69,17 @@ func TestROCommands(t *testing.T) {
 func TestCommands(t *testing.T) {
 	list := []string{
 		"/add",
+		"/alias",
+		"/alias/export",
+		"/alias/import",
+		"/alias/ls",
+		"/alias/rm",
+		"/alias/set",
 		"/bitswap",
 		"/bitswap/ledger",
 		"/bitswap/reprovide",
 		"/bitswap/stat",
+		"/bitswap/strategy",
 		"/bitswap/wantlist",
 		"/block",
 		"/block/get",
@@ -95,6 +102,7 @@ func TestCommands(t *testing.T) {
 		"/config/profile/apply",
 		"/dag",
 		"/dag/get",
+		"/dag/graph",
 		"/dag/put",
 		"/dag/resolve",
 		"/dht",
@@ -125,6 +133,8 @@ func TestCommands(t *testing.T) {
 		"/filestore",
 		"/filestore/dups",
 		"/filestore/ls",
+		"/filestore/repair",
+		"/filestore/rm",
 		"/filestore/verify",
 		"/files/write",
 		"/get",
@@ -146,6 +156,9 @@ func TestCommands(t *testing.T) {
 		"/name/pubsub/state",
 		"/name/pubsub/subs",
 		"/name/pubsub/cancel",
+		"/name/republish",
+		"/name/republish/status",
+		"/name/republish/now",
 		"/name/resolve",
 		"/object",
 		"/object/data",
@@ -185,6 +198,10 @@ func TestCommands(t *testing.T) {
 		"/repo",
 		"/repo/fsck",
 		"/repo/gc",
+		"/repo/migrate",
+		"/repo/migrate-datastore",
+		"/repo/backup",
+		"/repo/restore",
 		"/repo/stat",
 		"/repo/verify",
 		"/repo/version",
@@ -203,6 +220,21 @@ func TestCommands(t *testing.T) {
 		"/swarm/filters",
 		"/swarm/filters/add",
 		"/swarm/filters/rm",
+		"/swarm/key",
+		"/swarm/key/gen",
+		"/swarm/key/rotate",
+		"/swarm/key/status",
+		"/swarm/limits",
+		"/swarm/nat",
+		"/swarm/nat/status",
+		"/swarm/peering",
+		"/swarm/peering/allow",
+		"/swarm/peering/deny",
+		"/swarm/peering/add",
+		"/swarm/peering/rm",
+		"/swarm/peering/ls",
+		"/swarm/relay",
+		"/swarm/relay/status",
 		"/swarm/peers",
 		"/tar",
 		"/tar/add",
@@ -210,6 +242,8 @@ func TestCommands(t *testing.T) {
 		"/update",
 		"/urlstore",
 		"/urlstore/add",
+		"/urlstore/ls",
+		"/urlstore/verify",
 		"/version",
 		"/cid",
 		"/cid/format",
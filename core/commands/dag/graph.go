@@ -0,0 +1,454 @@
+package dagcmd
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"mbfs/go-mbfs/core/commands/cmdenv"
+	e "mbfs/go-mbfs/core/commands/e"
+
+	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	path "mbfs/go-mbfs/gx/QmRG3XuGwT7GYuAqgWDJBKTzdaHMwAnc1x7J2KHEXNHxzG/go-path"
+	bstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
+	unixfs "mbfs/go-mbfs/gx/QmXLCwhHh7bxRsBnCKNE9BAN87V44aSxXLquZYTtjr6fZ3/go-unixfs"
+	cmds "mbfs/go-mbfs/gx/Qma6uuSyjkecGhMFFLfzyJDPyoDtNJSHJNweDccZhaWkgU/go-ipfs-cmds"
+	mdag "mbfs/go-mbfs/gx/QmaDBne4KeY3UepeqSVKYpSmQGa3q9zP6x3LfVF2UjF3Hc/go-merkledag"
+	ipld "mbfs/go-mbfs/gx/QmcKKBwfz6FyQdHR2jsXrrF6XeSBXYL86anmWNewpFpoF5/go-ipld-format"
+	cmdkit "mbfs/go-mbfs/gx/Qmde5VP1qUkyQXKCfmEUA7bP64V2HAptbJ7phuPp7jXWwg/go-ipfs-cmdkit"
+)
+
+const (
+	graphFormatOptionName   = "format"
+	graphMaxDepthOptionName = "max-depth"
+	graphCollapseOptionName = "collapse-chunks"
+	graphFetchOptionName    = "fetch"
+)
+
+const (
+	graphFormatDot     = "dot"
+	graphFormatGraphML = "graphml"
+	graphFormatJSON    = "json"
+)
+
+// GraphNode is a block in the output of 'dag graph'
+type GraphNode struct {
+	Cid   string
+	Codec string
+	// Size is the size of the block itself, it is 0 for missing blocks
+	Size  int
+	Local bool
+	// Missing is set when the block is neither local nor fetched
+	Missing bool `json:",omitempty"`
+	// Truncated is set when the node has links that weren't followed
+	// because of the depth limit
+	Truncated bool `json:",omitempty"`
+
+	// Chunks, ChunksSize and ChunksMissing describe the chunk tree folded
+	// into a unixfs file node by --collapse-chunks
+	Chunks        int    `json:",omitempty"`
+	ChunksSize    uint64 `json:",omitempty"`
+	ChunksMissing int    `json:",omitempty"`
+}
+
+// GraphEdge is a link in the output of 'dag graph'
+type GraphEdge struct {
+	From, To, Name string
+}
+
+// GraphOutput is the output type of 'dag graph'
+type GraphOutput struct {
+	Root  string
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// DagGraphCmd writes a DAG out in a format graph tools understand
+var DagGraphCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Export a dag as a graph.",
+		ShortDescription: `
+'ipfs dag graph' walks the dag below the given path and writes it as a graph
+of blocks and links, in DOT, GraphML or JSON format.
+`,
+		LongDescription: `
+'ipfs dag graph' walks the dag below the given path and writes it as a graph
+of blocks and links, in DOT, GraphML or JSON format.
+
+Every node is annotated with its codec, the size of its block, and whether
+the block is in the local blockstore. Missing blocks are only fetched from
+the network with --fetch; otherwise they show up as missing leaves.
+
+--max-depth stops the walk at the given depth, with the root at depth 0.
+--collapse-chunks folds the chunk tree of each unixfs file into the file
+node, which keeps large files from drowning out the rest of the graph. A
+chunk shared by several files is only counted in the first of them.
+
+For example, to render a dag with graphviz:
+
+  > ipfs dag graph QmSomeDir | dot -Tsvg > dag.svg
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("root", true, false, "The path of the dag to export.").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption(graphFormatOptionName, "f", "Output format: dot, graphml or json.").WithDefault(graphFormatDot),
+		cmdkit.IntOption(graphMaxDepthOptionName, "Only walk the dag down to the given depth.").WithDefault(-1),
+		cmdkit.BoolOption(graphCollapseOptionName, "Fold unixfs file chunks into the file node."),
+		cmdkit.BoolOption(graphFetchOptionName, "Fetch missing blocks from the network."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		format, _ := req.Options[graphFormatOptionName].(string)
+		switch format {
+		case graphFormatDot, graphFormatGraphML, graphFormatJSON:
+		default:
+			return fmt.Errorf("unknown graph format %q", format)
+		}
+		maxDepth, _ := req.Options[graphMaxDepthOptionName].(int)
+		collapse, _ := req.Options[graphCollapseOptionName].(bool)
+		fetch, _ := req.Options[graphFetchOptionName].(bool)
+
+		p, err := path.ParsePath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		root, rem, err := nd.Resolver.ResolveToLastNode(req.Context, p)
+		if err != nil {
+			return err
+		}
+		if len(rem) > 0 {
+			return fmt.Errorf("%s does not resolve to a block", p)
+		}
+
+		g := &dagGrapher{
+			ctx:      req.Context,
+			bs:       nd.Blockstore,
+			dag:      nd.DAG,
+			fetch:    fetch,
+			maxDepth: maxDepth,
+			collapse: collapse,
+		}
+		out, err := g.graph(root)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Type: GraphOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*GraphOutput)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			format, _ := req.Options[graphFormatOptionName].(string)
+			switch format {
+			case graphFormatGraphML:
+				return writeGraphML(w, out)
+			case graphFormatJSON:
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(out)
+			default:
+				return writeDot(w, out)
+			}
+		}),
+	},
+}
+
+// dagGrapher walks a dag breadth first, so every block is expanded at the
+// smallest depth it is found at
+type dagGrapher struct {
+	ctx context.Context
+	bs  bstore.Blockstore
+	dag ipld.DAGService
+
+	fetch    bool
+	maxDepth int
+	collapse bool
+
+	// chunks are the chunks folded into a file node so far
+	chunks *cid.Set
+}
+
+type graphItem struct {
+	c     cid.Cid
+	depth int
+}
+
+func (g *dagGrapher) graph(root cid.Cid) (*GraphOutput, error) {
+	out := &GraphOutput{Root: root.String()}
+
+	seen := cid.NewSet()
+	seen.Add(root)
+	g.chunks = cid.NewSet()
+	queue := []graphItem{{root, 0}}
+
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+
+		gn, nd, err := g.node(item.c)
+		if err != nil {
+			return nil, err
+		}
+		if nd == nil {
+			out.Nodes = append(out.Nodes, gn)
+			continue
+		}
+
+		links := nd.Links()
+		switch {
+		case len(links) == 0:
+		case g.maxDepth >= 0 && item.depth >= g.maxDepth:
+			gn.Truncated = true
+			links = nil
+		case g.collapse && isChunkedFile(nd):
+			if err := g.collapseChunks(nd, &gn); err != nil {
+				return nil, err
+			}
+			links = nil
+		}
+		out.Nodes = append(out.Nodes, gn)
+
+		for _, l := range links {
+			out.Edges = append(out.Edges, GraphEdge{
+				From: gn.Cid,
+				To:   l.Cid.String(),
+				Name: l.Name,
+			})
+			if seen.Visit(l.Cid) {
+				queue = append(queue, graphItem{l.Cid, item.depth + 1})
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// node describes the block with the given cid. The decoded node is returned
+// unless the block is missing.
+func (g *dagGrapher) node(c cid.Cid) (GraphNode, ipld.Node, error) {
+	gn := GraphNode{
+		Cid:   c.String(),
+		Codec: codecName(c),
+	}
+
+	local, err := g.bs.Has(c)
+	if err != nil {
+		return gn, nil, err
+	}
+	gn.Local = local
+
+	if !local && !g.fetch {
+		gn.Missing = true
+		return gn, nil, nil
+	}
+
+	nd, err := g.dag.Get(g.ctx, c)
+	switch err {
+	case nil:
+	case ipld.ErrNotFound:
+		gn.Missing = true
+		return gn, nil, nil
+	default:
+		return gn, nil, err
+	}
+
+	gn.Size = len(nd.RawData())
+	return gn, nd, nil
+}
+
+// collapseChunks walks the chunk tree below a unixfs file node and records
+// its size on the node instead of adding the chunks to the graph. A chunk
+// shared between files, or repeated in a file, is only counted once, under
+// the first file node it is found below.
+func (g *dagGrapher) collapseChunks(nd ipld.Node, gn *GraphNode) error {
+	queue := nd.Links()
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+		if !g.chunks.Visit(l.Cid) {
+			continue
+		}
+
+		chunk, cnd, err := g.node(l.Cid)
+		if err != nil {
+			return err
+		}
+		gn.Chunks++
+		if cnd == nil {
+			gn.ChunksMissing++
+			continue
+		}
+		gn.ChunksSize += uint64(chunk.Size)
+		queue = append(queue, cnd.Links()...)
+	}
+	return nil
+}
+
+// isChunkedFile reports whether nd is a unixfs file split into chunks
+func isChunkedFile(nd ipld.Node) bool {
+	pn, ok := nd.(*mdag.ProtoNode)
+	if !ok || len(pn.Links()) == 0 {
+		return false
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return false
+	}
+	return fsn.Type() == unixfs.TFile || fsn.Type() == unixfs.TRaw
+}
+
+func codecName(c cid.Cid) string {
+	if name, ok := cid.CodecToStr[c.Type()]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", c.Type())
+}
+
+func writeDot(w io.Writer, out *GraphOutput) error {
+	fmt.Fprintf(w, "digraph %s {\n", dotQuote(out.Root))
+	fmt.Fprintln(w, "  node [shape=box, fontname=monospace];")
+
+	for _, n := range out.Nodes {
+		label := fmt.Sprintf("%s\n%s, %d bytes", n.Cid, n.Codec, n.Size)
+		attrs := ""
+		switch {
+		case n.Missing:
+			label = fmt.Sprintf("%s\n%s, missing", n.Cid, n.Codec)
+			attrs = ", style=dashed, color=red"
+		case !n.Local:
+			attrs = ", color=blue"
+		}
+		if n.Chunks > 0 {
+			label += fmt.Sprintf("\n+%d chunks, %d bytes", n.Chunks, n.ChunksSize)
+			if n.ChunksMissing > 0 {
+				label += fmt.Sprintf(", %d missing", n.ChunksMissing)
+			}
+		}
+		if n.Truncated {
+			label += "\n..."
+		}
+		fmt.Fprintf(w, "  %s [label=%s%s];\n", dotQuote(n.Cid), dotQuote(label), attrs)
+	}
+
+	for _, edge := range out.Edges {
+		fmt.Fprintf(w, "  %s -> %s", dotQuote(edge.From), dotQuote(edge.To))
+		if edge.Name != "" {
+			fmt.Fprintf(w, " [label=%s]", dotQuote(edge.Name))
+		}
+		fmt.Fprintln(w, ";")
+	}
+
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+func writeGraphML(w io.Writer, out *GraphOutput) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{"codec", "node", "codec", "string"},
+			{"size", "node", "size", "long"},
+			{"local", "node", "local", "boolean"},
+			{"missing", "node", "missing", "boolean"},
+			{"truncated", "node", "truncated", "boolean"},
+			{"chunks", "node", "chunks", "int"},
+			{"chunksize", "node", "chunksize", "long"},
+			{"chunksmissing", "node", "chunksmissing", "int"},
+			{"name", "edge", "name", "string"},
+		},
+	}
+	doc.Graph.ID = out.Root
+	doc.Graph.EdgeDefault = "directed"
+
+	for _, n := range out.Nodes {
+		data := []graphMLData{
+			{"codec", n.Codec},
+			{"size", fmt.Sprint(n.Size)},
+			{"local", fmt.Sprint(n.Local)},
+			{"missing", fmt.Sprint(n.Missing)},
+			{"truncated", fmt.Sprint(n.Truncated)},
+		}
+		if n.Chunks > 0 {
+			data = append(data,
+				graphMLData{"chunks", fmt.Sprint(n.Chunks)},
+				graphMLData{"chunksize", fmt.Sprint(n.ChunksSize)},
+				graphMLData{"chunksmissing", fmt.Sprint(n.ChunksMissing)},
+			)
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: n.Cid, Data: data})
+	}
+
+	for _, edge := range out.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: edge.From,
+			Target: edge.To,
+			Data:   []graphMLData{{"name", edge.Name}},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,79 @@
+package dagcmd
+
+import (
+	"context"
+	"testing"
+
+	offline "mbfs/go-mbfs/gx/QmPpnbwgAuvhUkA9jGooR88ZwZtTUHXXvoQNKdjZC6nYku/go-ipfs-exchange-offline"
+	bstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
+	bserv "mbfs/go-mbfs/gx/QmVPeMNK9DfGLXDZzs2W4RoFWC9Zq1EnLGmLXtYtWrNdcW/go-blockservice"
+	unixfs "mbfs/go-mbfs/gx/QmXLCwhHh7bxRsBnCKNE9BAN87V44aSxXLquZYTtjr6fZ3/go-unixfs"
+	mdag "mbfs/go-mbfs/gx/QmaDBne4KeY3UepeqSVKYpSmQGa3q9zP6x3LfVF2UjF3Hc/go-merkledag"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dssync "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/sync"
+	ipld "mbfs/go-mbfs/gx/QmcKKBwfz6FyQdHR2jsXrrF6XeSBXYL86anmWNewpFpoF5/go-ipld-format"
+)
+
+// fileNode makes a unixfs file node out of chunks
+func fileNode(t *testing.T, chunks ...ipld.Node) *mdag.ProtoNode {
+	t.Helper()
+	fsn := unixfs.NewFSNode(unixfs.TFile)
+	for _, c := range chunks {
+		fsn.AddBlockSize(uint64(len(c.RawData())))
+	}
+	data, err := fsn.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := mdag.NodeWithData(data)
+	for _, c := range chunks {
+		if err := nd.AddNodeLink("", c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nd
+}
+
+func TestGraphCollapseSharedChunks(t *testing.T) {
+	ctx := context.Background()
+	bs := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	dserv := mdag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	shared := mdag.NewRawNode([]byte("shared chunk"))
+	a := mdag.NewRawNode([]byte("chunk of a"))
+	b := mdag.NewRawNode([]byte("chunk of b, longer"))
+	// b repeats the shared chunk
+	fileA := fileNode(t, shared, a)
+	fileB := fileNode(t, shared, b, shared)
+	dir := mdag.NodeWithData(unixfs.FolderPBData())
+	dir.AddNodeLink("a", fileA)
+	dir.AddNodeLink("b", fileB)
+	for _, nd := range []ipld.Node{shared, a, b, fileA, fileB, dir} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := &dagGrapher{ctx: ctx, bs: bs, dag: dserv, maxDepth: -1, collapse: true}
+	out, err := g.graph(dir.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Nodes) != 3 || len(out.Edges) != 2 {
+		t.Fatalf("expected the directory and its two files, got %+v", out)
+	}
+
+	var chunks int
+	var size uint64
+	for _, n := range out.Nodes {
+		chunks += n.Chunks
+		size += n.ChunksSize
+		if n.ChunksMissing != 0 {
+			t.Fatalf("unexpected missing chunks on %s", n.Cid)
+		}
+	}
+	expected := uint64(len(shared.RawData()) + len(a.RawData()) + len(b.RawData()))
+	if chunks != 3 || size != expected {
+		t.Fatalf("expected each chunk to be counted once, 3 chunks of %d bytes, got %d of %d bytes", expected, chunks, size)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 
+	core "mbfs/go-mbfs/core"
+	cmdenv "mbfs/go-mbfs/core/commands/cmdenv"
 	ncmd "mbfs/go-mbfs/core/commands/name"
 	namesys "mbfs/go-mbfs/namesys"
 	nsopts "mbfs/go-mbfs/namesys/opts"
@@ -14,6 +16,7 @@ import (
 
 const (
 	dnsRecursiveOptionName = "recursive"
+	dnsNoCacheOptionName   = "nocache"
 )
 
 var DNSCmd = &cmds.Command{
@@ -51,6 +54,10 @@ The resolver can recursively resolve:
 	dnslink=/ipns/ipfs.io
 	> ipfs dns -r recursive.ipfs.io
 	/ipfs/QmRzTuh2Lpuz7Gr39stNr6mTFdqAghsZec1JoUnfySUzcy
+
+The DNS servers used, per domain suffix, and the cache of the answers are
+set in the DNS section of the config. Answers are cached for their TTL,
+use --nocache to look the records up again.
 `,
 	},
 
@@ -59,11 +66,31 @@ The resolver can recursively resolve:
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption(dnsRecursiveOptionName, "r", "Resolve until the result is not a DNS link."),
+		cmdkit.BoolOption(dnsNoCacheOptionName, "n", "Do not use cached answers."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		recursive, _ := req.Options[dnsRecursiveOptionName].(bool)
+		nocache, _ := req.Options[dnsNoCacheOptionName].(bool)
 		name := req.Arguments[0]
-		resolver := namesys.NewDNSResolver()
+
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		resolver := n.DNSResolver
+		if resolver == nil {
+			cfg, err := n.Repo.Config()
+			if err != nil {
+				return err
+			}
+			resolver, err = core.NewDNSResolver(cfg.DNS)
+			if err != nil {
+				return err
+			}
+		}
+		if nocache {
+			resolver = resolver.Uncached()
+		}
 
 		var ropts []nsopts.ResolveOpt
 		if !recursive {
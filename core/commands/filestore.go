@@ -1,9 +1,11 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	core "mbfs/go-mbfs/core"
 	cmdenv "mbfs/go-mbfs/core/commands/cmdenv"
@@ -11,6 +13,7 @@ import (
 	filestore "mbfs/go-mbfs/filestore"
 
 	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
 	cmds "mbfs/go-mbfs/gx/Qma6uuSyjkecGhMFFLfzyJDPyoDtNJSHJNweDccZhaWkgU/go-ipfs-cmds"
 	"mbfs/go-mbfs/gx/Qmde5VP1qUkyQXKCfmEUA7bP64V2HAptbJ7phuPp7jXWwg/go-ipfs-cmdkit"
 )
@@ -23,11 +26,16 @@ var FileStoreCmd = &cmds.Command{
 		"ls":     lsFileStore,
 		"verify": verifyFileStore,
 		"dups":   dupsFileStore,
+		"repair": repairFileStore,
+		"rm":     rmFileStore,
 	},
 }
 
 const (
-	fileOrderOptionName = "file-order"
+	fileOrderOptionName     = "file-order"
+	repairConvertOptionName = "convert"
+	repairDryRunOptionName  = "dry-run"
+	repairFetchTimeout      = time.Minute
 )
 
 var lsFileStore = &cmds.Command{
@@ -207,6 +215,136 @@ var dupsFileStore = &cmds.Command{
 	Type:     RefWrapper{},
 }
 
+var repairFileStore = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Repair filestore references to moved or changed files.",
+		LongDescription: `
+Repair the filestore references which 'ipfs filestore verify' reports as
+'changed' or 'no-file'.
+
+The files in the given directories are searched for the data of the broken
+references, which are then pointed to where the data was found. Data is
+matched by hash, so it is found even when files were renamed, moved around,
+split or joined, as long as the data still starts on a block boundary. The
+directories must be inside the ipfs root, like any file added with --nocopy,
+and relative paths are relative to it.
+
+With --convert, the blocks which still can't be found are fetched from the
+network and kept in the normal block storage instead of the filestore.
+
+With --dry-run, nothing is changed and nothing is fetched: the blocks
+--convert would fetch are reported as converted, whether they can be found
+on the network or not.
+
+The output is:
+
+<action> <hash> <old path> <old offset> [<new path> <new offset>]
+
+Where <action> is one of:
+relinked:   the reference now points to the new location
+converted:  the block is now kept in the normal block storage
+unresolved: the data could not be found
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("dir", false, true, "Directories to search for moved files."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption(repairConvertOptionName, "Fetch blocks which can't be found and store them in the blockstore."),
+		cmdkit.BoolOption(repairDryRunOptionName, "Only report what would be repaired."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, fs, err := getFilestore(env)
+		if err != nil {
+			return err
+		}
+
+		convert, _ := req.Options[repairConvertOptionName].(bool)
+		dryRun, _ := req.Options[repairDryRunOptionName].(bool)
+
+		opts := filestore.RepairOptions{
+			Dirs:   req.Arguments,
+			DryRun: dryRun,
+		}
+		if convert {
+			if !dryRun {
+				defer n.Blockstore.GCLock().Unlock()
+			}
+			opts.Fetch = func(c cid.Cid) (blocks.Block, error) {
+				ctx, cancel := context.WithTimeout(req.Context, repairFetchTimeout)
+				defer cancel()
+				return n.Exchange.GetBlock(ctx, c)
+			}
+		}
+
+		results, err := filestore.Repair(fs, opts)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			if err := res.Emit(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: streamResult(func(v interface{}, out io.Writer) nonFatalError {
+			r := v.(*filestore.RepairRes)
+			fmt.Fprintf(out, "%-10s %s %s %d", r.Action, r.Key, r.OldPath, r.OldOffset)
+			if r.Action == filestore.RepairRelinked {
+				fmt.Fprintf(out, " %s %d", r.FilePath, r.Offset)
+			}
+			fmt.Fprintln(out)
+			return nonFatalError(r.ErrorMsg)
+		}),
+	},
+	Type: filestore.RepairRes{},
+}
+
+var rmFileStore = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Remove filestore references to files under a path.",
+		LongDescription: `
+Remove the filestore references to files under the given paths. Paths are
+relative to the ipfs root unless they are absolute; urlstore references
+can be removed by giving a URL prefix.
+
+Only the references are removed: the files are left untouched, and pinned
+objects using the removed blocks will be incomplete.
+
+The output is:
+
+<hash> <size> <path> <offset>
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("path", true, true, "Path prefix of the references to remove."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, fs, err := getFilestore(env)
+		if err != nil {
+			return err
+		}
+		defer n.Blockstore.GCLock().Unlock()
+
+		for _, prefix := range req.Arguments {
+			removed, err := filestore.RemovePrefix(fs, prefix)
+			if err != nil {
+				return err
+			}
+			for _, r := range removed {
+				if err := res.Emit(r); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	},
+	PostRun: lsFileStore.PostRun,
+	Type:    filestore.ListRes{},
+}
+
 func getFilestore(env cmds.Environment) (*core.IpfsNode, *filestore.Filestore, error) {
 	n, err := cmdenv.GetNode(env)
 	if err != nil {
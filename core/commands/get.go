@@ -59,6 +59,7 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		cmdkit.BoolOption(archiveOptionName, "a", "Output a TAR archive."),
 		cmdkit.BoolOption(compressOptionName, "C", "Compress the output with GZIP compression."),
 		cmdkit.IntOption(compressionLevelOptionName, "l", "The level of compression (1-9)."),
+		cmdkit.IntOption(priorityOptionName, "Priority of the blocks requested from the network, from -10 to 10. Default: 0."),
 		// added by vingo
 		cmdkit.StringOption(accessKey, "Get the file with accessKey").WithDefault(""),
 	},
@@ -76,8 +77,18 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		if err != nil {
 			return err
 		}
+		ctx, err := priorityContext(req)
+		if err != nil {
+			return err
+		}
+
+		if !node.OnlineMode() {
+			if err := node.SetupOfflineRouting(); err != nil {
+				return err
+			}
+		}
+
 		p := path.Path(req.Arguments[0])
-		ctx := req.Context
 		dn, err := core.Resolve(ctx, node.Namesys, node.Resolver, p)
 		if err != nil {
 			return err
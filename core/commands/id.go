@@ -35,6 +35,10 @@ type IdOutput struct {
 	Addresses       []string
 	AgentVersion    string
 	ProtocolVersion string
+
+	// PNetFingerprint is the fingerprint of the swarm key the node sends
+	// with, if it is in a private network
+	PNetFingerprint string `json:",omitempty"`
 }
 
 const (
@@ -54,6 +58,7 @@ If no peer is specified, prints out information for local peers.
 <pver>: Protocol version.
 <pubkey>: Public key.
 <addrs>: Addresses (newline delimited).
+<pnet>: Fingerprint of the private network swarm key, if any.
 
 EXAMPLE:
 
@@ -120,6 +125,7 @@ EXAMPLE:
 				output = strings.Replace(output, "<pver>", out.ProtocolVersion, -1)
 				output = strings.Replace(output, "<pubkey>", out.PublicKey, -1)
 				output = strings.Replace(output, "<addrs>", strings.Join(out.Addresses, "\n"), -1)
+				output = strings.Replace(output, "<pnet>", out.PNetFingerprint, -1)
 				output = strings.Replace(output, "\\n", "\n", -1)
 				output = strings.Replace(output, "\\t", "\t", -1)
 				fmt.Fprint(w, output)
@@ -197,5 +203,11 @@ func printSelf(node *core.IpfsNode) (interface{}, error) {
 	}
 	info.ProtocolVersion = identify.LibP2PVersion
 	info.AgentVersion = identify.ClientVersion
+
+	st, err := swarmKeyStatus(node)
+	if err != nil {
+		return nil, err
+	}
+	info.PNetFingerprint = st.Fingerprint
 	return info, nil
 }
@@ -1,12 +1,13 @@
 package commands
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
+	gopath "path"
 	"text/tabwriter"
 
-	cmds "mbfs/go-mbfs/commands"
+	cmdenv "mbfs/go-mbfs/core/commands/cmdenv"
 	e "mbfs/go-mbfs/core/commands/e"
 	iface "mbfs/go-mbfs/core/coreapi/interface"
 
@@ -16,6 +17,7 @@ import (
 	unixfs "mbfs/go-mbfs/gx/QmXLCwhHh7bxRsBnCKNE9BAN87V44aSxXLquZYTtjr6fZ3/go-unixfs"
 	uio "mbfs/go-mbfs/gx/QmXLCwhHh7bxRsBnCKNE9BAN87V44aSxXLquZYTtjr6fZ3/go-unixfs/io"
 	unixfspb "mbfs/go-mbfs/gx/QmXLCwhHh7bxRsBnCKNE9BAN87V44aSxXLquZYTtjr6fZ3/go-unixfs/pb"
+	cmds "mbfs/go-mbfs/gx/Qma6uuSyjkecGhMFFLfzyJDPyoDtNJSHJNweDccZhaWkgU/go-ipfs-cmds"
 	merkledag "mbfs/go-mbfs/gx/QmaDBne4KeY3UepeqSVKYpSmQGa3q9zP6x3LfVF2UjF3Hc/go-merkledag"
 	ipld "mbfs/go-mbfs/gx/QmcKKBwfz6FyQdHR2jsXrrF6XeSBXYL86anmWNewpFpoF5/go-ipld-format"
 	"mbfs/go-mbfs/gx/Qmde5VP1qUkyQXKCfmEUA7bP64V2HAptbJ7phuPp7jXWwg/go-ipfs-cmdkit"
@@ -25,6 +27,7 @@ type LsLink struct {
 	Name, Hash string
 	Size       uint64
 	Type       unixfspb.Data_DataType
+	Mode       uint32
 }
 
 type LsObject struct {
@@ -39,6 +42,9 @@ type LsOutput struct {
 const (
 	lsHeadersOptionNameTime = "headers"
 	lsResolveTypeOptionName = "resolve-type"
+	lsStreamOptionName      = "stream"
+	lsRecursiveOptionName   = "recursive"
+	lsDepthOptionName       = "depth"
 )
 
 var LsCmd = &cmds.Command{
@@ -50,7 +56,26 @@ the following format:
 
   <link base58 hash> <link size in bytes> <link name>
 
-The JSON output contains type information.
+The JSON output contains type and mode information.
+`,
+		LongDescription: `
+Displays the contents of an IPFS or IPNS object(s) at the given path, with
+the following format:
+
+  <link base58 hash> <link size in bytes> <link name>
+
+The JSON output contains type and mode information.
+
+Entries are written as soon as they are fetched, which keeps memory use
+flat and lets very large or sharded directories be listed without timing
+out. With --enc=json, the output is one JSON object per line, one line per
+entry; an empty directory writes nothing. With --stream=false, the whole
+listing is collected first, which aligns the columns of the table, and the
+JSON output is a single object holding every entry.
+
+With --recursive, subdirectories are listed as well and entry names are
+given relative to the listed path. --depth limits how many levels are
+listed; a depth of 1 lists only the direct entries and -1 means no limit.
 `,
 	},
 
@@ -60,30 +85,37 @@ The JSON output contains type information.
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption(lsHeadersOptionNameTime, "v", "Print table headers (Hash, Size, Name)."),
 		cmdkit.BoolOption(lsResolveTypeOptionName, "Resolve linked objects to find out their types.").WithDefault(true),
+		cmdkit.BoolOption(lsStreamOptionName, "s", "Write entries as they are fetched instead of all at once.").WithDefault(true),
+		cmdkit.BoolOption(lsRecursiveOptionName, "r", "List the contents of subdirectories as well."),
+		cmdkit.IntOption(lsDepthOptionName, "Limit listing to the given depth. Defaults to 1, or unlimited with --recursive."),
 	},
-	Run: func(req cmds.Request, res cmds.Response) {
-		nd, err := req.InvocContext().GetNode()
-		if err != nil {
-			res.SetError(err, cmdkit.ErrNormal)
-			return
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		if err := req.ParseBodyArgs(); err != nil {
+			return err
 		}
 
-		api, err := req.InvocContext().GetApi()
+		nd, err := cmdenv.GetNode(env)
 		if err != nil {
-			res.SetError(err, cmdkit.ErrNormal)
-			return
+			return err
 		}
 
-		// get options early -> exit early in case of error
-		if _, _, err := req.Option(lsHeadersOptionNameTime).Bool(); err != nil {
-			res.SetError(err, cmdkit.ErrNormal)
-			return
+		api, err := cmdenv.GetApi(env)
+		if err != nil {
+			return err
 		}
 
-		resolve, _, err := req.Option(lsResolveTypeOptionName).Bool()
-		if err != nil {
-			res.SetError(err, cmdkit.ErrNormal)
-			return
+		resolve, _ := req.Options[lsResolveTypeOptionName].(bool)
+		stream, _ := req.Options[lsStreamOptionName].(bool)
+		recursive, _ := req.Options[lsRecursiveOptionName].(bool)
+		depth, found := req.Options[lsDepthOptionName].(int)
+		if !found {
+			depth = 1
+			if recursive {
+				depth = -1
+			}
+		}
+		if depth == 0 || depth < -1 {
+			return fmt.Errorf("invalid depth %d", depth)
 		}
 
 		dserv := nd.DAG
@@ -93,127 +125,260 @@ The JSON output contains type information.
 			dserv = merkledag.NewDAGService(bserv)
 		}
 
-		paths := req.Arguments()
+		paths := req.Arguments
 
 		var dagnodes []ipld.Node
 		for _, fpath := range paths {
 			p, err := iface.ParsePath(fpath)
 			if err != nil {
-				res.SetError(err, cmdkit.ErrNormal)
-				return
+				return err
 			}
 
-			dagnode, err := api.ResolveNode(req.Context(), p)
+			dagnode, err := api.ResolveNode(req.Context, p)
 			if err != nil {
-				res.SetError(err, cmdkit.ErrNormal)
-				return
+				return err
 			}
 			dagnodes = append(dagnodes, dagnode)
 		}
 
-		output := make([]LsObject, len(req.Arguments()))
+		ng := merkledag.NewSession(req.Context, nd.DAG)
 
-		ng := merkledag.NewSession(req.Context(), nd.DAG)
-
-		ro := merkledag.NewReadOnlyDagService(ng)
-
-		for i, dagnode := range dagnodes {
-			dir, err := uio.NewDirectoryFromNode(ro, dagnode)
-			if err != nil && err != uio.ErrNotADir {
-				res.SetError(fmt.Errorf("the data in %s (at %q) is not a UnixFS directory: %s", dagnode.Cid(), paths[i], err), cmdkit.ErrNormal)
-				return
-			}
+		w := &lsWalker{
+			ctx:      req.Context,
+			dag:      merkledag.NewReadOnlyDagService(ng),
+			types:    dserv,
+			resolve:  resolve,
+			maxDepth: depth,
+		}
 
-			var links []*ipld.Link
-			if dir == nil {
-				links = dagnode.Links()
-			} else {
-				links, err = dir.Links(req.Context())
+		if stream {
+			for i, dagnode := range dagnodes {
+				err := w.walk(dagnode, "", 1, func(link LsLink) error {
+					return res.Emit(&LsOutput{[]LsObject{{
+						Hash:  paths[i],
+						Links: []LsLink{link},
+					}}})
+				})
 				if err != nil {
-					res.SetError(err, cmdkit.ErrNormal)
-					return
+					return fmt.Errorf("listing %q: %s", paths[i], err)
 				}
 			}
+			return nil
+		}
 
+		output := make([]LsObject, len(paths))
+		for i, dagnode := range dagnodes {
 			output[i] = LsObject{
 				Hash:  paths[i],
-				Links: make([]LsLink, len(links)),
+				Links: []LsLink{},
 			}
 
-			for j, link := range links {
-				t := unixfspb.Data_DataType(-1)
-
-				switch link.Cid.Type() {
-				case cid.Raw:
-					// No need to check with raw leaves
-					t = unixfs.TFile
-				case cid.DagProtobuf:
-					linkNode, err := link.GetNode(req.Context(), dserv)
-					if err == ipld.ErrNotFound && !resolve {
-						// not an error
-						linkNode = nil
-					} else if err != nil {
-						res.SetError(err, cmdkit.ErrNormal)
-						return
-					}
-
-					if pn, ok := linkNode.(*merkledag.ProtoNode); ok {
-						d, err := unixfs.FSNodeFromBytes(pn.Data())
-						if err != nil {
-							res.SetError(err, cmdkit.ErrNormal)
-							return
-						}
-						t = d.Type()
-					}
-				}
-				output[i].Links[j] = LsLink{
-					Name: link.Name,
-					Hash: link.Cid.String(),
-					Size: link.Size,
-					Type: t,
-				}
+			err := w.walk(dagnode, "", 1, func(link LsLink) error {
+				output[i].Links = append(output[i].Links, link)
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("listing %q: %s", paths[i], err)
 			}
 		}
 
-		res.SetOutput(&LsOutput{output})
+		return cmds.EmitOnce(res, &LsOutput{output})
 	},
-	Marshalers: cmds.MarshalerMap{
-		cmds.Text: func(res cmds.Response) (io.Reader, error) {
-
-			v, err := unwrapOutput(res.Output())
-			if err != nil {
-				return nil, err
+	Encoders: cmds.EncoderMap{
+		cmds.Text: func(req *cmds.Request) func(io.Writer) cmds.Encoder {
+			return func(w io.Writer) cmds.Encoder {
+				return &lsTextEncoder{req: req, w: w}
 			}
+		},
+	},
+	Type: LsOutput{},
+}
+
+// lsWalker lists unixfs directories, down to maxDepth levels
+type lsWalker struct {
+	ctx context.Context
+
+	// dag is used to enumerate directories and types to resolve the type
+	// of each entry
+	dag      ipld.DAGService
+	types    ipld.DAGService
+	resolve  bool
+	maxDepth int
+}
+
+// walk calls emit for every entry below nd as soon as the entry is fetched.
+// Sharded directories are enumerated without loading the whole shard.
+func (w *lsWalker) walk(nd ipld.Node, prefix string, depth int, emit func(LsLink) error) error {
+	ctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+
+	dir, err := uio.NewDirectoryFromNode(w.dag, nd)
+	if err != nil && err != uio.ErrNotADir {
+		return fmt.Errorf("the data in %s is not a UnixFS directory: %s", nd.Cid(), err)
+	}
+
+	var links <-chan unixfs.LinkResult
+	if dir == nil {
+		links = nodeLinksAsync(ctx, nd)
+	} else {
+		links = dir.EnumLinksAsync(ctx)
+	}
+
+	for lr := range links {
+		if lr.Err != nil {
+			return lr.Err
+		}
+		link := lr.Link
+
+		t, linkNode, err := w.linkType(ctx, link)
+		if err != nil {
+			return err
+		}
 
-			headers, _, _ := res.Request().Option(lsHeadersOptionNameTime).Bool()
-			output, ok := v.(*LsOutput)
-			if !ok {
-				return nil, e.TypeErr(output, v)
+		name := link.Name
+		if prefix != "" {
+			name = gopath.Join(prefix, name)
+		}
+
+		err = emit(LsLink{
+			Name: name,
+			Hash: link.Cid.String(),
+			Size: link.Size,
+			Type: t,
+			Mode: unixfsMode(t),
+		})
+		if err != nil {
+			return err
+		}
+
+		if linkNode == nil || (w.maxDepth >= 0 && depth >= w.maxDepth) {
+			continue
+		}
+		if t == unixfs.TDirectory || t == unixfs.THAMTShard {
+			if err := w.walk(linkNode, name, depth+1, emit); err != nil {
+				return err
 			}
+		}
+	}
 
-			buf := new(bytes.Buffer)
-			w := tabwriter.NewWriter(buf, 1, 2, 1, ' ', 0)
-			for _, object := range output.Objects {
-				if len(output.Objects) > 1 {
-					fmt.Fprintf(w, "%s:\n", object.Hash)
-				}
-				if headers {
-					fmt.Fprintln(w, "Hash\tSize\tName")
-				}
-				for _, link := range object.Links {
-					if link.Type == unixfs.TDirectory {
-						link.Name += "/"
-					}
-					fmt.Fprintf(w, "%s\t%v\t%s\n", link.Hash, link.Size, link.Name)
-				}
-				if len(output.Objects) > 1 {
-					fmt.Fprintln(w)
-				}
+	return ctx.Err()
+}
+
+// linkType finds out the unixfs type of the linked node. The node is
+// returned when it had to be fetched.
+func (w *lsWalker) linkType(ctx context.Context, link *ipld.Link) (unixfspb.Data_DataType, ipld.Node, error) {
+	switch link.Cid.Type() {
+	case cid.Raw:
+		// No need to check with raw leaves
+		return unixfs.TFile, nil, nil
+	case cid.DagProtobuf:
+		linkNode, err := link.GetNode(ctx, w.types)
+		if err == ipld.ErrNotFound && !w.resolve {
+			// not an error
+			return -1, nil, nil
+		} else if err != nil {
+			return -1, nil, err
+		}
+		return unixfsType(linkNode), linkNode, nil
+	}
+	return -1, nil, nil
+}
+
+// nodeLinksAsync sends the links of a node that is not a directory
+func nodeLinksAsync(ctx context.Context, nd ipld.Node) <-chan unixfs.LinkResult {
+	out := make(chan unixfs.LinkResult)
+	go func() {
+		defer close(out)
+		for _, l := range nd.Links() {
+			select {
+			case out <- unixfs.LinkResult{Link: l}:
+			case <-ctx.Done():
+				return
 			}
-			w.Flush()
+		}
+	}()
+	return out
+}
 
-			return buf, nil
-		},
-	},
-	Type: LsOutput{},
+// unixfsType returns the unixfs type of a node, or -1 if it isn't a unixfs
+// node.
+func unixfsType(nd ipld.Node) unixfspb.Data_DataType {
+	switch nd := nd.(type) {
+	case *merkledag.RawNode:
+		return unixfs.TFile
+	case *merkledag.ProtoNode:
+		d, err := unixfs.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return -1
+		}
+		return d.Type()
+	}
+	return -1
+}
+
+// POSIX file type bits, as found in st_mode
+const (
+	modeTypeDir     = 0040000
+	modeTypeFile    = 0100000
+	modeTypeSymlink = 0120000
+)
+
+// unixfsMode returns the POSIX mode entries of the given type are exposed
+// with. Unixfs doesn't store permissions, so these are the defaults used when
+// files are written out by 'ipfs get' and the fuse mounts.
+func unixfsMode(t unixfspb.Data_DataType) uint32 {
+	switch t {
+	case unixfs.TDirectory, unixfs.THAMTShard:
+		return modeTypeDir | 0755
+	case unixfs.TFile, unixfs.TRaw:
+		return modeTypeFile | 0644
+	case unixfs.TSymlink:
+		return modeTypeSymlink | 0777
+	}
+	return 0
+}
+
+// lsTextEncoder writes the listing as a table. When streaming, each entry
+// arrives on its own, so object headers are written when the object changes.
+type lsTextEncoder struct {
+	req  *cmds.Request
+	w    io.Writer
+	last string
+}
+
+func (enc *lsTextEncoder) Encode(v interface{}) error {
+	output, ok := v.(*LsOutput)
+	if !ok {
+		return e.TypeErr(output, v)
+	}
+
+	headers, _ := enc.req.Options[lsHeadersOptionNameTime].(bool)
+	stream, _ := enc.req.Options[lsStreamOptionName].(bool)
+	multi := len(enc.req.Arguments) > 1
+
+	w := tabwriter.NewWriter(enc.w, 1, 2, 1, ' ', 0)
+	for _, object := range output.Objects {
+		if !stream || object.Hash != enc.last {
+			if stream && multi && enc.last != "" {
+				fmt.Fprintln(w)
+			}
+			if multi {
+				fmt.Fprintf(w, "%s:\n", object.Hash)
+			}
+			if headers {
+				fmt.Fprintln(w, "Hash\tSize\tName")
+			}
+			enc.last = object.Hash
+		}
+		for _, link := range object.Links {
+			if link.Type == unixfs.TDirectory || link.Type == unixfs.THAMTShard {
+				link.Name += "/"
+			}
+			fmt.Fprintf(w, "%s\t%v\t%s\n", link.Hash, link.Size, link.Name)
+		}
+		if !stream && multi {
+			fmt.Fprintln(w)
+		}
+	}
+	return w.Flush()
 }
@@ -29,6 +29,9 @@ const (
 	dhtRecordCountOptionName = "dht-record-count"
 	dhtTimeoutOptionName     = "dht-timeout"
 	streamOptionName         = "stream"
+	parallelOptionName       = "parallel"
+	quorumOptionName         = "quorum"
+	quorumTimeoutOptionName  = "quorum-timeout"
 )
 
 var IpnsCmd = &cmds.Command{
@@ -66,6 +69,13 @@ Resolve the value of a dnslink:
   > ipfs name resolve ipfs.io
   /ipfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5
 
+Resolve a name without waiting on a slow DHT, returning the newest record
+given by the local records, pubsub and the DHT once two of them answered or
+after a second:
+
+  > ipfs name resolve --parallel --quorum-timeout=1s QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+  /ipfs/QmSiTko9JZyabH56y2fussEt1A5oDqsFXB3CkvAqraFryz
+
 `,
 	},
 
@@ -78,6 +88,9 @@ Resolve the value of a dnslink:
 		cmdkit.UintOption(dhtRecordCountOptionName, "dhtrc", "Number of records to request for DHT resolution."),
 		cmdkit.StringOption(dhtTimeoutOptionName, "dhtt", "Max time to collect values during DHT resolution eg \"30s\". Pass 0 for no timeout."),
 		cmdkit.BoolOption(streamOptionName, "s", "Stream entries as they are found."),
+		cmdkit.BoolOption(parallelOptionName, "p", "Query the local records, pubsub and the DHT at once, and return the newest record."),
+		cmdkit.UintOption(quorumOptionName, "Number of sources to get a record from before returning with --parallel. Default: 2."),
+		cmdkit.StringOption(quorumTimeoutOptionName, "Max time to wait for the quorum with --parallel before returning the newest record found, eg \"2s\". Default: 5s."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env)
@@ -126,6 +139,23 @@ Resolve the value of a dnslink:
 			opts = append(opts, options.Name.ResolveOption(nsopts.DhtTimeout(d)))
 		}
 
+		if parallel, _ := req.Options[parallelOptionName].(bool); parallel {
+			opts = append(opts, options.Name.ResolveOption(nsopts.Strategy(nsopts.Parallel)))
+		}
+		if q, ok := req.Options[quorumOptionName].(uint); ok {
+			opts = append(opts, options.Name.ResolveOption(nsopts.Quorum(q)))
+		}
+		if qt, ok := req.Options[quorumTimeoutOptionName].(string); ok {
+			d, err := time.ParseDuration(qt)
+			if err != nil {
+				return err
+			}
+			if d < 0 {
+				return errors.New("quorum timeout value must be >= 0")
+			}
+			opts = append(opts, options.Name.ResolveOption(nsopts.QuorumTimeout(d)))
+		}
+
 		if !strings.HasPrefix(name, "/ipns/") {
 			name = "/ipns/" + name
 		}
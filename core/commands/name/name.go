@@ -60,8 +60,9 @@ Resolve the value of a dnslink:
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"publish": PublishCmd,
-		"resolve": IpnsCmd,
-		"pubsub":  IpnsPubsubCmd,
+		"publish":   PublishCmd,
+		"resolve":   IpnsCmd,
+		"pubsub":    IpnsPubsubCmd,
+		"republish": IpnsRepublishCmd,
 	},
 }
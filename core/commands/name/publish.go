@@ -26,6 +26,7 @@ const (
 	ttlOptionName          = "ttl"
 	keyOptionName          = "key"
 	quieterOptionName      = "quieter"
+	skipDHTOptionName      = "skip-dht"
 )
 
 var PublishCmd = &cmds.Command{
@@ -81,6 +82,7 @@ Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by
 		cmdkit.StringOption(ttlOptionName, "Time duration this record should be cached for (caution: experimental)."),
 		cmdkit.StringOption(keyOptionName, "k", "Name of the key to be used or a valid PeerID, as listed by 'ipfs key list -l'. Default: <<default>>.").WithDefault("self"),
 		cmdkit.BoolOption(quieterOptionName, "Q", "Write only final hash."),
+		cmdkit.BoolOption(skipDHTOptionName, "Only put the record to the local datastore and pubsub, leaving the DHT to the next republication."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env)
@@ -89,6 +91,7 @@ Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by
 		}
 
 		allowOffline, _ := req.Options[allowOfflineOptionName].(bool)
+		skipDHT, _ := req.Options[skipDHTOptionName].(bool)
 		kname, _ := req.Options[keyOptionName].(string)
 
 		validTimeOpt, _ := req.Options[lifeTimeOptionName].(string)
@@ -101,6 +104,7 @@ Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by
 			options.Name.AllowOffline(allowOffline),
 			options.Name.Key(kname),
 			options.Name.ValidTime(validTime),
+			options.Name.SkipDHT(skipDHT),
 		}
 
 		if ttl, found := req.Options[ttlOptionName].(string); found {
@@ -0,0 +1,138 @@
+package name
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	cmdenv "mbfs/go-mbfs/core/commands/cmdenv"
+	republisher "mbfs/go-mbfs/namesys/republisher"
+
+	cmds "mbfs/go-mbfs/gx/Qma6uuSyjkecGhMFFLfzyJDPyoDtNJSHJNweDccZhaWkgU/go-ipfs-cmds"
+	cmdkit "mbfs/go-mbfs/gx/Qmde5VP1qUkyQXKCfmEUA7bP64V2HAptbJ7phuPp7jXWwg/go-ipfs-cmdkit"
+)
+
+var errNoRepublisher = errors.New("the republisher only runs in online mode. Try running 'ipfs daemon' first")
+
+type republishStatus struct {
+	Keys []*republisher.KeyStatus
+}
+
+// IpnsRepublishCmd is the subcommand inspecting and driving the republisher
+var IpnsRepublishCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Inspect and trigger the republication of IPNS records.",
+		ShortDescription: `
+The daemon republishes the last record of each key every Ipns.RepublishPeriod,
+or the period set for the key in Ipns.RepublishKeys, except for the keys listed
+in Ipns.RepublishExclude. A failed republication is retried after a backoff
+without holding up the other keys.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"status": republishStatusCmd,
+		"now":    republishNowCmd,
+	},
+}
+
+var republishStatusCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show when the record of each key was and will be republished.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if n.IpnsRepub == nil {
+			return errNoRepublisher
+		}
+
+		keys, err := n.IpnsRepub.Status()
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &republishStatus{Keys: keys})
+	},
+	Type: republishStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *republishStatus) error {
+			return writeRepublishStatus(w, out.Keys...)
+		}),
+	},
+}
+
+var republishNowCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Republish the record of a key right away.",
+		ShortDescription: `
+Republishes the last record of the given key, 'self' for the key of the node,
+even if the key is excluded from the republications. The next republication
+is scheduled from now.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("key", true, false, "The name of the key to republish."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if n.IpnsRepub == nil {
+			return errNoRepublisher
+		}
+
+		s, err := n.IpnsRepub.RepublishNow(req.Context, req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, s)
+	},
+	Type: republisher.KeyStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *republisher.KeyStatus) error {
+			return writeRepublishStatus(w, s)
+		}),
+	},
+}
+
+func writeRepublishStatus(w io.Writer, keys ...*republisher.KeyStatus) error {
+	tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tINTERVAL\tLAST PUBLISH\tNEXT PUBLISH\tEOL\tERROR")
+	for _, s := range keys {
+		next := formatRepublishTime(s.NextPublish)
+		switch {
+		case s.ID == "":
+			// the key couldn't be loaded
+			next = "-"
+		case !s.Published:
+			next = "no record"
+		case s.Excluded:
+			next = "excluded"
+		case s.NextPublish.IsZero():
+			next = "next round"
+		}
+
+		lastErr := "-"
+		if s.LastError != "" {
+			lastErr = s.LastError
+			if s.Failures > 1 {
+				lastErr = fmt.Sprintf("%s (%d failures)", lastErr, s.Failures)
+			}
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Interval,
+			formatRepublishTime(s.LastPublish), next, formatRepublishTime(s.EOL), lastErr)
+	}
+	return tw.Flush()
+}
+
+func formatRepublishTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
@@ -20,6 +20,8 @@ import (
 	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
 	protocol "mbfs/go-mbfs/gx/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
 	cmds "mbfs/go-mbfs/gx/Qma6uuSyjkecGhMFFLfzyJDPyoDtNJSHJNweDccZhaWkgU/go-ipfs-cmds"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
 	cmdkit "mbfs/go-mbfs/gx/Qmde5VP1qUkyQXKCfmEUA7bP64V2HAptbJ7phuPp7jXWwg/go-ipfs-cmdkit"
 )
 
@@ -31,6 +33,12 @@ type P2PListenerInfoOutput struct {
 	Protocol      string
 	ListenAddress string
 	TargetAddress string
+
+	AllowedPeers []string `json:",omitempty"`
+	MaxStreams   int
+	MaxBytes     int64
+	Streams      int
+	Bytes        int64
 }
 
 // P2PStreamInfoOutput is output type of streams command
@@ -53,6 +61,10 @@ type P2PStreamsOutput struct {
 
 const (
 	allowCustomProtocolOptionName = "allow-custom-protocol"
+	allowPeerOptionName           = "allow-peer"
+	maxStreamsOptionName          = "max-streams"
+	maxBytesOptionName            = "max-bytes"
+	p2pPersistOptionName          = "persist"
 )
 
 var resolveTimeout = 10 * time.Second
@@ -86,10 +98,24 @@ Forward connections made to <listen-address> to <target-address>.
 <protocol> specifies the libp2p protocol name to use for libp2p
 connections and/or handlers. It must be prefixed with '` + P2PProtoPrefix + `'.
 
+If <listen-address> is a UDP address, datagrams are forwarded instead, one
+libp2p stream per sending address. The remote service must then be created
+with a UDP <target-address> as well.
+
+--max-streams limits the number of concurrently forwarded connections and
+--max-bytes the total traffic through this forward.
+
+With --persist, the forward is saved to the P2P section of the config and
+restored when the daemon starts. The remote peer of a persisted forward is
+kept connected and redialed with backoff when the connection drops.
+
 Example:
   ipfs p2p forward ` + P2PProtoPrefix + `myproto /ip4/127.0.0.1/tcp/4567 /ipfs/QmPeer
     - Forward connections to 127.0.0.1:4567 to '` + P2PProtoPrefix + `myproto' service on /ipfs/QmPeer
 
+  ipfs p2p forward ` + P2PProtoPrefix + `dns /ip4/127.0.0.1/udp/5353 /ipfs/QmPeer
+    - Forward datagrams sent to 127.0.0.1:5353 to '` + P2PProtoPrefix + `dns' service on /ipfs/QmPeer
+
 `,
 	},
 	Arguments: []cmdkit.Argument{
@@ -99,6 +125,9 @@ Example:
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption(allowCustomProtocolOptionName, "Don't require /x/ prefix"),
+		cmdkit.IntOption(maxStreamsOptionName, "Maximum number of concurrent streams. 0 means no limit.").WithDefault(0),
+		cmdkit.Int64Option(maxBytesOptionName, "Maximum number of bytes to forward. 0 means no limit.").WithDefault(int64(0)),
+		cmdkit.BoolOption(p2pPersistOptionName, "Save the forward to the config and restore it on startup."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := p2pGetNode(env)
@@ -106,6 +135,11 @@ Example:
 			return err
 		}
 
+		limits, err := p2pLimitsFromOptions(req, false)
+		if err != nil {
+			return err
+		}
+
 		protoOpt := req.Arguments[0]
 		listenOpt := req.Arguments[1]
 		targetOpt := req.Arguments[2]
@@ -128,7 +162,32 @@ Example:
 			return errors.New("protocol name must be within '" + P2PProtoPrefix + "' namespace")
 		}
 
-		return forwardLocal(n.Context(), n.P2P, n.Peerstore, proto, listen, targets)
+		l, err := forwardLocal(n.Context(), n.P2P, n.Peerstore, proto, listen, targets, limits)
+		if err != nil {
+			return err
+		}
+
+		persist, _ := req.Options[p2pPersistOptionName].(bool)
+		if !persist {
+			return nil
+		}
+
+		err = n.P2P.KeepConnected(l)
+		if err == nil {
+			err = p2pPersistForward(n, config.P2PForward{
+				Protocol:      string(proto),
+				ListenAddress: l.ListenAddress().String(),
+				TargetAddress: targets[0].String(),
+				MaxStreams:    limits.MaxStreams,
+				MaxBytes:      limits.MaxBytes,
+			})
+		}
+		if err != nil {
+			// not to leave running a forward reported as failed
+			n.P2P.ListenersLocal.Close(func(o p2p.Listener) bool { return o == l })
+			return err
+		}
+		return nil
 	},
 }
 
@@ -171,10 +230,24 @@ Create libp2p service and forward connections made to <target-address>.
 
 <protocol> specifies the libp2p handler name. It must be prefixed with '` + P2PProtoPrefix + `'.
 
+By default any peer that knows the protocol name can connect. Use
+--allow-peer to restrict the service to a comma separated list of peer IDs.
+--max-streams limits the number of concurrent streams and --max-bytes the
+total traffic the service may carry.
+
+If <target-address> is a UDP address, streams carry datagrams sent by a
+'ipfs p2p forward' bound to a UDP address.
+
+With --persist, the service is saved to the P2P section of the config and
+restored when the daemon starts.
+
 Example:
   ipfs p2p listen ` + P2PProtoPrefix + `myproto /ip4/127.0.0.1/tcp/1234
     - Forward connections to 'myproto' libp2p service to 127.0.0.1:1234
 
+  ipfs p2p listen --allow-peer=QmPeerA,QmPeerB ` + P2PProtoPrefix + `myproto /ip4/127.0.0.1/tcp/1234
+    - Same as above, but only QmPeerA and QmPeerB may connect
+
 `,
 	},
 	Arguments: []cmdkit.Argument{
@@ -183,6 +256,10 @@ Example:
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption(allowCustomProtocolOptionName, "Don't require /x/ prefix"),
+		cmdkit.StringOption(allowPeerOptionName, "Comma separated list of peer IDs allowed to connect."),
+		cmdkit.IntOption(maxStreamsOptionName, "Maximum number of concurrent streams. 0 means no limit.").WithDefault(0),
+		cmdkit.Int64Option(maxBytesOptionName, "Maximum number of bytes to forward. 0 means no limit.").WithDefault(int64(0)),
+		cmdkit.BoolOption(p2pPersistOptionName, "Save the service to the config and restore it on startup."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := p2pGetNode(env)
@@ -190,6 +267,11 @@ Example:
 			return err
 		}
 
+		limits, err := p2pLimitsFromOptions(req, true)
+		if err != nil {
+			return err
+		}
+
 		protoOpt := req.Arguments[0]
 		targetOpt := req.Arguments[1]
 
@@ -214,10 +296,64 @@ Example:
 			return errors.New("protocol name must be within '" + P2PProtoPrefix + "' namespace")
 		}
 
-		return forwardRemote(n.Context(), n.P2P, proto, target)
+		l, err := forwardRemote(n.Context(), n.P2P, proto, target, limits)
+		if err != nil {
+			return err
+		}
+
+		persist, _ := req.Options[p2pPersistOptionName].(bool)
+		if !persist {
+			return nil
+		}
+
+		lc := config.P2PListener{
+			Protocol:      string(proto),
+			TargetAddress: target.String(),
+			MaxStreams:    limits.MaxStreams,
+			MaxBytes:      limits.MaxBytes,
+		}
+		for _, p := range limits.AllowedPeers {
+			lc.AllowedPeers = append(lc.AllowedPeers, p.Pretty())
+		}
+		if err := p2pPersistListener(n, lc); err != nil {
+			// not to leave running a service reported as failed
+			n.P2P.ListenersP2P.Close(func(o p2p.Listener) bool { return o == l })
+			return err
+		}
+		return nil
 	},
 }
 
+// p2pLimitsFromOptions reads listener limits from the request options.
+// Peer allow-lists only apply to p2p listeners.
+func p2pLimitsFromOptions(req *cmds.Request, allowPeers bool) (p2p.ListenerLimits, error) {
+	var limits p2p.ListenerLimits
+
+	limits.MaxStreams, _ = req.Options[maxStreamsOptionName].(int)
+	limits.MaxBytes, _ = req.Options[maxBytesOptionName].(int64)
+	if limits.MaxStreams < 0 || limits.MaxBytes < 0 {
+		return limits, errors.New("limits must not be negative")
+	}
+
+	if !allowPeers {
+		return limits, nil
+	}
+
+	allowOpt, _ := req.Options[allowPeerOptionName].(string)
+	for _, s := range strings.Split(allowOpt, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := peer.IDB58Decode(s)
+		if err != nil {
+			return limits, fmt.Errorf("invalid peer ID %q: %s", s, err)
+		}
+		limits.AllowedPeers = append(limits.AllowedPeers, id)
+	}
+	return limits, nil
+}
+
 // checkPort checks whether target multiaddr contains tcp or udp protocol
 // and whether the port is equal to 0
 func checkPort(target ma.Multiaddr) error {
@@ -253,26 +389,106 @@ func checkPort(target ma.Multiaddr) error {
 }
 
 // forwardRemote forwards libp2p service connections to a manet address
-func forwardRemote(ctx context.Context, p *p2p.P2P, proto protocol.ID, target ma.Multiaddr) error {
+func forwardRemote(ctx context.Context, p *p2p.P2P, proto protocol.ID, target ma.Multiaddr, limits p2p.ListenerLimits) (p2p.Listener, error) {
 	// TODO: return some info
-	_, err := p.ForwardRemote(ctx, proto, target)
-	return err
+	return p.ForwardRemote(ctx, proto, target, limits)
 }
 
 // forwardLocal forwards local connections to a libp2p service
-func forwardLocal(ctx context.Context, p *p2p.P2P, ps pstore.Peerstore, proto protocol.ID, bindAddr ma.Multiaddr, addrs []ipfsaddr.IPFSAddr) error {
+func forwardLocal(ctx context.Context, p *p2p.P2P, ps pstore.Peerstore, proto protocol.ID, bindAddr ma.Multiaddr, addrs []ipfsaddr.IPFSAddr, limits p2p.ListenerLimits) (p2p.Listener, error) {
 	for _, addr := range addrs {
 		ps.AddAddr(addr.ID(), addr.Multiaddr(), pstore.TempAddrTTL)
 	}
 	// TODO: return some info
 	// the length of the addrs must large than 0
 	// peerIDs in addr must be the same and choose addr[0] to connect
-	_, err := p.ForwardLocal(ctx, addrs[0].ID(), proto, bindAddr)
-	return err
+	return p.ForwardLocal(ctx, addrs[0].ID(), proto, bindAddr, limits)
+}
+
+// p2pPersistListener saves a p2p listener to the config, replacing any
+// listener persisted for the same protocol
+func p2pPersistListener(n *core.IpfsNode, lc config.P2PListener) error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	listeners := []config.P2PListener{lc}
+	for _, old := range cfg.P2P.Listeners {
+		if old.Protocol != lc.Protocol {
+			listeners = append(listeners, old)
+		}
+	}
+	cfg.P2P.Listeners = listeners
+	return n.Repo.SetConfig(cfg)
+}
+
+// p2pPersistForward saves a forward to the config, replacing any forward
+// persisted for the same listen address
+func p2pPersistForward(n *core.IpfsNode, fc config.P2PForward) error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	forwards := []config.P2PForward{fc}
+	for _, old := range cfg.P2P.Forwards {
+		if old.ListenAddress != fc.ListenAddress {
+			forwards = append(forwards, old)
+		}
+	}
+	cfg.P2P.Forwards = forwards
+	return n.Repo.SetConfig(cfg)
+}
+
+// p2pUnpersist removes the config entries matching the options of 'ipfs p2p
+// close'. The entries are matched by themselves rather than by the listeners
+// closed, so the ones that failed to restore at startup can be removed too.
+func p2pUnpersist(n *core.IpfsNode, match func(proto protocol.ID, listen, target ma.Multiaddr) bool) error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	self, err := ma.NewMultiaddr("/ipfs/" + n.Identity.Pretty())
+	if err != nil {
+		return err
+	}
+
+	var listeners []config.P2PListener
+	for _, lc := range cfg.P2P.Listeners {
+		target, err := ma.NewMultiaddr(lc.TargetAddress)
+		if err != nil || !match(protocol.ID(lc.Protocol), self, target) {
+			listeners = append(listeners, lc)
+		}
+	}
+
+	var forwards []config.P2PForward
+	for _, fc := range cfg.P2P.Forwards {
+		listen, err := ma.NewMultiaddr(fc.ListenAddress)
+		if err != nil {
+			forwards = append(forwards, fc)
+			continue
+		}
+		addr, err := ipfsaddr.ParseString(fc.TargetAddress)
+		if err != nil {
+			forwards = append(forwards, fc)
+			continue
+		}
+		target, err := ma.NewMultiaddr("/ipfs/" + addr.ID().Pretty())
+		if err != nil || !match(protocol.ID(fc.Protocol), listen, target) {
+			forwards = append(forwards, fc)
+		}
+	}
+
+	cfg.P2P.Listeners = listeners
+	cfg.P2P.Forwards = forwards
+	return n.Repo.SetConfig(cfg)
 }
 
 const (
 	p2pHeadersOptionName = "headers"
+	p2pLimitsOptionName  = "limits"
 )
 
 var p2pLsCmd = &cmds.Command{
@@ -281,6 +497,7 @@ var p2pLsCmd = &cmds.Command{
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption(p2pHeadersOptionName, "v", "Print table headers (Protocol, Listen, Target)."),
+		cmdkit.BoolOption(p2pLimitsOptionName, "Also print allowed peers, limits and usage."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := p2pGetNode(env)
@@ -292,21 +509,13 @@ var p2pLsCmd = &cmds.Command{
 
 		n.P2P.ListenersLocal.Lock()
 		for _, listener := range n.P2P.ListenersLocal.Listeners {
-			output.Listeners = append(output.Listeners, P2PListenerInfoOutput{
-				Protocol:      string(listener.Protocol()),
-				ListenAddress: listener.ListenAddress().String(),
-				TargetAddress: listener.TargetAddress().String(),
-			})
+			output.Listeners = append(output.Listeners, p2pListenerInfo(listener))
 		}
 		n.P2P.ListenersLocal.Unlock()
 
 		n.P2P.ListenersP2P.Lock()
 		for _, listener := range n.P2P.ListenersP2P.Listeners {
-			output.Listeners = append(output.Listeners, P2PListenerInfoOutput{
-				Protocol:      string(listener.Protocol()),
-				ListenAddress: listener.ListenAddress().String(),
-				TargetAddress: listener.TargetAddress().String(),
-			})
+			output.Listeners = append(output.Listeners, p2pListenerInfo(listener))
 		}
 		n.P2P.ListenersP2P.Unlock()
 
@@ -316,13 +525,30 @@ var p2pLsCmd = &cmds.Command{
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *P2PLsOutput) error {
 			headers, _ := req.Options[p2pHeadersOptionName].(bool)
+			limits, _ := req.Options[p2pLimitsOptionName].(bool)
 			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
 			for _, listener := range out.Listeners {
 				if headers {
-					fmt.Fprintln(tw, "Protocol\tListen Address\tTarget Address")
+					if limits {
+						fmt.Fprintln(tw, "Protocol\tListen Address\tTarget Address\tStreams\tBytes\tAllowed Peers")
+					} else {
+						fmt.Fprintln(tw, "Protocol\tListen Address\tTarget Address")
+					}
+				}
+
+				if !limits {
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", listener.Protocol, listener.ListenAddress, listener.TargetAddress)
+					continue
 				}
 
-				fmt.Fprintf(tw, "%s\t%s\t%s\n", listener.Protocol, listener.ListenAddress, listener.TargetAddress)
+				allowed := "*"
+				if len(listener.AllowedPeers) > 0 {
+					allowed = strings.Join(listener.AllowedPeers, ",")
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", listener.Protocol, listener.ListenAddress, listener.TargetAddress,
+					p2pUsageString(int64(listener.Streams), int64(listener.MaxStreams)),
+					p2pUsageString(listener.Bytes, listener.MaxBytes),
+					allowed)
 			}
 			tw.Flush()
 
@@ -331,6 +557,34 @@ var p2pLsCmd = &cmds.Command{
 	},
 }
 
+func p2pListenerInfo(l p2p.Listener) P2PListenerInfoOutput {
+	limits := l.Limits()
+	streams, bytes := l.Usage()
+
+	info := P2PListenerInfoOutput{
+		Protocol:      string(l.Protocol()),
+		ListenAddress: l.ListenAddress().String(),
+		TargetAddress: l.TargetAddress().String(),
+
+		MaxStreams: limits.MaxStreams,
+		MaxBytes:   limits.MaxBytes,
+		Streams:    streams,
+		Bytes:      bytes,
+	}
+	for _, p := range limits.AllowedPeers {
+		info.AllowedPeers = append(info.AllowedPeers, p.Pretty())
+	}
+	return info
+}
+
+// p2pUsageString formats usage against a limit, where 0 means unlimited
+func p2pUsageString(used, limit int64) string {
+	if limit == 0 {
+		return strconv.FormatInt(used, 10)
+	}
+	return strconv.FormatInt(used, 10) + "/" + strconv.FormatInt(limit, 10)
+}
+
 const (
 	p2pAllOptionName           = "all"
 	p2pProtocolOptionName      = "protocol"
@@ -347,6 +601,7 @@ var p2pCloseCmd = &cmds.Command{
 		cmdkit.StringOption(p2pProtocolOptionName, "p", "Match protocol name"),
 		cmdkit.StringOption(p2pListenAddressOptionName, "l", "Match listen address"),
 		cmdkit.StringOption(p2pTargetAddressOptionName, "t", "Match target address"),
+		cmdkit.BoolOption(p2pPersistOptionName, "Also remove the matching listeners from the config, running or not."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := p2pGetNode(env)
@@ -379,25 +634,35 @@ var p2pCloseCmd = &cmds.Command{
 			return errors.New("can't combine --all with other matching options")
 		}
 
-		match := func(listener p2p.Listener) bool {
+		matchAddrs := func(lproto protocol.ID, llisten, ltarget ma.Multiaddr) bool {
 			if closeAll {
 				return true
 			}
-			if p && proto != listener.Protocol() {
+			if p && proto != lproto {
 				return false
 			}
-			if l && !listen.Equal(listener.ListenAddress()) {
+			if l && !listen.Equal(llisten) {
 				return false
 			}
-			if t && !target.Equal(listener.TargetAddress()) {
+			if t && !target.Equal(ltarget) {
 				return false
 			}
 			return true
 		}
+		match := func(listener p2p.Listener) bool {
+			return matchAddrs(listener.Protocol(), listener.ListenAddress(), listener.TargetAddress())
+		}
 
 		done := n.P2P.ListenersLocal.Close(match)
 		done += n.P2P.ListenersP2P.Close(match)
 
+		persist, _ := req.Options[p2pPersistOptionName].(bool)
+		if persist {
+			if err := p2pUnpersist(n, matchAddrs); err != nil {
+				return err
+			}
+		}
+
 		return cmds.EmitOnce(res, done)
 	},
 	Type: int(0),
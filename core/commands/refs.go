@@ -12,6 +12,7 @@ import (
 
 	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
 	path "mbfs/go-mbfs/gx/QmRG3XuGwT7GYuAqgWDJBKTzdaHMwAnc1x7J2KHEXNHxzG/go-path"
+	unixfspb "mbfs/go-mbfs/gx/QmXLCwhHh7bxRsBnCKNE9BAN87V44aSxXLquZYTtjr6fZ3/go-unixfs/pb"
 	cmds "mbfs/go-mbfs/gx/Qma6uuSyjkecGhMFFLfzyJDPyoDtNJSHJNweDccZhaWkgU/go-ipfs-cmds"
 	ipld "mbfs/go-mbfs/gx/QmcKKBwfz6FyQdHR2jsXrrF6XeSBXYL86anmWNewpFpoF5/go-ipld-format"
 	cmdkit "mbfs/go-mbfs/gx/Qmde5VP1qUkyQXKCfmEUA7bP64V2HAptbJ7phuPp7jXWwg/go-ipfs-cmdkit"
@@ -52,6 +53,18 @@ with the following format:
   <link base58 hash>
 
 NOTE: List all references recursively by using the flag '-r'.
+`,
+		LongDescription: `
+Lists the hashes of all the links an IPFS or IPNS object(s) contains,
+with the following format:
+
+  <link base58 hash>
+
+NOTE: List all references recursively by using the flag '-r'.
+
+Refs are written as they are fetched. With --enc=json, each ref is written
+as one JSON object per line, which also carries the source, destination,
+link name, size, unixfs type and mode of the edge.
 `,
 	},
 	Subcommands: map[string]*cmds.Command{
@@ -179,6 +192,18 @@ func objectsForPaths(ctx context.Context, n *core.IpfsNode, paths []string) ([]i
 type RefWrapper struct {
 	Ref string
 	Err string
+
+	// Link describes the edge a ref was written for. It is only set by
+	// 'ipfs refs', not by the commands listing local refs.
+	Link *RefLink `json:",omitempty"`
+}
+
+// RefLink is the unixfs metadata of an edge written by RefWriter
+type RefLink struct {
+	Src, Dst, Name string
+	Size           uint64
+	Type           unixfspb.Data_DataType
+	Mode           uint32
 }
 
 type RefWriter struct {
@@ -228,7 +253,7 @@ func (rw *RefWriter) writeRefsRecursive(n ipld.Node, depth int) (int, error) {
 
 		// Write this node if not done before (or !Unique)
 		if shouldWrite {
-			if err := rw.WriteEdge(nc, lc, n.Links()[i].Name); err != nil {
+			if err := rw.WriteEdge(nc, n.Links()[i], nd); err != nil {
 				return count, err
 			}
 			count++
@@ -308,8 +333,9 @@ func (rw *RefWriter) visit(c cid.Cid, depth int) (bool, bool) {
 	return !atMaxDepth, !ok
 }
 
-// Write one edge
-func (rw *RefWriter) WriteEdge(from, to cid.Cid, linkname string) error {
+// WriteEdge writes the edge from the node with the given cid through link to
+// the node nd it points to.
+func (rw *RefWriter) WriteEdge(from cid.Cid, link *ipld.Link, nd ipld.Node) error {
 	if rw.Ctx != nil {
 		select {
 		case <-rw.Ctx.Done(): // just in case.
@@ -318,6 +344,8 @@ func (rw *RefWriter) WriteEdge(from, to cid.Cid, linkname string) error {
 		}
 	}
 
+	to, linkname := link.Cid, link.Name
+
 	var s string
 	switch {
 	case rw.PrintFmt != "":
@@ -329,5 +357,16 @@ func (rw *RefWriter) WriteEdge(from, to cid.Cid, linkname string) error {
 		s += to.String()
 	}
 
-	return rw.res.Emit(&RefWrapper{Ref: s})
+	t := unixfsType(nd)
+	return rw.res.Emit(&RefWrapper{
+		Ref: s,
+		Link: &RefLink{
+			Src:  from.String(),
+			Dst:  to.String(),
+			Name: linkname,
+			Size: link.Size,
+			Type: t,
+			Mode: unixfsMode(t),
+		},
+	})
 }
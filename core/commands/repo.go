@@ -11,10 +11,12 @@ import (
 	"strings"
 	"sync"
 	"text/tabwriter"
+	"time"
 
 	cmdenv "mbfs/go-mbfs/core/commands/cmdenv"
 	corerepo "mbfs/go-mbfs/core/corerepo"
 	fsrepo "mbfs/go-mbfs/repo/fsrepo"
+	mfsr "mbfs/go-mbfs/repo/fsrepo/migrations"
 
 	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
 	bstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
@@ -41,6 +43,12 @@ var RepoCmd = &cmds.Command{
 		"fsck":    repoFsckCmd,
 		"version": repoVersionCmd,
 		"verify":  repoVerifyCmd,
+
+		"migrate":           repoMigrateCmd,
+		"migrate-datastore": repoMigrateDatastoreCmd,
+
+		"backup":  repoBackupCmd,
+		"restore": repoRestoreCmd,
 	},
 }
 
@@ -210,21 +218,60 @@ Version         string The repo version.
 	},
 }
 
+const (
+	fsckFixOptionName          = "fix"
+	fsckFetchOptionName        = "fetch"
+	fsckFetchTimeoutOptionName = "fetch-timeout"
+)
+
 var repoFsckCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
-		Tagline: "Remove repo lockfiles.",
+		Tagline: "Check the repo for inconsistencies and repair them.",
 		ShortDescription: `
-'ipfs repo fsck' is a plumbing command that will remove repo and level db
-lockfiles, as well as the api file. This command can only run when no ipfs
-daemons are running.
+'ipfs repo fsck' removes the repo and level db lockfiles, as well as the api
+file, then checks the repo for:
+
+  corrupt-block     blocks whose data doesn't match their hash
+  filestore-orphan  filestore entries whose file is gone or changed
+  filestore-entry   filestore entries which cannot be verified
+  pin-set           parts of the pin set which cannot be read
+  incomplete-pin    pins with blocks missing from their dag
+  files-root        an unreadable files (mfs) root
+
+With --fix, corrupt blocks and orphaned filestore entries are removed, a new
+pin set is written with the pins which could be read, and the files root is
+reset to an empty directory; the old pin set and files root are kept under
+the /local/pins-recovery and /local/filesroot-recovery datastore keys.
+Missing and corrupt blocks are fetched from the network if --fetch is set
+too, otherwise they are only reported. Filestore entries which cannot be
+verified, for instance because their file cannot be read, are only reported.
+
+The report can be read by programs with --enc=json. The command fails if
+problems remain. It can only run when no ipfs daemons are running.
 `,
 	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption(fsckFixOptionName, "Repair the problems found."),
+		cmdkit.BoolOption(fsckFetchOptionName, "Fetch missing and corrupt blocks from the network when repairing."),
+		cmdkit.StringOption(fsckFetchTimeoutOptionName, "Time to wait for each block fetched.").WithDefault("1m"),
+	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		configRoot, err := cmdenv.GetConfigRoot(env)
 		if err != nil {
 			return err
 		}
 
+		fix, _ := req.Options[fsckFixOptionName].(bool)
+		fetch, _ := req.Options[fsckFetchOptionName].(bool)
+		timeoutStr, _ := req.Options[fsckFetchTimeoutOptionName].(string)
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			return fmt.Errorf("invalid fetch timeout: %s", err)
+		}
+		if fetch && !fix {
+			return fmt.Errorf("--%s requires --%s", fsckFetchOptionName, fsckFixOptionName)
+		}
+
 		dsPath, err := config.DataStorePath(configRoot)
 		if err != nil {
 			return err
@@ -251,12 +298,34 @@ daemons are running.
 			return err
 		}
 
-		return cmds.EmitOnce(res, &MessageOutput{"Lockfiles have been removed.\n"})
+		report, err := corerepo.Fsck(req.Context, configRoot, corerepo.FsckOptions{
+			Fix:          fix,
+			Fetch:        fetch,
+			FetchTimeout: timeout,
+		})
+		if err != nil {
+			return err
+		}
+		if err := res.Emit(report); err != nil {
+			return err
+		}
+		if unfixed := report.Unfixed(); unfixed > 0 {
+			return fmt.Errorf("fsck found %d problems", unfixed)
+		}
+		return nil
 	},
-	Type: MessageOutput{},
+	Type: corerepo.FsckReport{},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *MessageOutput) error {
-			fmt.Fprintf(w, out.Message)
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, report *corerepo.FsckReport) error {
+			fmt.Fprintln(w, "Lockfiles have been removed.")
+			for _, p := range report.Problems {
+				fmt.Fprintf(w, "%s %s: %s\n", p.Kind, p.Key, p.Detail)
+				if p.Action != "" {
+					fmt.Fprintf(w, "  %s\n", p.Action)
+				}
+			}
+			fmt.Fprintf(w, "Checked %d blocks and %d pins, %d problems found, %d fixed.\n",
+				report.Blocks, report.Pins, len(report.Problems), len(report.Problems)-report.Unfixed())
 			return nil
 		}),
 	},
@@ -403,3 +472,305 @@ var repoVersionCmd = &cmds.Command{
 		}),
 	},
 }
+
+const (
+	migrateToOptionName        = "to"
+	migrateBatchSizeOptionName = "batch-size"
+	migrateStatusOptionName    = "status"
+	migrateAbortOptionName     = "abort"
+)
+
+var repoMigrateDatastoreCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Move the repo to another datastore configuration.",
+		ShortDescription: `
+'ipfs repo migrate-datastore --to <profile>' copies the content of the
+datastore into a new datastore, configured as the given config profile
+(e.g. badgerds or default-datastore) would, and switches the repo to it.
+`,
+		LongDescription: `
+'ipfs repo migrate-datastore --to <profile>' copies the content of the
+datastore into a new datastore, configured as the given config profile
+(e.g. badgerds or default-datastore) would, and switches the repo to it.
+
+The copy is staged in the migrate-datastore directory of the repo and can
+run while the daemon is running. It is done in batches, and an interrupted
+copy resumes where it stopped when the command is run again.
+
+The switch happens the next time the repo is opened, e.g. when the daemon
+is restarted: the keys changed since the copy are synced, the new datastore
+replaces the old one in the repo and its datastore_spec, and its content is
+verified against the old datastore. The old datastore is only deleted once
+verified, the switch is rolled back if anything fails.
+
+Use --status to show the progress of the migration, and --abort to remove a
+staged migration. Aborting requires the daemon to be stopped, and is refused
+while the repo switches to the staged datastore.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption(migrateToOptionName, "Config profile describing the new datastore."),
+		cmdkit.IntOption(migrateBatchSizeOptionName, "Number of keys copied per batch.").WithDefault(fsrepo.DefaultMigrationBatchSize),
+		cmdkit.BoolOption(migrateStatusOptionName, "Show the state of the migration."),
+		cmdkit.BoolOption(migrateAbortOptionName, "Remove the staged migration."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		configRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+
+		if status, _ := req.Options[migrateStatusOptionName].(bool); status {
+			st, err := fsrepo.MigrationStatus(configRoot)
+			if err != nil {
+				return err
+			}
+			if st == nil {
+				return errors.New("no datastore migration was started")
+			}
+			return cmds.EmitOnce(res, st)
+		}
+
+		if abort, _ := req.Options[migrateAbortOptionName].(bool); abort {
+			return fsrepo.AbortDatastoreMigration(configRoot)
+		}
+
+		name, _ := req.Options[migrateToOptionName].(string)
+		if name == "" {
+			return errors.New("the --to option is required")
+		}
+		profile, ok := config.Profiles[name]
+		if !ok {
+			return fmt.Errorf("%s is not a profile", name)
+		}
+
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+
+		// apply the profile to a copy of the config
+		m, err := config.ToMap(cfg)
+		if err != nil {
+			return err
+		}
+		newCfg, err := config.FromMap(m)
+		if err != nil {
+			return err
+		}
+		if err := profile.Transform(newCfg); err != nil {
+			return err
+		}
+
+		batchSize, _ := req.Options[migrateBatchSizeOptionName].(int)
+		return fsrepo.StageDatastoreMigration(req.Context, configRoot, n.Repo.Datastore(), name, newCfg.Datastore.Spec, batchSize, func(st *fsrepo.MigrationState) {
+			res.Emit(st)
+		})
+	},
+	Type: fsrepo.MigrationState{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, st *fsrepo.MigrationState) error {
+			if status, _ := req.Options[migrateStatusOptionName].(bool); status {
+				fmt.Fprintf(w, "Profile: %s\n", st.Profile)
+				fmt.Fprintf(w, "Phase: %s\n", st.Phase)
+				fmt.Fprintf(w, "Copied: %d\n", st.Copied)
+				fmt.Fprintf(w, "Skipped: %d\n", st.Skipped)
+				fmt.Fprintf(w, "Updated: %s\n", st.Updated.Format(time.RFC3339))
+				if st.Error != "" {
+					fmt.Fprintf(w, "Error: %s\n", st.Error)
+				}
+				return nil
+			}
+
+			fmt.Fprintf(w, "%d keys copied, %d already present.\r", st.Copied, st.Skipped)
+			if st.Phase == fsrepo.MigrationReady {
+				fmt.Fprintln(w)
+				fmt.Fprintln(w, "The repo will switch to the new datastore the next time it is opened,")
+				fmt.Fprintln(w, "restart the daemon to complete the migration.")
+			}
+			return nil
+		}),
+	},
+}
+
+const (
+	migrateDryRunOptionName   = "dry-run"
+	migrateExternalOptionName = "external"
+)
+
+var repoMigrateCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Upgrade the repo to the version of this program.",
+		ShortDescription: `
+'ipfs repo migrate' runs the built-in migrations upgrading the repo to the
+version this program expects. The version, config and datastore_spec files
+are saved in the migration-backup directory of the repo first, and restored
+if a migration fails. This command can only run when no ipfs daemons are
+running.
+
+Use --dry-run to list the migrations without running them. The
+fs-repo-migrations binary is only used, and downloaded if it isn't in the
+PATH, for the versions without a built-in migration when --external is set.
+The migrations from version 5 onwards are built in.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption(migrateDryRunOptionName, "Only show the migrations which would run."),
+		cmdkit.BoolOption(migrateExternalOptionName, "Use fs-repo-migrations for the migrations which are not built in."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		configRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+
+		dryRun, _ := req.Options[migrateDryRunOptionName].(bool)
+		external, _ := req.Options[migrateExternalOptionName].(bool)
+		return fsrepo.Migrate(configRoot, mfsr.Options{
+			DryRun:   dryRun,
+			External: external,
+			Out:      &messageWriter{res},
+		})
+	},
+	Type: MessageOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *MessageOutput) error {
+			fmt.Fprint(w, out.Message)
+			return nil
+		}),
+	},
+}
+
+// messageWriter emits what is written to it as MessageOutputs
+type messageWriter struct {
+	res cmds.ResponseEmitter
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if err := w.res.Emit(&MessageOutput{string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+const (
+	backupBlocksOptionName = "blocks"
+	restoreForceOptionName = "force"
+)
+
+var repoBackupCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Save a snapshot of the repo.",
+		ShortDescription: `
+'ipfs repo backup' writes a snapshot of the config, keys, pin set, files
+(mfs) root and IPNS records of the repo to the directory <dest>, which must
+not exist or be empty. Garbage collection is held off while the snapshot is
+taken.
+
+With --blocks, the blocks of the pins and of the files root are included
+too, as a CAR file. Otherwise the restored pins have to be fetched from the
+network again.
+
+The snapshot holds the private keys of the node: keep it safe.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("dest", true, false, "Directory to write the snapshot to."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption(backupBlocksOptionName, "Include the pinned blocks as a CAR file."),
+	},
+	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		// the daemon may run in another directory
+		dest, err := filepath.Abs(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		req.Arguments[0] = dest
+		return nil
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		configRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+
+		blocks, _ := req.Options[backupBlocksOptionName].(bool)
+		m, err := corerepo.Backup(req.Context, n, configRoot, req.Arguments[0], corerepo.BackupOptions{Blocks: blocks})
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, m)
+	},
+	Type: corerepo.BackupManifest{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, m *corerepo.BackupManifest) error {
+			fmt.Fprintf(w, "Saved %d keys, %d pins and %d IPNS records of %s", m.Keys, m.Pins, m.Records, m.PeerID)
+			if _, ok := m.Files[corerepo.BackupBlocksFile]; ok {
+				fmt.Fprintf(w, ", with %d blocks", m.Blocks)
+			}
+			fmt.Fprintf(w, " to %s\n", req.Arguments[0])
+			if m.Missing > 0 {
+				fmt.Fprintf(w, "warning: %d blocks were missing from the repo and are not in the snapshot\n", m.Missing)
+			}
+			return nil
+		}),
+	},
+}
+
+var repoRestoreCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Rebuild the repo from a snapshot.",
+		ShortDescription: `
+'ipfs repo restore' checks the snapshot written by 'ipfs repo backup' in
+<src> and rebuilds the repo from it: the repo is initialized with the config
+of the snapshot if it doesn't exist, then its keys, blocks, IPNS records,
+files root and pin set are restored. Nothing is changed if the snapshot is
+damaged or incomplete.
+
+Restoring over an existing repo requires --force. Its datastore
+configuration is kept, its keys and pin set are replaced, and the blocks it
+already has are left alone. This command can only run when no ipfs daemons
+are running.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("src", true, false, "Directory holding the snapshot."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption(restoreForceOptionName, "f", "Restore over an existing repo."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		configRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+
+		force, _ := req.Options[restoreForceOptionName].(bool)
+		r, err := corerepo.Restore(req.Context, configRoot, req.Arguments[0], force)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, r)
+	},
+	Type: corerepo.RestoreResult{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, r *corerepo.RestoreResult) error {
+			fmt.Fprintf(w, "Restored %s: %d keys, %d pins, %d IPNS records and %d blocks\n", r.PeerID, r.Keys, r.Pins, r.Records, r.Blocks)
+			if r.FilesRoot != "" {
+				fmt.Fprintf(w, "files root: %s\n", r.FilesRoot)
+			} else {
+				fmt.Fprintln(w, "the files root was not restored, its blocks are not in the snapshot")
+			}
+			return nil
+		}),
+	},
+}
@@ -3,7 +3,6 @@ package commands
 import (
 	"errors"
 
-	lgc "mbfs/go-mbfs/commands/legacy"
 	dag "mbfs/go-mbfs/core/commands/dag"
 	name "mbfs/go-mbfs/core/commands/name"
 	ocmd "mbfs/go-mbfs/core/commands/object"
@@ -108,6 +107,7 @@ var CommandsDaemonCmd = CommandsCmd(Root)
 
 var rootSubcommands = map[string]*cmds.Command{
 	"add":       AddCmd,
+	"alias":     AliasCmd,
 	"bitswap":   BitswapCmd,
 	"block":     BlockCmd,
 	"cat":       CatCmd,
@@ -127,7 +127,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"id":        IDCmd,
 	"key":       KeyCmd,
 	"log":       LogCmd,
-	"ls":        lgc.NewCommand(LsCmd),
+	"ls":        LsCmd,
 	"mount":     MountCmd,
 	"name":      name.NameCmd,
 	"object":    ocmd.ObjectCmd,
@@ -165,7 +165,7 @@ var rootROSubcommands = map[string]*cmds.Command{
 	},
 	"get": GetCmd,
 	"dns": DNSCmd,
-	"ls":  lgc.NewCommand(LsCmd),
+	"ls":  LsCmd,
 	"name": {
 		Subcommands: map[string]*cmds.Command{
 			"resolve": name.IpnsCmd,
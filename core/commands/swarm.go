@@ -4,14 +4,23 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"path"
 	"sort"
+	"text/tabwriter"
+	"time"
 
 	commands "mbfs/go-mbfs/commands"
+	core "mbfs/go-mbfs/core"
 	cmdenv "mbfs/go-mbfs/core/commands/cmdenv"
+	nat "mbfs/go-mbfs/nat"
+	pnet "mbfs/go-mbfs/pnet"
+	rcmgr "mbfs/go-mbfs/rcmgr"
+	relay "mbfs/go-mbfs/relay"
 	repo "mbfs/go-mbfs/repo"
 	fsrepo "mbfs/go-mbfs/repo/fsrepo"
 
+	humanize "mbfs/go-mbfs/gx/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
 	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
 	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
 	mafilter "mbfs/go-mbfs/gx/QmSMZwvs3n4GBikZ7hKzT17c3bk65FmyZo2JqtJ16swqCv/multiaddr-filter"
@@ -21,6 +30,7 @@ import (
 	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
 	swarm "mbfs/go-mbfs/gx/QmcYC4ayKi7bq8xecEZxHVEuTL6HREZWTTErrSRd1S3Spz/go-libp2p-swarm"
 	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+	circuit "mbfs/go-mbfs/gx/QmddZ5gv3Gkicoqh5NDfHGjpij6zw92pQjvpa181yfnXm2/go-libp2p-circuit"
 	cmdkit "mbfs/go-mbfs/gx/Qmde5VP1qUkyQXKCfmEUA7bP64V2HAptbJ7phuPp7jXWwg/go-ipfs-cmdkit"
 )
 
@@ -46,6 +56,11 @@ ipfs peers in the internet.
 		"connect":    swarmConnectCmd,
 		"disconnect": swarmDisconnectCmd,
 		"filters":    swarmFiltersCmd,
+		"key":        swarmKeyCmd,
+		"limits":     swarmLimitsCmd,
+		"nat":        swarmNatCmd,
+		"peering":    swarmPeeringCmd,
+		"relay":      swarmRelayCmd,
 		"peers":      swarmPeersCmd,
 	},
 }
@@ -739,3 +754,1160 @@ func filtersRemove(r repo.Repo, cfg *config.Config, toRemoveFilters []string) ([
 
 	return removed, nil
 }
+
+const (
+	swarmKeySwitchOptionName = "switch"
+	swarmKeyRevokeOptionName = "revoke"
+)
+
+type swarmKeyOutput struct {
+	Key string
+}
+
+// SwarmKeyStatus is the state of the swarm keys of the node. The connections
+// are only counted by a running daemon.
+type SwarmKeyStatus struct {
+	pnet.Status
+	Online bool
+}
+
+var swarmKeyCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage the swarm keys of the private network.",
+		ShortDescription: `
+A node with a swarm key in its repo only connects to the peers with the same
+key. While the key is rotated, the node also accepts the peers sending with
+a secondary key, so that the new key can reach all the nodes before the old
+one is revoked.
+
+A key is rotated in three steps, each waiting for the previous one to reach
+all the nodes of the network:
+
+  > ipfs swarm key rotate new.key    # accept the new key too
+  > ipfs swarm key rotate --switch   # send with the new key, still accept the old one
+  > ipfs swarm key rotate --revoke   # stop accepting the old key
+
+The changes apply to the new connections of a running daemon right away, and
+are kept in the repo.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"gen":    swarmKeyGenCmd,
+		"rotate": swarmKeyRotateCmd,
+		"status": swarmKeyStatusCmd,
+	},
+}
+
+var swarmKeyGenCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Generate a new swarm key.",
+		ShortDescription: `
+Writes a new random swarm key, in the format of the swarm.key file of the repo.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		key, err := pnet.Generate()
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &swarmKeyOutput{Key: string(key)})
+	},
+	Type: swarmKeyOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *swarmKeyOutput) error {
+			_, err := io.WriteString(w, out.Key)
+			return err
+		}),
+	},
+}
+
+var swarmKeyRotateCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Go through a step of the rotation of the swarm key.",
+		ShortDescription: `
+Given a key file, adds the key as the secondary key: peers sending with it are
+accepted, while the node keeps sending with its current key. --switch makes the
+secondary key the one the node sends with, still accepting the previous one.
+--revoke drops the secondary key.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.FileArg("key", false, false, "The new swarm key."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption(swarmKeySwitchOptionName, "Send with the secondary key, still accepting the current one."),
+		cmdkit.BoolOption(swarmKeyRevokeOptionName, "Stop accepting the secondary key."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		switchKeys, _ := req.Options[swarmKeySwitchOptionName].(bool)
+		revoke, _ := req.Options[swarmKeyRevokeOptionName].(bool)
+
+		var newKey []byte
+		if req.Files != nil {
+			file, err := req.Files.NextFile()
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if file != nil {
+				defer file.Close()
+				if newKey, err = ioutil.ReadAll(file); err != nil {
+					return err
+				}
+				if len(newKey) == 0 {
+					return errors.New("the key file is empty")
+				}
+			}
+		}
+
+		steps := 0
+		for _, step := range []bool{newKey != nil, switchKeys, revoke} {
+			if step {
+				steps++
+			}
+		}
+		if steps != 1 {
+			return errors.New("expected either a new key, --switch or --revoke")
+		}
+
+		primary, err := n.Repo.SwarmKey()
+		if err != nil {
+			return err
+		}
+		if primary == nil {
+			return errors.New("the node is not in a private network: there is no swarm.key in the repo")
+		}
+		if n.OnlineMode() && n.PNet == nil {
+			return errors.New("the daemon was started outside of the private network, restart it first")
+		}
+		secondary, err := n.Repo.SecondarySwarmKey()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case newKey != nil:
+			psk, err := pnet.Decode(newKey)
+			if err != nil {
+				return err
+			}
+			if cur, err := pnet.Decode(primary); err == nil && *cur == *psk {
+				return errors.New("the node already sends with that swarm key")
+			}
+			secondary = newKey
+		case secondary == nil:
+			return errors.New("there is no secondary swarm key, add one first")
+		case switchKeys:
+			primary, secondary = secondary, primary
+		case revoke:
+			secondary = nil
+		}
+
+		if n.PNet != nil {
+			if err := n.PNet.SetKeys(primary, secondary); err != nil {
+				return err
+			}
+			n.PNetFingerprint = n.PNet.Fingerprint()
+		}
+		if err := n.Repo.SetSwarmKeys(primary, secondary); err != nil {
+			return err
+		}
+
+		st, err := swarmKeyStatus(n)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, st)
+	},
+	Type: SwarmKeyStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(writeSwarmKeyStatus),
+	},
+}
+
+var swarmKeyStatusCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the swarm keys and the connections using each of them.",
+		ShortDescription: `
+Lists the fingerprints of the swarm keys and, on a running daemon, how many
+open connections the peers send with each of them. Once no peer sends with the
+previous key after a switch, it can be revoked.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		st, err := swarmKeyStatus(n)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, st)
+	},
+	Type: SwarmKeyStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(writeSwarmKeyStatus),
+	},
+}
+
+// swarmKeyStatus returns the state of the swarm keys of the running daemon,
+// or of the swarm keys in the repo
+func swarmKeyStatus(n *core.IpfsNode) (*SwarmKeyStatus, error) {
+	if n.PNet != nil {
+		return &SwarmKeyStatus{Status: *n.PNet.Status(), Online: true}, nil
+	}
+
+	st := &SwarmKeyStatus{}
+	for _, k := range []struct {
+		get func() ([]byte, error)
+		fp  *string
+	}{
+		{n.Repo.SwarmKey, &st.Fingerprint},
+		{n.Repo.SecondarySwarmKey, &st.Secondary},
+	} {
+		data, err := k.get()
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		psk, err := pnet.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		*k.fp = pnet.Fingerprint(psk)
+	}
+	return st, nil
+}
+
+func writeSwarmKeyStatus(req *cmds.Request, w io.Writer, st *SwarmKeyStatus) error {
+	if st.Fingerprint == "" {
+		_, err := fmt.Fprintln(w, "not in a private network")
+		return err
+	}
+
+	conns := func(fp string) string {
+		if !st.Online {
+			return "-"
+		}
+		return fmt.Sprint(st.Conns[fp])
+	}
+
+	tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tFINGERPRINT\tCONNECTIONS")
+	fmt.Fprintf(tw, "primary\t%s\t%s\n", st.Fingerprint, conns(st.Fingerprint))
+	if st.Secondary != "" {
+		fmt.Fprintf(tw, "secondary\t%s\t%s\n", st.Secondary, conns(st.Secondary))
+	}
+	if st.Online {
+		fmt.Fprintf(tw, "rejected\t-\t%d\n", st.Rejected)
+	}
+	return tw.Flush()
+}
+
+const (
+	swarmPeeringRmOptionName = "rm"
+)
+
+type gaterListOutput struct {
+	Rules []string
+
+	// Closed is the number of open connections closed as they are refused
+	// by the new lists
+	Closed int
+}
+
+var swarmPeeringCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Choose which peers the node connects with.",
+		ShortDescription: `
+The peering peers, kept in Peering.Peers, are the peers the node stays
+connected to: their connections are the last ones the connection manager
+trims, and they are redialed with exponential backoff whenever they drop.
+
+The connection gater refuses the connections, inbound and outbound, of the
+peers and IP ranges denied. As long as some peers or ranges are allowed, it
+also refuses all the connections but theirs. The lists are kept in
+Swarm.ConnGater.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"add":   swarmPeeringAddCmd,
+		"rm":    swarmPeeringRmCmd,
+		"ls":    swarmPeeringLsCmd,
+		"allow": gaterListCmd("allow", "Allow", func(g *config.ConnGater) *[]string { return &g.Allow }),
+		"deny":  gaterListCmd("deny", "Deny", func(g *config.ConnGater) *[]string { return &g.Deny }),
+	},
+}
+
+type peeringPeer struct {
+	ID        string
+	Addrs     []string
+	Connected bool
+
+	// Failures is the number of dials failed since the peer was last
+	// connected, NextDial the time of the next one if any
+	Failures  int       `json:",omitempty"`
+	NextDial  time.Time `json:",omitempty"`
+	LastError string    `json:",omitempty"`
+}
+
+type peeringOutput struct {
+	Peers []peeringPeer
+
+	// Online tells whether the connections of the peers are known, the
+	// node running
+	Online bool
+}
+
+var swarmPeeringAddCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Add peers to stay connected to.",
+		ShortDescription: `
+Adds the addresses, ending with /ipfs/<peer ID>, to Peering.Peers. On a
+running daemon, the new peers are dialed right away.
+
+Example:
+
+  > ipfs swarm peering add /ip4/10.0.0.5/tcp/4001/ipfs/QmSoLV4Bbm51jM9C4gDYZQ9Cy3U6aXMJDAbzgu2fzaDs64
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("address", true, true, "Address of the peer, ending with /ipfs/<peer ID>."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		return editPeering(req, res, env, func(addrs []string) ([]string, error) {
+			for _, arg := range req.Arguments {
+				if _, err := core.ParsePeeringPeers([]string{arg}); err != nil {
+					return nil, err
+				}
+				if indexOf(addrs, arg) < 0 {
+					addrs = append(addrs, arg)
+				}
+			}
+			return addrs, nil
+		})
+	},
+	Type: peeringOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(writePeering),
+	},
+}
+
+var swarmPeeringRmCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Remove peers to stay connected to.",
+		ShortDescription: `
+Removes addresses from Peering.Peers, or all the addresses of a peer given by
+its ID. The open connections are left alone, but the connection manager can
+trim them again.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("peer-or-address", true, true, "Peer ID, or address ending with /ipfs/<peer ID>."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		return editPeering(req, res, env, func(addrs []string) ([]string, error) {
+			for _, arg := range req.Arguments {
+				if i := indexOf(addrs, arg); i >= 0 {
+					addrs = append(addrs[:i], addrs[i+1:]...)
+					continue
+				}
+
+				p, err := peer.IDB58Decode(arg)
+				if err != nil {
+					return nil, fmt.Errorf("%s is not in Peering.Peers", arg)
+				}
+				kept := addrs[:0]
+				for _, a := range addrs {
+					pis, err := core.ParsePeeringPeers([]string{a})
+					if err != nil {
+						return nil, err
+					}
+					if pis[0].ID != p {
+						kept = append(kept, a)
+					}
+				}
+				if len(kept) == len(addrs) {
+					return nil, fmt.Errorf("%s is not in Peering.Peers", arg)
+				}
+				addrs = kept
+			}
+			return addrs, nil
+		})
+	},
+	Type: peeringOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(writePeering),
+	},
+}
+
+var swarmPeeringLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the peers to stay connected to.",
+		ShortDescription: `
+Lists the peers of Peering.Peers with their addresses and, on a running
+daemon, whether they are connected or when they are dialed next.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+		out, err := peeringStatus(n, cfg.Peering.Peers)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Type: peeringOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(writePeering),
+	},
+}
+
+// editPeering applies edit to Peering.Peers, and to the peering service of a
+// running daemon, then emits the new state of the peers
+func editPeering(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment, edit func([]string) ([]string, error)) error {
+	n, err := cmdenv.GetNode(env)
+	if err != nil {
+		return err
+	}
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	addrs, err := edit(append([]string(nil), cfg.Peering.Peers...))
+	if err != nil {
+		return err
+	}
+	peers, err := core.ParsePeeringPeers(addrs)
+	if err != nil {
+		return err
+	}
+
+	cfg.Peering.Peers = addrs
+	if err := n.Repo.SetConfig(cfg); err != nil {
+		return err
+	}
+	if n.Peering != nil {
+		n.Peering.SetPeers(peers)
+	}
+
+	out, err := peeringStatus(n, addrs)
+	if err != nil {
+		return err
+	}
+	return cmds.EmitOnce(res, out)
+}
+
+// peeringStatus returns the state of the peering service of a running daemon,
+// or the peers of addrs otherwise
+func peeringStatus(n *core.IpfsNode, addrs []string) (*peeringOutput, error) {
+	out := &peeringOutput{Peers: []peeringPeer{}}
+	if n.Peering != nil {
+		out.Online = true
+		for _, st := range n.Peering.ListPeers() {
+			pp := peeringPeer{
+				ID:        st.ID.Pretty(),
+				Connected: st.Connected,
+				Failures:  st.Failures,
+				NextDial:  st.NextDial,
+				LastError: st.LastError,
+			}
+			for _, a := range st.Addrs {
+				pp.Addrs = append(pp.Addrs, a.String())
+			}
+			out.Peers = append(out.Peers, pp)
+		}
+		return out, nil
+	}
+
+	peers, err := core.ParsePeeringPeers(addrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, pi := range peers {
+		pp := peeringPeer{ID: pi.ID.Pretty()}
+		for _, a := range pi.Addrs {
+			pp.Addrs = append(pp.Addrs, a.String())
+		}
+		out.Peers = append(out.Peers, pp)
+	}
+	sort.Slice(out.Peers, func(i, j int) bool { return out.Peers[i].ID < out.Peers[j].ID })
+	return out, nil
+}
+
+func writePeering(req *cmds.Request, w io.Writer, out *peeringOutput) error {
+	for _, p := range out.Peers {
+		switch {
+		case !out.Online:
+			fmt.Fprintln(w, p.ID)
+		case p.Connected:
+			fmt.Fprintf(w, "%s connected\n", p.ID)
+		case !p.NextDial.IsZero() && p.Failures > 0:
+			fmt.Fprintf(w, "%s redialing in %s after %d failures: %s\n", p.ID,
+				time.Until(p.NextDial).Round(time.Second), p.Failures, p.LastError)
+		default:
+			fmt.Fprintf(w, "%s dialing\n", p.ID)
+		}
+		for _, a := range p.Addrs {
+			fmt.Fprintf(w, "  %s\n", a)
+		}
+	}
+	return nil
+}
+
+// gaterListCmd returns the command listing and editing one of the lists of
+// the connection gater
+func gaterListCmd(verb, field string, list func(*config.ConnGater) *[]string) *cmds.Command {
+	return &cmds.Command{
+		Helptext: cmdkit.HelpText{
+			Tagline: fmt.Sprintf("List, add or remove the peers and IP ranges to %s.", verb),
+			ShortDescription: fmt.Sprintf(`
+Without arguments, lists the peer IDs and IP ranges in Swarm.ConnGater.%s.
+Otherwise, adds the given peer IDs, IP ranges in CIDR notation or IP addresses
+to the list, or removes them with --rm. On a running daemon, the change
+applies right away, closing the connections it refuses.
+`, field),
+		},
+		Arguments: []cmdkit.Argument{
+			cmdkit.StringArg("peer-or-range", false, true, "Peer ID, IP range or IP address."),
+		},
+		Options: []cmdkit.Option{
+			cmdkit.BoolOption(swarmPeeringRmOptionName, "Remove the entries from the list."),
+		},
+		Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+			n, err := cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			rm, _ := req.Options[swarmPeeringRmOptionName].(bool)
+
+			cfg, err := n.Repo.Config()
+			if err != nil {
+				return err
+			}
+			gcfg := config.ConnGater{
+				Allow: append([]string(nil), cfg.Swarm.ConnGater.Allow...),
+				Deny:  append([]string(nil), cfg.Swarm.ConnGater.Deny...),
+			}
+			rules := list(&gcfg)
+
+			if len(req.Arguments) == 0 {
+				if rm {
+					return errors.New("no entries to remove")
+				}
+				return cmds.EmitOnce(res, &gaterListOutput{Rules: *rules})
+			}
+
+			for _, arg := range req.Arguments {
+				if _, _, err := core.ParseGaterRule(arg); err != nil {
+					return err
+				}
+				i := indexOf(*rules, arg)
+				switch {
+				case rm && i < 0:
+					return fmt.Errorf("%s is not in the list", arg)
+				case rm:
+					*rules = append((*rules)[:i], (*rules)[i+1:]...)
+				case i < 0:
+					*rules = append(*rules, arg)
+				}
+			}
+
+			// the config is written first, so that the gater never applies
+			// lists which are not persisted
+			old := cfg.Swarm.ConnGater
+			cfg.Swarm.ConnGater = gcfg
+			if err := n.Repo.SetConfig(cfg); err != nil {
+				return err
+			}
+
+			out := &gaterListOutput{Rules: *rules}
+			if n.ConnGater != nil {
+				if err := n.ConnGater.SetConfig(gcfg); err != nil {
+					cfg.Swarm.ConnGater = old
+					if rerr := n.Repo.SetConfig(cfg); rerr != nil {
+						return fmt.Errorf("%s, and the config could not be restored: %s", err, rerr)
+					}
+					return err
+				}
+				out.Closed = n.ConnGater.CloseGated(n.PeerHost.Network())
+			}
+			return cmds.EmitOnce(res, out)
+		},
+		Type: gaterListOutput{},
+		Encoders: cmds.EncoderMap{
+			cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *gaterListOutput) error {
+				for _, r := range out.Rules {
+					fmt.Fprintln(w, r)
+				}
+				if out.Closed > 0 {
+					fmt.Fprintf(w, "closed %d connections refused by the new list\n", out.Closed)
+				}
+				return nil
+			}),
+		},
+	}
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+const (
+	swarmLimitsSubnetsOptionName = "subnets"
+)
+
+var swarmLimitsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the use of the limits of the connection manager.",
+		ShortDescription: `
+Shows the open connections, streams and the memory counted for them against
+the limits of the resource-aware connection manager, the open streams by
+protocol and the subnets with the most connections, and how many connections
+and streams it closed. Its limits are set in Swarm.ConnMgr, with the "resource"
+type.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.IntOption(swarmLimitsSubnetsOptionName, "Number of subnets to list, the ones with the most connections.").WithDefault(10),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.OnlineMode() {
+			return ErrNotOnline
+		}
+
+		cm, ok := n.PeerHost.ConnManager().(*rcmgr.ConnManager)
+		if !ok {
+			return errors.New(`the connection manager has no limits, set Swarm.ConnMgr.Type to "resource"`)
+		}
+		return cmds.EmitOnce(res, cm.Stat())
+	},
+	Type: rcmgr.Stat{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, st *rcmgr.Stat) error {
+			maxSubnets, _ := req.Options[swarmLimitsSubnetsOptionName].(int)
+			return writeSwarmLimits(w, st, maxSubnets)
+		}),
+	},
+}
+
+func writeSwarmLimits(w io.Writer, st *rcmgr.Stat, maxSubnets int) error {
+	limit := func(n int) string {
+		if n <= 0 {
+			return "-"
+		}
+		return fmt.Sprint(n)
+	}
+
+	tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESOURCE\tOPEN\tLIMIT")
+	conns := limit(st.Limits.HighWater)
+	if st.Limits.HighWater > 0 {
+		conns += fmt.Sprintf(" (trimmed to %d)", st.Limits.LowWater)
+	}
+	fmt.Fprintf(tw, "connections\t%d (%d peers)\t%s\n", st.Conns, st.Peers, conns)
+	fmt.Fprintf(tw, "streams\t%d\t%s\n", st.Streams, limit(st.Limits.MaxStreams))
+	budget := "-"
+	if st.Limits.MemoryBudget > 0 {
+		budget = humanize.Bytes(uint64(st.Limits.MemoryBudget))
+	}
+	fmt.Fprintf(tw, "memory\t%s\t%s\n", humanize.Bytes(uint64(st.Memory)), budget)
+
+	protos := make(map[string]bool)
+	for p := range st.Protocols {
+		protos[p] = true
+	}
+	for p := range st.Limits.MaxStreamsPerProtocol {
+		protos[p] = true
+	}
+	if len(protos) > 0 {
+		names := make([]string, 0, len(protos))
+		for p := range protos {
+			names = append(names, p)
+		}
+		sort.Strings(names)
+
+		fmt.Fprintln(tw, "\nPROTOCOL\tSTREAMS\tLIMIT")
+		for _, p := range names {
+			name := p
+			if name == "" {
+				name = "<negotiating>"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", name, st.Protocols[p], limit(st.Limits.MaxStreamsPerProtocol[p]))
+		}
+	}
+
+	if len(st.Subnets) > 0 && maxSubnets > 0 {
+		subnets := make([]string, 0, len(st.Subnets))
+		for s := range st.Subnets {
+			subnets = append(subnets, s)
+		}
+		sort.Slice(subnets, func(i, j int) bool {
+			if st.Subnets[subnets[i]] != st.Subnets[subnets[j]] {
+				return st.Subnets[subnets[i]] > st.Subnets[subnets[j]]
+			}
+			return subnets[i] < subnets[j]
+		})
+		if len(subnets) > maxSubnets {
+			subnets = subnets[:maxSubnets]
+		}
+
+		fmt.Fprintln(tw, "\nSUBNET\tCONNECTIONS\tLIMIT")
+		for _, s := range subnets {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", s, st.Subnets[s], limit(st.Limits.MaxConnsPerSubnet))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\nclosed %d connections trimmed, %d connections over their subnet limit, %d streams reset\n",
+		st.Trimmed, st.Refused, st.Reset)
+	return err
+}
+
+var swarmRelayCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Inspect the relaying of circuits.",
+		ShortDescription: `
+With Swarm.RelayService enabled, the node relays the circuits to the peers
+holding a reservation only, each for a limited time and data. The peers behind
+a NAT keep a reservation at the relays listed in Swarm.RelayReservations, and
+can then be dialed at /ipfs/<relay>/p2p-circuit/ipfs/<peer>.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"status": swarmRelayStatusCmd,
+	},
+}
+
+type relayStatusOutput struct {
+	// Service is the state of the relay service, if enabled, and Unlimited
+	// tells whether the node relays all the circuits instead
+	Service   *relayServiceStatus `json:",omitempty"`
+	Unlimited bool                `json:",omitempty"`
+
+	// Reservations are the reservations of the node at relays
+	Reservations []relayReservation
+}
+
+type relayServiceStatus struct {
+	Limits       relay.Limits
+	Reservations []relayServiceReservation
+	Circuits     []relayCircuit
+	Refused      uint64
+}
+
+type relayServiceReservation struct {
+	Peer     string
+	Expires  time.Time
+	Circuits int
+}
+
+type relayCircuit struct {
+	Src, Dst string
+	Opened   time.Time
+	Relayed  int64
+}
+
+type relayReservation struct {
+	Relay     string
+	Addrs     []string
+	Failures  int
+	LastError string
+	NextTry   time.Time
+
+	// Expires is zero without a reservation
+	Expires         time.Time
+	CircuitDuration time.Duration
+	CircuitData     int64
+}
+
+func relayStatus(svc *relay.Service, client *relay.Client) *relayStatusOutput {
+	out := &relayStatusOutput{Reservations: []relayReservation{}}
+	if svc != nil {
+		st := svc.Stat()
+		out.Service = &relayServiceStatus{
+			Limits:       st.Limits,
+			Reservations: make([]relayServiceReservation, 0, len(st.Reservations)),
+			Circuits:     make([]relayCircuit, 0, len(st.Circuits)),
+			Refused:      st.Refused,
+		}
+		for _, r := range st.Reservations {
+			out.Service.Reservations = append(out.Service.Reservations, relayServiceReservation{
+				Peer:     r.Peer.Pretty(),
+				Expires:  r.Expires,
+				Circuits: r.Circuits,
+			})
+		}
+		for _, c := range st.Circuits {
+			out.Service.Circuits = append(out.Service.Circuits, relayCircuit{
+				Src:     c.Src.Pretty(),
+				Dst:     c.Dst.Pretty(),
+				Opened:  c.Opened,
+				Relayed: c.Relayed,
+			})
+		}
+	}
+	if client != nil {
+		for _, st := range client.Status() {
+			r := relayReservation{
+				Relay:     st.Relay.Pretty(),
+				Addrs:     make([]string, 0, len(st.Addrs)),
+				Failures:  st.Failures,
+				LastError: st.LastError,
+				NextTry:   st.NextTry,
+			}
+			for _, a := range st.Addrs {
+				r.Addrs = append(r.Addrs, a.String())
+			}
+			if res := st.Reservation; res != nil {
+				r.Expires, r.CircuitDuration, r.CircuitData = res.Expires, res.CircuitDuration, res.CircuitData
+			}
+			out.Reservations = append(out.Reservations, r)
+		}
+	}
+	return out
+}
+
+var swarmRelayStatusCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the reservations and the circuits of the relay.",
+		ShortDescription: `
+Shows the reservations held at the relay service of the node and the circuits
+it relays, with the bytes relayed so far, and the reservations the node holds
+at other relays.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.OnlineMode() {
+			return ErrNotOnline
+		}
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+
+		out := relayStatus(n.RelayService, n.RelayClient)
+		out.Unlimited = n.RelayService == nil && cfg.Swarm.EnableRelayHop && !cfg.Swarm.DisableRelay
+		return cmds.EmitOnce(res, out)
+	},
+	Type: relayStatusOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *relayStatusOutput) error {
+			return writeRelayStatus(w, out)
+		}),
+	},
+}
+
+func writeRelayStatus(w io.Writer, out *relayStatusOutput) error {
+	since := func(t time.Time) time.Duration { return time.Since(t).Round(time.Second) }
+	until := func(t time.Time) time.Duration { return time.Until(t).Round(time.Second) }
+
+	tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+	switch st := out.Service; {
+	case st != nil:
+		l := st.Limits
+		fmt.Fprintf(tw, "relay service: %d of %d reservations, %d circuits, %d refused\n",
+			len(st.Reservations), l.MaxReservations, len(st.Circuits), st.Refused)
+		fmt.Fprintf(tw, "up to %d circuits per peer, closed after %s or %s\n",
+			l.MaxCircuits, l.CircuitDuration, humanize.IBytes(uint64(l.CircuitData)))
+		if len(st.Reservations) > 0 {
+			fmt.Fprintln(tw, "\nPEER\tEXPIRES IN\tCIRCUITS")
+			for _, r := range st.Reservations {
+				fmt.Fprintf(tw, "%s\t%s\t%d\n", r.Peer, until(r.Expires), r.Circuits)
+			}
+		}
+		if len(st.Circuits) > 0 {
+			fmt.Fprintln(tw, "\nSOURCE\tDESTINATION\tOPEN FOR\tRELAYED")
+			for _, c := range st.Circuits {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Src, c.Dst, since(c.Opened), humanize.IBytes(uint64(c.Relayed)))
+			}
+		}
+	case out.Unlimited:
+		fmt.Fprintln(tw, "relay service: relaying all the circuits, Swarm.EnableRelayHop is set")
+	default:
+		fmt.Fprintln(tw, "relay service: disabled")
+	}
+
+	if len(out.Reservations) > 0 {
+		fmt.Fprintln(tw, "\nRELAY\tRESERVATION")
+		for _, r := range out.Reservations {
+			var state string
+			switch {
+			case !r.Expires.IsZero():
+				state = fmt.Sprintf("expires in %s, renewed in %s, circuits closed after %s or %s",
+					until(r.Expires), until(r.NextTry), r.CircuitDuration, humanize.IBytes(uint64(r.CircuitData)))
+			case r.Failures > 0:
+				state = fmt.Sprintf("retrying in %s after %d failures: %s", until(r.NextTry), r.Failures, r.LastError)
+			default:
+				state = "reserving"
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", r.Relay, state)
+		}
+	}
+	return tw.Flush()
+}
+
+var swarmNatCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Inspect the NAT traversal.",
+		ShortDescription: `
+The node asks its peers to dial it back to tell whether it is reachable from
+the internet, and dials back the peers asking unless Swarm.AutoNAT.DisableService
+is set. When a peer connects through a relay, the two punch a hole through
+their NATs to connect directly, unless Swarm.DisableHolePunching is set.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"status": swarmNatStatusCmd,
+	},
+}
+
+type natStatusOutput struct {
+	Reachability string
+	Confidence   int
+	PublicAddrs  []string
+	LastProbe    time.Time
+	NextProbe    time.Time
+	Probes       []natProbe
+
+	// Serving tells whether the node dials back the peers, Served counts the
+	// probes it answered and Refused the ones it refused
+	Serving bool
+	Served  uint64
+	Refused uint64
+
+	// RelayedPeers counts the peers connected through a relay only
+	RelayedPeers int
+	// HolePunching is the state of the hole punching, if enabled
+	HolePunching *natHolePunching `json:",omitempty"`
+}
+
+type natProbe struct {
+	Peer         string
+	Time         time.Time
+	Reachability string
+	Addr         string
+	Error        string
+}
+
+type natHolePunching struct {
+	Attempts  uint64
+	Successes uint64
+	Active    []string
+	Results   []natHolePunch
+}
+
+type natHolePunch struct {
+	Peer      string
+	Time      time.Time
+	Initiator bool
+	Attempts  int
+	RTT       time.Duration
+	Addr      string
+	Error     string
+}
+
+func natStatus(as *nat.AutoNAT, hp *nat.HolePuncher, n inet.Network) *natStatusOutput {
+	st := as.Status()
+	out := &natStatusOutput{
+		Reachability: st.Reachability.String(),
+		Confidence:   st.Confidence,
+		PublicAddrs:  make([]string, 0, len(st.PublicAddrs)),
+		LastProbe:    st.LastProbe,
+		NextProbe:    st.NextProbe,
+		Probes:       make([]natProbe, 0, len(st.Results)),
+		Serving:      st.Serving,
+		Served:       st.Served,
+		Refused:      st.Refused,
+	}
+	for _, a := range st.PublicAddrs {
+		out.PublicAddrs = append(out.PublicAddrs, a.String())
+	}
+	for _, r := range st.Results {
+		p := natProbe{
+			Peer:         r.Peer.Pretty(),
+			Time:         r.Time,
+			Reachability: r.Reachability.String(),
+			Error:        r.Error,
+		}
+		if r.Addr != nil {
+			p.Addr = r.Addr.String()
+		}
+		out.Probes = append(out.Probes, p)
+	}
+
+	for _, p := range n.Peers() {
+		relayed := true
+		for _, c := range n.ConnsToPeer(p) {
+			if _, err := c.RemoteMultiaddr().ValueForProtocol(circuit.P_CIRCUIT); err != nil {
+				relayed = false
+			}
+		}
+		if relayed {
+			out.RelayedPeers++
+		}
+	}
+
+	if hp != nil {
+		st := hp.Status()
+		out.HolePunching = &natHolePunching{
+			Attempts:  st.Attempts,
+			Successes: st.Successes,
+			Active:    make([]string, 0, len(st.Active)),
+			Results:   make([]natHolePunch, 0, len(st.Results)),
+		}
+		for _, p := range st.Active {
+			out.HolePunching.Active = append(out.HolePunching.Active, p.Pretty())
+		}
+		for _, r := range st.Results {
+			h := natHolePunch{
+				Peer:      r.Peer.Pretty(),
+				Time:      r.Time,
+				Initiator: r.Initiator,
+				Attempts:  r.Attempts,
+				RTT:       r.RTT,
+				Error:     r.Error,
+			}
+			if r.Addr != nil {
+				h.Addr = r.Addr.String()
+			}
+			out.HolePunching.Results = append(out.HolePunching.Results, h)
+		}
+	}
+	return out
+}
+
+const (
+	swarmNatProbeOptionName = "probe"
+)
+
+var swarmNatStatusCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the reachability of the node and the hole punches.",
+		ShortDescription: `
+Shows whether the node is reachable from the internet (public), behind a NAT
+(private) or not known yet, the addresses the peers reached it at, and the
+answers to the last probes. Then the number of peers connected through a relay
+only, and the last hole punches upgrading such connections to direct ones.
+
+With --probe, the reachability is probed again right away, and shown once
+the probe is over.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption(swarmNatProbeOptionName, "Probe the reachability now."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.OnlineMode() || n.AutoNAT == nil {
+			return ErrNotOnline
+		}
+
+		if probe, _ := req.Options[swarmNatProbeOptionName].(bool); probe {
+			// the answers of the peers to a failed probe show in the status
+			err := n.AutoNAT.Probe(req.Context)
+			if err == nat.ErrNoPeers || req.Context.Err() != nil {
+				return err
+			}
+		}
+		return cmds.EmitOnce(res, natStatus(n.AutoNAT, n.HolePuncher, n.PeerHost.Network()))
+	},
+	Type: natStatusOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *natStatusOutput) error {
+			return writeNatStatus(w, out)
+		}),
+	},
+}
+
+func writeNatStatus(w io.Writer, out *natStatusOutput) error {
+	since := func(t time.Time) time.Duration { return time.Since(t).Round(time.Second) }
+	until := func(t time.Time) time.Duration { return time.Until(t).Round(time.Second) }
+
+	tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "reachability: %s", out.Reachability)
+	if out.Reachability != nat.ReachabilityUnknown.String() {
+		fmt.Fprintf(tw, ", confidence %d", out.Confidence)
+	}
+	if !out.LastProbe.IsZero() {
+		fmt.Fprintf(tw, ", probed %s ago", since(out.LastProbe))
+	}
+	fmt.Fprintf(tw, ", next probe in %s\n", until(out.NextProbe))
+	for _, a := range out.PublicAddrs {
+		fmt.Fprintf(tw, "public address: %s\n", a)
+	}
+	if out.Serving {
+		fmt.Fprintf(tw, "dialing back the peers: %d probes answered, %d refused\n", out.Served, out.Refused)
+	} else {
+		fmt.Fprintln(tw, "dialing back the peers: disabled")
+	}
+	fmt.Fprintf(tw, "peers connected through a relay only: %d\n", out.RelayedPeers)
+
+	if len(out.Probes) > 0 {
+		fmt.Fprintln(tw, "\nPEER\tPROBED\tRESULT")
+		for _, p := range out.Probes {
+			result := p.Reachability
+			switch {
+			case p.Addr != "":
+				result += " at " + p.Addr
+			case p.Error != "":
+				result += ": " + p.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s ago\t%s\n", p.Peer, since(p.Time), result)
+		}
+	}
+
+	hp := out.HolePunching
+	if hp == nil {
+		fmt.Fprintln(tw, "\nhole punching: disabled")
+		return tw.Flush()
+	}
+	fmt.Fprintf(tw, "\nhole punching: %d of %d succeeded, %d in progress\n", hp.Successes, hp.Attempts, len(hp.Active))
+	if len(hp.Results) > 0 {
+		fmt.Fprintln(tw, "\nPEER\tSTARTED\tBY\tATTEMPTS\tRTT\tRESULT")
+		for _, r := range hp.Results {
+			by := "peer"
+			if r.Initiator {
+				by = "node"
+			}
+			result := "direct at " + r.Addr
+			if r.Error != "" {
+				result = "failed: " + r.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s ago\t%s\t%d\t%s\t%s\n", r.Peer, since(r.Time), by, r.Attempts, r.RTT.Round(time.Millisecond), result)
+		}
+	}
+	return tw.Flush()
+}
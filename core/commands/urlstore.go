@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 
+	core "mbfs/go-mbfs/core"
 	cmdenv "mbfs/go-mbfs/core/commands/cmdenv"
 	filestore "mbfs/go-mbfs/filestore"
 
@@ -19,11 +20,33 @@ import (
 )
 
 var urlStoreCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Interact with urlstore.",
+	},
 	Subcommands: map[string]*cmds.Command{
-		"add": urlAdd,
+		"add":    urlAdd,
+		"ls":     urlLs,
+		"verify": urlVerify,
 	},
 }
 
+// getUrlstore returns the node and its filestore, if the urlstore is enabled
+func getUrlstore(env cmds.Environment) (*core.IpfsNode, *filestore.Filestore, error) {
+	n, err := cmdenv.GetNode(env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !cfg.Experimental.UrlstoreEnabled || n.Filestore == nil {
+		return nil, nil, filestore.ErrUrlstoreNotEnabled
+	}
+	return n, n.Filestore, nil
+}
+
 var urlAdd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Add URL via urlstore.",
@@ -36,6 +59,11 @@ control.
 The file is added using raw-leaves but otherwise using the default
 settings for 'ipfs add'.
 
+Any further URLs are mirrors serving the same data. When a block can't be
+fetched from the first URL, the mirrors are tried in order.
+
+Credentials for the URLs can be set in the Urlstore section of the config.
+
 The file is not pinned, so this command should be followed by an 'ipfs
 pin add'.
 
@@ -49,40 +77,31 @@ time.
 	},
 	Arguments: []cmdkit.Argument{
 		cmdkit.StringArg("url", true, false, "URL to add to IPFS"),
+		cmdkit.StringArg("mirror", false, true, "URLs serving the same data."),
 	},
 	Type: &BlockStat{},
 
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		url := req.Arguments[0]
-		n, err := cmdenv.GetNode(env)
-		if err != nil {
-			return err
-		}
-
-		if !filestore.IsURL(url) {
-			return fmt.Errorf("unsupported url syntax: %s", url)
-		}
-
-		cfg, err := n.Repo.Config()
+		mirrors := req.Arguments[1:]
+		n, fs, err := getUrlstore(env)
 		if err != nil {
 			return err
 		}
 
-		if !cfg.Experimental.UrlstoreEnabled {
-			return filestore.ErrUrlstoreNotEnabled
+		for _, u := range req.Arguments {
+			if !filestore.IsURL(u) {
+				return fmt.Errorf("unsupported url syntax: %s", u)
+			}
 		}
 
 		useTrickledag, _ := req.Options[trickleOptionName].(bool)
 
-		hreq, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return err
-		}
-
-		hres, err := http.DefaultClient.Do(hreq)
+		hres, err := fs.FileManager().Fetcher().Get(url, "")
 		if err != nil {
 			return err
 		}
+		defer hres.Body.Close()
 		if hres.StatusCode != http.StatusOK {
 			return fmt.Errorf("expected code 200, got: %d", hres.StatusCode)
 		}
@@ -106,6 +125,9 @@ time.
 		if err != nil {
 			return err
 		}
+		if err := fs.FileManager().SetMirrors(url, mirrors); err != nil {
+			return err
+		}
 
 		return cmds.EmitOnce(res, &BlockStat{
 			Key:  root.Cid().String(),
@@ -119,3 +141,90 @@ time.
 		}),
 	},
 }
+
+var urlLs = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List URLs in urlstore.",
+		LongDescription: `
+List the URLs referenced by the urlstore.
+
+The output is:
+
+<url> <blocks> <size>
+
+followed by the mirrors of the URL, if any, indented.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		_, fs, err := getUrlstore(env)
+		if err != nil {
+			return err
+		}
+
+		entries, err := filestore.ListURLs(fs)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := res.Emit(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, e *filestore.URLEntry) error {
+			fmt.Fprintf(w, "%s %d %d\n", e.URL, e.Blocks, e.Size)
+			for _, m := range e.Mirrors {
+				fmt.Fprintf(w, "  %s\n", m)
+			}
+			return nil
+		}),
+	},
+	Type: filestore.URLEntry{},
+}
+
+var urlVerify = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Verify objects in urlstore.",
+		LongDescription: `
+Verify that the blocks referenced by the urlstore can be fetched, from their
+URL or one of its mirrors.
+
+If a <url> is specified only verify the blocks of that URL, otherwise verify
+all of them.
+
+The output is the same as the one of 'ipfs filestore verify'.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("url", false, false, "URL to verify."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		_, fs, err := getUrlstore(env)
+		if err != nil {
+			return err
+		}
+
+		var url string
+		if len(req.Arguments) > 0 {
+			url = req.Arguments[0]
+		}
+
+		next, err := filestore.VerifyURLs(fs, url)
+		if err != nil {
+			return err
+		}
+		for {
+			r := next()
+			if r == nil {
+				return nil
+			}
+			if err := res.Emit(r); err != nil {
+				return err
+			}
+		}
+	},
+	PostRun: verifyFileStore.PostRun,
+	Type:    filestore.ListRes{},
+}
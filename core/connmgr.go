@@ -0,0 +1,98 @@
+package core
+
+import (
+	"fmt"
+	"math/bits"
+	"time"
+
+	rcmgr "mbfs/go-mbfs/rcmgr"
+
+	humanize "mbfs/go-mbfs/gx/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	bitswap "mbfs/go-mbfs/gx/QmXRphxBT4BH2GqGHUSbqULm7wNsxnpA2NrbNaY3DU1Y5K/go-bitswap"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+const (
+	// bitswapWantScore is the score of a peer waiting for blocks from the
+	// node, on top of its score for the bytes exchanged with it
+	bitswapWantScore = 10
+	// dhtScore is the score of a peer in the routing table of the DHT
+	dhtScore = 20
+)
+
+// ResourceLimits converts the Swarm.ConnMgr section of the config to the
+// limits of the resource-aware connection manager.
+func ResourceLimits(cfg config.ConnMgr) (rcmgr.Limits, error) {
+	l := rcmgr.Limits{
+		LowWater:          cfg.LowWater,
+		HighWater:         cfg.HighWater,
+		GracePeriod:       config.DefaultConnMgrGracePeriod,
+		MaxStreams:        cfg.MaxStreams,
+		MaxConnsPerSubnet: cfg.MaxConnsPerSubnet,
+	}
+	if cfg.GracePeriod != "" {
+		grace, err := time.ParseDuration(cfg.GracePeriod)
+		if err != nil {
+			return l, fmt.Errorf("parsing Swarm.ConnMgr.GracePeriod: %s", err)
+		}
+		l.GracePeriod = grace
+	}
+	if cfg.MemoryBudget != "" {
+		budget, err := humanize.ParseBytes(cfg.MemoryBudget)
+		if err != nil {
+			return l, fmt.Errorf("invalid Swarm.ConnMgr.MemoryBudget %q: %s", cfg.MemoryBudget, err)
+		}
+		l.MemoryBudget = int64(budget)
+	}
+	if len(cfg.MaxStreamsPerProtocol) > 0 {
+		l.MaxStreamsPerProtocol = make(map[string]int, len(cfg.MaxStreamsPerProtocol))
+		for proto, max := range cfg.MaxStreamsPerProtocol {
+			if max <= 0 {
+				return l, fmt.Errorf("invalid Swarm.ConnMgr.MaxStreamsPerProtocol for %s: %d", proto, max)
+			}
+			l.MaxStreamsPerProtocol[proto] = max
+		}
+	}
+	if l.HighWater > 0 && l.LowWater > l.HighWater {
+		return l, fmt.Errorf("Swarm.ConnMgr.LowWater %d is above HighWater %d", l.LowWater, l.HighWater)
+	}
+	return l, nil
+}
+
+// setupConnMgrScorers scores the peers for the resource-aware connection
+// manager, if it is the one of the host, by their bitswap activity and their
+// membership of the DHT routing table.
+func (n *IpfsNode) setupConnMgrScorers() {
+	cm, ok := n.PeerHost.ConnManager().(*rcmgr.ConnManager)
+	if !ok {
+		return
+	}
+	if bs, ok := n.Exchange.(*bitswap.Bitswap); ok {
+		cm.SetScorer("bitswap", func(p peer.ID) int {
+			return bitswapScore(bs, p)
+		})
+	}
+	if n.DHT != nil {
+		rt := n.DHT.RoutingTable()
+		cm.SetScorer("dht", func(p peer.ID) int {
+			if rt.Find(p) == "" {
+				return 0
+			}
+			return dhtScore
+		})
+	}
+}
+
+// bitswapScore scores a peer by the bytes exchanged with it, on a log scale,
+// and by whether it waits for blocks
+func bitswapScore(bs *bitswap.Bitswap, p peer.ID) int {
+	score := 0
+	if r := bs.LedgerForPeer(p); r != nil {
+		score = bits.Len64(r.Sent + r.Recv)
+	}
+	if len(bs.WantlistForPeer(p)) > 0 {
+		score += bitswapWantScore
+	}
+	return score
+}
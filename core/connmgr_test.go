@@ -0,0 +1,46 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+)
+
+func TestResourceLimits(t *testing.T) {
+	l, err := ResourceLimits(config.ConnMgr{
+		Type:                  "resource",
+		LowWater:              100,
+		HighWater:             200,
+		MaxStreams:            1000,
+		MaxStreamsPerProtocol: map[string]int{"/ipfs/bitswap/1.1.0": 300},
+		MaxConnsPerSubnet:     8,
+		MemoryBudget:          "64MB",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.GracePeriod != config.DefaultConnMgrGracePeriod || l.MemoryBudget != 64000000 ||
+		l.MaxStreamsPerProtocol["/ipfs/bitswap/1.1.0"] != 300 || l.MaxConnsPerSubnet != 8 {
+		t.Fatalf("unexpected limits %+v", l)
+	}
+
+	l, err = ResourceLimits(config.ConnMgr{GracePeriod: "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.GracePeriod != time.Minute {
+		t.Fatalf("expected a grace period of 1m, got %s", l.GracePeriod)
+	}
+
+	for _, cfg := range []config.ConnMgr{
+		{MemoryBudget: "lots"},
+		{GracePeriod: "soon"},
+		{MaxStreamsPerProtocol: map[string]int{"/ipfs/bitswap/1.1.0": 0}},
+		{LowWater: 300, HighWater: 200},
+	} {
+		if _, err := ResourceLimits(cfg); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
@@ -10,7 +10,6 @@ interfaces and how core/... fits into the bigger IPFS picture, see:
 package core
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -26,8 +25,12 @@ import (
 	mount "mbfs/go-mbfs/fuse/mount"
 	namesys "mbfs/go-mbfs/namesys"
 	ipnsrp "mbfs/go-mbfs/namesys/republisher"
+	nat "mbfs/go-mbfs/nat"
 	p2p "mbfs/go-mbfs/p2p"
 	pin "mbfs/go-mbfs/pin"
+	pnet "mbfs/go-mbfs/pnet"
+	rcmgr "mbfs/go-mbfs/rcmgr"
+	relay "mbfs/go-mbfs/relay"
 	repo "mbfs/go-mbfs/repo"
 
 	ic "mbfs/go-mbfs/gx/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
@@ -57,7 +60,6 @@ import (
 	p2pbhost "mbfs/go-mbfs/gx/QmXnpYYg2onGLXVxM4Q5PEFcx29k8zeJQkPeLAk9h9naxg/go-libp2p/p2p/host/basic"
 	rhost "mbfs/go-mbfs/gx/QmXnpYYg2onGLXVxM4Q5PEFcx29k8zeJQkPeLAk9h9naxg/go-libp2p/p2p/host/routed"
 	identify "mbfs/go-mbfs/gx/QmXnpYYg2onGLXVxM4Q5PEFcx29k8zeJQkPeLAk9h9naxg/go-libp2p/p2p/protocol/identify"
-	pnet "mbfs/go-mbfs/gx/QmY4Q5JC4vxLEi8EpVxJM4rcRryEVtH1zRKVTAm6BKV1pg/go-libp2p-pnet"
 	smux "mbfs/go-mbfs/gx/QmY9JXR3FupnYAYJWK9aMr9bCpqWKcToQ1tz8DVGTrHpHw/go-stream-muxer"
 	psrouter "mbfs/go-mbfs/gx/QmYcT5nKL223v6h2ABFBErjmuEx7kPMtNskFu5twieLWCg/go-libp2p-pubsub-router"
 	routing "mbfs/go-mbfs/gx/QmYyg3UnyiQubxjs4uhKixPxR7eeKrhJ5Vyz6Et4Tet18B/go-libp2p-routing"
@@ -68,6 +70,8 @@ import (
 	dhtopts "mbfs/go-mbfs/gx/QmadRyQYRn64xHb5HKy2jRFp2Der643Cgo7NEjFgs4MX2k/go-libp2p-kad-dht/opts"
 	quic "mbfs/go-mbfs/gx/Qmaxt2iTi6eDtCmcxmK54Ejy4f2s129g4A5Ej8Bh34NaWg/go-libp2p-quic-transport"
 	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	security "mbfs/go-mbfs/gx/QmbNjbKRJKbek3jPV6rpbGEtrZd84cDxjpBtsiGYa9Z5Do/go-conn-security"
+	secio "mbfs/go-mbfs/gx/QmcDSLssuPYMwC71biMiCvWMGg6rBzk8TKf4UJN64M68YK/go-libp2p-secio"
 	ipld "mbfs/go-mbfs/gx/QmcKKBwfz6FyQdHR2jsXrrF6XeSBXYL86anmWNewpFpoF5/go-ipld-format"
 	mfs "mbfs/go-mbfs/gx/QmcUXFi2Fp7oguoFT81f2poJpnb44dFkZanQhDBHMoYyG9/go-mfs"
 	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
@@ -107,10 +111,11 @@ type IpfsNode struct {
 	Repo repo.Repo
 
 	// Local node
-	Pinning         pin.Pinner // the pinning manager
-	Mounts          Mounts     // current mount state, if any.
-	PrivateKey      ic.PrivKey // the local node's private Key
-	PNetFingerprint []byte     // fingerprint of private network
+	Pinning         pin.Pinner      // the pinning manager
+	Mounts          Mounts          // current mount state, if any.
+	PrivateKey      ic.PrivKey      // the local node's private Key
+	PNetFingerprint []byte          // fingerprint of private network
+	PNet            *pnet.Protector // private network protector, if any
 
 	// Services
 	Peerstore       pstore.Peerstore     // storage for other Peer instances
@@ -127,12 +132,19 @@ type IpfsNode struct {
 	RecordValidator record.Validator
 
 	// Online
-	PeerHost     p2phost.Host        // the network host (server+client)
-	Bootstrapper io.Closer           // the periodic bootstrapper
-	Routing      routing.IpfsRouting // the routing system. recommend ipfs-dht
-	Exchange     exchange.Interface  // the block exchange + strategy (bitswap)
-	Namesys      namesys.NameSystem  // the name system, resolves paths to hashes
-	Reprovider   *rp.Reprovider      // the value reprovider system
+	PeerHost     p2phost.Host         // the network host (server+client)
+	ConnGater    *ConnGater           // allows and denies the connections of the host
+	Peering      *PeeringService      // keeps the node connected to the peering peers
+	RelayService *relay.Service       // the limited relay, if enabled
+	RelayClient  *relay.Client        // keeps the reservations at the relays
+	AutoNAT      *nat.AutoNAT         // probes the reachability of the node
+	HolePuncher  *nat.HolePuncher     // upgrades the relayed connections to direct ones
+	Bootstrapper io.Closer            // the periodic bootstrapper
+	Routing      routing.IpfsRouting  // the routing system. recommend ipfs-dht
+	Exchange     exchange.Interface   // the block exchange + strategy (bitswap)
+	Namesys      namesys.NameSystem   // the name system, resolves paths to hashes
+	DNSResolver  *namesys.DNSResolver // the DNSLink resolver of the name system
+	Reprovider   *rp.Reprovider       // the value reprovider system
 	IpnsRepub    *ipnsrp.Republisher
 
 	PubSub   *pubsub.PubSub
@@ -192,10 +204,15 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 	}
 
 	if swarmkey != nil {
-		protec, err := pnet.NewProtector(bytes.NewReader(swarmkey))
+		secondary, err := n.Repo.SecondarySwarmKey()
+		if err != nil {
+			return err
+		}
+		protec, err := pnet.NewProtector(swarmkey, secondary)
 		if err != nil {
 			return fmt.Errorf("failed to configure private network: %s", err)
 		}
+		n.PNet = protec
 		n.PNetFingerprint = protec.Fingerprint()
 		go func() {
 			t := time.NewTicker(30 * time.Second)
@@ -228,12 +245,45 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 	}
 	libp2pOpts = append(libp2pOpts, libp2p.AddrsFactory(addrsFactory))
 
-	connm, err := constructConnMgr(cfg.Swarm.ConnMgr)
+	connm, err := constructConnMgr(ctx, cfg.Swarm.ConnMgr)
 	if err != nil {
 		return err
 	}
 	libp2pOpts = append(libp2pOpts, libp2p.ConnectionManager(connm))
 
+	gater, err := NewConnGater(cfg.Swarm.ConnGater)
+	if err != nil {
+		return err
+	}
+	peering, err := ParsePeeringPeers(cfg.Peering.Peers)
+	if err != nil {
+		return err
+	}
+	reservations, err := ParseRelayReservations(cfg.Swarm.RelayReservations)
+	if err != nil {
+		return err
+	}
+	var relayLimits relay.Limits
+	if cfg.Swarm.RelayService.Enabled {
+		if relayLimits, err = RelayLimits(cfg.Swarm.RelayService); err != nil {
+			return err
+		}
+	}
+	autonatInterval, err := AutoNATInterval(cfg.Swarm.AutoNAT)
+	if err != nil {
+		return err
+	}
+	if cfg.Swarm.DisableRelay && (cfg.Swarm.RelayService.Enabled || len(reservations) > 0) {
+		return errors.New("Swarm.RelayService and Swarm.RelayReservations need the relay, unset Swarm.DisableRelay")
+	}
+	libp2pOpts = append(libp2pOpts, libp2p.Security(secio.ID, func(sk ic.PrivKey) (security.Transport, error) {
+		t, err := secio.New(sk)
+		if err != nil {
+			return nil, err
+		}
+		return gater.WrapSecurity(t), nil
+	}))
+
 	libp2pOpts = append(libp2pOpts, makeSmuxTransportOption(mplex))
 
 	if !cfg.Swarm.DisableNatPortMap {
@@ -248,7 +298,7 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 		libp2pOpts = append(libp2pOpts, libp2p.DisableRelay())
 	} else {
 		relayOpts := []circuit.RelayOpt{circuit.OptDiscovery}
-		if cfg.Swarm.EnableRelayHop {
+		if cfg.Swarm.EnableRelayHop || cfg.Swarm.RelayService.Enabled {
 			relayOpts = append(relayOpts, circuit.OptHop)
 		}
 		libp2pOpts = append(libp2pOpts, libp2p.EnableRelay(relayOpts...))
@@ -266,17 +316,46 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 	if err != nil {
 		return err
 	}
+	n.ConnGater = gater
+	peerhost.Network().Notify(gater.Notifee())
 
 	if err := n.startOnlineServicesWithHost(ctx, peerhost, routingOption, pubsub, ipnsps); err != nil {
 		return err
 	}
+	n.setupConnMgrScorers()
+	if cfg.Swarm.RelayService.Enabled {
+		if err := n.startRelayService(relayLimits); err != nil {
+			return err
+		}
+	}
+
+	n.AutoNAT = nat.NewAutoNAT(n.PeerHost, autonatInterval, !cfg.Swarm.AutoNAT.DisableService)
+	if !cfg.Swarm.DisableHolePunching {
+		n.HolePuncher = nat.NewHolePuncher(n.PeerHost)
+		n.HolePuncher.Start()
+	}
 
 	// Ok, now we're ready to listen.
 	if err := startListening(n.PeerHost, cfg); err != nil {
 		return err
 	}
 
+	n.Peering = NewPeeringService(n.PeerHost)
+	for _, pi := range peering {
+		n.Peering.AddPeer(pi)
+	}
+	if err := n.Peering.Start(); err != nil {
+		return err
+	}
+
+	n.RelayClient = relay.NewClient(n.PeerHost, reservations)
+	n.RelayClient.Start()
+	n.AutoNAT.Start()
+
 	n.P2P = p2p.NewP2P(n.Identity, n.PeerHost, n.Peerstore)
+	if cfg.Experimental.Libp2pStreamMounting {
+		n.restoreP2P(cfg.P2P)
+	}
 
 	// setup local discovery
 	if do != nil {
@@ -292,7 +371,7 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 	return n.Bootstrap(DefaultBootstrapConfig)
 }
 
-func constructConnMgr(cfg config.ConnMgr) (ifconnmgr.ConnManager, error) {
+func constructConnMgr(ctx context.Context, cfg config.ConnMgr) (ifconnmgr.ConnManager, error) {
 	switch cfg.Type {
 	case "":
 		// 'default' value is the basic connection manager
@@ -306,6 +385,12 @@ func constructConnMgr(cfg config.ConnMgr) (ifconnmgr.ConnManager, error) {
 		}
 
 		return connmgr.NewConnManager(cfg.LowWater, cfg.HighWater, grace), nil
+	case "resource":
+		limits, err := ResourceLimits(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return rcmgr.NewConnManager(ctx, limits), nil
 	default:
 		return nil, fmt.Errorf("unrecognized ConnMgr.Type: %q", cfg.Type)
 	}
@@ -549,16 +634,29 @@ func (n *IpfsNode) startOnlineServicesWithHost(ctx context.Context, host p2phost
 	n.PeerHost = rhost.Wrap(host, n.Routing)
 
 	// setup exchange service
-	bitswapNetwork := bsnet.NewFromIpfsHost(n.PeerHost, n.Routing)
-	n.Exchange = bitswap.New(ctx, bitswapNetwork, n.Blockstore)
-
-	size, err := n.getCacheSize()
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+	limits, err := BitswapLimits(cfg.Bitswap)
+	if err != nil {
+		return err
+	}
+	strategy, err := BitswapStrategy(cfg.Bitswap)
 	if err != nil {
 		return err
 	}
+	bitswapNetwork := bsnet.NewFromIpfsHost(n.PeerHost, n.Routing)
+	bs := bitswap.New(ctx, bitswapNetwork, n.Blockstore).(*bitswap.Bitswap)
+	bs.SetLimits(limits)
+	bs.SetStrategy(strategy)
+	bs.SetLedgerStore(n.Repo.Datastore())
+	n.Exchange = bs
 
 	// setup name system
-	n.Namesys = namesys.NewNameSystem(n.Routing, n.Repo.Datastore(), size)
+	if err := n.setupNamesys(); err != nil {
+		return err
+	}
 
 	// setup ipns republishing
 	return n.setupIpnsRepublisher()
@@ -590,16 +688,25 @@ func (n *IpfsNode) setupIpnsRepublisher() error {
 	n.IpnsRepub = ipnsrp.NewRepublisher(n.Namesys, n.Repo.Datastore(), n.PrivateKey, n.Repo.Keystore())
 
 	if cfg.Ipns.RepublishPeriod != "" {
-		d, err := time.ParseDuration(cfg.Ipns.RepublishPeriod)
+		d, err := parseRepublishPeriod("IPNS.RepublishPeriod", cfg.Ipns.RepublishPeriod)
 		if err != nil {
-			return fmt.Errorf("failure to parse config setting IPNS.RepublishPeriod: %s", err)
+			return err
 		}
+		n.IpnsRepub.Interval = d
+	}
 
-		if !u.Debug && (d < time.Minute || d > (time.Hour*24)) {
-			return fmt.Errorf("config setting IPNS.RepublishPeriod is not between 1min and 1day: %s", d)
+	n.IpnsRepub.KeyIntervals = make(map[string]time.Duration)
+	for name, s := range cfg.Ipns.RepublishKeys {
+		d, err := parseRepublishPeriod("IPNS.RepublishKeys["+name+"]", s)
+		if err != nil {
+			return err
 		}
+		n.IpnsRepub.KeyIntervals[name] = d
+	}
 
-		n.IpnsRepub.Interval = d
+	n.IpnsRepub.Exclude = make(map[string]bool)
+	for _, name := range cfg.Ipns.RepublishExclude {
+		n.IpnsRepub.Exclude[name] = true
 	}
 
 	if cfg.Ipns.RecordLifetime != "" {
@@ -616,6 +723,18 @@ func (n *IpfsNode) setupIpnsRepublisher() error {
 	return nil
 }
 
+func parseRepublishPeriod(setting, s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("failure to parse config setting %s: %s", setting, err)
+	}
+
+	if !u.Debug && (d < time.Minute || d > (time.Hour*24)) {
+		return 0, fmt.Errorf("config setting %s is not between 1min and 1day: %s", setting, d)
+	}
+	return d, nil
+}
+
 // Process returns the Process object
 func (n *IpfsNode) Process() goprocess.Process {
 	return n.proc
@@ -673,6 +792,26 @@ func (n *IpfsNode) teardown() error {
 		closers = append(closers, n.Bootstrapper)
 	}
 
+	if n.Peering != nil {
+		closers = append(closers, n.Peering)
+	}
+
+	if n.RelayClient != nil {
+		closers = append(closers, n.RelayClient)
+	}
+
+	if n.RelayService != nil {
+		closers = append(closers, n.RelayService)
+	}
+
+	if n.AutoNAT != nil {
+		closers = append(closers, n.AutoNAT)
+	}
+
+	if n.HolePuncher != nil {
+		closers = append(closers, n.HolePuncher)
+	}
+
 	if n.PeerHost != nil {
 		closers = append(closers, n.PeerHost)
 	}
@@ -880,16 +1019,65 @@ func (n *IpfsNode) SetupOfflineRouting() error {
 
 	n.Routing = offroute.NewOfflineRouter(n.Repo.Datastore(), n.RecordValidator)
 
+	return n.setupNamesys()
+}
+
+// setupNamesys builds the name system on top of n.Routing, with the DNS
+// resolver set in the config
+func (n *IpfsNode) setupNamesys() error {
 	size, err := n.getCacheSize()
 	if err != nil {
 		return err
 	}
 
-	n.Namesys = namesys.NewNameSystem(n.Routing, n.Repo.Datastore(), size)
+	if n.DNSResolver == nil {
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+		n.DNSResolver, err = NewDNSResolver(cfg.DNS)
+		if err != nil {
+			return err
+		}
+	}
 
+	n.Namesys = n.NewNameSystem(size, n.DNSResolver)
 	return nil
 }
 
+// NewNameSystem builds a name system on top of the routing of the node,
+// caching size names and resolving domain names with dns.
+func (n *IpfsNode) NewNameSystem(size int, dns *namesys.DNSResolver) namesys.NameSystem {
+	// the sources of the parallel resolution, and where the records are
+	// published when skipping the DHT
+	local := offroute.NewOfflineRouter(n.Repo.Datastore(), n.RecordValidator)
+	sources := []namesys.RecordSource{{Name: "local", Store: local}}
+	fast := rhelpers.Tiered{Routers: []routing.IpfsRouting{local}, Validator: n.RecordValidator}
+	if n.PSRouter != nil {
+		sources = append(sources, namesys.RecordSource{Name: "pubsub", Store: n.PSRouter})
+		fast.Routers = append(fast.Routers, &rhelpers.Compose{
+			ValueStore: &rhelpers.LimitedValueStore{
+				ValueStore: n.PSRouter,
+				Namespaces: []string{"ipns"},
+			},
+		})
+	}
+	if n.DHT != nil {
+		sources = append(sources, namesys.RecordSource{Name: "dht", Store: n.DHT})
+	} else if n.OnlineMode() {
+		sources = append(sources, namesys.RecordSource{Name: "routing", Store: n.Routing})
+	}
+
+	options := []namesys.Option{
+		namesys.WithRecordSources(sources...),
+		namesys.WithFastRouting(fast),
+	}
+	if dns != nil {
+		options = append(options, namesys.WithDNSResolver(dns))
+	}
+	return namesys.NewNameSystem(n.Routing, n.Repo.Datastore(), size, options...)
+}
+
 func loadPrivateKey(cfg *config.Identity, id peer.ID) (ic.PrivKey, error) {
 	sk, err := cfg.DecodePrivateKey("passphrase todo!")
 	if err != nil {
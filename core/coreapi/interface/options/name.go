@@ -17,6 +17,8 @@ type NamePublishSettings struct {
 	TTL *time.Duration
 
 	AllowOffline bool
+
+	SkipDHT bool
 }
 
 type NameResolveSettings struct {
@@ -106,6 +108,16 @@ func (nameOpts) TTL(ttl time.Duration) NamePublishOption {
 	}
 }
 
+// SkipDHT is an option for Name.Publish which specifies whether to only put
+// the record to the local datastore and pubsub, skipping the slow DHT put. The
+// DHT gets the record at its next republication. Default value is false
+func (nameOpts) SkipDHT(skip bool) NamePublishOption {
+	return func(settings *NamePublishSettings) error {
+		settings.SkipDHT = skip
+		return nil
+	}
+}
+
 // Local is an option for Name.Resolve which specifies if the lookup should be
 // offline. Default value is false
 func (nameOpts) Local(local bool) NameResolveOption {
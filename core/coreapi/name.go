@@ -72,6 +72,10 @@ func (api *NameAPI) Publish(ctx context.Context, p coreiface.Path, opts ...caopt
 		ctx = context.WithValue(ctx, "ipns-publish-ttl", *options.TTL)
 	}
 
+	if options.SkipDHT {
+		ctx = namesys.SkipDHT(ctx)
+	}
+
 	eol := time.Now().Add(options.ValidTime)
 	err = n.Namesys.PublishWithEOL(ctx, k, pth, eol)
 	if err != nil {
@@ -116,7 +120,11 @@ func (api *NameAPI) Search(ctx context.Context, name string, opts ...caopts.Name
 	}
 
 	if !options.Cache {
-		resolver = namesys.NewNameSystem(n.Routing, n.Repo.Datastore(), 0)
+		dns := n.DNSResolver
+		if dns != nil {
+			dns = dns.Uncached()
+		}
+		resolver = n.NewNameSystem(0, dns)
 	}
 
 	if !strings.HasPrefix(name, "/ipns/") {
@@ -0,0 +1,117 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	core "mbfs/go-mbfs/core"
+
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	protocol "mbfs/go-mbfs/gx/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// defaultP2PHTTPProtocol is the libp2p protocol used by /p2p/<peer>/http/
+const defaultP2PHTTPProtocol = "/x/http"
+
+// P2PProxyOption proxies HTTP requests to services that remote peers expose
+// with 'ipfs p2p listen'. Requests to /p2p/<peer>/http/<path> go to the
+// /x/http service of <peer>, and requests to /p2p/<peer>/x/<name>/http/<path>
+// go to its /x/<name> service.
+func P2PProxyOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/p2p/", func(w http.ResponseWriter, r *http.Request) {
+			preq, err := parseP2PRequest(r.URL.Path)
+			if err != nil {
+				webErrorWithCode(w, "failed to parse request", err, http.StatusBadRequest)
+				return
+			}
+
+			if n.PeerHost == nil {
+				webErrorWithCode(w, "p2p proxy unavailable", fmt.Errorf("node is offline"), http.StatusServiceUnavailable)
+				return
+			}
+
+			r.Host = "" // let the URL host take precedence
+			r.URL.Path = preq.httpPath
+
+			target := &url.URL{Scheme: "http", Host: preq.peer.Pretty()}
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			proxy.Transport = &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					s, err := n.PeerHost.NewStream(ctx, preq.peer, preq.proto)
+					if err != nil {
+						return nil, err
+					}
+					return &streamConn{Stream: s}, nil
+				},
+				DisableKeepAlives: true,
+			}
+			proxy.ServeHTTP(w, r)
+		})
+		return mux, nil
+	}
+}
+
+type p2pRequest struct {
+	peer     peer.ID
+	proto    protocol.ID
+	httpPath string
+}
+
+// parseP2PRequest splits /p2p/<peer>/[x/<name>/]http/<path>
+func parseP2PRequest(path string) (*p2pRequest, error) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/p2p/"), "/", 2)
+	if len(parts) < 2 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid request path %q", path)
+	}
+
+	id, err := peer.IDB58Decode(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID %q: %s", parts[0], err)
+	}
+
+	rest := parts[1]
+	proto := protocol.ID(defaultP2PHTTPProtocol)
+	if strings.HasPrefix(rest, "x/") {
+		sp := strings.SplitN(rest[len("x/"):], "/", 2)
+		if len(sp) < 2 || sp[0] == "" {
+			return nil, fmt.Errorf("invalid request path %q", path)
+		}
+		proto = protocol.ID("/x/" + sp[0])
+		rest = sp[1]
+	}
+
+	if rest != "http" && !strings.HasPrefix(rest, "http/") {
+		return nil, fmt.Errorf("invalid request path %q", path)
+	}
+
+	return &p2pRequest{
+		peer:     id,
+		proto:    proto,
+		httpPath: "/" + strings.TrimPrefix(strings.TrimPrefix(rest, "http"), "/"),
+	}, nil
+}
+
+// streamConn lets a libp2p stream be used as a net.Conn
+type streamConn struct {
+	inet.Stream
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return streamAddr(c.Conn().LocalPeer())
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return streamAddr(c.Conn().RemotePeer())
+}
+
+type streamAddr peer.ID
+
+func (a streamAddr) Network() string { return "libp2p" }
+func (a streamAddr) String() string  { return peer.ID(a).Pretty() }
@@ -0,0 +1,51 @@
+package corehttp
+
+import (
+	"testing"
+
+	protocol "mbfs/go-mbfs/gx/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+)
+
+const testPeer = "QmT8JtU54XSmC38xSb1XHFSMm775VuTeajg7LWWWTAwzxT"
+
+func TestParseP2PRequest(t *testing.T) {
+	tcs := []struct {
+		path  string
+		proto protocol.ID
+		http  string
+	}{
+		{"/p2p/" + testPeer + "/http/", "/x/http", "/"},
+		{"/p2p/" + testPeer + "/http", "/x/http", "/"},
+		{"/p2p/" + testPeer + "/http/index.html", "/x/http", "/index.html"},
+		{"/p2p/" + testPeer + "/x/web/http/a/b", "/x/web", "/a/b"},
+	}
+
+	for _, tc := range tcs {
+		req, err := parseP2PRequest(tc.path)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.path, err)
+		}
+		if req.peer.Pretty() != testPeer {
+			t.Errorf("%s: wrong peer %s", tc.path, req.peer.Pretty())
+		}
+		if req.proto != tc.proto {
+			t.Errorf("%s: expected protocol %s, got %s", tc.path, tc.proto, req.proto)
+		}
+		if req.httpPath != tc.http {
+			t.Errorf("%s: expected path %s, got %s", tc.path, tc.http, req.httpPath)
+		}
+	}
+
+	for _, path := range []string{
+		"/p2p/",
+		"/p2p/" + testPeer,
+		"/p2p/" + testPeer + "/ftp/",
+		"/p2p/" + testPeer + "/x/web/",
+		"/p2p/" + testPeer + "/x//http/",
+		"/p2p/notapeer/http/",
+	} {
+		if _, err := parseP2PRequest(path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
@@ -0,0 +1,717 @@
+package corerepo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mbfs/go-mbfs/core"
+	keystore "mbfs/go-mbfs/keystore"
+	pin "mbfs/go-mbfs/pin"
+	fsrepo "mbfs/go-mbfs/repo/fsrepo"
+
+	ci "mbfs/go-mbfs/gx/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	offline "mbfs/go-mbfs/gx/QmPpnbwgAuvhUkA9jGooR88ZwZtTUHXXvoQNKdjZC6nYku/go-ipfs-exchange-offline"
+	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	bstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
+	bserv "mbfs/go-mbfs/gx/QmVPeMNK9DfGLXDZzs2W4RoFWC9Zq1EnLGmLXtYtWrNdcW/go-blockservice"
+	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+	dag "mbfs/go-mbfs/gx/QmaDBne4KeY3UepeqSVKYpSmQGa3q9zP6x3LfVF2UjF3Hc/go-merkledag"
+	dshelp "mbfs/go-mbfs/gx/QmaHSUAhuf9WG3mzJUd1fLDsQGvjsaQdUE7w5cZncz9AcB/go-ipfs-ds-help"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dsq "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/query"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	ipld "mbfs/go-mbfs/gx/QmcKKBwfz6FyQdHR2jsXrrF6XeSBXYL86anmWNewpFpoF5/go-ipld-format"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// A backup is a directory holding the files below, described by its
+// manifest.
+const (
+	BackupManifestFile  = "manifest.json"
+	BackupConfigFile    = "config"
+	BackupKeysFile      = "keys.json"
+	BackupPinsFile      = "pins.json"
+	BackupDatastoreFile = "datastore.json"
+	BackupBlocksFile    = "blocks.car"
+)
+
+// BackupFormat is the version of the backup layout.
+const BackupFormat = 1
+
+// restoreBatchSize is the number of blocks restored at once
+const restoreBatchSize = 256
+
+// filesRootKey is where the node keeps the root of the mfs tree
+var filesRootKey = ds.NewKey("/local/filesroot")
+
+// ipnsPrefix holds the ipns records published by the node
+const ipnsPrefix = "/ipns/"
+
+// routingPrefixes hold the records of the offline router, keyed by the
+// base32 of their routing key: the ipns records, and the public keys needed
+// to validate them
+var routingPrefixes = map[string]string{
+	"/ipns/": routingPrefix("/ipns/"),
+	"/pk/":   routingPrefix("/pk/"),
+}
+
+// routingPrefix returns the prefix shared by the datastore keys of the
+// routing keys starting with ns: the characters of its base32 encoding
+// which don't depend on what follows it
+func routingPrefix(ns string) string {
+	k := dshelp.NewKeyFromBinary([]byte(ns)).String()
+	return k[:1+len(ns)*8/5]
+}
+
+// isRecordKey tells if k is the key of an ipns record or of the public key
+// of one
+func isRecordKey(k string) bool {
+	if strings.HasPrefix(k, ipnsPrefix) {
+		return true
+	}
+	rk, err := dshelp.BinaryFromDsKey(ds.NewKey(k))
+	if err != nil {
+		return false
+	}
+	for ns, prefix := range routingPrefixes {
+		if strings.HasPrefix(k, prefix) && strings.HasPrefix(string(rk), ns) {
+			return true
+		}
+	}
+	return false
+}
+
+// BackupOptions configure Backup.
+type BackupOptions struct {
+	// Blocks includes the pinned blocks and the blocks of the mfs tree in
+	// the backup, as a CAR file.
+	Blocks bool
+}
+
+// BackupManifest describes a backup.
+type BackupManifest struct {
+	Format      int
+	Created     time.Time
+	RepoVersion int
+	PeerID      string
+
+	Keys    int
+	Pins    int
+	Records int
+	Blocks  int
+
+	// Missing counts the blocks which should have been in the CAR file
+	// but aren't in the repo.
+	Missing int `json:",omitempty"`
+
+	// Files maps the name of every file of the backup to its sha256.
+	Files map[string]string
+}
+
+type backupKey struct {
+	Name string
+	Key  []byte
+}
+
+type backupPins struct {
+	Recursive []cid.Cid
+	Direct    []cid.Cid
+}
+
+// Backup writes a snapshot of the config, keys, pins, mfs root and ipns
+// records of the node, and optionally of the blocks they reference, to the
+// directory dest, which must not exist or be empty. Garbage collection is
+// held off while the snapshot is taken.
+func Backup(ctx context.Context, n *core.IpfsNode, configRoot, dest string, opts BackupOptions) (*BackupManifest, error) {
+	if err := makeBackupDir(dest); err != nil {
+		return nil, err
+	}
+
+	defer n.Blockstore.PinLock().Unlock()
+
+	m := &BackupManifest{
+		Format:      BackupFormat,
+		Created:     time.Now().UTC(),
+		RepoVersion: fsrepo.RepoVersion,
+		PeerID:      n.Identity.Pretty(),
+		Files:       make(map[string]string),
+	}
+	bw := &backupWriter{dir: dest, manifest: m}
+
+	cfgFile, err := config.Filename(configRoot)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ioutil.ReadFile(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := bw.writeFile(BackupConfigFile, cfg); err != nil {
+		return nil, err
+	}
+
+	names, err := n.Repo.Keystore().List()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]backupKey, 0, len(names))
+	for _, name := range names {
+		sk, err := n.Repo.Keystore().Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", name, err)
+		}
+		b, err := ci.MarshalPrivateKey(sk)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", name, err)
+		}
+		keys = append(keys, backupKey{Name: name, Key: b})
+	}
+	m.Keys = len(keys)
+	if err := bw.writeJSON(BackupKeysFile, keys); err != nil {
+		return nil, err
+	}
+
+	pins := backupPins{
+		Recursive: n.Pinning.RecursiveKeys(),
+		Direct:    n.Pinning.DirectKeys(),
+	}
+	m.Pins = len(pins.Recursive) + len(pins.Direct)
+	if err := bw.writeJSON(BackupPinsFile, pins); err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string][]byte)
+	var filesRoot cid.Cid
+	if n.FilesRoot != nil {
+		nd, err := n.FilesRoot.GetDirectory().GetNode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the mfs root: %s", err)
+		}
+		filesRoot = nd.Cid()
+		entries[filesRootKey.String()] = filesRoot.Bytes()
+	} else if v, err := n.Repo.Datastore().Get(filesRootKey); err == nil {
+		if filesRoot, err = cid.Cast(v); err != nil {
+			return nil, fmt.Errorf("invalid mfs root: %s", err)
+		}
+		entries[filesRootKey.String()] = v
+	} else if err != ds.ErrNotFound {
+		return nil, err
+	}
+
+	prefixes := []string{ipnsPrefix}
+	for _, prefix := range routingPrefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	for _, prefix := range prefixes {
+		res, err := n.Repo.Datastore().Query(dsq.Query{Prefix: prefix})
+		if err != nil {
+			return nil, err
+		}
+		for r := range res.Next() {
+			if r.Error != nil {
+				res.Close()
+				return nil, r.Error
+			}
+			if isRecordKey(r.Key) {
+				entries[r.Key] = r.Value
+				m.Records++
+			}
+		}
+		res.Close()
+	}
+	if err := bw.writeJSON(BackupDatastoreFile, entries); err != nil {
+		return nil, err
+	}
+
+	if opts.Blocks {
+		roots := append(append([]cid.Cid{}, pins.Recursive...), pins.Direct...)
+		if filesRoot.Defined() {
+			roots = append(roots, filesRoot)
+		}
+		err := bw.writeWith(BackupBlocksFile, func(w io.Writer) error {
+			return writeBlocks(ctx, n.Blockstore, w, roots, pins.Recursive, filesRoot, m)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write the blocks: %s", err)
+		}
+	}
+
+	if err := bw.writeJSON(BackupManifestFile, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func makeBackupDir(dest string) error {
+	entries, err := ioutil.ReadDir(dest)
+	switch {
+	case os.IsNotExist(err):
+		return os.MkdirAll(dest, 0700)
+	case err != nil:
+		return err
+	case len(entries) > 0:
+		return fmt.Errorf("%s is not empty", dest)
+	}
+	return nil
+}
+
+// writeBlocks writes the blocks of the dags of the recursive pins and of the
+// mfs root, and the direct pins, to a CAR
+func writeBlocks(ctx context.Context, bs bstore.Blockstore, w io.Writer, roots, recursive []cid.Cid, filesRoot cid.Cid, m *BackupManifest) error {
+	cw, err := newCarWriter(w, roots)
+	if err != nil {
+		return err
+	}
+
+	seen := cid.NewSet()
+	var walk func(c cid.Cid, links bool) error
+	walk = func(c cid.Cid, links bool) error {
+		if !seen.Visit(c) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b, err := bs.Get(c)
+		if err == bstore.ErrNotFound {
+			m.Missing++
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := cw.put(b); err != nil {
+			return err
+		}
+		m.Blocks++
+		if !links {
+			return nil
+		}
+		nd, err := ipld.Decode(b)
+		if err != nil {
+			// blocks of unknown formats are kept, not walked
+			return nil
+		}
+		for _, l := range nd.Links() {
+			if err := walk(l.Cid, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, c := range recursive {
+		if err := walk(c, true); err != nil {
+			return err
+		}
+	}
+	if filesRoot.Defined() {
+		if err := walk(filesRoot, true); err != nil {
+			return err
+		}
+	}
+	for _, c := range roots {
+		if err := walk(c, false); err != nil {
+			return err
+		}
+	}
+	return cw.flush()
+}
+
+type backupWriter struct {
+	dir      string
+	manifest *BackupManifest
+}
+
+func (bw *backupWriter) writeWith(name string, write func(io.Writer) error) error {
+	f, err := os.OpenFile(filepath.Join(bw.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if err := write(io.MultiWriter(f, h)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if name != BackupManifestFile {
+		bw.manifest.Files[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
+}
+
+func (bw *backupWriter) writeFile(name string, b []byte) error {
+	return bw.writeWith(name, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+func (bw *backupWriter) writeJSON(name string, v interface{}) error {
+	return bw.writeWith(name, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	})
+}
+
+// RestoreResult reports what Restore brought back.
+type RestoreResult struct {
+	PeerID  string
+	Keys    int
+	Pins    int
+	Records int
+	Blocks  int
+
+	// FilesRoot is the restored mfs root, empty if the backup doesn't
+	// hold its blocks and the repo doesn't have them either.
+	FilesRoot string `json:",omitempty"`
+}
+
+// snapshot is a backup read and validated by loadBackup
+type snapshot struct {
+	dir      string
+	manifest BackupManifest
+	config   *config.Config
+	keys     map[string]ci.PrivKey
+	pins     backupPins
+	entries  map[string][]byte
+}
+
+// Restore validates the backup in src and rebuilds the repo at repoPath
+// from it. The repo is initialized if needed; restoring over an existing
+// repo requires force, and keeps its datastore configuration. The repo must
+// not be in use.
+func Restore(ctx context.Context, repoPath, src string, force bool) (*RestoreResult, error) {
+	s, err := loadBackup(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup: %s", err)
+	}
+
+	if fsrepo.IsInitialized(repoPath) {
+		if !force {
+			return nil, fmt.Errorf("a repo already exists at %s, use --force to restore over it", repoPath)
+		}
+		cur, err := fsrepo.ConfigAt(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		s.config.Datastore = cur.Datastore
+	} else if err := fsrepo.Init(repoPath, s.config); err != nil {
+		return nil, err
+	}
+
+	r, err := fsrepo.Open(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// the config is put back if the keys can't be restored, not to leave
+	// the identity of the backup with the keys of the repo
+	cur, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	old := *cur
+	if err := r.SetConfig(s.config); err != nil {
+		return nil, err
+	}
+
+	res := &RestoreResult{PeerID: s.manifest.PeerID}
+
+	if err := restoreKeys(r.Keystore(), s.keys); err != nil {
+		if cerr := r.SetConfig(&old); cerr != nil {
+			return nil, fmt.Errorf("%s, and the config could not be put back: %s", err, cerr)
+		}
+		return nil, err
+	}
+	res.Keys = len(s.keys)
+
+	bs := bstore.NewBlockstore(r.Datastore())
+	if _, ok := s.manifest.Files[BackupBlocksFile]; ok {
+		batch := make([]blocks.Block, 0, restoreBatchSize)
+		res.Blocks, err = s.eachBlock(func(b blocks.Block) error {
+			batch = append(batch, b)
+			if len(batch) < restoreBatchSize {
+				return nil
+			}
+			err := bs.PutMany(batch)
+			batch = batch[:0]
+			return err
+		})
+		if err == nil && len(batch) > 0 {
+			err = bs.PutMany(batch)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore the blocks: %s", err)
+		}
+	}
+
+	for k, v := range s.entries {
+		key := ds.NewKey(k)
+		if key == filesRootKey {
+			c, _ := cid.Cast(v)
+			has, err := bs.Has(c)
+			if err != nil {
+				return nil, err
+			}
+			if !has {
+				log.Warningf("restore: the blocks of the mfs root %s are not available, not restoring it", c)
+				continue
+			}
+			res.FilesRoot = c.String()
+		} else {
+			res.Records++
+		}
+		if err := r.Datastore().Put(key, v); err != nil {
+			return nil, err
+		}
+	}
+
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	pinner := pin.NewPinner(r.Datastore(), dag.NewDAGService(bsrv), dag.NewDAGService(bsrv))
+	for _, c := range s.pins.Recursive {
+		pinner.PinWithMode(c, pin.Recursive)
+	}
+	for _, c := range s.pins.Direct {
+		pinner.PinWithMode(c, pin.Direct)
+	}
+	if err := pinner.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to restore the pins: %s", err)
+	}
+	res.Pins = len(s.pins.Recursive) + len(s.pins.Direct)
+
+	return res, nil
+}
+
+// restoreKeys makes the keystore hold the keys of the backup. The keys of
+// the backup are written before the other ones are deleted, and the
+// keystore is put back as it was if any of this fails.
+func restoreKeys(ks keystore.Keystore, keys map[string]ci.PrivKey) error {
+	names, err := ks.List()
+	if err != nil {
+		return err
+	}
+	old := make(map[string]ci.PrivKey, len(names))
+	for _, name := range names {
+		sk, err := ks.Get(name)
+		if err != nil {
+			return fmt.Errorf("key %s: %s", name, err)
+		}
+		old[name] = sk
+	}
+
+	// the names of the keys added, and the keys replaced or deleted
+	var added []string
+	changed := make(map[string]ci.PrivKey)
+	rollback := func(err error) error {
+		for _, name := range added {
+			ks.Delete(name)
+		}
+		for name, sk := range changed {
+			ks.Delete(name)
+			if perr := ks.Put(name, sk); perr != nil {
+				return fmt.Errorf("%s, and the key %s could not be put back: %s", err, name, perr)
+			}
+		}
+		return err
+	}
+
+	for name, sk := range keys {
+		cur, ok := old[name]
+		if ok && cur.Equals(sk) {
+			continue
+		}
+		if ok {
+			if err := ks.Delete(name); err != nil {
+				return rollback(fmt.Errorf("key %s: %s", name, err))
+			}
+			changed[name] = cur
+		}
+		if err := ks.Put(name, sk); err != nil {
+			return rollback(fmt.Errorf("key %s: %s", name, err))
+		}
+		if !ok {
+			added = append(added, name)
+		}
+	}
+
+	for name, sk := range old {
+		if _, ok := keys[name]; ok {
+			continue
+		}
+		if err := ks.Delete(name); err != nil {
+			return rollback(fmt.Errorf("key %s: %s", name, err))
+		}
+		changed[name] = sk
+	}
+	return nil
+}
+
+// loadBackup reads the backup in dir, checking its files against the
+// manifest and their contents, so that nothing is restored from a damaged
+// or incomplete backup
+func loadBackup(dir string) (*snapshot, error) {
+	s := &snapshot{dir: dir}
+	if err := readJSON(filepath.Join(dir, BackupManifestFile), &s.manifest); err != nil {
+		return nil, err
+	}
+	m := &s.manifest
+	if m.Format != BackupFormat {
+		return nil, fmt.Errorf("unsupported backup format %d", m.Format)
+	}
+	if m.RepoVersion > fsrepo.RepoVersion {
+		return nil, fmt.Errorf("the backup is of a version %d repo, newer than this version of mbfs supports (%d)", m.RepoVersion, fsrepo.RepoVersion)
+	}
+
+	for _, name := range []string{BackupConfigFile, BackupKeysFile, BackupPinsFile, BackupDatastoreFile} {
+		if _, ok := m.Files[name]; !ok {
+			return nil, fmt.Errorf("%s is missing from the manifest", name)
+		}
+	}
+	for name, sum := range m.Files {
+		if name != filepath.Base(name) {
+			return nil, fmt.Errorf("invalid file name %q in the manifest", name)
+		}
+		got, err := fileSum(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if got != sum {
+			return nil, fmt.Errorf("checksum mismatch for %s", name)
+		}
+	}
+
+	s.config = new(config.Config)
+	if err := readJSON(filepath.Join(dir, BackupConfigFile), s.config); err != nil {
+		return nil, err
+	}
+	sk, err := s.config.Identity.DecodePrivateKey("")
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in the config: %s", err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	if id.Pretty() != s.config.Identity.PeerID || id.Pretty() != m.PeerID {
+		return nil, errors.New("the peer id doesn't match the private key of the config")
+	}
+
+	var keys []backupKey
+	if err := readJSON(filepath.Join(dir, BackupKeysFile), &keys); err != nil {
+		return nil, err
+	}
+	s.keys = make(map[string]ci.PrivKey, len(keys))
+	for _, k := range keys {
+		sk, err := ci.UnmarshalPrivateKey(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %s", k.Name, err)
+		}
+		if _, ok := s.keys[k.Name]; ok || k.Name == "" || k.Name == "self" || strings.Contains(k.Name, "/") || strings.HasPrefix(k.Name, ".") {
+			return nil, fmt.Errorf("invalid or duplicate key name %q", k.Name)
+		}
+		s.keys[k.Name] = sk
+	}
+
+	if err := readJSON(filepath.Join(dir, BackupPinsFile), &s.pins); err != nil {
+		return nil, err
+	}
+
+	if err := readJSON(filepath.Join(dir, BackupDatastoreFile), &s.entries); err != nil {
+		return nil, err
+	}
+	for k, v := range s.entries {
+		switch {
+		case ds.NewKey(k) == filesRootKey:
+			if _, err := cid.Cast(v); err != nil {
+				return nil, fmt.Errorf("invalid mfs root: %s", err)
+			}
+		case isRecordKey(k):
+		default:
+			return nil, fmt.Errorf("unexpected datastore key %s", k)
+		}
+	}
+
+	if _, ok := m.Files[BackupBlocksFile]; ok {
+		n, err := s.eachBlock(func(blocks.Block) error { return nil })
+		if err != nil {
+			return nil, err
+		}
+		if n != m.Blocks {
+			return nil, fmt.Errorf("the CAR file holds %d blocks, expected %d", n, m.Blocks)
+		}
+	}
+	return s, nil
+}
+
+// eachBlock calls f with every block of the CAR file of the backup, and
+// returns their number
+func (s *snapshot) eachBlock(f func(blocks.Block) error) (int, error) {
+	fi, err := os.Open(filepath.Join(s.dir, BackupBlocksFile))
+	if err != nil {
+		return 0, err
+	}
+	defer fi.Close()
+
+	cr, err := newCarReader(fi)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		b, err := cr.next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := f(b); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %s", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func fileSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
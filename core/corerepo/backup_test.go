@@ -0,0 +1,243 @@
+package corerepo
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mbfs/go-mbfs/core"
+	keystore "mbfs/go-mbfs/keystore"
+	pin "mbfs/go-mbfs/pin"
+	"mbfs/go-mbfs/plugin/loader"
+	fsrepo "mbfs/go-mbfs/repo/fsrepo"
+
+	ci "mbfs/go-mbfs/gx/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	offline "mbfs/go-mbfs/gx/QmPpnbwgAuvhUkA9jGooR88ZwZtTUHXXvoQNKdjZC6nYku/go-ipfs-exchange-offline"
+	bstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
+	bserv "mbfs/go-mbfs/gx/QmVPeMNK9DfGLXDZzs2W4RoFWC9Zq1EnLGmLXtYtWrNdcW/go-blockservice"
+	dag "mbfs/go-mbfs/gx/QmaDBne4KeY3UepeqSVKYpSmQGa3q9zP6x3LfVF2UjF3Hc/go-merkledag"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+)
+
+func newKey(t *testing.T) ci.PrivKey {
+	t.Helper()
+	sk, _, err := ci.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk
+}
+
+func initRepo(t *testing.T, path string) {
+	t.Helper()
+	cfg, err := config.Init(ioutil.Discard, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsrepo.Init(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	loader.LoadPlugins("")
+	ctx := context.Background()
+
+	tmp, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	orig := filepath.Join(tmp, "orig")
+	dest := filepath.Join(tmp, "backup")
+
+	initRepo(t, orig)
+	r, err := fsrepo.Open(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetConfigKey("Addresses.Gateway", "/ip4/127.0.0.1/tcp/18080"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := core.NewNode(ctx, &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk := newKey(t)
+	if err := n.Repo.Keystore().Put("mykey", sk); err != nil {
+		t.Fatal(err)
+	}
+	nd := dag.NodeWithData([]byte("pinned"))
+	if err := n.DAG.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Pin(ctx, nd, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Backup(ctx, n, orig, dest, BackupOptions{Blocks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Close()
+
+	check := func(path string) {
+		t.Helper()
+		r, err := fsrepo.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		cfg, err := r.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Identity.PeerID != m.PeerID {
+			t.Fatalf("expected the identity %s, got %s", m.PeerID, cfg.Identity.PeerID)
+		}
+		if len(cfg.Addresses.Gateway) != 1 || cfg.Addresses.Gateway[0] != "/ip4/127.0.0.1/tcp/18080" {
+			t.Fatalf("the config was not restored, the gateway is %s", cfg.Addresses.Gateway)
+		}
+
+		names, err := r.Keystore().List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 1 || names[0] != "mykey" {
+			t.Fatalf("expected only mykey in the keystore, got %v", names)
+		}
+		k, err := r.Keystore().Get("mykey")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !k.Equals(sk) {
+			t.Fatal("mykey was not restored")
+		}
+
+		bs := bstore.NewBlockstore(r.Datastore())
+		dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+		pinner, err := pin.LoadPinner(r.Datastore(), dserv, dserv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mode, pinned, err := pinner.IsPinned(nd.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pinned || mode != "recursive" {
+			t.Fatalf("expected %s to be pinned recursively, got %q", nd.Cid(), mode)
+		}
+		if has, err := bs.Has(nd.Cid()); err != nil || !has {
+			t.Fatal("the pinned block was not restored", err)
+		}
+	}
+
+	fresh := filepath.Join(tmp, "fresh")
+	if _, err := Restore(ctx, fresh, dest, false); err != nil {
+		t.Fatal(err)
+	}
+	check(fresh)
+
+	// over an existing repo, holding another key and a different mykey
+	other := filepath.Join(tmp, "other")
+	initRepo(t, other)
+	r, err = fsrepo.Open(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Keystore().Put("mykey", newKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Keystore().Put("extra", newKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	if _, err := Restore(ctx, other, dest, false); err == nil {
+		t.Fatal("restoring over an existing repo should require force")
+	}
+	if _, err := Restore(ctx, other, dest, true); err != nil {
+		t.Fatal(err)
+	}
+	check(other)
+
+	// the config is put back when the keys can't be restored
+	broken := filepath.Join(tmp, "broken")
+	initRepo(t, broken)
+	cfg, err := fsrepo.ConfigAt(broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a key that can't be read, in the keystore made on opening the repo
+	r, err = fsrepo.Open(broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if err := os.Mkdir(filepath.Join(broken, "keystore", "unreadable"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Restore(ctx, broken, dest, true); err == nil {
+		t.Fatal("expected the restore of the keys to fail")
+	}
+	after, err := fsrepo.ConfigAt(broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Identity.PeerID != cfg.Identity.PeerID || len(after.Addresses.Gateway) != len(cfg.Addresses.Gateway) || after.Addresses.Gateway[0] != cfg.Addresses.Gateway[0] {
+		t.Fatalf("the config was not put back, the identity is %s", after.Identity.PeerID)
+	}
+}
+
+// failingKeystore fails to put the key named fail
+type failingKeystore struct {
+	keystore.Keystore
+	fail string
+}
+
+func (ks *failingKeystore) Put(name string, k ci.PrivKey) error {
+	if name == ks.fail {
+		return errors.New("put failed")
+	}
+	return ks.Keystore.Put(name, k)
+}
+
+func TestRestoreKeysRollback(t *testing.T) {
+	ks := &failingKeystore{Keystore: keystore.NewMemKeystore(), fail: "b"}
+	a, old := newKey(t), newKey(t)
+	if err := ks.Put("a", old); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("c", old); err != nil {
+		t.Fatal(err)
+	}
+
+	err := restoreKeys(ks, map[string]ci.PrivKey{"a": a, "b": newKey(t)})
+	if err == nil {
+		t.Fatal("expected the restore of the keys to fail")
+	}
+
+	names, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("expected the keystore to be put back, got %v", names)
+	}
+	for _, name := range []string{"a", "c"} {
+		k, err := ks.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !k.Equals(old) {
+			t.Fatalf("key %s was not put back", name)
+		}
+	}
+}
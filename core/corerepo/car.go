@@ -0,0 +1,163 @@
+package corerepo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	cbor "mbfs/go-mbfs/gx/QmRoARq3nkUb13HSKZGepCZSWe5GrVPwx7xURJGZ7KWv9V/go-ipld-cbor"
+	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+)
+
+// A CAR (content addressable archive) is a dag-cbor header listing the roots
+// of the archive followed by the blocks, each prefixed by the varint length
+// of its cid and data. See https://github.com/ipld/specs/blob/master/block-layer/content-addressable-archives.md
+
+// maxCarSection bounds the size of the header and blocks read from a CAR
+const maxCarSection = 32 << 20
+
+type carHeader struct {
+	Roots   []cid.Cid `refmt:"roots"`
+	Version uint64    `refmt:"version"`
+}
+
+func init() {
+	cbor.RegisterCborType(carHeader{})
+}
+
+type carWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func newCarWriter(w io.Writer, roots []cid.Cid) (*carWriter, error) {
+	h, err := cbor.DumpObject(&carHeader{Roots: roots, Version: 1})
+	if err != nil {
+		return nil, err
+	}
+	cw := &carWriter{w: bufio.NewWriter(w)}
+	if err := cw.section(h); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *carWriter) section(parts ...[]byte) error {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+	n := binary.PutUvarint(cw.buf[:], uint64(size))
+	if _, err := cw.w.Write(cw.buf[:n]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := cw.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cw *carWriter) put(b blocks.Block) error {
+	return cw.section(b.Cid().Bytes(), b.RawData())
+}
+
+func (cw *carWriter) flush() error {
+	return cw.w.Flush()
+}
+
+type carReader struct {
+	r      *bufio.Reader
+	header carHeader
+}
+
+func newCarReader(r io.Reader) (*carReader, error) {
+	cr := &carReader{r: bufio.NewReader(r)}
+	h, err := cr.section()
+	if err == io.EOF {
+		return nil, errors.New("car: missing header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := cbor.DecodeInto(h, &cr.header); err != nil {
+		return nil, fmt.Errorf("car: invalid header: %s", err)
+	}
+	if cr.header.Version != 1 {
+		return nil, fmt.Errorf("car: unsupported version %d", cr.header.Version)
+	}
+	return cr, nil
+}
+
+func (cr *carReader) section() ([]byte, error) {
+	size, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxCarSection {
+		return nil, fmt.Errorf("car: section of %d bytes is too large", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// next returns the next block, checking that its data matches its cid, or
+// io.EOF at the end of the archive
+func (cr *carReader) next() (blocks.Block, error) {
+	s, err := cr.section()
+	if err != nil {
+		return nil, err
+	}
+	n, err := cidLen(s)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cid.Cast(s[:n])
+	if err != nil {
+		return nil, fmt.Errorf("car: invalid cid: %s", err)
+	}
+	data := s[n:]
+
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !sum.Equals(c) {
+		return nil, fmt.Errorf("car: data of block %s doesn't match its hash", c)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// cidLen returns the length of the cid at the start of b
+func cidLen(b []byte) (int, error) {
+	// CIDv0 is a bare sha2-256 multihash
+	if len(b) >= 34 && b[0] == 0x12 && b[1] == 0x20 {
+		return 34, nil
+	}
+
+	// version, codec, hash function and digest length
+	off := 0
+	var digest uint64
+	for i := 0; i < 4; i++ {
+		v, n := binary.Uvarint(b[off:])
+		if n <= 0 {
+			return 0, errors.New("car: invalid cid")
+		}
+		off += n
+		digest = v
+	}
+	if uint64(len(b)-off) < digest {
+		return 0, errors.New("car: truncated cid")
+	}
+	return off + int(digest), nil
+}
@@ -0,0 +1,69 @@
+package corerepo
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+	mh "mbfs/go-mbfs/gx/QmerPMzPk1mJVowm8KgmoknWa4yCYvvugMPsgWmDNUvDLW/go-multihash"
+)
+
+func TestCarRoundTrip(t *testing.T) {
+	v0 := blocks.NewBlock([]byte("a v0 block"))
+	v1, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: mh.SHA2_256, MhLength: -1}.Sum([]byte("a raw block"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := blocks.NewBlockWithCid([]byte("a raw block"), v1)
+	in := []blocks.Block{v0, raw}
+
+	var buf bytes.Buffer
+	cw, err := newCarWriter(&buf, []cid.Cid{v0.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range in {
+		if err := cw.put(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	cr, err := newCarReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.header.Roots) != 1 || !cr.header.Roots[0].Equals(v0.Cid()) {
+		t.Fatalf("wrong roots: %v", cr.header.Roots)
+	}
+	for _, expected := range in {
+		b, err := cr.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !b.Cid().Equals(expected.Cid()) || !bytes.Equal(b.RawData(), expected.RawData()) {
+			t.Fatalf("got block %s, expected %s", b.Cid(), expected.Cid())
+		}
+	}
+	if _, err := cr.next(); err != io.EOF {
+		t.Fatal("expected the end of the archive, got", err)
+	}
+
+	// corrupt the data of the last block
+	data[len(data)-1] ^= 1
+	cr, err = newCarReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cr.next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cr.next(); err == nil {
+		t.Fatal("expected the corrupted block to be rejected")
+	}
+}
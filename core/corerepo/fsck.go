@@ -0,0 +1,491 @@
+package corerepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mbfs/go-mbfs/core"
+	filestore "mbfs/go-mbfs/filestore"
+	pin "mbfs/go-mbfs/pin"
+	repo "mbfs/go-mbfs/repo"
+	fsrepo "mbfs/go-mbfs/repo/fsrepo"
+
+	offline "mbfs/go-mbfs/gx/QmPpnbwgAuvhUkA9jGooR88ZwZtTUHXXvoQNKdjZC6nYku/go-ipfs-exchange-offline"
+	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	bstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
+	bserv "mbfs/go-mbfs/gx/QmVPeMNK9DfGLXDZzs2W4RoFWC9Zq1EnLGmLXtYtWrNdcW/go-blockservice"
+	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+	ft "mbfs/go-mbfs/gx/QmXLCwhHh7bxRsBnCKNE9BAN87V44aSxXLquZYTtjr6fZ3/go-unixfs"
+	dag "mbfs/go-mbfs/gx/QmaDBne4KeY3UepeqSVKYpSmQGa3q9zP6x3LfVF2UjF3Hc/go-merkledag"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	ipld "mbfs/go-mbfs/gx/QmcKKBwfz6FyQdHR2jsXrrF6XeSBXYL86anmWNewpFpoF5/go-ipld-format"
+)
+
+// FsckKind is the kind of a problem found by Fsck.
+type FsckKind string
+
+// These are the problems Fsck looks for.
+const (
+	// FsckCorruptBlock is a block whose data doesn't match its hash. It is
+	// deleted, and fixed if it can be fetched again.
+	FsckCorruptBlock FsckKind = "corrupt-block"
+
+	// FsckFilestoreOrphan is a filestore entry whose file is gone, or whose
+	// contents changed. It is fixed by removing the entry.
+	FsckFilestoreOrphan FsckKind = "filestore-orphan"
+
+	// FsckFilestoreEntry is a filestore entry which cannot be verified, as
+	// its file cannot be read or the entry cannot be decoded. It is only
+	// reported, as the file may be readable again later.
+	FsckFilestoreEntry FsckKind = "filestore-entry"
+
+	// FsckPinSet is a part of the pin set which cannot be read. It is fixed
+	// by writing a new pin set with the pins which could be read; the old
+	// pin set root is kept under /local/pins-recovery.
+	FsckPinSet FsckKind = "pin-set"
+
+	// FsckIncompletePin is a pin whose dag has missing blocks. It is fixed
+	// by fetching them.
+	FsckIncompletePin FsckKind = "incomplete-pin"
+
+	// FsckFilesRoot is an unreadable mfs root. It is fixed by resetting mfs
+	// to an empty directory; the old root is kept under
+	// /local/filesroot-recovery.
+	FsckFilesRoot FsckKind = "files-root"
+)
+
+var errNoFetch = errors.New("not fetching")
+
+var (
+	pinsKey              = ds.NewKey("/local/pins")
+	pinsRecoveryKey      = ds.NewKey("/local/pins-recovery")
+	filesRootRecoveryKey = ds.NewKey("/local/filesroot-recovery")
+)
+
+// FsckOptions configure Fsck.
+type FsckOptions struct {
+	// Fix repairs the problems found. Without it the repo isn't modified.
+	Fix bool
+
+	// Fetch gets the missing and corrupt blocks from the network when
+	// fixing, with a node brought online for that purpose.
+	Fetch bool
+
+	// FetchTimeout bounds the time spent fetching each block. Zero means a
+	// minute.
+	FetchTimeout time.Duration
+}
+
+// FsckProblem is a problem found by Fsck.
+type FsckProblem struct {
+	Kind FsckKind
+
+	// Key is the cid of the block, pin or filestore entry concerned, or the
+	// datastore key.
+	Key    string
+	Detail string
+
+	Fixed bool
+	// Action tells what was done to fix the problem.
+	Action string `json:",omitempty"`
+}
+
+// FsckReport is the outcome of Fsck.
+type FsckReport struct {
+	Blocks   int
+	Pins     int
+	Problems []*FsckProblem
+}
+
+// Unfixed counts the problems which were not fixed.
+func (r *FsckReport) Unfixed() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Fixed {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck checks the repo at repoPath for corrupt blocks, orphaned filestore
+// entries, an unreadable mfs root, a damaged pin set and incomplete pins,
+// and repairs them with opts.Fix. It works on the repo rather than on a
+// node, as a node cannot be built on a repo whose mfs root is unreadable.
+// Nothing else may use the repo meanwhile.
+func Fsck(ctx context.Context, repoPath string, opts FsckOptions) (*FsckReport, error) {
+	if opts.FetchTimeout == 0 {
+		opts.FetchTimeout = time.Minute
+	}
+
+	r, err := fsrepo.Open(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f := &fsck{
+		ctx:    ctx,
+		path:   repoPath,
+		r:      r,
+		opts:   opts,
+		report: new(FsckReport),
+		bs:     bstore.NewBlockstore(r.Datastore()),
+	}
+	f.blocks = f.bs
+	if fm := r.FileManager(); fm != nil {
+		f.fs = filestore.NewFilestore(f.bs, fm)
+		f.blocks = f.fs
+	}
+	f.dag = dag.NewDAGService(bserv.New(f.blocks, offline.Exchange(f.blocks)))
+	defer func() {
+		if f.node != nil {
+			f.node.Close()
+		}
+	}()
+
+	// the mfs root is repaired before fetching anything, as the node
+	// used to fetch needs it
+	for _, check := range []func() error{
+		f.checkBlocks,
+		f.checkFilestore,
+		f.checkFilesRoot,
+		f.fetchCorrupt,
+		f.checkPins,
+	} {
+		if err := check(); err != nil {
+			return f.report, err
+		}
+	}
+	return f.report, nil
+}
+
+type fsck struct {
+	ctx    context.Context
+	path   string
+	r      repo.Repo
+	opts   FsckOptions
+	report *FsckReport
+
+	bs     bstore.Blockstore // the blocks of the repo
+	fs     *filestore.Filestore
+	blocks bstore.Blockstore // bs, and the filestore if enabled
+	dag    ipld.DAGService
+
+	// corrupt blocks deleted, to fetch again
+	deleted map[cid.Cid]*FsckProblem
+
+	// node is brought online to fetch blocks
+	node *core.IpfsNode
+}
+
+func (f *fsck) problem(kind FsckKind, key, detail string) *FsckProblem {
+	p := &FsckProblem{Kind: kind, Key: key, Detail: detail}
+	f.report.Problems = append(f.report.Problems, p)
+	return p
+}
+
+// fetch gets a block from the network and stores it
+func (f *fsck) fetch(c cid.Cid) (blocks.Block, error) {
+	if !f.opts.Fix || !f.opts.Fetch {
+		return nil, errNoFetch
+	}
+	if f.node == nil {
+		// the node owns its reference to the repo
+		r, err := fsrepo.Open(f.path)
+		if err != nil {
+			return nil, err
+		}
+		n, err := core.NewNode(f.ctx, &core.BuildCfg{Repo: r, Online: true})
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		f.node = n
+	}
+	ctx, cancel := context.WithTimeout(f.ctx, f.opts.FetchTimeout)
+	defer cancel()
+	return f.node.Blocks.GetBlock(ctx, c)
+}
+
+func (f *fsck) checkBlocks() error {
+	// a blockstore of its own, not to hash every block read afterwards
+	bs := bstore.NewBlockstore(f.r.Datastore())
+	bs.HashOnRead(true)
+
+	keys, err := bs.AllKeysChan(f.ctx)
+	if err != nil {
+		return err
+	}
+	var corrupt []cid.Cid
+	for c := range keys {
+		f.report.Blocks++
+		if _, err := bs.Get(c); err == bstore.ErrHashMismatch {
+			corrupt = append(corrupt, c)
+		}
+	}
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range corrupt {
+		p := f.problem(FsckCorruptBlock, c.String(), "the data doesn't match the hash")
+		if !f.opts.Fix {
+			continue
+		}
+		if err := bs.DeleteBlock(c); err != nil {
+			return err
+		}
+		p.Action = "deleted"
+		if f.deleted == nil {
+			f.deleted = make(map[cid.Cid]*FsckProblem)
+		}
+		f.deleted[c] = p
+	}
+	return nil
+}
+
+func (f *fsck) fetchCorrupt() error {
+	for c, p := range f.deleted {
+		_, err := f.fetch(c)
+		if err == errNoFetch {
+			continue
+		}
+		if err != nil {
+			p.Action += fmt.Sprintf(", could not fetch it again: %s", err)
+			continue
+		}
+		p.Fixed = true
+		p.Action += " and fetched again"
+	}
+	return nil
+}
+
+func (f *fsck) checkFilestore() error {
+	fs := f.fs
+	if fs == nil {
+		return nil
+	}
+	next, err := filestore.VerifyAll(fs, false)
+	if err != nil {
+		return err
+	}
+	for r := next(); r != nil; r = next() {
+		if err := f.ctx.Err(); err != nil {
+			return err
+		}
+		switch r.Status {
+		case filestore.StatusOk:
+			continue
+		case filestore.StatusFileNotFound, filestore.StatusFileChanged:
+		default:
+			f.problem(FsckFilestoreEntry, r.Key.String(), r.ErrorMsg)
+			continue
+		}
+		p := f.problem(FsckFilestoreOrphan, r.Key.String(), r.ErrorMsg)
+		if !f.opts.Fix {
+			continue
+		}
+		if err := fs.FileManager().DeleteBlock(r.Key); err != nil {
+			return err
+		}
+		p.Fixed = true
+		p.Action = "removed the entry"
+	}
+	return nil
+}
+
+func (f *fsck) checkPins() error {
+	d := f.r.Datastore()
+	internal := dag.NewDAGService(bserv.New(f.bs, offline.Exchange(f.bs)))
+
+	pinner, errs := pin.RecoverPinner(d, f.dag, internal)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			f.problem(FsckPinSet, pinsKey.String(), err.Error())
+		}
+		if f.opts.Fix {
+			if err := f.rewritePins(pinner); err != nil {
+				return err
+			}
+		}
+	}
+
+	recursive := pinner.RecursiveKeys()
+	direct := pinner.DirectKeys()
+	f.report.Pins = len(recursive) + len(direct)
+
+	// blocks seen complete, shared by the dags of the pins
+	seen := cid.NewSet()
+	for _, c := range recursive {
+		if err := f.checkPin(c, true, seen); err != nil {
+			return err
+		}
+	}
+	for _, c := range direct {
+		if err := f.checkPin(c, false, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewritePins writes the recovered pin set, keeping the old root
+func (f *fsck) rewritePins(pinner pin.Pinner) error {
+	d := f.r.Datastore()
+	old, err := d.Get(pinsKey)
+	switch err {
+	case nil:
+		if err := d.Put(pinsRecoveryKey, old); err != nil {
+			return err
+		}
+	case ds.ErrNotFound:
+	default:
+		return err
+	}
+	if err := pinner.Flush(); err != nil {
+		return fmt.Errorf("failed to write the pin set: %s", err)
+	}
+
+	action := fmt.Sprintf("wrote a new pin set with the %d recovered pins", len(pinner.RecursiveKeys())+len(pinner.DirectKeys()))
+	if old != nil {
+		action += fmt.Sprintf(", the old one is kept under %s", pinsRecoveryKey)
+	}
+	for _, p := range f.report.Problems {
+		if p.Kind == FsckPinSet {
+			p.Fixed = true
+			p.Action = action
+		}
+	}
+	return nil
+}
+
+func (f *fsck) checkPin(root cid.Cid, recursive bool, seen *cid.Set) error {
+	var missing []cid.Cid
+	fetched := 0
+	var walk func(c cid.Cid) error
+	walk = func(c cid.Cid) error {
+		if !seen.Visit(c) {
+			return nil
+		}
+		if err := f.ctx.Err(); err != nil {
+			return err
+		}
+		b, err := f.blocks.Get(c)
+		if err == bstore.ErrNotFound && f.opts.Fetch {
+			if b, err = f.fetch(c); err == nil {
+				fetched++
+			}
+		}
+		if err != nil {
+			// forget it, so that the other pins sharing it report it too
+			seen.Remove(c)
+			missing = append(missing, c)
+			return nil
+		}
+		if !recursive {
+			return nil
+		}
+		nd, err := ipld.Decode(b)
+		if err != nil {
+			// blocks of unknown formats have no links we can follow
+			return nil
+		}
+		for _, l := range nd.Links() {
+			if err := walk(l.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(root); err != nil {
+		return err
+	}
+	if len(missing) == 0 && fetched == 0 {
+		return nil
+	}
+
+	detail := fmt.Sprintf("%d blocks missing", len(missing)+fetched)
+	if len(missing) > 0 {
+		detail += fmt.Sprintf(", first %s", missing[0])
+	}
+	p := f.problem(FsckIncompletePin, root.String(), detail)
+	switch {
+	case len(missing) == 0:
+		p.Fixed = true
+		p.Action = fmt.Sprintf("fetched %d blocks", fetched)
+	case f.opts.Fix && !f.opts.Fetch:
+		p.Action = "the missing blocks were not fetched"
+	case f.opts.Fix:
+		p.Action = fmt.Sprintf("fetched %d blocks, %d could not be fetched", fetched, len(missing))
+	}
+	return nil
+}
+
+func (f *fsck) checkFilesRoot() error {
+	val, err := f.r.Datastore().Get(filesRootKey)
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = f.readFilesRoot(val)
+	if err == nil {
+		return nil
+	}
+	p := f.problem(FsckFilesRoot, filesRootKey.String(), err.Error())
+	if !f.opts.Fix {
+		return nil
+	}
+	return f.resetFilesRoot(p, val)
+}
+
+func (f *fsck) readFilesRoot(val []byte) error {
+	c, err := cid.Cast(val)
+	if err != nil {
+		return fmt.Errorf("invalid cid: %s", err)
+	}
+	nd, err := f.dag.Get(f.ctx, c)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %s", c, err)
+	}
+	pbnd, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return fmt.Errorf("%s: %s", c, dag.ErrNotProtobuf)
+	}
+	fsn, err := ft.FSNodeFromBytes(pbnd.Data())
+	if err != nil {
+		return fmt.Errorf("%s is not a unixfs node: %s", c, err)
+	}
+	if fsn.Type() != ft.TDirectory && fsn.Type() != ft.THAMTShard {
+		return fmt.Errorf("%s is not a directory", c)
+	}
+	return nil
+}
+
+// resetFilesRoot points the mfs root to an empty directory, keeping the old
+// value under filesRootRecoveryKey
+func (f *fsck) resetFilesRoot(p *FsckProblem, old []byte) error {
+	d := f.r.Datastore()
+	if err := d.Put(filesRootRecoveryKey, old); err != nil {
+		return err
+	}
+	nd := ft.EmptyDirNode()
+	if err := f.dag.Add(f.ctx, nd); err != nil {
+		return err
+	}
+	if err := d.Put(filesRootKey, nd.Cid().Bytes()); err != nil {
+		return err
+	}
+	p.Fixed = true
+	p.Action = fmt.Sprintf("reset to an empty directory, the old root is kept under %s", filesRootRecoveryKey)
+	if c, err := cid.Cast(old); err == nil {
+		p.Action += fmt.Sprintf(" (%s)", c)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package corerepo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	filestore "mbfs/go-mbfs/filestore"
+	"mbfs/go-mbfs/plugin/loader"
+	fsrepo "mbfs/go-mbfs/repo/fsrepo"
+
+	posinfo "mbfs/go-mbfs/gx/QmR6YMs8EkXQLXNwQKxLnQp2VBZSepoEJ8KCZAyanJHhJu/go-ipfs-posinfo"
+	bstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
+	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+	dag "mbfs/go-mbfs/gx/QmaDBne4KeY3UepeqSVKYpSmQGa3q9zP6x3LfVF2UjF3Hc/go-merkledag"
+	dshelp "mbfs/go-mbfs/gx/QmaHSUAhuf9WG3mzJUd1fLDsQGvjsaQdUE7w5cZncz9AcB/go-ipfs-ds-help"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+)
+
+func TestFsck(t *testing.T) {
+	loader.LoadPlugins("")
+
+	path, err := ioutil.TempDir("", "fsck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path)
+
+	cfg, err := config.Init(ioutil.Discard, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsrepo.Init(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := fsrepo.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := blocks.NewBlock([]byte("good"))
+	bad := blocks.NewBlock([]byte("bad"))
+	if err := bstore.NewBlockstore(r.Datastore()).Put(good); err != nil {
+		t.Fatal(err)
+	}
+	// the data of the good block under the key of the bad one
+	if err := r.Datastore().Put(bstore.BlockPrefix.Child(dshelp.CidToDsKey(bad.Cid())), good.RawData()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Datastore().Put(filesRootKey, []byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	check := func(fix bool, kinds ...FsckKind) *FsckReport {
+		t.Helper()
+		report, err := Fsck(context.Background(), path, FsckOptions{Fix: fix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Problems) != len(kinds) {
+			t.Fatalf("expected %d problems, got %+v", len(kinds), report.Problems)
+		}
+		for i, k := range kinds {
+			if report.Problems[i].Kind != k {
+				t.Fatalf("expected a %s problem, got %+v", k, report.Problems[i])
+			}
+		}
+		return report
+	}
+
+	check(false, FsckCorruptBlock, FsckFilesRoot)
+	// nothing was changed
+	report := check(true, FsckCorruptBlock, FsckFilesRoot)
+	if report.Unfixed() != 1 || !report.Problems[1].Fixed {
+		t.Fatalf("the files root should have been fixed, and the block not fetched: %+v", report.Problems)
+	}
+	check(false)
+
+	r, err = fsrepo.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	old, err := r.Datastore().Get(filesRootRecoveryKey)
+	if err != nil || string(old) != "garbage" {
+		t.Fatal("the old files root was not kept", err)
+	}
+}
+
+func TestFsckFilestore(t *testing.T) {
+	loader.LoadPlugins("")
+
+	dir, err := ioutil.TempDir("", "fsck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "repo")
+
+	cfg, err := config.Init(ioutil.Discard, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Experimental.FilestoreEnabled = true
+	if err := fsrepo.Init(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := fsrepo.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an entry whose file is gone, and one which cannot be decoded
+	gone := filepath.Join(dir, "gone")
+	if err := ioutil.WriteFile(gone, []byte("gone"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = r.FileManager().Put(&posinfo.FilestoreNode{
+		Node:    dag.NewRawNode([]byte("gone")),
+		PosInfo: &posinfo.PosInfo{FullPath: gone},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	garbage := blocks.NewBlock([]byte("garbage"))
+	key := filestore.FilestorePrefix.Child(dshelp.CidToDsKey(garbage.Cid()))
+	if err := r.Datastore().Put(key, []byte("garbage")); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	check := func(fix bool, kinds ...FsckKind) {
+		t.Helper()
+		report, err := Fsck(context.Background(), path, FsckOptions{Fix: fix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make(map[FsckKind]*FsckProblem)
+		for _, p := range report.Problems {
+			found[p.Kind] = p
+		}
+		if len(report.Problems) != len(kinds) {
+			t.Fatalf("expected %d problems, got %+v", len(kinds), report.Problems)
+		}
+		for _, k := range kinds {
+			if found[k] == nil {
+				t.Fatalf("expected a %s problem, got %+v", k, report.Problems)
+			}
+		}
+		if p := found[FsckFilestoreEntry]; p != nil && p.Fixed {
+			t.Fatal("an entry which cannot be verified should not be removed")
+		}
+	}
+
+	check(true, FsckFilestoreOrphan, FsckFilestoreEntry)
+	// the orphan was removed, the other entry is kept
+	check(false, FsckFilestoreEntry)
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	namesys "mbfs/go-mbfs/namesys"
+
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+)
+
+// DefaultDNSCacheSize is the number of DNS answers cached when the DNS
+// section of the config doesn't set it.
+const DefaultDNSCacheSize = 256
+
+// NewDNSResolver builds the DNSLink resolver described by the DNS section of
+// the config.
+func NewDNSResolver(cfg config.DNS) (*namesys.DNSResolver, error) {
+	lookup := &namesys.SuffixLookup{Suffixes: make(map[string]namesys.TXTLookup)}
+	for suffix, s := range cfg.Resolvers {
+		l, err := namesys.ParseTXTLookup(s)
+		if err != nil {
+			return nil, fmt.Errorf("DNS.Resolvers[%q]: %s", suffix, err)
+		}
+		suffix = strings.ToLower(strings.Trim(suffix, "."))
+		if suffix == "" {
+			lookup.Default = l
+		} else {
+			lookup.Suffixes[suffix] = l
+		}
+	}
+
+	size := cfg.CacheSize
+	if size == 0 {
+		size = DefaultDNSCacheSize
+	}
+
+	var maxTTL time.Duration
+	if cfg.MaxCacheTTL != "" {
+		var err error
+		maxTTL, err = time.ParseDuration(cfg.MaxCacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS.MaxCacheTTL %q: %s", cfg.MaxCacheTTL, err)
+		}
+	}
+
+	return namesys.NewCachedDNSResolver(lookup, size, maxTTL), nil
+}
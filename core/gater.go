@@ -0,0 +1,231 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	security "mbfs/go-mbfs/gx/QmbNjbKRJKbek3jPV6rpbGEtrZd84cDxjpBtsiGYa9Z5Do/go-conn-security"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+	circuit "mbfs/go-mbfs/gx/QmddZ5gv3Gkicoqh5NDfHGjpij6zw92pQjvpa181yfnXm2/go-libp2p-circuit"
+)
+
+// ConnGater refuses the connections, inbound and outbound, of the peers and
+// addresses denied by Swarm.ConnGater, or not allowed by it when it allows
+// some. The connections are checked while they are secured, before any
+// protocol runs on them.
+type ConnGater struct {
+	lk    sync.RWMutex
+	allow gaterList
+	deny  gaterList
+}
+
+// gaterList is a list of peers and IP ranges
+type gaterList struct {
+	peers map[peer.ID]struct{}
+	nets  []*net.IPNet
+}
+
+func (l *gaterList) empty() bool {
+	return len(l.peers) == 0 && len(l.nets) == 0
+}
+
+func (l *gaterList) hasPeer(p peer.ID) bool {
+	_, ok := l.peers[p]
+	return ok
+}
+
+func (l *gaterList) hasIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range l.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseGaterRule parses an entry of the Swarm.ConnGater lists: a peer ID, an
+// IP range in CIDR notation, or a single IP address.
+func ParseGaterRule(s string) (peer.ID, *net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid IP range %q: %s", s, err)
+		}
+		return "", n, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return "", &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	p, err := peer.IDB58Decode(s)
+	if err != nil {
+		return "", nil, fmt.Errorf("%q is neither a peer ID nor an IP range", s)
+	}
+	return p, nil, nil
+}
+
+func parseGaterList(field string, rules []string) (gaterList, error) {
+	l := gaterList{peers: make(map[peer.ID]struct{})}
+	for _, r := range rules {
+		p, n, err := ParseGaterRule(r)
+		if err != nil {
+			return l, fmt.Errorf("Swarm.ConnGater.%s: %s", field, err)
+		}
+		if n != nil {
+			l.nets = append(l.nets, n)
+		} else {
+			l.peers[p] = struct{}{}
+		}
+	}
+	return l, nil
+}
+
+// NewConnGater constructs a connection gater with the lists of the config.
+func NewConnGater(cfg config.ConnGater) (*ConnGater, error) {
+	g := new(ConnGater)
+	if err := g.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// SetConfig replaces the lists of the gater. The open connections are left
+// alone, see CloseGated.
+func (g *ConnGater) SetConfig(cfg config.ConnGater) error {
+	allow, err := parseGaterList("Allow", cfg.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseGaterList("Deny", cfg.Deny)
+	if err != nil {
+		return err
+	}
+
+	g.lk.Lock()
+	g.allow, g.deny = allow, deny
+	g.lk.Unlock()
+	return nil
+}
+
+// Allowed tells whether the connections of peer p from or to ip are allowed.
+// The IP can be nil when the connection doesn't go over IP.
+func (g *ConnGater) Allowed(p peer.ID, ip net.IP) bool {
+	g.lk.RLock()
+	defer g.lk.RUnlock()
+	if g.deny.hasPeer(p) || g.deny.hasIP(ip) {
+		return false
+	}
+	return g.allow.empty() || g.allow.hasPeer(p) || g.allow.hasIP(ip)
+}
+
+// addrDenied tells whether the connections from or to ip are denied whatever
+// their peer
+func (g *ConnGater) addrDenied(ip net.IP) bool {
+	g.lk.RLock()
+	defer g.lk.RUnlock()
+	return g.deny.hasIP(ip)
+}
+
+// CloseGated closes the open connections of network the gater doesn't allow
+// anymore, and returns how many it closed.
+func (g *ConnGater) CloseGated(network inet.Network) int {
+	closed := 0
+	for _, c := range network.Conns() {
+		if !g.Allowed(c.RemotePeer(), multiaddrIP(c.RemoteMultiaddr())) {
+			log.Infof("closing the connection to %s %s, refused by the connection gater", c.RemotePeer().Pretty(), c.RemoteMultiaddr())
+			c.Close()
+			closed++
+		}
+	}
+	return closed
+}
+
+// Notifee returns a network notifiee closing the connections the gater
+// doesn't allow, for the transports that don't go through a security
+// transport wrapped by WrapSecurity, e.g. QUIC.
+func (g *ConnGater) Notifee() inet.Notifiee {
+	return &inet.NotifyBundle{
+		ConnectedF: func(_ inet.Network, c inet.Conn) {
+			if !g.Allowed(c.RemotePeer(), multiaddrIP(c.RemoteMultiaddr())) {
+				log.Debugf("closing the connection to %s %s, refused by the connection gater", c.RemotePeer().Pretty(), c.RemoteMultiaddr())
+				c.Close()
+			}
+		},
+	}
+}
+
+// WrapSecurity returns a security transport refusing the connections the gater
+// doesn't allow: on the remote address before the handshake, and on the
+// remote peer once it is authenticated.
+func (g *ConnGater) WrapSecurity(t security.Transport) security.Transport {
+	return &gatedSecurity{Transport: t, g: g}
+}
+
+type gatedSecurity struct {
+	security.Transport
+	g *ConnGater
+}
+
+func (s *gatedSecurity) SecureInbound(ctx context.Context, insecure net.Conn) (security.Conn, error) {
+	ip := netAddrIP(insecure.RemoteAddr())
+	if s.g.addrDenied(ip) {
+		return nil, fmt.Errorf("connection from %s refused by the connection gater", insecure.RemoteAddr())
+	}
+	c, err := s.Transport.SecureInbound(ctx, insecure)
+	if err != nil {
+		return nil, err
+	}
+	if !s.g.Allowed(c.RemotePeer(), ip) {
+		c.Close()
+		return nil, fmt.Errorf("connection from %s %s refused by the connection gater", c.RemotePeer().Pretty(), insecure.RemoteAddr())
+	}
+	return c, nil
+}
+
+func (s *gatedSecurity) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (security.Conn, error) {
+	if !s.g.Allowed(p, netAddrIP(insecure.RemoteAddr())) {
+		return nil, fmt.Errorf("connection to %s %s refused by the connection gater", p.Pretty(), insecure.RemoteAddr())
+	}
+	return s.Transport.SecureOutbound(ctx, insecure, p)
+}
+
+func netAddrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// multiaddrIP returns the IP of the peer a connection goes to, or nil for a
+// relayed connection
+func multiaddrIP(a ma.Multiaddr) net.IP {
+	if a == nil {
+		return nil
+	}
+	if _, err := a.ValueForProtocol(circuit.P_CIRCUIT); err == nil {
+		return nil
+	}
+	for _, code := range []int{ma.P_IP4, ma.P_IP6} {
+		if s, err := a.ValueForProtocol(code); err == nil {
+			return net.ParseIP(s)
+		}
+	}
+	return nil
+}
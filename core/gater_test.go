@@ -0,0 +1,81 @@
+package core
+
+import (
+	"net"
+	"testing"
+
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+func TestConnGater(t *testing.T) {
+	var friend, foe, stranger peer.ID
+	for id, s := range map[*peer.ID]string{
+		&friend:   "QmSoLV4Bbm51jM9C4gDYZQ9Cy3U6aXMJDAbzgu2fzaDs64",
+		&foe:      "QmRKFwzzFaS4rGkptmo4gYKXnvpxxzTieY8bUysidVpn7k",
+		&stranger: "QmdWZ1Sf3pZqEPo7P19WXch7bF2dgWVvL64Wu98V88xCBx",
+	} {
+		p, err := peer.IDB58Decode(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*id = p
+	}
+	lan := net.ParseIP("10.1.2.3")
+	wan := net.ParseIP("8.8.8.8")
+
+	g, err := NewConnGater(config.ConnGater{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Allowed(stranger, wan) {
+		t.Fatal("an empty gater should allow everything")
+	}
+
+	if _, err := NewConnGater(config.ConnGater{Deny: []string{"nope"}}); err == nil {
+		t.Fatal("expected an invalid entry to be rejected")
+	}
+
+	err = g.SetConfig(config.ConnGater{
+		Allow: []string{friend.Pretty(), "10.0.0.0/8"},
+		Deny:  []string{foe.Pretty(), "10.1.2.3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		p       peer.ID
+		ip      net.IP
+		allowed bool
+	}{
+		{friend, wan, true},
+		{friend, nil, true},
+		{friend, lan, false}, // the address is denied
+		{foe, net.ParseIP("10.9.9.9"), false},
+		{stranger, net.ParseIP("10.9.9.9"), true},
+		{stranger, wan, false},
+		{stranger, nil, false},
+	} {
+		if g.Allowed(c.p, c.ip) != c.allowed {
+			t.Errorf("Allowed(%s, %s) should be %t", c.p, c.ip, c.allowed)
+		}
+	}
+}
+
+func TestMultiaddrIP(t *testing.T) {
+	for s, ip := range map[string]string{
+		"/ip4/1.2.3.4/tcp/4001":  "1.2.3.4",
+		"/ip6/::1/udp/4001/quic": "::1",
+		"/ip4/1.2.3.4/tcp/4001/ipfs/QmSoLV4Bbm51jM9C4gDYZQ9Cy3U6aXMJDAbzgu2fzaDs64/p2p-circuit": "",
+	} {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := multiaddrIP(a); (ip == "" && got != nil) || (ip != "" && !got.Equal(net.ParseIP(ip))) {
+			t.Errorf("multiaddrIP(%s) = %s, expected %q", s, got, ip)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	nat "mbfs/go-mbfs/nat"
+
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+)
+
+// AutoNATInterval returns the time between the reachability probes of the
+// Swarm.AutoNAT section of the config, the default if it is unset.
+func AutoNATInterval(cfg config.AutoNAT) (time.Duration, error) {
+	if cfg.Interval == "" {
+		return nat.DefaultProbeInterval, nil
+	}
+	d, err := time.ParseDuration(cfg.Interval)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid Swarm.AutoNAT.Interval %q", cfg.Interval)
+	}
+	return d, nil
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	nat "mbfs/go-mbfs/nat"
+
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+)
+
+func TestAutoNATInterval(t *testing.T) {
+	for cfg, d := range map[config.AutoNAT]time.Duration{
+		{}:                     nat.DefaultProbeInterval,
+		{Interval: "5m"}:       5 * time.Minute,
+		{DisableService: true}: nat.DefaultProbeInterval,
+	} {
+		v, err := AutoNATInterval(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != d {
+			t.Errorf("expected %s for %+v, got %s", d, cfg, v)
+		}
+	}
+
+	for _, s := range []string{"often", "0s", "-1m"} {
+		if _, err := AutoNATInterval(config.AutoNAT{Interval: s}); err == nil {
+			t.Errorf("expected %q to be refused", s)
+		}
+	}
+}
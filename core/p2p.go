@@ -0,0 +1,79 @@
+package core
+
+import (
+	"fmt"
+
+	p2p "mbfs/go-mbfs/p2p"
+
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	ipfsaddr "mbfs/go-mbfs/gx/QmUSE3APe1pMFVsUBZUZaKQKERiPteCWvTAERtVQmtXzgE/go-ipfs-addr"
+	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
+	protocol "mbfs/go-mbfs/gx/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// restoreP2P re-creates the p2p listeners and forwards persisted in the
+// config. Entries that fail to start are logged and skipped so a single bad
+// entry, such as a port already in use, doesn't keep the node from starting.
+func (n *IpfsNode) restoreP2P(cfg config.P2P) {
+	for _, lc := range cfg.Listeners {
+		if err := n.restoreP2PListener(lc); err != nil {
+			log.Errorf("failed to restore p2p listener %s: %s", lc.Protocol, err)
+		}
+	}
+
+	for _, fc := range cfg.Forwards {
+		if err := n.restoreP2PForward(fc); err != nil {
+			log.Errorf("failed to restore p2p forward %s on %s: %s", fc.Protocol, fc.ListenAddress, err)
+		}
+	}
+}
+
+func (n *IpfsNode) restoreP2PListener(lc config.P2PListener) error {
+	target, err := ma.NewMultiaddr(lc.TargetAddress)
+	if err != nil {
+		return err
+	}
+
+	limits := p2p.ListenerLimits{
+		MaxStreams: lc.MaxStreams,
+		MaxBytes:   lc.MaxBytes,
+	}
+	for _, s := range lc.AllowedPeers {
+		id, err := peer.IDB58Decode(s)
+		if err != nil {
+			return fmt.Errorf("invalid allowed peer %q: %s", s, err)
+		}
+		limits.AllowedPeers = append(limits.AllowedPeers, id)
+	}
+
+	_, err = n.P2P.ForwardRemote(n.Context(), protocol.ID(lc.Protocol), target, limits)
+	return err
+}
+
+func (n *IpfsNode) restoreP2PForward(fc config.P2PForward) error {
+	listen, err := ma.NewMultiaddr(fc.ListenAddress)
+	if err != nil {
+		return err
+	}
+
+	target, err := ipfsaddr.ParseString(fc.TargetAddress)
+	if err != nil {
+		return err
+	}
+	if t := target.Transport(); t != nil {
+		n.Peerstore.AddAddr(target.ID(), t, pstore.PermanentAddrTTL)
+	}
+
+	limits := p2p.ListenerLimits{
+		MaxStreams: fc.MaxStreams,
+		MaxBytes:   fc.MaxBytes,
+	}
+
+	l, err := n.P2P.ForwardLocal(n.Context(), target.ID(), protocol.ID(fc.Protocol), listen, limits)
+	if err != nil {
+		return err
+	}
+	return n.P2P.KeepConnected(l)
+}
@@ -0,0 +1,333 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	iaddr "mbfs/go-mbfs/gx/QmUSE3APe1pMFVsUBZUZaKQKERiPteCWvTAERtVQmtXzgE/go-ipfs-addr"
+	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
+	host "mbfs/go-mbfs/gx/QmVrjR2KMe57y4YyfHdYa3yKD278gN8W7CTiqSuYmxjA7F/go-libp2p-host"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+const (
+	// peeringTag is the connection manager tag of the peering peers
+	peeringTag = "peering"
+	// peeringTagValue outweighs the tags of all the other peers, so that a
+	// connection manager which has no way to protect a peer trims the
+	// connections of the peering peers last
+	peeringTagValue = 1 << 20
+)
+
+// protector is a connection manager which can protect the connections of a
+// peer, as the resource-aware one does
+type protector interface {
+	Protect(p peer.ID, tag string)
+	Unprotect(p peer.ID, tag string) bool
+}
+
+var (
+	// peeringMinBackoff is the delay before the first redial of a lost peer
+	peeringMinBackoff = time.Second
+	// peeringMaxBackoff caps the delay between redials
+	peeringMaxBackoff = 10 * time.Minute
+	// peeringCheckInterval is how often a connected peer is checked, and
+	// tagged again as the connection manager forgets the tags of the peers
+	// it saw disconnect; it is shorter than the default grace period of the
+	// connection manager
+	peeringCheckInterval = 10 * time.Second
+	// peeringDialTimeout bounds a single dial
+	peeringDialTimeout = 30 * time.Second
+)
+
+// ParsePeeringPeers parses the multiaddrs of Peering.Peers, grouping the
+// addresses by peer, in the order of the list.
+func ParsePeeringPeers(addrs []string) ([]pstore.PeerInfo, error) {
+	return parsePeerAddrs("peering", addrs)
+}
+
+// parsePeerAddrs parses multiaddrs ending with a peer ID, grouping the
+// addresses by peer, in the order of the list. The kind of the addresses
+// names them in the errors.
+func parsePeerAddrs(kind string, addrs []string) ([]pstore.PeerInfo, error) {
+	var peers []pstore.PeerInfo
+	index := make(map[peer.ID]int)
+	for _, s := range addrs {
+		a, err := iaddr.ParseString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s address %q: %s", kind, s, err)
+		}
+		i, ok := index[a.ID()]
+		if !ok {
+			i = len(peers)
+			index[a.ID()] = i
+			peers = append(peers, pstore.PeerInfo{ID: a.ID()})
+		}
+		if t := a.Transport(); t != nil && len(t.Bytes()) > 0 {
+			peers[i].Addrs = append(peers[i].Addrs, t)
+		}
+	}
+	return peers, nil
+}
+
+// PeeringService keeps the node connected to the peers of the Peering config:
+// it tags them in the connection manager and, from when it is started, dials
+// them and redials them with exponential backoff whenever they disconnect.
+type PeeringService struct {
+	host host.Host
+	nb   *inet.NotifyBundle
+
+	lk      sync.Mutex
+	peers   map[peer.ID]*peeringHandler
+	ctx     context.Context
+	cancel  context.CancelFunc
+	started bool
+
+	// wg waits for the handlers
+	wg sync.WaitGroup
+}
+
+// PeeringStatus is the state of the connection to a peering peer
+type PeeringStatus struct {
+	ID        peer.ID
+	Addrs     []ma.Multiaddr
+	Connected bool
+
+	// Failures is the number of dials failed since the peer was last
+	// connected, NextDial the time of the next dial after a failure, and
+	// LastError the error of the last failed dial
+	Failures  int
+	NextDial  time.Time
+	LastError string
+}
+
+// peeringHandler keeps a peering peer connected. Its fields but id, cancel
+// and poke are guarded by the lock of the service.
+type peeringHandler struct {
+	id     peer.ID
+	cancel context.CancelFunc
+
+	// poke wakes the handler up when the peer connects, disconnects or gets
+	// new addresses
+	poke chan struct{}
+
+	addrs    []ma.Multiaddr
+	failures int
+	nextDial time.Time
+	lastErr  string
+}
+
+// NewPeeringService constructs a peering service for the peers of h.
+func NewPeeringService(h host.Host) *PeeringService {
+	ps := &PeeringService{
+		host:  h,
+		peers: make(map[peer.ID]*peeringHandler),
+	}
+	ps.ctx, ps.cancel = context.WithCancel(context.Background())
+	ps.nb = &inet.NotifyBundle{
+		ConnectedF:    ps.wake,
+		DisconnectedF: ps.wake,
+	}
+	return ps
+}
+
+// Start starts keeping the peering peers connected.
+func (ps *PeeringService) Start() error {
+	ps.host.Network().Notify(ps.nb)
+
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	if ps.started {
+		return nil
+	}
+	ps.started = true
+	for _, h := range ps.peers {
+		ps.run(h)
+	}
+	return nil
+}
+
+// Close stops the service for good. The connections are left open.
+func (ps *PeeringService) Close() error {
+	ps.cancel()
+	ps.host.Network().StopNotify(ps.nb)
+	ps.wg.Wait()
+	return nil
+}
+
+// AddPeer adds a peer to keep connected to, or replaces its addresses if it
+// is a peering peer already.
+func (ps *PeeringService) AddPeer(pi pstore.PeerInfo) {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+
+	if h, ok := ps.peers[pi.ID]; ok {
+		ps.host.Peerstore().UpdateAddrs(pi.ID, pstore.PermanentAddrTTL, pstore.TempAddrTTL)
+		ps.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.PermanentAddrTTL)
+		h.addrs = pi.Addrs
+		h.wake()
+		return
+	}
+
+	log.Infof("peering with %s", pi.ID.Pretty())
+	ps.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.PermanentAddrTTL)
+	h := &peeringHandler{
+		id:    pi.ID,
+		addrs: pi.Addrs,
+		poke:  make(chan struct{}, 1),
+	}
+	ps.peers[pi.ID] = h
+	if ps.started {
+		ps.run(h)
+	}
+}
+
+// RemovePeer stops keeping p connected, leaving its connection open, and
+// tells whether it was a peering peer.
+func (ps *PeeringService) RemovePeer(p peer.ID) bool {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+
+	h, ok := ps.peers[p]
+	if !ok {
+		return false
+	}
+	log.Infof("no longer peering with %s", p.Pretty())
+	if h.cancel != nil {
+		h.cancel()
+	}
+	delete(ps.peers, p)
+	ps.host.ConnManager().UntagPeer(p, peeringTag)
+	if pr, ok := ps.host.ConnManager().(protector); ok {
+		pr.Unprotect(p, peeringTag)
+	}
+	ps.host.Peerstore().UpdateAddrs(p, pstore.PermanentAddrTTL, pstore.TempAddrTTL)
+	return true
+}
+
+// SetPeers makes peers the peering peers, adding, updating and removing
+// peers as needed.
+func (ps *PeeringService) SetPeers(peers []pstore.PeerInfo) {
+	keep := make(map[peer.ID]bool, len(peers))
+	for _, pi := range peers {
+		keep[pi.ID] = true
+		ps.AddPeer(pi)
+	}
+	for _, st := range ps.ListPeers() {
+		if !keep[st.ID] {
+			ps.RemovePeer(st.ID)
+		}
+	}
+}
+
+// ListPeers returns the state of the peering peers, sorted by peer ID.
+func (ps *PeeringService) ListPeers() []PeeringStatus {
+	ps.lk.Lock()
+	out := make([]PeeringStatus, 0, len(ps.peers))
+	for _, h := range ps.peers {
+		out = append(out, PeeringStatus{
+			ID:        h.id,
+			Addrs:     h.addrs,
+			Failures:  h.failures,
+			NextDial:  h.nextDial,
+			LastError: h.lastErr,
+		})
+	}
+	ps.lk.Unlock()
+
+	for i := range out {
+		out[i].Connected = ps.host.Network().Connectedness(out[i].ID) == inet.Connected
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// wake wakes up the handler of the peer of c, if it is a peering peer
+func (ps *PeeringService) wake(_ inet.Network, c inet.Conn) {
+	ps.lk.Lock()
+	defer ps.lk.Unlock()
+	if h, ok := ps.peers[c.RemotePeer()]; ok {
+		h.wake()
+	}
+}
+
+func (h *peeringHandler) wake() {
+	select {
+	case h.poke <- struct{}{}:
+	default:
+	}
+}
+
+// run starts the handler of h, with the lock of the service held
+func (ps *PeeringService) run(h *peeringHandler) {
+	ctx, cancel := context.WithCancel(ps.ctx)
+	h.cancel = cancel
+	ps.wg.Add(1)
+	go ps.keepConnected(ctx, h)
+}
+
+func (ps *PeeringService) keepConnected(ctx context.Context, h *peeringHandler) {
+	defer ps.wg.Done()
+
+	backoff := peeringMinBackoff
+	for {
+		wait := peeringCheckInterval
+		if ps.host.Network().Connectedness(h.id) != inet.Connected {
+			ps.lk.Lock()
+			pi := pstore.PeerInfo{ID: h.id, Addrs: h.addrs}
+			ps.lk.Unlock()
+
+			dctx, cancel := context.WithTimeout(ctx, peeringDialTimeout)
+			err := ps.host.Connect(dctx, pi)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				log.Debugf("failed to connect to peering peer %s, retrying in %s: %s", h.id.Pretty(), backoff, err)
+				ps.lk.Lock()
+				h.failures++
+				h.lastErr = err.Error()
+				h.nextDial = time.Now().Add(backoff)
+				ps.lk.Unlock()
+
+				wait = backoff
+				backoff *= 2
+				if backoff > peeringMaxBackoff {
+					backoff = peeringMaxBackoff
+				}
+			} else {
+				log.Infof("connected to peering peer %s", h.id.Pretty())
+			}
+		}
+
+		if ps.host.Network().Connectedness(h.id) == inet.Connected {
+			// the connection manager only keeps the tags of connected peers
+			ps.host.ConnManager().TagPeer(h.id, peeringTag, peeringTagValue)
+			if pr, ok := ps.host.ConnManager().(protector); ok {
+				pr.Protect(h.id, peeringTag)
+			}
+
+			ps.lk.Lock()
+			h.failures, h.lastErr, h.nextDial = 0, "", time.Time{}
+			ps.lk.Unlock()
+			backoff = peeringMinBackoff
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-h.poke:
+			t.Stop()
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	testutil "mbfs/go-mbfs/thirdparty/testutil"
+
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	connmgr "mbfs/go-mbfs/gx/QmThq7QRtwjwg1DLQUQkcZcChhVih9Xfsp9m2ZYK9Jw1ri/go-libp2p-connmgr"
+	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
+	bhost "mbfs/go-mbfs/gx/QmXnpYYg2onGLXVxM4Q5PEFcx29k8zeJQkPeLAk9h9naxg/go-libp2p/p2p/host/basic"
+	mocknet "mbfs/go-mbfs/gx/QmXnpYYg2onGLXVxM4Q5PEFcx29k8zeJQkPeLAk9h9naxg/go-libp2p/p2p/net/mock"
+)
+
+func TestParsePeeringPeers(t *testing.T) {
+	peers, err := ParsePeeringPeers([]string{
+		"/ip4/10.0.0.1/tcp/4001/ipfs/QmSoLV4Bbm51jM9C4gDYZQ9Cy3U6aXMJDAbzgu2fzaDs64",
+		"/ipfs/QmRKFwzzFaS4rGkptmo4gYKXnvpxxzTieY8bUysidVpn7k",
+		"/ip6/::1/tcp/4001/ipfs/QmSoLV4Bbm51jM9C4gDYZQ9Cy3U6aXMJDAbzgu2fzaDs64",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(peers))
+	}
+	if peers[0].ID.Pretty() != "QmSoLV4Bbm51jM9C4gDYZQ9Cy3U6aXMJDAbzgu2fzaDs64" || len(peers[0].Addrs) != 2 {
+		t.Fatalf("unexpected first peer %s %v", peers[0].ID.Pretty(), peers[0].Addrs)
+	}
+	if len(peers[1].Addrs) != 0 {
+		t.Fatalf("expected no address for the second peer, got %v", peers[1].Addrs)
+	}
+
+	if _, err := ParsePeeringPeers([]string{"/ip4/10.0.0.1/tcp/4001"}); err == nil {
+		t.Fatal("expected an address without peer ID to be rejected")
+	}
+}
+
+func TestPeeringReconnect(t *testing.T) {
+	defer func(min, check time.Duration) {
+		peeringMinBackoff, peeringCheckInterval = min, check
+	}(peeringMinBackoff, peeringCheckInterval)
+	peeringMinBackoff = 20 * time.Millisecond
+	peeringCheckInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New(ctx)
+	a, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := mn.GenPeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the host of a, with a connection manager
+	cm := connmgr.NewConnManager(10, 20, 0)
+	ha, err := bhost.NewHost(ctx, mn.Net(a.ID()), &bhost.HostOpts{NegotiationTimeout: -1, ConnManager: cm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged := func() bool {
+		info := cm.GetTagInfo(b.ID())
+		return info != nil && info.Tags[peeringTag] == peeringTagValue
+	}
+
+	ps := NewPeeringService(ha)
+	defer ps.Close()
+	ps.AddPeer(pstore.PeerInfo{ID: b.ID(), Addrs: b.Addrs()})
+	if err := ps.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// no link yet, the dials fail
+	testutil.WaitFor(t, 5*time.Second, "failed dials", func() bool {
+		st := ps.ListPeers()
+		return len(st) == 1 && st[0].Failures >= 2 && !st[0].NextDial.IsZero()
+	})
+
+	if _, err := mn.LinkPeers(a.ID(), b.ID()); err != nil {
+		t.Fatal(err)
+	}
+	connected := func() bool {
+		return a.Network().Connectedness(b.ID()) == inet.Connected
+	}
+	testutil.WaitFor(t, 5*time.Second, "the connection", connected)
+	testutil.WaitFor(t, 5*time.Second, "the tag", tagged)
+	testutil.WaitFor(t, 5*time.Second, "the failures to be reset", func() bool {
+		return ps.ListPeers()[0].Failures == 0
+	})
+
+	if err := mn.DisconnectPeers(a.ID(), b.ID()); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, 5*time.Second, "the reconnection", connected)
+	testutil.WaitFor(t, 5*time.Second, "the tag after the reconnection", tagged)
+
+	if !ps.RemovePeer(b.ID()) {
+		t.Fatal("expected the peer to be removed")
+	}
+	if ps.RemovePeer(b.ID()) {
+		t.Fatal("expected the peer to be removed once")
+	}
+	if tagged() {
+		t.Fatal("expected a removed peer to be untagged")
+	}
+	if err := mn.DisconnectPeers(a.ID(), b.ID()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * peeringMinBackoff)
+	if connected() {
+		t.Fatal("expected a removed peer not to be redialed")
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	relay "mbfs/go-mbfs/relay"
+
+	humanize "mbfs/go-mbfs/gx/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	swarm "mbfs/go-mbfs/gx/QmcYC4ayKi7bq8xecEZxHVEuTL6HREZWTTErrSRd1S3Spz/go-libp2p-swarm"
+	circuit "mbfs/go-mbfs/gx/QmddZ5gv3Gkicoqh5NDfHGjpij6zw92pQjvpa181yfnXm2/go-libp2p-circuit"
+)
+
+// RelayLimits converts the Swarm.RelayService section of the config to the
+// limits of the relay service, the defaults standing for the unset ones.
+func RelayLimits(cfg config.RelayService) (relay.Limits, error) {
+	l := relay.DefaultLimits
+	durations := []struct {
+		name string
+		s    string
+		d    *time.Duration
+	}{
+		{"ReservationTTL", cfg.ReservationTTL, &l.ReservationTTL},
+		{"CircuitDuration", cfg.CircuitDuration, &l.CircuitDuration},
+	}
+	for _, d := range durations {
+		if d.s == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.s)
+		if err != nil || v <= 0 {
+			return l, fmt.Errorf("invalid Swarm.RelayService.%s %q", d.name, d.s)
+		}
+		*d.d = v
+	}
+	if cfg.CircuitData != "" {
+		data, err := humanize.ParseBytes(cfg.CircuitData)
+		if err != nil || data == 0 {
+			return l, fmt.Errorf("invalid Swarm.RelayService.CircuitData %q", cfg.CircuitData)
+		}
+		l.CircuitData = int64(data)
+	}
+	if cfg.MaxReservations < 0 || cfg.MaxCircuits < 0 {
+		return l, errors.New("Swarm.RelayService limits can't be negative")
+	}
+	if cfg.MaxReservations > 0 {
+		l.MaxReservations = cfg.MaxReservations
+	}
+	if cfg.MaxCircuits > 0 {
+		l.MaxCircuits = cfg.MaxCircuits
+	}
+	return l, nil
+}
+
+// ParseRelayReservations parses the multiaddrs of Swarm.RelayReservations,
+// grouping the addresses by relay.
+func ParseRelayReservations(addrs []string) ([]pstore.PeerInfo, error) {
+	return parsePeerAddrs("relay", addrs)
+}
+
+// startRelayService makes the relay of the p2p-circuit transport of the host
+// admit the circuits to the peers holding a reservation only, within the
+// limits l.
+func (n *IpfsNode) startRelayService(l relay.Limits) error {
+	swrm, ok := n.PeerHost.Network().(*swarm.Swarm)
+	if !ok {
+		return errors.New("the relay service needs a swarm network")
+	}
+	t, ok := swrm.TransportForDialing(ma.StringCast("/p2p-circuit")).(*circuit.RelayTransport)
+	if !ok {
+		return errors.New("the relay service needs the relay transport")
+	}
+
+	n.RelayService = relay.NewService(n.PeerHost, l)
+	t.Relay().SetHopLimiter(n.RelayService)
+	return nil
+}
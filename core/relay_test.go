@@ -0,0 +1,46 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	relay "mbfs/go-mbfs/relay"
+
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+)
+
+func TestRelayLimits(t *testing.T) {
+	l, err := RelayLimits(config.RelayService{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l != relay.DefaultLimits {
+		t.Fatalf("expected the default limits, got %+v", l)
+	}
+
+	l, err = RelayLimits(config.RelayService{
+		Enabled:         true,
+		ReservationTTL:  "10m",
+		MaxReservations: 4,
+		CircuitDuration: "30s",
+		CircuitData:     "1MiB",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.ReservationTTL != 10*time.Minute || l.MaxReservations != 4 || l.MaxCircuits != relay.DefaultLimits.MaxCircuits ||
+		l.CircuitDuration != 30*time.Second || l.CircuitData != 1<<20 {
+		t.Fatalf("unexpected limits %+v", l)
+	}
+
+	for _, cfg := range []config.RelayService{
+		{ReservationTTL: "soon"},
+		{CircuitDuration: "-1m"},
+		{CircuitData: "lots"},
+		{MaxCircuits: -1},
+	} {
+		if _, err := RelayLimits(cfg); err == nil {
+			t.Errorf("expected %+v to be rejected", cfg)
+		}
+	}
+}
@@ -139,14 +139,23 @@ func (f *Filestore) DeleteBlock(c cid.Cid) error {
 }
 
 // Get retrieves the block with the given Cid. It may return
-// ErrNotFound when the block is not stored.
+// ErrNotFound when the block is not stored, or when the file it references
+// was moved or changed, so that callers fetch the block from elsewhere.
 func (f *Filestore) Get(c cid.Cid) (blocks.Block, error) {
 	blk, err := f.bs.Get(c)
 	switch err {
 	case nil:
 		return blk, nil
 	case blockstore.ErrNotFound:
-		return f.fm.Get(c)
+		blk, err := f.fm.Get(c)
+		if cerr, ok := err.(*CorruptReferenceError); ok {
+			switch cerr.Code {
+			case StatusFileNotFound, StatusFileChanged:
+				log.Warningf("stale filestore reference %s: %s", c, err)
+				return nil, blockstore.ErrNotFound
+			}
+		}
+		return blk, err
 	default:
 		return nil, err
 	}
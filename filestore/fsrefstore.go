@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 
 	pb "mbfs/go-mbfs/filestore/pb"
 
@@ -31,8 +31,14 @@ var FilestorePrefix = ds.NewKey("filestore")
 type FileManager struct {
 	AllowFiles bool
 	AllowUrls  bool
+	URLFetcher *URLFetcher // used for urlstore blocks, a default one if nil
 	ds         ds.Batching
+	mirrors    ds.Datastore
 	root       string
+
+	staleLk sync.RWMutex
+	stale   map[string]struct{} // files which changed since being added
+	watcher *Watcher
 }
 
 // CorruptReferenceError implements the error interface.
@@ -54,7 +60,11 @@ func (c CorruptReferenceError) Error() string {
 // datastore and root. All FilestoreNodes paths are relative to the
 // root path given here, which is prepended for any operations.
 func NewFileManager(ds ds.Batching, root string) *FileManager {
-	return &FileManager{ds: dsns.Wrap(ds, FilestorePrefix), root: root}
+	return &FileManager{
+		ds:      dsns.Wrap(ds, FilestorePrefix),
+		mirrors: ds,
+		root:    root,
+	}
 }
 
 // AllKeysChan returns a channel from which to read the keys stored in
@@ -174,12 +184,24 @@ func (f *FileManager) readFileDataObj(c cid.Cid, d *pb.DataObj) ([]byte, error)
 
 	fi, err := os.Open(abspath)
 	if os.IsNotExist(err) {
+		f.markStale(d.GetFilePath())
 		return nil, &CorruptReferenceError{StatusFileNotFound, err}
 	} else if err != nil {
 		return nil, &CorruptReferenceError{StatusFileError, err}
 	}
 	defer fi.Close()
 
+	// a stat is much cheaper than reading and hashing the block, and tells
+	// most changes apart
+	st, err := fi.Stat()
+	if err != nil {
+		return nil, &CorruptReferenceError{StatusFileError, err}
+	}
+	if err := checkFileInfo(d, st); err != nil {
+		f.markStale(d.GetFilePath())
+		return nil, err
+	}
+
 	_, err = fi.Seek(int64(d.GetOffset()), io.SeekStart)
 	if err != nil {
 		return nil, &CorruptReferenceError{StatusFileError, err}
@@ -188,6 +210,7 @@ func (f *FileManager) readFileDataObj(c cid.Cid, d *pb.DataObj) ([]byte, error)
 	outbuf := make([]byte, d.GetSize_())
 	_, err = io.ReadFull(fi, outbuf)
 	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		f.markStale(d.GetFilePath())
 		return nil, &CorruptReferenceError{StatusFileChanged, err}
 	} else if err != nil {
 		return nil, &CorruptReferenceError{StatusFileError, err}
@@ -199,6 +222,7 @@ func (f *FileManager) readFileDataObj(c cid.Cid, d *pb.DataObj) ([]byte, error)
 	}
 
 	if !c.Equals(outcid) {
+		f.markStale(d.GetFilePath())
 		return nil, &CorruptReferenceError{StatusFileChanged,
 			fmt.Errorf("data in file did not match. %s offset %d", d.GetFilePath(), d.GetOffset())}
 	}
@@ -206,57 +230,91 @@ func (f *FileManager) readFileDataObj(c cid.Cid, d *pb.DataObj) ([]byte, error)
 	return outbuf, nil
 }
 
-// reads and verifies the block from URL
-func (f *FileManager) readURLDataObj(c cid.Cid, d *pb.DataObj) ([]byte, error) {
-	if !f.AllowUrls {
-		return nil, ErrUrlstoreNotEnabled
+// checkFileInfo compares the size and modification time of a file with the
+// ones recorded when the reference was added. References written by older
+// versions have no ModTime and are not checked.
+func checkFileInfo(d *pb.DataObj, fi os.FileInfo) error {
+	if d.GetModTime() == 0 {
+		return nil
 	}
-
-	req, err := http.NewRequest("GET", d.GetFilePath(), nil)
-	if err != nil {
-		return nil, err
+	if uint64(fi.Size()) != d.GetFileSize() || fi.ModTime().UnixNano() != d.GetModTime() {
+		return &CorruptReferenceError{StatusFileChanged,
+			fmt.Errorf("file was modified after it was added: %s", d.GetFilePath())}
 	}
+	return nil
+}
 
-	req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", d.GetOffset(), d.GetOffset()+d.GetSize_()-1))
-
-	res, err := http.DefaultClient.Do(req)
+// stampFileInfo records the current size and modification time of the file
+// referenced by d.
+func (f *FileManager) stampFileInfo(d *pb.DataObj) error {
+	fi, err := os.Stat(filepath.Join(f.root, filepath.FromSlash(d.GetFilePath())))
 	if err != nil {
-		return nil, &CorruptReferenceError{StatusFileError, err}
-	}
-	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
-		return nil, &CorruptReferenceError{StatusFileError,
-			fmt.Errorf("expected HTTP 200 or 206 got %d", res.StatusCode)}
+		return err
 	}
+	d.FileSize = uint64(fi.Size())
+	d.ModTime = fi.ModTime().UnixNano()
+	return nil
+}
 
-	outbuf := make([]byte, d.GetSize_())
-	_, err = io.ReadFull(res.Body, outbuf)
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
-		return nil, &CorruptReferenceError{StatusFileChanged, err}
-	} else if err != nil {
-		return nil, &CorruptReferenceError{StatusFileError, err}
+// markStale remembers that a file changed, references to it are then checked
+// by Has.
+func (f *FileManager) markStale(path string) {
+	f.staleLk.Lock()
+	if f.stale == nil {
+		f.stale = make(map[string]struct{})
 	}
-	res.Body.Close()
+	f.stale[path] = struct{}{}
+	f.staleLk.Unlock()
+}
 
-	outcid, err := c.Prefix().Sum(outbuf)
-	if err != nil {
-		return nil, err
+// isStale tells whether the data of a reference is known to have changed.
+// Only references to files marked by markStale are checked, so this stays
+// cheap for the common case of files that were never touched.
+func (f *FileManager) isStale(d *pb.DataObj) bool {
+	if IsURL(d.GetFilePath()) {
+		return false
 	}
 
-	if !c.Equals(outcid) {
-		return nil, &CorruptReferenceError{StatusFileChanged,
-			fmt.Errorf("data in file did not match. %s offset %d", d.GetFilePath(), d.GetOffset())}
+	f.staleLk.RLock()
+	_, marked := f.stale[d.GetFilePath()]
+	f.staleLk.RUnlock()
+	if !marked {
+		return false
+	}
+	if d.GetModTime() == 0 {
+		// nothing to tell whether this block is still there
+		return true
 	}
 
-	return outbuf, nil
+	fi, err := os.Stat(filepath.Join(f.root, filepath.FromSlash(d.GetFilePath())))
+	return err != nil || checkFileInfo(d, fi) != nil
+}
+
+func (f *FileManager) hasStale() bool {
+	f.staleLk.RLock()
+	defer f.staleLk.RUnlock()
+	return len(f.stale) > 0
 }
 
 // Has returns if the FileManager is storing a block reference. It does not
-// validate the data, nor checks if the reference is valid.
+// validate the data, but references to files known to have changed since
+// they were added are reported missing, so that the blocks get fetched
+// again.
 func (f *FileManager) Has(c cid.Cid) (bool, error) {
-	// NOTE: interesting thing to consider. Has doesnt validate the data.
-	// So the data on disk could be invalid, and we could think we have it.
 	dsk := dshelp.CidToDsKey(c)
-	return f.ds.Has(dsk)
+	if !f.hasStale() {
+		return f.ds.Has(dsk)
+	}
+
+	dobj, err := f.getDataObj(c)
+	switch err {
+	case nil:
+		return !f.isStale(dobj), nil
+	case blockstore.ErrNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
 }
 
 type putter interface {
@@ -291,6 +349,16 @@ func (f *FileManager) putTo(b *posinfo.FilestoreNode, to putter) error {
 		}
 
 		dobj.FilePath = filepath.ToSlash(p)
+		if err := f.stampFileInfo(&dobj); err != nil {
+			return err
+		}
+
+		f.staleLk.RLock()
+		w := f.watcher
+		f.staleLk.RUnlock()
+		if w != nil {
+			w.track(dobj.FilePath)
+		}
 	}
 	dobj.Offset = b.PosInfo.Offset
 	dobj.Size_ = uint64(len(b.RawData()))
@@ -0,0 +1,411 @@
+package filestore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pb "mbfs/go-mbfs/filestore/pb"
+
+	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+	dshelp "mbfs/go-mbfs/gx/QmaHSUAhuf9WG3mzJUd1fLDsQGvjsaQdUE7w5cZncz9AcB/go-ipfs-ds-help"
+	dsq "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/query"
+	proto "mbfs/go-mbfs/gx/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+	mh "mbfs/go-mbfs/gx/QmerPMzPk1mJVowm8KgmoknWa4yCYvvugMPsgWmDNUvDLW/go-multihash"
+)
+
+// RepairAction tells what Repair did with a broken reference.
+type RepairAction int32
+
+// These are the actions Repair takes.
+const (
+	RepairNone      RepairAction = 0 // the data could not be found
+	RepairRelinked  RepairAction = 1 // the reference points to a new location
+	RepairConverted RepairAction = 2 // the block moved to the main blockstore
+)
+
+// String provides a human-readable representation for RepairActions.
+func (a RepairAction) String() string {
+	switch a {
+	case RepairNone:
+		return "unresolved"
+	case RepairRelinked:
+		return "relinked"
+	case RepairConverted:
+		return "converted"
+	default:
+		return "???"
+	}
+}
+
+// RepairRes is the outcome of repairing one broken reference. FilePath and
+// Offset are the new location of relinked blocks.
+type RepairRes struct {
+	Key       cid.Cid
+	Status    Status
+	Action    RepairAction
+	ErrorMsg  string
+	OldPath   string
+	OldOffset uint64
+	FilePath  string
+	Offset    uint64
+	Size      uint64
+}
+
+// RepairOptions configures Repair.
+type RepairOptions struct {
+	// Dirs are searched for files holding the data of broken references.
+	// They must be inside the filestore root, relative paths are relative
+	// to it.
+	Dirs []string
+
+	// Fetch, if set, is used to get the blocks which could not be found in
+	// Dirs. Fetched blocks are stored in the main blockstore and their
+	// reference is dropped.
+	Fetch func(cid.Cid) (blocks.Block, error)
+
+	// DryRun reports what would be done without changing anything. The
+	// blocks are not fetched either: the references left to Fetch are
+	// reported as converted, whether the blocks can be fetched or not.
+	DryRun bool
+}
+
+// brokenRef is a reference whose data couldn't be read
+type brokenRef struct {
+	key  cid.Cid
+	dobj *pb.DataObj
+	res  *RepairRes
+}
+
+// Repair looks for the data of every file reference that can't be read
+// anymore, and points the reference to its new location.
+//
+// Files in the candidate directories are split into blocks of the sizes
+// used by the broken references and matched by hash, so data is found
+// wherever it moved to, as long as it still starts on a block boundary.
+// Files named like the original are also checked at the original offsets,
+// which covers files added with a variable size chunker.
+func Repair(fs *Filestore, opts RepairOptions) ([]*RepairRes, error) {
+	dirs := make([]string, 0, len(opts.Dirs))
+	for _, d := range opts.Dirs {
+		abs := d
+		if !filepath.IsAbs(abs) {
+			abs = filepath.Join(fs.fm.root, d)
+		}
+		if !filepath.HasPrefix(abs, fs.fm.root) {
+			return nil, fmt.Errorf("cannot repair from %s, it is outside ipfs root (%s)", abs, fs.fm.root)
+		}
+		dirs = append(dirs, abs)
+	}
+
+	broken, err := brokenRefs(fs)
+	if err != nil {
+		return nil, err
+	}
+	if len(broken) == 0 {
+		return nil, nil
+	}
+
+	if len(dirs) > 0 {
+		if err := relink(fs, dirs, broken); err != nil {
+			return nil, err
+		}
+	}
+
+	var out []*RepairRes
+	for _, b := range broken {
+		if b.res.Action == RepairNone && opts.Fetch != nil {
+			if opts.DryRun {
+				b.res.Action = RepairConverted
+			} else {
+				convert(fs, opts.Fetch, b)
+			}
+		}
+		out = append(out, b.res)
+	}
+
+	if opts.DryRun {
+		return out, nil
+	}
+
+	batch, err := fs.fm.ds.Batch()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range broken {
+		switch b.res.Action {
+		case RepairRelinked:
+			if err := fs.fm.stampFileInfo(b.dobj); err != nil {
+				return nil, err
+			}
+			data, err := proto.Marshal(b.dobj)
+			if err != nil {
+				return nil, err
+			}
+			if err := batch.Put(dshelp.CidToDsKey(b.key), data); err != nil {
+				return nil, err
+			}
+		case RepairConverted:
+			if err := batch.Delete(dshelp.CidToDsKey(b.key)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, batch.Commit()
+}
+
+// brokenRefs returns the file references whose data is missing or changed
+func brokenRefs(fs *Filestore) ([]*brokenRef, error) {
+	qr, err := fs.fm.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer qr.Close()
+
+	var out []*brokenRef
+	for {
+		c, dobj, err := next(qr)
+		if dobj == nil && err == nil {
+			return out, nil
+		}
+		if err != nil || IsURL(dobj.GetFilePath()) {
+			continue
+		}
+
+		_, err = fs.fm.readFileDataObj(c, dobj)
+		cerr, ok := err.(*CorruptReferenceError)
+		if !ok || (cerr.Code != StatusFileNotFound && cerr.Code != StatusFileChanged) {
+			continue
+		}
+
+		out = append(out, &brokenRef{
+			key:  c,
+			dobj: dobj,
+			res: &RepairRes{
+				Key:       c,
+				Status:    cerr.Code,
+				OldPath:   dobj.GetFilePath(),
+				OldOffset: dobj.GetOffset(),
+				Size:      dobj.GetSize_(),
+			},
+		})
+	}
+}
+
+type hashFunc struct {
+	code   uint64
+	length int
+}
+
+func relink(fs *Filestore, dirs []string, broken []*brokenRef) error {
+	sizes := make(map[uint64]int)
+	hashes := make(map[hashFunc]struct{})
+	byName := make(map[string][]*brokenRef)
+	wanted := make(map[string][]*brokenRef)
+	for _, b := range broken {
+		wanted[string(b.key.Hash())] = append(wanted[string(b.key.Hash())], b)
+		sizes[b.dobj.GetSize_()]++
+		pref := b.key.Prefix()
+		hashes[hashFunc{pref.MhType, pref.MhLength}] = struct{}{}
+		name := filepath.Base(filepath.FromSlash(b.dobj.GetFilePath()))
+		byName[name] = append(byName[name], b)
+	}
+
+	// fixed size chunkers leave all blocks but the last of a file with the
+	// same size, those sizes are worth scanning files for
+	var strides []uint64
+	for size, n := range sizes {
+		if n > 1 || len(broken) == 1 {
+			strides = append(strides, size)
+		}
+	}
+
+	walk := func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			log.Warningf("filestore repair: %s", err)
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(fs.fm.root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if err := scanFile(wanted, path, rel, fi.Size(), strides, sizes, hashes); err != nil {
+			log.Warningf("filestore repair: %s", err)
+			return nil
+		}
+
+		for _, b := range byName[fi.Name()] {
+			if b.res.Action != RepairNone {
+				continue
+			}
+			dobj := &pb.DataObj{
+				FilePath: rel,
+				Offset:   b.dobj.GetOffset(),
+				Size_:    b.dobj.GetSize_(),
+			}
+			if _, err := fs.fm.readFileDataObj(b.key, dobj); err == nil {
+				setLocation(b, rel, dobj.Offset)
+			}
+		}
+		return nil
+	}
+
+	for _, dir := range dirs {
+		if err := filepath.Walk(dir, walk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setLocation(b *brokenRef, path string, offset uint64) {
+	b.dobj = &pb.DataObj{
+		FilePath: path,
+		Offset:   offset,
+		Size_:    b.dobj.GetSize_(),
+	}
+	b.res.Action = RepairRelinked
+	b.res.FilePath = path
+	b.res.Offset = offset
+}
+
+// scanFile hashes the file at path in blocks of each of the stride sizes,
+// and relinks the wanted references whose hash it finds. The short block at
+// the end of the file, and the whole file, are hashed too when their size
+// matches a broken reference.
+func scanFile(wanted map[string][]*brokenRef, path, rel string, size int64, strides []uint64, sizes map[uint64]int, hashes map[hashFunc]struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	add := func(offset, length uint64) error {
+		buf := make([]byte, length)
+		if _, err := f.ReadAt(buf, int64(offset)); err != nil && err != io.EOF {
+			return err
+		}
+		for h := range hashes {
+			sum, err := mh.Sum(buf, h.code, h.length)
+			if err != nil {
+				return err
+			}
+			for _, b := range wanted[string(sum)] {
+				if b.res.Action == RepairNone {
+					setLocation(b, rel, offset)
+				}
+			}
+		}
+		return nil
+	}
+
+	fsize := uint64(size)
+	if _, ok := sizes[fsize]; ok && fsize > 0 {
+		if err := add(0, fsize); err != nil {
+			return err
+		}
+	}
+
+	for _, stride := range strides {
+		if stride == 0 {
+			continue
+		}
+		for off := uint64(0); off < fsize; off += stride {
+			length := stride
+			if off+length > fsize {
+				length = fsize - off
+				if _, ok := sizes[length]; !ok {
+					break
+				}
+			}
+			if off == 0 && length == fsize {
+				// already hashed as a whole
+				continue
+			}
+			if err := add(off, length); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// convert fetches the block of a broken reference and moves it to the main
+// blockstore
+func convert(fs *Filestore, fetch func(cid.Cid) (blocks.Block, error), b *brokenRef) {
+	blk, err := fetch(b.key)
+	if err != nil {
+		b.res.ErrorMsg = err.Error()
+		return
+	}
+	if err := fs.bs.Put(blk); err != nil {
+		b.res.ErrorMsg = err.Error()
+		return
+	}
+	b.res.Action = RepairConverted
+}
+
+// RemovePrefix drops the references to files below the given path, which is
+// relative to the filestore root unless it is absolute or a URL. Only the
+// references are removed, the files are left alone.
+func RemovePrefix(fs *Filestore, prefix string) ([]*ListRes, error) {
+	if !IsURL(prefix) {
+		if filepath.IsAbs(prefix) {
+			rel, err := filepath.Rel(fs.fm.root, prefix)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return nil, fmt.Errorf("%s is outside ipfs root (%s)", prefix, fs.fm.root)
+			}
+			prefix = rel
+		}
+		prefix = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(prefix)), "/")
+	}
+
+	qr, err := fs.fm.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*ListRes
+	for {
+		c, dobj, err := next(qr)
+		if dobj == nil && err == nil {
+			break
+		}
+		if err != nil || !hasPathPrefix(dobj.GetFilePath(), prefix) {
+			continue
+		}
+		out = append(out, mkListRes(c, dobj, nil))
+	}
+	qr.Close()
+
+	batch, err := fs.fm.ds.Batch()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range out {
+		if err := batch.Delete(dshelp.CidToDsKey(r.Key)); err != nil {
+			return nil, err
+		}
+	}
+	return out, batch.Commit()
+}
+
+// hasPathPrefix reports whether p is prefix or a path below it
+func hasPathPrefix(p, prefix string) bool {
+	if prefix == "." || prefix == "" {
+		return !IsURL(p)
+	}
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return len(p) == len(prefix) || p[len(prefix)] == '/' || strings.HasSuffix(prefix, "/")
+}
@@ -0,0 +1,194 @@
+package filestore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	blockstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
+	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+)
+
+func checkReadable(t *testing.T, fs *Filestore, cids []cid.Cid) {
+	for _, c := range cids {
+		if r := Verify(fs, c); r.Status != StatusOk {
+			t.Fatalf("%s: %s %s", c, r.Status, r.ErrorMsg)
+		}
+	}
+}
+
+func TestRepairMovedFile(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+	fname, cids := randomFileAdd(t, fs, dir, 100)
+
+	moved := filepath.Join(dir, "moved")
+	if err := os.Mkdir(moved, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(fname, filepath.Join(moved, "renamed")); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Repair(fs, RepairOptions{Dirs: []string{moved}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(cids) {
+		t.Fatalf("expected %d repairs, got %d", len(cids), len(res))
+	}
+	for _, r := range res {
+		if r.Status != StatusFileNotFound || r.Action != RepairRelinked {
+			t.Fatalf("%s: unexpected result %s %s", r.Key, r.Status, r.Action)
+		}
+		if r.FilePath != "moved/renamed" || r.Offset != r.OldOffset {
+			t.Fatalf("%s: relinked to %s %d", r.Key, r.FilePath, r.Offset)
+		}
+	}
+	checkReadable(t, fs, cids)
+}
+
+func TestRepairShiftedData(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+	fname, cids := randomFileAdd(t, fs, dir, 100)
+
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two blocks worth of data in front, and the file split in two
+	shifted := append(bytes.Repeat([]byte{0}, 20), data[:50]...)
+	if err := ioutil.WriteFile(fname, shifted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "rest"), data[50:], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Repair(fs, RepairOptions{Dirs: []string{dir}, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.Status != StatusFileChanged || r.Action != RepairRelinked {
+			t.Fatalf("%s: unexpected result %s %s", r.Key, r.Status, r.Action)
+		}
+	}
+	if r := Verify(fs, cids[0]); r.Status != StatusFileChanged {
+		t.Fatal("dry run should not change references")
+	}
+
+	if _, err := Repair(fs, RepairOptions{Dirs: []string{dir}}); err != nil {
+		t.Fatal(err)
+	}
+	checkReadable(t, fs, cids)
+}
+
+func TestRepairConvert(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+	fname, cids := randomFileAdd(t, fs, dir, 100)
+
+	stored := make(map[string]blocks.Block)
+	for _, c := range cids {
+		blk, err := fs.Get(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored[c.KeyString()] = blk
+	}
+	if err := os.Remove(fname); err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(c cid.Cid) (blocks.Block, error) {
+		if c.Equals(cids[0]) {
+			return nil, errors.New("not available")
+		}
+		return stored[c.KeyString()], nil
+	}
+
+	// a dry run fetches nothing
+	res, err := Repair(fs, RepairOptions{
+		Dirs:   []string{dir},
+		Fetch:  func(c cid.Cid) (blocks.Block, error) { t.Fatal("fetched during a dry run"); return nil, nil },
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(cids) {
+		t.Fatalf("expected %d results, got %d", len(cids), len(res))
+	}
+	for _, r := range res {
+		if r.Action != RepairConverted {
+			t.Fatalf("%s: expected %s, got %s", r.Key, RepairConverted, r.Action)
+		}
+		if has, _ := fs.MainBlockstore().Has(r.Key); has {
+			t.Fatal("a dry run stored a block")
+		}
+	}
+
+	res, err = Repair(fs, RepairOptions{Dirs: []string{dir}, Fetch: fetch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		want := RepairConverted
+		if r.Key.Equals(cids[0]) {
+			want = RepairNone
+		}
+		if r.Action != want {
+			t.Fatalf("%s: expected %s, got %s", r.Key, want, r.Action)
+		}
+	}
+
+	for _, c := range cids[1:] {
+		if has, _ := fs.FileManager().Has(c); has {
+			t.Fatal("converted block is still referenced")
+		}
+		if has, _ := fs.MainBlockstore().Has(c); !has {
+			t.Fatal("converted block is not in the main blockstore")
+		}
+	}
+	// the stale reference is hidden from Has, but kept
+	if r := List(fs, cids[0]); r.Status == StatusKeyNotFound {
+		t.Fatal("unresolved reference was dropped")
+	}
+}
+
+func TestRemovePrefix(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, keep := randomFileAdd(t, fs, dir, 50)
+	_, drop := randomFileAdd(t, fs, sub, 50)
+
+	// "su" is not a path prefix of "sub"
+	res, err := RemovePrefix(fs, "su")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 0 {
+		t.Fatal("removed references outside of the prefix")
+	}
+
+	res, err = RemovePrefix(fs, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(drop) {
+		t.Fatalf("expected %d references to be removed, got %d", len(drop), len(res))
+	}
+	for _, c := range drop {
+		if _, err := fs.Get(c); err != blockstore.ErrNotFound {
+			t.Fatal("reference was not removed")
+		}
+	}
+	checkReadable(t, fs, keep)
+}
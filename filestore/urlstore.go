@@ -0,0 +1,391 @@
+package filestore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "mbfs/go-mbfs/filestore/pb"
+
+	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dsq "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/query"
+)
+
+// UrlMirrorsPrefix identifies the key prefix for the mirror lists of the
+// urlstore.
+var UrlMirrorsPrefix = ds.NewKey("urlstore/mirrors")
+
+// These are the defaults of NewURLFetcher.
+const (
+	DefaultURLMaxConnsPerHost = 8
+	DefaultURLRetries         = 3
+	DefaultURLTimeout         = time.Minute
+)
+
+// URLCredential authenticates the requests for the URLs starting with
+// Prefix, with a bearer token, basic auth, or arbitrary headers.
+type URLCredential struct {
+	Prefix      string
+	BearerToken string
+	Username    string
+	Password    string
+	Headers     map[string]string
+}
+
+// URLFetcher does the HTTP requests of the urlstore. It keeps a bounded pool
+// of connections per host, adds the credentials matching each URL, and
+// retries requests failing with network errors or server errors.
+type URLFetcher struct {
+	Credentials []URLCredential
+	Retries     int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+
+	client *http.Client
+}
+
+// NewURLFetcher creates a URLFetcher keeping up to maxConnsPerHost
+// connections to each host, and giving up on requests not answered with
+// their headers within timeout. The body is not bounded in time, as a large
+// object can take long to download. Zero values select the defaults.
+func NewURLFetcher(maxConnsPerHost int, timeout time.Duration) *URLFetcher {
+	if maxConnsPerHost <= 0 {
+		maxConnsPerHost = DefaultURLMaxConnsPerHost
+	}
+	if timeout <= 0 {
+		timeout = DefaultURLTimeout
+	}
+
+	return &URLFetcher{
+		Retries:    DefaultURLRetries,
+		MinBackoff: 250 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				DialContext:           (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext,
+				TLSHandshakeTimeout:   timeout,
+				ResponseHeaderTimeout: timeout,
+				MaxConnsPerHost:       maxConnsPerHost,
+				MaxIdleConnsPerHost:   maxConnsPerHost,
+				IdleConnTimeout:       90 * time.Second,
+			},
+		},
+	}
+}
+
+var defaultFetcherOnce sync.Once
+var defaultFetcher *URLFetcher
+
+// HTTPStatusError is returned for requests answered with an unexpected
+// status code.
+type HTTPStatusError struct {
+	URL  string
+	Code int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s: expected HTTP 200 or 206 got %d", e.URL, e.Code)
+}
+
+// temporary tells whether a failed request is worth retrying
+func temporary(err error) bool {
+	if serr, ok := err.(*HTTPStatusError); ok {
+		return serr.Code >= 500 || serr.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// credential returns the credential with the longest prefix of rawurl. The
+// prefix must have the scheme and host of the URL, and its path must end at a
+// path separator of the URL, so that no look-alike host gets the credential.
+func (u *URLFetcher) credential(rawurl string) *URLCredential {
+	target, err := url.Parse(rawurl)
+	if err != nil {
+		return nil
+	}
+	path := target.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	var best *URLCredential
+	bestLen := -1
+	for i, c := range u.Credentials {
+		prefix, err := url.Parse(c.Prefix)
+		if err != nil || !strings.EqualFold(prefix.Scheme, target.Scheme) || !strings.EqualFold(prefix.Host, target.Host) {
+			continue
+		}
+		if !hasPathPrefix(path, prefix.EscapedPath()) {
+			continue
+		}
+		if l := len(prefix.EscapedPath()); l > bestLen {
+			best = &u.Credentials[i]
+			bestLen = l
+		}
+	}
+	return best
+}
+
+func (u *URLFetcher) do(url, rng string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	if c := u.credential(url); c != nil {
+		for k, v := range c.Headers {
+			req.Header.Set(k, v)
+		}
+		if c.BearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+		} else if c.Username != "" || c.Password != "" {
+			req.SetBasicAuth(c.Username, c.Password)
+		}
+	}
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		// drain so the connection can be reused
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+		return nil, &HTTPStatusError{url, res.StatusCode}
+	}
+	return res, nil
+}
+
+// Get requests url, with the given Range header unless rng is empty. The
+// caller must close the body of the response.
+func (u *URLFetcher) Get(url, rng string) (*http.Response, error) {
+	backoff := u.MinBackoff
+	for attempt := 0; ; attempt++ {
+		res, err := u.do(url, rng)
+		if err == nil {
+			return res, nil
+		}
+		if attempt >= u.Retries || !temporary(err) {
+			return nil, err
+		}
+
+		log.Debugf("urlstore: %s, retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > u.MaxBackoff {
+			backoff = u.MaxBackoff
+		}
+	}
+}
+
+// fetchRange reads size bytes at offset from url
+func (u *URLFetcher) fetchRange(url string, offset, size uint64) ([]byte, error) {
+	res, err := u.Get(url, fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+	if err != nil {
+		return nil, &CorruptReferenceError{StatusFileError, err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK && offset > 0 {
+		// the server ignored the range
+		if _, err := io.CopyN(ioutil.Discard, res.Body, int64(offset)); err != nil {
+			return nil, &CorruptReferenceError{StatusFileChanged, err}
+		}
+	}
+
+	outbuf := make([]byte, size)
+	_, err = io.ReadFull(res.Body, outbuf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, &CorruptReferenceError{StatusFileChanged, err}
+	} else if err != nil {
+		return nil, &CorruptReferenceError{StatusFileError, err}
+	}
+	return outbuf, nil
+}
+
+// Fetcher returns the URLFetcher used by the FileManager.
+func (f *FileManager) Fetcher() *URLFetcher {
+	if f.URLFetcher != nil {
+		return f.URLFetcher
+	}
+	defaultFetcherOnce.Do(func() {
+		defaultFetcher = NewURLFetcher(0, 0)
+	})
+	return defaultFetcher
+}
+
+func mirrorsKey(url string) ds.Key {
+	return UrlMirrorsPrefix.ChildString(base64.RawURLEncoding.EncodeToString([]byte(url)))
+}
+
+// SetMirrors records URLs serving the same data as url. They are tried in
+// order when url fails. An empty list removes the mirrors.
+func (f *FileManager) SetMirrors(url string, mirrors []string) error {
+	if len(mirrors) == 0 {
+		err := f.mirrors.Delete(mirrorsKey(url))
+		if err == ds.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	for _, m := range mirrors {
+		if !IsURL(m) {
+			return fmt.Errorf("unsupported url syntax: %s", m)
+		}
+	}
+	data, err := json.Marshal(mirrors)
+	if err != nil {
+		return err
+	}
+	return f.mirrors.Put(mirrorsKey(url), data)
+}
+
+// Mirrors returns the mirrors of url.
+func (f *FileManager) Mirrors(url string) ([]string, error) {
+	data, err := f.mirrors.Get(mirrorsKey(url))
+	if err == ds.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// reads and verifies the block from its URL, or from the mirrors of the URL
+// when that fails
+func (f *FileManager) readURLDataObj(c cid.Cid, d *pb.DataObj) ([]byte, error) {
+	if !f.AllowUrls {
+		return nil, ErrUrlstoreNotEnabled
+	}
+
+	urls := []string{d.GetFilePath()}
+	var err error
+	for i := 0; i < len(urls); i++ {
+		var out []byte
+		out, err = f.Fetcher().fetchRange(urls[i], d.GetOffset(), d.GetSize_())
+		if err == nil {
+			out, err = verifyURLData(c, d, urls[i], out)
+			if err == nil {
+				return out, nil
+			}
+		}
+
+		if i == 0 {
+			mirrors, merr := f.Mirrors(d.GetFilePath())
+			if merr != nil {
+				log.Errorf("urlstore: reading mirrors of %s: %s", d.GetFilePath(), merr)
+			}
+			urls = append(urls, mirrors...)
+		}
+		if i+1 < len(urls) {
+			log.Debugf("urlstore: %s, trying %s", err, urls[i+1])
+		}
+	}
+	return nil, err
+}
+
+func verifyURLData(c cid.Cid, d *pb.DataObj, url string, data []byte) ([]byte, error) {
+	outcid, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.Equals(outcid) {
+		return nil, &CorruptReferenceError{StatusFileChanged,
+			fmt.Errorf("data in file did not match. %s offset %d", url, d.GetOffset())}
+	}
+	return data, nil
+}
+
+// URLEntry summarizes the references to one URL.
+type URLEntry struct {
+	URL     string
+	Blocks  int
+	Size    uint64
+	Mirrors []string `json:",omitempty"`
+}
+
+// ListURLs returns the URLs referenced by the urlstore, sorted.
+func ListURLs(fs *Filestore) ([]*URLEntry, error) {
+	qr, err := fs.fm.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+
+	byURL := make(map[string]*URLEntry)
+	for {
+		_, dobj, err := next(qr)
+		if dobj == nil && err == nil {
+			break
+		}
+		if err != nil || !IsURL(dobj.GetFilePath()) {
+			continue
+		}
+
+		e, ok := byURL[dobj.GetFilePath()]
+		if !ok {
+			e = &URLEntry{URL: dobj.GetFilePath()}
+			byURL[e.URL] = e
+		}
+		e.Blocks++
+		e.Size += dobj.GetSize_()
+	}
+	qr.Close()
+
+	out := make([]*URLEntry, 0, len(byURL))
+	for _, e := range byURL {
+		if e.Mirrors, err = fs.fm.Mirrors(e.URL); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	return out, nil
+}
+
+// VerifyURLs returns a function as an iterator which, once invoked, returns
+// one by one each URL reference, after checking that its data can be
+// fetched. If url is not empty, only the references to it are returned.
+func VerifyURLs(fs *Filestore, url string) (func() *ListRes, error) {
+	qr, err := fs.fm.ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() *ListRes {
+		for {
+			c, dobj, err := next(qr)
+			if dobj == nil && err == nil {
+				qr.Close()
+				return nil
+			}
+			if err == nil {
+				if !IsURL(dobj.GetFilePath()) || (url != "" && dobj.GetFilePath() != url) {
+					continue
+				}
+				_, err = fs.fm.readURLDataObj(c, dobj)
+			}
+			return mkListRes(c, dobj, err)
+		}
+	}, nil
+}
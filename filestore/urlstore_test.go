@@ -0,0 +1,222 @@
+package filestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dag "mbfs/go-mbfs/gx/QmaDBne4KeY3UepeqSVKYpSmQGa3q9zP6x3LfVF2UjF3Hc/go-merkledag"
+
+	posinfo "mbfs/go-mbfs/gx/QmR6YMs8EkXQLXNwQKxLnQp2VBZSepoEJ8KCZAyanJHhJu/go-ipfs-posinfo"
+	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+)
+
+// bucket serves data like an object store, honouring range requests, and
+// only to requests carrying the expected Authorization header
+func bucket(t *testing.T, data []byte, auth string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != auth {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "object", time.Time{}, bytes.NewReader(data))
+	}))
+}
+
+func newTestUrlstore(t *testing.T) *Filestore {
+	_, fs := newTestFilestore(t)
+	fs.fm.AllowUrls = true
+	fs.fm.URLFetcher = NewURLFetcher(2, 5*time.Second)
+	fs.fm.URLFetcher.MinBackoff = time.Millisecond
+	return fs
+}
+
+func urlAdd(t *testing.T, fs *Filestore, url string, data []byte) []cid.Cid {
+	var out []cid.Cid
+	for i := 0; i < len(data)/10; i++ {
+		n := &posinfo.FilestoreNode{
+			PosInfo: &posinfo.PosInfo{
+				FullPath: url,
+				Offset:   uint64(i * 10),
+			},
+			Node: dag.NewRawNode(data[i*10 : (i+1)*10]),
+		}
+		if err := fs.Put(n); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, n.Cid())
+	}
+	return out
+}
+
+func TestUrlstoreCredentials(t *testing.T) {
+	data := make([]byte, 100)
+	rand.Read(data)
+	data2 := make([]byte, 100)
+	rand.Read(data2)
+
+	bearer := bucket(t, data, "Bearer secret")
+	defer bearer.Close()
+	basic := bucket(t, data2, "Basic dXNlcjpwYXNz") // user:pass
+	defer basic.Close()
+
+	fs := newTestUrlstore(t)
+	fs.fm.URLFetcher.Credentials = []URLCredential{
+		{Prefix: bearer.URL, BearerToken: "wrong"},
+		{Prefix: bearer.URL + "/bucket/", BearerToken: "secret"},
+		{Prefix: basic.URL, Username: "user", Password: "pass"},
+	}
+
+	checkReadable(t, fs, urlAdd(t, fs, bearer.URL+"/bucket/obj", data))
+	checkReadable(t, fs, urlAdd(t, fs, basic.URL+"/obj", data2))
+
+	// only the wrong token applies here
+	other := make([]byte, 100)
+	rand.Read(other)
+	cids := urlAdd(t, fs, bearer.URL+"/other", other)
+	if r := Verify(fs, cids[0]); r.Status != StatusFileError {
+		t.Fatalf("expected the request to be refused, got %s", r.Status)
+	}
+}
+
+func TestUrlstoreCredentialPrefix(t *testing.T) {
+	u := &URLFetcher{Credentials: []URLCredential{
+		{Prefix: "https://bucket.example.com", BearerToken: "host"},
+		{Prefix: "https://bucket.example.com/private", BearerToken: "private"},
+		{Prefix: "https://bucket.example.com/shared/", BearerToken: "shared"},
+	}}
+
+	for rawurl, token := range map[string]string{
+		"https://bucket.example.com/obj":               "host",
+		"https://BUCKET.example.com/obj":               "host",
+		"https://bucket.example.com/private":           "private",
+		"https://bucket.example.com/private/obj":       "private",
+		"https://bucket.example.com/privateer/obj":     "host",
+		"https://bucket.example.com/shared/obj":        "shared",
+		"https://bucket.example.com.evil/obj":          "",
+		"https://bucket.example.com@attacker/obj":      "",
+		"https://bucket.example.com:8443/obj":          "",
+		"http://bucket.example.com/obj":                "",
+		"https://attacker/?https://bucket.example.com": "",
+	} {
+		var got string
+		if c := u.credential(rawurl); c != nil {
+			got = c.BearerToken
+		}
+		if got != token {
+			t.Errorf("expected the credential %q for %s, got %q", token, rawurl, got)
+		}
+	}
+}
+
+func TestUrlstoreMirrors(t *testing.T) {
+	data := make([]byte, 100)
+	rand.Read(data)
+	changed := make([]byte, 100)
+	rand.Read(changed)
+
+	gone := httptest.NewServer(http.NotFoundHandler())
+	defer gone.Close()
+	stale := bucket(t, changed, "")
+	defer stale.Close()
+	good := bucket(t, data, "")
+	defer good.Close()
+
+	fs := newTestUrlstore(t)
+	url := gone.URL + "/obj"
+	cids := urlAdd(t, fs, url, data)
+	if r := Verify(fs, cids[0]); r.Status != StatusFileError {
+		t.Fatalf("expected an error without mirrors, got %s", r.Status)
+	}
+
+	if err := fs.fm.SetMirrors(url, []string{stale.URL + "/obj", good.URL + "/obj"}); err != nil {
+		t.Fatal(err)
+	}
+	checkReadable(t, fs, cids)
+
+	urls, err := ListURLs(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].Blocks != len(cids) || urls[0].Size != 100 || len(urls[0].Mirrors) != 2 {
+		t.Fatalf("unexpected listing: %+v", urls)
+	}
+
+	next, err := VerifyURLs(fs, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for r := next(); r != nil; r = next() {
+		if r.Status != StatusOk {
+			t.Fatalf("%s: %s", r.Key, r.ErrorMsg)
+		}
+		n++
+	}
+	if n != len(cids) {
+		t.Fatalf("expected %d results, got %d", len(cids), n)
+	}
+}
+
+func TestUrlstoreRetry(t *testing.T) {
+	data := make([]byte, 100)
+	rand.Read(data)
+
+	var requests int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// ignores the range
+		w.Write(data)
+	}))
+	defer flaky.Close()
+
+	fs := newTestUrlstore(t)
+	cids := urlAdd(t, fs, flaky.URL, data)
+	checkReadable(t, fs, cids)
+
+	fs.fm.URLFetcher.Retries = 1
+	if r := Verify(fs, cids[0]); r.Status != StatusFileError {
+		t.Fatalf("expected to give up after one retry, got %s", r.Status)
+	}
+}
+
+func TestURLFetcherTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/headers" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// the body takes longer than the timeout
+		for i := 0; i < 4; i++ {
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("data"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer slow.Close()
+
+	f := NewURLFetcher(2, 100*time.Millisecond)
+	f.Retries = 0
+	res, err := f.Get(slow.URL+"/body", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(data) != "datadatadatadata" {
+		t.Fatalf("expected the whole body, got %q: %v", data, err)
+	}
+
+	if _, err := f.Get(slow.URL+"/headers", ""); err == nil {
+		t.Fatal("expected the request to time out waiting for the headers")
+	}
+}
@@ -0,0 +1,200 @@
+package filestore
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	dsq "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/query"
+	fsnotify "mbfs/go-mbfs/gx/QmfNjggF4Pt6erqg3NDafD3MdvDHk1qqCVr8pL5hnPucS8/fsnotify"
+)
+
+// pruneInterval is how often the files marked stale are checked again, to
+// forget the ones that were added again or whose references were removed
+var pruneInterval = 10 * time.Minute
+
+// Watcher marks the references to a file stale as soon as the file is
+// written to, replaced or removed. Without it, changes are only noticed when
+// a block is read.
+//
+// Directories are watched rather than files, so that files being replaced
+// are noticed too, and to keep the number of watches low.
+type Watcher struct {
+	fm *FileManager
+	w  *fsnotify.Watcher
+
+	lk    sync.Mutex
+	dirs  map[string]struct{} // watched directories, relative to the root
+	files map[string]struct{} // referenced files, relative to the root
+}
+
+// NewWatcher starts watching the files referenced by the FileManager. Files
+// referenced later on are watched as they are added. Only one Watcher can be
+// active for a FileManager.
+func NewWatcher(fm *FileManager) (*Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &Watcher{
+		fm:    fm,
+		w:     w,
+		dirs:  make(map[string]struct{}),
+		files: make(map[string]struct{}),
+	}
+
+	qr, err := fm.ds.Query(dsq.Query{})
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	for {
+		_, dobj, err := next(qr)
+		if dobj == nil && err == nil {
+			break
+		}
+		if err != nil || IsURL(dobj.GetFilePath()) {
+			continue
+		}
+		fw.track(dobj.GetFilePath())
+	}
+	qr.Close()
+
+	fm.staleLk.Lock()
+	fm.watcher = fw
+	fm.staleLk.Unlock()
+
+	go fw.run()
+	return fw, nil
+}
+
+// track starts watching the file at p, relative to the filestore root
+func (fw *Watcher) track(p string) {
+	fw.lk.Lock()
+	defer fw.lk.Unlock()
+
+	fw.files[p] = struct{}{}
+
+	dir := path.Dir(p)
+	if _, ok := fw.dirs[dir]; ok {
+		return
+	}
+	if err := fw.w.Add(filepath.Join(fw.fm.root, filepath.FromSlash(dir))); err != nil {
+		log.Warningf("filestore: cannot watch %s: %s", dir, err)
+		return
+	}
+	fw.dirs[dir] = struct{}{}
+}
+
+func (fw *Watcher) run() {
+	t := time.NewTicker(pruneInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := fw.prune(); err != nil {
+				log.Warningf("filestore watcher: %s", err)
+			}
+		case e, ok := <-fw.w.Events:
+			if !ok {
+				return
+			}
+			fw.handle(e)
+		case err, ok := <-fw.w.Errors:
+			if !ok {
+				return
+			}
+			log.Warningf("filestore watcher: %s", err)
+		}
+	}
+}
+
+func (fw *Watcher) handle(e fsnotify.Event) {
+	rel, err := filepath.Rel(fw.fm.root, e.Name)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+
+	fw.lk.Lock()
+	defer fw.lk.Unlock()
+
+	if _, ok := fw.files[rel]; ok {
+		log.Debugf("filestore: %s changed (%s)", rel, e.Op)
+		fw.fm.markStale(rel)
+		return
+	}
+
+	// a watched directory going away takes its files with it
+	if _, ok := fw.dirs[rel]; ok && e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		for d := range fw.dirs {
+			if d == rel || strings.HasPrefix(d, rel+"/") {
+				delete(fw.dirs, d)
+			}
+		}
+		for f := range fw.files {
+			if strings.HasPrefix(f, rel+"/") {
+				fw.fm.markStale(f)
+			}
+		}
+	}
+}
+
+// prune forgets the files marked stale that no reference is stale for
+// anymore: the files added again since they changed, and the ones whose
+// references were removed. It runs with the events, so that no file is
+// marked while the references are checked.
+func (fw *Watcher) prune() error {
+	fm := fw.fm
+	fm.staleLk.RLock()
+	marked := make(map[string]bool, len(fm.stale))
+	for p := range fm.stale {
+		marked[p] = false
+	}
+	fm.staleLk.RUnlock()
+	if len(marked) == 0 {
+		return nil
+	}
+
+	qr, err := fm.ds.Query(dsq.Query{})
+	if err != nil {
+		return err
+	}
+	defer qr.Close()
+	for {
+		_, dobj, err := next(qr)
+		if dobj == nil && err == nil {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		// marked[p] tells whether a reference to p is stale
+		p := dobj.GetFilePath()
+		if stale, ok := marked[p]; ok && !stale {
+			marked[p] = fm.isStale(dobj)
+		}
+	}
+
+	fm.staleLk.Lock()
+	defer fm.staleLk.Unlock()
+	for p, stale := range marked {
+		if !stale {
+			delete(fm.stale, p)
+		}
+	}
+	return nil
+}
+
+// Close stops watching.
+func (fw *Watcher) Close() error {
+	fw.fm.staleLk.Lock()
+	if fw.fm.watcher == fw {
+		fw.fm.watcher = nil
+	}
+	fw.fm.staleLk.Unlock()
+	return fw.w.Close()
+}
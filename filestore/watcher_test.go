@@ -0,0 +1,160 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	posinfo "mbfs/go-mbfs/gx/QmR6YMs8EkXQLXNwQKxLnQp2VBZSepoEJ8KCZAyanJHhJu/go-ipfs-posinfo"
+	blockstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
+	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+	dag "mbfs/go-mbfs/gx/QmaDBne4KeY3UepeqSVKYpSmQGa3q9zP6x3LfVF2UjF3Hc/go-merkledag"
+)
+
+// rewrite replaces the contents of a file with data of the same size
+func rewrite(t *testing.T, fname string) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range data {
+		data[i] ^= 0xff
+	}
+	if err := ioutil.WriteFile(fname, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// make sure the mtime moves even on filesystems with coarse timestamps
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(fname, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaleReference(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+	fname, cids := randomFileAdd(t, fs, dir, 100)
+
+	blk, err := fs.Get(cids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := blk.RawData()
+
+	// touching the file is enough, the data isn't even read
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(fname, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if r := Verify(fs, cids[0]); r.Status != StatusFileChanged {
+		t.Fatalf("expected a changed file, got %s", r.Status)
+	}
+
+	if _, err := fs.Get(cids[0]); err != blockstore.ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a stale block, got %v", err)
+	}
+	if has, _ := fs.Has(cids[0]); has {
+		t.Fatal("stale block should not be reported")
+	}
+
+	// a copy fetched from elsewhere is stored and served
+	fetched, err := blocks.NewBlockWithCid(data, cids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Put(fetched); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Get(cids[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+	fname, cids := randomFileAdd(t, fs, dir, 100)
+	_, other := randomFileAdd(t, fs, dir, 100)
+
+	w, err := NewWatcher(fs.FileManager())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	rewrite(t, fname)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		has, err := fs.Has(cids[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("change was not noticed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, c := range other {
+		if has, _ := fs.Has(c); !has {
+			t.Fatal("unchanged file was marked stale")
+		}
+	}
+}
+
+func TestWatcherPrune(t *testing.T) {
+	dir, fs := newTestFilestore(t)
+	fname, cids := randomFileAdd(t, fs, dir, 100)
+	other, otherCids := randomFileAdd(t, fs, dir, 100)
+	kept, keptCids := randomFileAdd(t, fs, dir, 100)
+
+	// the files are marked by hand, without events to race with
+	fm := fs.FileManager()
+	w := &Watcher{fm: fm}
+	for _, f := range []string{fname, other, kept} {
+		rewrite(t, f)
+		p, err := filepath.Rel(dir, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fm.markStale(filepath.ToSlash(p))
+	}
+
+	// the first file is added again, the references to the second one are
+	// removed
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cids {
+		if err := fs.DeleteBlock(c); err != nil {
+			t.Fatal(err)
+		}
+		n := &posinfo.FilestoreNode{
+			PosInfo: &posinfo.PosInfo{FullPath: fname, Offset: uint64(i * 10)},
+			Node:    dag.NewRawNode(data[i*10 : (i+1)*10]),
+		}
+		if err := fs.Put(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range otherCids {
+		if err := fs.DeleteBlock(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.prune(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fm.stale) != 1 {
+		t.Fatalf("expected only the file still stale to stay marked, got %v", fm.stale)
+	}
+	if has, _ := fs.Has(keptCids[0]); has {
+		t.Fatal("expected the references to the file still stale to be reported missing")
+	}
+}
@@ -23,6 +23,7 @@ import (
 	procctx "mbfs/go-mbfs/gx/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess/context"
 	"mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
 	"mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
 	"mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
 	logging "mbfs/go-mbfs/gx/QmcuXC5cxs79ro2cUuHs4HQ2bkDLJUYokwL8aivcX6HW3C/go-log"
 	"mbfs/go-mbfs/gx/QmekzFM3hPZjTjUFGTABdQkEnQ3PTiMstY198PwSFr5w1Q/go-metrics-interface"
@@ -225,6 +226,37 @@ func (bs *Bitswap) LedgerForPeer(p peer.ID) *decision.Receipt {
 	return bs.engine.LedgerForPeer(p)
 }
 
+// SetLimits replaces the rate limits and peer classes used to share the
+// bandwidth between peers
+func (bs *Bitswap) SetLimits(l decision.Limits) {
+	bs.engine.SetLimits(l)
+}
+
+// Limits returns the rate limits and peer classes of the node
+func (bs *Bitswap) Limits() decision.Limits {
+	return bs.engine.Limits()
+}
+
+// SetStrategy replaces the strategy deciding which peers are served first
+func (bs *Bitswap) SetStrategy(s decision.Strategy) {
+	bs.engine.SetStrategy(s)
+}
+
+// Strategy returns the strategy deciding which peers are served first
+func (bs *Bitswap) Strategy() decision.Strategy {
+	return bs.engine.Strategy()
+}
+
+// SetLedgerStore keeps the ledgers of the peers in d across restarts
+func (bs *Bitswap) SetLedgerStore(d ds.Datastore) {
+	bs.engine.SetLedgerStore(d)
+}
+
+// Ledgers returns the ledgers of all known peers, sorted by score
+func (bs *Bitswap) Ledgers() ([]*decision.Receipt, error) {
+	return bs.engine.Ledgers()
+}
+
 // GetBlocks returns a channel where the caller may receive blocks that
 // correspond to the provided |keys|. Returns an error if BitSwap is unable to
 // begin this request within the deadline enforced by the context.
@@ -443,6 +475,17 @@ func (bs *Bitswap) ReceiveMessage(ctx context.Context, p peer.ID, incoming bsmsg
 		}(block)
 	}
 	wg.Wait()
+
+	// holding the stream of a peer over its receive rate back keeps it from
+	// sending more until it is under it again
+	if d := bs.engine.ReceiveWait(p, incoming); d > 0 {
+		log.Debugf("peer %s is over its receive rate, waiting %s", p, d)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		case <-bs.process.Closing():
+		}
+	}
 }
 
 var ErrAlreadyHaveBlock = errors.New("already have block")
@@ -491,6 +534,9 @@ func (bs *Bitswap) ReceiveError(err error) {
 }
 
 func (bs *Bitswap) Close() error {
+	if err := bs.engine.FlushLedgers(); err != nil {
+		log.Errorf("failed to write the ledgers: %s", err)
+	}
 	return bs.process.Close()
 }
 
@@ -672,3 +672,22 @@ func TestBitswapLedgerTwoWay(t *testing.T) {
 		}
 	}
 }
+
+func TestWantPriority(t *testing.T) {
+	ctx := context.Background()
+	high := ContextWithPriority(ctx, 1)
+	low := ContextWithPriority(ctx, MinPriority-5)
+
+	if wantPriority(ctx, 0) <= wantPriority(ctx, 1) {
+		t.Fatal("the first blocks of a request should come first")
+	}
+	if wantPriority(high, 1000) <= wantPriority(ctx, 0) {
+		t.Fatal("a higher priority should come before the default one")
+	}
+	if wantPriority(low, 0) >= wantPriority(ctx, 1000) || wantPriority(low, 1<<40) <= 0 {
+		t.Fatal("a lower priority should come after the default one and stay positive")
+	}
+	if p := wantPriority(ContextWithPriority(ctx, MaxPriority+5), 0); p > kMaxPriority {
+		t.Fatalf("priority %d is over the maximum", p)
+	}
+}
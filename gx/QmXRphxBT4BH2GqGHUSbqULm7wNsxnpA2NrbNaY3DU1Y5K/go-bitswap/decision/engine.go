@@ -11,6 +11,7 @@ import (
 
 	bstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
 	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
 	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
 	logging "mbfs/go-mbfs/gx/QmcuXC5cxs79ro2cUuHs4HQ2bkDLJUYokwL8aivcX6HW3C/go-log"
 )
@@ -90,19 +91,36 @@ type Engine struct {
 	// ledgerMap lists Ledgers by their Partner key.
 	ledgerMap map[peer.ID]*ledger
 
+	// limits tracks the bandwidth used by the peers against their limits
+	limits *limiter
+
+	// store keeps the ledgers across restarts, when set, and pending holds
+	// the ledgers being written to it
+	store   ds.Datastore
+	pending map[peer.ID]*pendingLedger
+
+	strategyLk sync.RWMutex
+	// strategy scores the peers from their ledger
+	strategy Strategy
+
 	ticker *time.Ticker
 }
 
 func NewEngine(ctx context.Context, bs bstore.Blockstore) *Engine {
 	e := &Engine{
 		ledgerMap:        make(map[peer.ID]*ledger),
+		pending:          make(map[peer.ID]*pendingLedger),
 		bs:               bs,
 		peerRequestQueue: newPRQ(),
 		outbox:           make(chan (<-chan *Envelope), outboxChanBuffer),
 		workSignal:       make(chan struct{}, 1),
 		ticker:           time.NewTicker(time.Millisecond * 100),
+		limits:           newLimiter(),
+		strategy:         Nice,
 	}
+	e.peerRequestQueue.limits = e.limits
 	go e.taskWorker(ctx)
+	go e.flushWorker(ctx)
 	return e
 }
 
@@ -119,13 +137,79 @@ func (e *Engine) LedgerForPeer(p peer.ID) *Receipt {
 	ledger.lk.Lock()
 	defer ledger.lk.Unlock()
 
-	return &Receipt{
-		Peer:      ledger.Partner.String(),
-		Value:     ledger.Accounting.Value(),
-		Sent:      ledger.Accounting.BytesSent,
-		Recv:      ledger.Accounting.BytesRecv,
-		Exchanged: ledger.ExchangeCount(),
+	return e.receipt(ledger)
+}
+
+// receipt returns the receipt of l. NB: l.lk must be held
+func (e *Engine) receipt(l *ledger) *Receipt {
+	class := e.limits.class(l.Partner)
+	r := &Receipt{
+		Peer:      l.Partner.String(),
+		Value:     l.Accounting.Value(),
+		Sent:      l.Accounting.BytesSent,
+		Recv:      l.Accounting.BytesRecv,
+		Exchanged: l.ExchangeCount(),
+		Class:     class.Name,
+		Priority:  class.Priority,
+		SendRate:  class.SendRate,
+		RecvRate:  class.RecvRate,
+	}
+	r.Score = e.Strategy().Score(r)
+	return r
+}
+
+// SetStrategy replaces the strategy scoring the peers
+func (e *Engine) SetStrategy(s Strategy) {
+	e.strategyLk.Lock()
+	e.strategy = s
+	e.strategyLk.Unlock()
+
+	e.lock.Lock()
+	for _, l := range e.ledgerMap {
+		l.lk.Lock()
+		e.updateScore(l)
+		l.lk.Unlock()
+	}
+	e.lock.Unlock()
+	e.signalNewWork()
+}
+
+// Strategy returns the strategy scoring the peers
+func (e *Engine) Strategy() Strategy {
+	e.strategyLk.RLock()
+	defer e.strategyLk.RUnlock()
+	return e.strategy
+}
+
+// updateScore updates the score of the peer of l after a change of its
+// ledger. NB: l.lk must be held
+func (e *Engine) updateScore(l *ledger) {
+	e.peerRequestQueue.setScore(l.Partner, e.receipt(l).Score)
+}
+
+// SetLimits replaces the rate limits and peer classes of the engine
+func (e *Engine) SetLimits(l Limits) {
+	e.limits.setLimits(l)
+	e.peerRequestQueue.reclassify()
+	e.signalNewWork()
+}
+
+// Limits returns the rate limits and peer classes of the engine
+func (e *Engine) Limits() Limits {
+	e.limits.lk.Lock()
+	defer e.limits.lk.Unlock()
+	return e.limits.limits
+}
+
+// ReceiveWait records the blocks of a message received from p against the
+// receive limits, and returns how long to wait before reading the next
+// message of p so that it stays under them.
+func (e *Engine) ReceiveWait(p peer.ID, m bsmsg.BitSwapMessage) time.Duration {
+	n := 0
+	for _, block := range m.Blocks() {
+		n += len(block.RawData())
 	}
+	return e.limits.received(p, n)
 }
 
 func (e *Engine) taskWorker(ctx context.Context) {
@@ -175,6 +259,9 @@ func (e *Engine) nextEnvelope(ctx context.Context) (*Envelope, error) {
 				continue
 			}
 			msg.AddBlock(block)
+			// charged now rather than once sent, so that the next tasks
+			// of the peer wait for it
+			e.limits.sent(nextTask.Target, len(block.RawData()))
 		}
 
 		if msg.Empty() {
@@ -277,6 +364,9 @@ func (e *Engine) MessageReceived(p peer.ID, m bsmsg.BitSwapMessage) error {
 		log.Debugf("got block %s %d bytes", block, len(block.RawData()))
 		l.ReceivedBytes(len(block.RawData()))
 	}
+	if len(m.Blocks()) > 0 {
+		e.updateScore(l)
+	}
 	return nil
 }
 
@@ -324,6 +414,9 @@ func (e *Engine) MessageSent(p peer.ID, m bsmsg.BitSwapMessage) error {
 		l.wantList.Remove(block.Cid())
 		e.peerRequestQueue.Remove(block.Cid(), p)
 	}
+	if len(m.Blocks()) > 0 {
+		e.updateScore(l)
+	}
 
 	return nil
 }
@@ -334,6 +427,7 @@ func (e *Engine) PeerConnected(p peer.ID) {
 	l, ok := e.ledgerMap[p]
 	if !ok {
 		l = newLedger(p)
+		e.loadLedger(l)
 		e.ledgerMap[p] = l
 	}
 	l.lk.Lock()
@@ -343,16 +437,28 @@ func (e *Engine) PeerConnected(p peer.ID) {
 
 func (e *Engine) PeerDisconnected(p peer.ID) {
 	e.lock.Lock()
-	defer e.lock.Unlock()
 	l, ok := e.ledgerMap[p]
 	if !ok {
+		e.lock.Unlock()
 		return
 	}
 	l.lk.Lock()
-	defer l.lk.Unlock()
 	l.ref--
+	var pl *pendingLedger
 	if l.ref <= 0 {
 		delete(e.ledgerMap, p)
+		e.limits.forget(p)
+		e.peerRequestQueue.forget(p)
+		pl = e.snapshotLedger(l)
+	}
+	l.lk.Unlock()
+	d := e.store
+	e.lock.Unlock()
+
+	if pl != nil {
+		if err := e.saveLedger(d, p, pl); err != nil {
+			log.Errorf("failed to write the ledger of %s: %s", p, err)
+		}
 	}
 }
 
@@ -374,6 +480,7 @@ func (e *Engine) findOrCreate(p peer.ID) *ledger {
 	l, ok := e.ledgerMap[p]
 	if !ok {
 		l = newLedger(p)
+		e.loadLedger(l)
 		e.ledgerMap[p] = l
 	}
 	return l
@@ -2,13 +2,16 @@ package decision
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	message "mbfs/go-mbfs/gx/QmXRphxBT4BH2GqGHUSbqULm7wNsxnpA2NrbNaY3DU1Y5K/go-bitswap/message"
+	wl "mbfs/go-mbfs/gx/QmXRphxBT4BH2GqGHUSbqULm7wNsxnpA2NrbNaY3DU1Y5K/go-bitswap/wantlist"
 
 	blockstore "mbfs/go-mbfs/gx/QmSNLNnL3kq3A1NGdQA9AtgxM9CWKiiSEup3W435jCkRQS/go-ipfs-blockstore"
 	blocks "mbfs/go-mbfs/gx/QmWoXtvgC8inqFkAATB7cp2Dax7XBi9VDvSg9RCCZufmRk/go-block-format"
@@ -241,3 +244,147 @@ func stringsComplement(set, subset []string) []string {
 	}
 	return complement
 }
+
+func TestDebtRatioStrategy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := NewEngine(ctx, blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())))
+	e.SetStrategy(&DebtRatio{Grace: 1 << 10})
+
+	taker := peer.ID("taker")
+	giver := peer.ID("giver")
+	m := message.New(false)
+	m.AddBlock(blocks.NewBlock(make([]byte, 1<<14)))
+	e.MessageSent(taker, m)
+	e.MessageReceived(giver, m)
+
+	if s := e.LedgerForPeer(taker).Score; s > 0.01 {
+		t.Fatalf("a peer only taking should have a low score, got %f", s)
+	}
+	if s := e.LedgerForPeer(giver).Score; s < 0.99 {
+		t.Fatalf("a peer only giving should have a high score, got %f", s)
+	}
+
+	// the giver is served first, though it asked last
+	c := blocks.NewBlock([]byte("wanted")).Cid()
+	e.peerRequestQueue.Push(taker, &wl.Entry{Cid: c})
+	e.peerRequestQueue.Push(giver, &wl.Entry{Cid: c})
+	if task := e.peerRequestQueue.Pop(); task.Target != giver {
+		t.Fatalf("expected the giver to be served first, got %s", task.Target)
+	}
+
+	// and the nice strategy serves both alike
+	e.SetStrategy(Nice)
+	if s := e.LedgerForPeer(taker).Score; s != 1 {
+		t.Fatalf("expected a score of 1, got %f", s)
+	}
+}
+
+func TestLedgerStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	p := testutil.RandPeerIDFatal(t)
+
+	e := NewEngine(ctx, bs)
+	e.SetLedgerStore(d)
+	m := message.New(false)
+	m.AddBlock(blocks.NewBlock([]byte("some data")))
+	e.MessageSent(p, m)
+	e.MessageReceived(p, m)
+	if err := e.FlushLedgers(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new engine lists the stored ledger before the peer shows up
+	e = NewEngine(ctx, bs)
+	e.SetLedgerStore(d)
+	ledgers, err := e.Ledgers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ledgers) != 1 || ledgers[0].Peer != p.String() || ledgers[0].Sent != 9 {
+		t.Fatalf("expected the stored ledger, got %+v", ledgers)
+	}
+
+	// and keeps counting from it
+	e.MessageSent(p, m)
+	if r := e.LedgerForPeer(p); r.Sent != 18 || r.Recv != 9 || r.Exchanged != 3 {
+		t.Fatalf("the stored ledger wasn't loaded: %+v", r)
+	}
+}
+
+func TestPeerDisconnectedForgets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := NewEngine(ctx, blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())))
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	e.SetLedgerStore(d)
+	e.SetStrategy(&DebtRatio{Grace: 1 << 10})
+	p := testutil.RandPeerIDFatal(t)
+
+	e.PeerConnected(p)
+	m := message.New(false)
+	m.AddBlock(blocks.NewBlock([]byte("some data")))
+	e.MessageSent(p, m)
+	prq := e.peerRequestQueue
+	prq.lock.Lock()
+	_, scored := prq.scores[p]
+	prq.lock.Unlock()
+	if !scored {
+		t.Fatal("expected the peer to be scored")
+	}
+
+	e.PeerDisconnected(p)
+	prq.lock.Lock()
+	_, scored = prq.scores[p]
+	prq.lock.Unlock()
+	if scored {
+		t.Fatal("expected the score of the disconnected peer to be forgotten")
+	}
+	e.lock.Lock()
+	pending := len(e.pending)
+	e.lock.Unlock()
+	if pending != 0 {
+		t.Fatalf("expected no pending ledger, got %d", pending)
+	}
+	if has, err := d.Has(ledgerKey(p)); err != nil || !has {
+		t.Fatal("expected the ledger to be stored on disconnection", err)
+	}
+}
+
+func TestExpireLedgers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := NewEngine(ctx, blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())))
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	e.SetLedgerStore(d)
+
+	store := func(p peer.ID, last time.Time) {
+		data, err := json.Marshal(&storedLedger{Sent: 1, LastExchange: last})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Put(ledgerKey(p), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * ledgerExpiry)
+	expired := testutil.RandPeerIDFatal(t)
+	recent := testutil.RandPeerIDFatal(t)
+	connected := testutil.RandPeerIDFatal(t)
+	store(expired, old)
+	store(recent, time.Now())
+	store(connected, old)
+	e.PeerConnected(connected)
+
+	if err := e.expireLedgers(); err != nil {
+		t.Fatal(err)
+	}
+	for p, kept := range map[peer.ID]bool{expired: false, recent: true, connected: true} {
+		if has, err := d.Has(ledgerKey(p)); err != nil || has != kept {
+			t.Fatalf("expected the ledger of %s to be kept: %t, got %t %v", p, kept, has, err)
+		}
+	}
+}
@@ -40,6 +40,9 @@ type ledger struct {
 	// to a given peer
 	sentToPeer map[string]time.Time
 
+	// dirty is set when the ledger changed since it was last stored
+	dirty bool
+
 	// ref is the reference count for this ledger, its used to ensure we
 	// don't drop the reference to this ledger in multi-connection scenarios
 	ref int
@@ -53,6 +56,16 @@ type Receipt struct {
 	Sent      uint64
 	Recv      uint64
 	Exchanged uint64
+
+	// Class is the name of the class of the peer, with its priority and
+	// per-peer rate limits in bytes per second
+	Class    string `json:",omitempty"`
+	Priority int    `json:",omitempty"`
+	SendRate int64  `json:",omitempty"`
+	RecvRate int64  `json:",omitempty"`
+
+	// Score is the score of the peer given by the strategy of the engine
+	Score float64
 }
 
 type debtRatio struct {
@@ -68,12 +81,14 @@ func (l *ledger) SentBytes(n int) {
 	l.exchangeCount++
 	l.lastExchange = time.Now()
 	l.Accounting.BytesSent += uint64(n)
+	l.dirty = true
 }
 
 func (l *ledger) ReceivedBytes(n int) {
 	l.exchangeCount++
 	l.lastExchange = time.Now()
 	l.Accounting.BytesRecv += uint64(n)
+	l.dirty = true
 }
 
 func (l *ledger) Wants(k cid.Cid, priority int) {
@@ -0,0 +1,280 @@
+package decision
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dsq "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/query"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// ledgerPrefix is where the ledgers are kept in the datastore, by peer
+var ledgerPrefix = ds.NewKey("/bitswap/ledgers")
+
+const (
+	// ledgerFlushInterval is how often the changed ledgers are written to
+	// the datastore, and ledgerExpireInterval how often the stored ledgers
+	// are expired
+	ledgerFlushInterval  = time.Minute
+	ledgerExpireInterval = time.Hour
+)
+
+// ledgerExpiry is how long the stored ledger of a peer is kept after its last
+// exchange with the node
+var ledgerExpiry = 30 * 24 * time.Hour
+
+// storedLedger is the part of a ledger kept across restarts
+type storedLedger struct {
+	Sent         uint64
+	Recv         uint64
+	Exchanged    uint64
+	LastExchange time.Time
+}
+
+func ledgerKey(p peer.ID) ds.Key {
+	return ledgerPrefix.ChildString(p.Pretty())
+}
+
+// SetLedgerStore makes the engine keep its ledgers in d, so that they
+// survive restarts. The ledgers already in d are loaded as their peers show
+// up.
+func (e *Engine) SetLedgerStore(d ds.Datastore) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.store = d
+	for _, l := range e.ledgerMap {
+		l.lk.Lock()
+		e.loadLedger(l)
+		l.lk.Unlock()
+	}
+}
+
+// pendingLedger is a ledger being written to the datastore
+type pendingLedger struct {
+	data []byte
+}
+
+// loadLedger adds the counters stored for the peer of l to it, or the ones
+// being written. NB: e.lock and l.lk must be held
+func (e *Engine) loadLedger(l *ledger) {
+	if e.store == nil {
+		return
+	}
+	var data []byte
+	if pl, ok := e.pending[l.Partner]; ok {
+		// written again, in case the pending write fails
+		data = pl.data
+		l.dirty = true
+	} else {
+		var err error
+		data, err = e.store.Get(ledgerKey(l.Partner))
+		if err == ds.ErrNotFound {
+			return
+		}
+		if err != nil {
+			log.Errorf("failed to load the ledger of %s: %s", l.Partner, err)
+			return
+		}
+	}
+	var sl storedLedger
+	if err := json.Unmarshal(data, &sl); err != nil {
+		log.Errorf("invalid ledger for %s: %s", l.Partner, err)
+		return
+	}
+	l.Accounting.BytesSent += sl.Sent
+	l.Accounting.BytesRecv += sl.Recv
+	l.exchangeCount += sl.Exchanged
+	if sl.LastExchange.After(l.lastExchange) {
+		l.lastExchange = sl.LastExchange
+	}
+	e.updateScore(l)
+}
+
+// snapshotLedger marks l as stored, and returns what is to be written to
+// the datastore with saveLedger, or nil if l didn't change. Until then, the
+// ledger is loaded from the snapshot. NB: e.lock and l.lk must be held
+func (e *Engine) snapshotLedger(l *ledger) *pendingLedger {
+	if e.store == nil || !l.dirty {
+		return nil
+	}
+	data, err := json.Marshal(&storedLedger{
+		Sent:         l.Accounting.BytesSent,
+		Recv:         l.Accounting.BytesRecv,
+		Exchanged:    l.exchangeCount,
+		LastExchange: l.lastExchange,
+	})
+	if err != nil {
+		log.Errorf("failed to encode the ledger of %s: %s", l.Partner, err)
+		return nil
+	}
+	l.dirty = false
+	pl := &pendingLedger{data: data}
+	e.pending[l.Partner] = pl
+	return pl
+}
+
+// saveLedger writes the snapshot pl of the ledger of p to d, without the
+// locks held. A ledger which fails to be written is written again with the
+// next flush if it is still in memory.
+func (e *Engine) saveLedger(d ds.Datastore, p peer.ID, pl *pendingLedger) error {
+	err := d.Put(ledgerKey(p), pl.data)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.pending[p] == pl {
+		delete(e.pending, p)
+	}
+	if err != nil {
+		if l, ok := e.ledgerMap[p]; ok {
+			l.lk.Lock()
+			l.dirty = true
+			l.lk.Unlock()
+		}
+	}
+	return err
+}
+
+// FlushLedgers writes the ledgers changed since the last flush to the
+// datastore set with SetLedgerStore
+func (e *Engine) FlushLedgers() error {
+	e.lock.Lock()
+	d := e.store
+	snapshots := make(map[peer.ID]*pendingLedger)
+	for p, l := range e.ledgerMap {
+		l.lk.Lock()
+		if pl := e.snapshotLedger(l); pl != nil {
+			snapshots[p] = pl
+		}
+		l.lk.Unlock()
+	}
+	e.lock.Unlock()
+
+	var firstErr error
+	for p, pl := range snapshots {
+		if err := e.saveLedger(d, p, pl); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// expireLedgers deletes the stored ledgers of the peers not in memory whose
+// last exchange is older than ledgerExpiry
+func (e *Engine) expireLedgers() error {
+	e.lock.Lock()
+	d := e.store
+	e.lock.Unlock()
+	if d == nil {
+		return nil
+	}
+
+	res, err := d.Query(dsq.Query{Prefix: ledgerPrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+
+	before := time.Now().Add(-ledgerExpiry)
+	for _, r := range entries {
+		var sl storedLedger
+		if err := json.Unmarshal(r.Value, &sl); err == nil && sl.LastExchange.After(before) {
+			continue
+		}
+		p, err := peer.IDB58Decode(ds.RawKey(r.Key).BaseNamespace())
+		if err == nil {
+			e.lock.Lock()
+			_, inMemory := e.ledgerMap[p]
+			_, writing := e.pending[p]
+			e.lock.Unlock()
+			if inMemory || writing {
+				continue
+			}
+		}
+		if err := d.Delete(ds.RawKey(r.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) flushWorker(ctx context.Context) {
+	flush := time.NewTicker(ledgerFlushInterval)
+	defer flush.Stop()
+	expire := time.NewTicker(ledgerExpireInterval)
+	defer expire.Stop()
+	for {
+		select {
+		case <-flush.C:
+			if err := e.FlushLedgers(); err != nil {
+				log.Errorf("failed to write the bitswap ledgers: %s", err)
+			}
+		case <-expire.C:
+			if err := e.expireLedgers(); err != nil {
+				log.Errorf("failed to expire the bitswap ledgers: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Ledgers returns the receipts of all the peers with a ledger, in memory or
+// in the datastore, sorted by score
+func (e *Engine) Ledgers() ([]*Receipt, error) {
+	e.lock.Lock()
+	peers := make([]peer.ID, 0, len(e.ledgerMap))
+	for p := range e.ledgerMap {
+		peers = append(peers, p)
+	}
+	d := e.store
+	e.lock.Unlock()
+
+	out := make([]*Receipt, 0, len(peers))
+	seen := make(map[peer.ID]bool)
+	for _, p := range peers {
+		out = append(out, e.LedgerForPeer(p))
+		seen[p] = true
+	}
+
+	if d != nil {
+		res, err := d.Query(dsq.Query{Prefix: ledgerPrefix.String()})
+		if err != nil {
+			return nil, err
+		}
+		defer res.Close()
+		for r := range res.Next() {
+			if r.Error != nil {
+				return nil, r.Error
+			}
+			p, err := peer.IDB58Decode(ds.RawKey(r.Key).BaseNamespace())
+			if err != nil || seen[p] {
+				continue
+			}
+			var sl storedLedger
+			if err := json.Unmarshal(r.Value, &sl); err != nil {
+				log.Errorf("invalid ledger for %s: %s", p, err)
+				continue
+			}
+			l := newLedger(p)
+			l.Accounting = debtRatio{BytesSent: sl.Sent, BytesRecv: sl.Recv}
+			l.exchangeCount = sl.Exchanged
+			l.lastExchange = sl.LastExchange
+			out = append(out, e.receipt(l))
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Peer < out[j].Peer
+	})
+	return out, nil
+}
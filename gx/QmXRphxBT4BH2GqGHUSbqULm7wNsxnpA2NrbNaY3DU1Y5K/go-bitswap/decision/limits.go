@@ -0,0 +1,178 @@
+package decision
+
+import (
+	"sync"
+	"time"
+
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// PeerClass groups peers sharing a priority and per-peer rate limits. The
+// requests of peers in a class with a higher priority are served first.
+type PeerClass struct {
+	Name     string
+	Priority int
+
+	// SendRate and RecvRate bound the bytes per second exchanged with each
+	// peer of the class, 0 means no limit.
+	SendRate int64
+	RecvRate int64
+}
+
+// Limits configures how the engine shares its bandwidth between peers
+type Limits struct {
+	// SendRate and RecvRate bound the bytes per second exchanged with all
+	// peers, 0 means no limit.
+	SendRate int64
+	RecvRate int64
+
+	// Default is the class of the peers missing from Peers
+	Default PeerClass
+
+	// Peers maps the peers with a known class to it
+	Peers map[peer.ID]*PeerClass
+}
+
+func (l *Limits) classOf(p peer.ID) *PeerClass {
+	if c, ok := l.Peers[p]; ok {
+		return c
+	}
+	return &l.Default
+}
+
+// bucket is a token bucket refilled at rate bytes per second. Sending is
+// allowed as long as tokens are left, so a message may take it into debt
+// which has to be paid back before the next one.
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	// allow bursts of a second worth of data
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// wait returns how long to wait before the bucket can be used again. A nil
+// bucket has no limit.
+func (b *bucket) wait(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens > 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *bucket) take(n int) {
+	if b != nil {
+		b.tokens -= float64(n)
+	}
+}
+
+// peerLimits holds the buckets of a peer
+type peerLimits struct {
+	class *PeerClass
+	send  *bucket
+	recv  *bucket
+}
+
+// limiter tracks the bandwidth used by every peer against the Limits
+type limiter struct {
+	lk     sync.Mutex
+	limits Limits
+	send   *bucket
+	recv   *bucket
+	peers  map[peer.ID]*peerLimits
+}
+
+func newLimiter() *limiter {
+	return &limiter{peers: make(map[peer.ID]*peerLimits)}
+}
+
+func (l *limiter) setLimits(limits Limits) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.limits = limits
+	l.send = newBucket(limits.SendRate)
+	l.recv = newBucket(limits.RecvRate)
+	l.peers = make(map[peer.ID]*peerLimits)
+}
+
+// peer returns the limits of p. NB: l.lk must be held
+func (l *limiter) peer(p peer.ID) *peerLimits {
+	pl, ok := l.peers[p]
+	if !ok {
+		c := l.limits.classOf(p)
+		pl = &peerLimits{class: c, send: newBucket(c.SendRate), recv: newBucket(c.RecvRate)}
+		l.peers[p] = pl
+	}
+	return pl
+}
+
+func (l *limiter) class(p peer.ID) *PeerClass {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.peer(p).class
+}
+
+// sendWait returns how long to wait before sending to p
+func (l *limiter) sendWait(p peer.ID) time.Duration {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	now := time.Now()
+	return maxDuration(l.send.wait(now), l.peer(p).send.wait(now))
+}
+
+// sendBlocked reports whether nothing can be sent to anyone
+func (l *limiter) sendBlocked() bool {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	return l.send.wait(time.Now()) > 0
+}
+
+func (l *limiter) sent(p peer.ID, n int) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.send.take(n)
+	l.peer(p).send.take(n)
+}
+
+// received records n bytes received from p and returns how long to wait
+// before reading more from it
+func (l *limiter) received(p peer.ID, n int) time.Duration {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	pl := l.peer(p)
+	l.recv.take(n)
+	pl.recv.take(n)
+	now := time.Now()
+	return maxDuration(l.recv.wait(now), pl.recv.wait(now))
+}
+
+func (l *limiter) forget(p peer.ID) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	delete(l.peers, p)
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -27,6 +27,7 @@ func newPRQ() *prq {
 		partners: make(map[peer.ID]*activePartner),
 		frozen:   make(map[peer.ID]*activePartner),
 		pQueue:   pq.New(partnerCompare),
+		scores:   make(map[peer.ID]int),
 	}
 }
 
@@ -43,6 +44,13 @@ type prq struct {
 	partners map[peer.ID]*activePartner
 
 	frozen map[peer.ID]*activePartner
+
+	// limits, when set, gives the partners their class priority and holds
+	// back the partners over their send rate
+	limits *limiter
+
+	// scores holds the score level of the peers given by the strategy
+	scores map[peer.ID]int
 }
 
 // Push currently adds a new peerRequestTask to the end of the list
@@ -52,6 +60,11 @@ func (tl *prq) Push(to peer.ID, entries ...*wantlist.Entry) {
 	partner, ok := tl.partners[to]
 	if !ok {
 		partner = newActivePartner()
+		partner.id = to
+		partner.score = tl.score(to)
+		if tl.limits != nil {
+			partner.priority = tl.limits.class(to).Priority
+		}
 		tl.pQueue.Push(partner)
 		tl.partners[to] = partner
 	}
@@ -111,7 +124,25 @@ func (tl *prq) Pop() *peerRequestTask {
 	if tl.pQueue.Len() == 0 {
 		return nil
 	}
+	if tl.limits != nil && tl.limits.sendBlocked() {
+		return nil
+	}
+
+	// partners over their send rate are skipped until they are back under it
+	var throttled []*activePartner
+	defer func() {
+		for _, p := range throttled {
+			tl.pQueue.Push(p)
+		}
+	}()
 	partner := tl.pQueue.Pop().(*activePartner)
+	for tl.limits != nil && partner.requests > 0 && tl.limits.sendWait(partner.id) > 0 {
+		throttled = append(throttled, partner)
+		if tl.pQueue.Len() == 0 {
+			return nil
+		}
+		partner = tl.pQueue.Pop().(*activePartner)
+	}
 
 	var out *peerRequestTask
 	for partner.taskQueue.Len() > 0 && partner.freezeVal == 0 {
@@ -140,6 +171,46 @@ func (tl *prq) Pop() *peerRequestTask {
 	return out
 }
 
+// score returns the score level of p. NB: tl.lock must be held
+func (tl *prq) score(p peer.ID) int {
+	if s, ok := tl.scores[p]; ok {
+		return s
+	}
+	return scoreLevels - 1
+}
+
+// setScore updates the score of p given by the strategy
+func (tl *prq) setScore(p peer.ID, score float64) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+
+	level := scoreLevel(score)
+	tl.scores[p] = level
+	if partner, ok := tl.partners[p]; ok && partner.score != level {
+		partner.score = level
+		tl.pQueue.Update(partner.index)
+	}
+}
+
+// forget forgets the score of p, once disconnected
+func (tl *prq) forget(p peer.ID) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	delete(tl.scores, p)
+}
+
+// reclassify updates the class priority of the partners after a change of
+// the limits
+func (tl *prq) reclassify() {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+
+	for id, partner := range tl.partners {
+		partner.priority = tl.limits.class(id).Priority
+		tl.pQueue.Update(partner.index)
+	}
+}
+
 // Remove removes a task from the queue
 func (tl *prq) Remove(k cid.Cid, p peer.ID) {
 	tl.lock.Lock()
@@ -247,6 +318,13 @@ func wrapCmp(f func(a, b *peerRequestTask) bool) func(a, b pq.Elem) bool {
 }
 
 type activePartner struct {
+	id peer.ID
+
+	// priority is the priority of the class of the peer
+	priority int
+
+	// score is the score level given to the peer by the strategy
+	score int
 
 	// Active is the number of blocks this peer is currently being sent
 	// active must be locked around as it will be updated externally
@@ -291,6 +369,13 @@ func partnerCompare(a, b pq.Elem) bool {
 		return true
 	}
 
+	if pa.priority != pb.priority {
+		return pa.priority > pb.priority
+	}
+	if pa.score != pb.score {
+		return pa.score > pb.score
+	}
+
 	if pa.freezeVal > pb.freezeVal {
 		return false
 	}
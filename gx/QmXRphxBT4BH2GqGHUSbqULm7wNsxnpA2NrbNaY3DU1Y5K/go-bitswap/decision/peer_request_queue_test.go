@@ -12,6 +12,7 @@ import (
 	cid "mbfs/go-mbfs/gx/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
 	"mbfs/go-mbfs/gx/QmXRphxBT4BH2GqGHUSbqULm7wNsxnpA2NrbNaY3DU1Y5K/go-bitswap/wantlist"
 	"mbfs/go-mbfs/gx/QmZXjR5X1p4KrQ967cTsy4MymMzUM8mZECF3PV8UcN4o3g/go-testutil"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
 )
 
 func TestPushPop(t *testing.T) {
@@ -128,3 +129,60 @@ func TestPeerRepeats(t *testing.T) {
 		}
 	}
 }
+
+func TestPeerClasses(t *testing.T) {
+	prq := newPRQ()
+	prq.limits = newLimiter()
+	trusted := testutil.RandPeerIDFatal(t)
+	unknown := testutil.RandPeerIDFatal(t)
+	throttled := testutil.RandPeerIDFatal(t)
+	prq.limits.setLimits(Limits{
+		Default: PeerClass{SendRate: 100},
+		Peers: map[peer.ID]*PeerClass{
+			trusted: {Name: "trusted", Priority: 1},
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		elcid := cid.NewCidV0(u.Hash([]byte(fmt.Sprint(i))))
+		prq.Push(unknown, &wantlist.Entry{Cid: elcid})
+		prq.Push(throttled, &wantlist.Entry{Cid: elcid})
+		prq.Push(trusted, &wantlist.Entry{Cid: elcid})
+	}
+	// throttled is well over its send rate
+	prq.limits.sent(throttled, 1000)
+
+	// the trusted peer comes first, then the unknown one within its rate
+	for _, expected := range []peer.ID{trusted, trusted, unknown, unknown} {
+		task := prq.Pop()
+		if task == nil || task.Target != expected {
+			t.Fatalf("expected a task for %s, got %v", expected, task)
+		}
+		task.Done(task.Entries)
+	}
+	if task := prq.Pop(); task != nil {
+		t.Fatalf("expected no task while the peer is over its send rate, got one for %s", task.Target)
+	}
+
+	// going back under the send rate lets it through
+	prq.limits.setLimits(Limits{})
+	if task := prq.Pop(); task == nil || task.Target != throttled {
+		t.Fatalf("expected a task for %s, got %v", throttled, task)
+	}
+}
+
+func TestGlobalSendRate(t *testing.T) {
+	prq := newPRQ()
+	prq.limits = newLimiter()
+	prq.limits.setLimits(Limits{SendRate: 100})
+	a := testutil.RandPeerIDFatal(t)
+	b := testutil.RandPeerIDFatal(t)
+
+	prq.Push(a, &wantlist.Entry{Cid: cid.NewCidV0(u.Hash([]byte("a")))})
+	prq.Push(b, &wantlist.Entry{Cid: cid.NewCidV0(u.Hash([]byte("b")))})
+	prq.limits.sent(a, 1000)
+
+	if task := prq.Pop(); task != nil {
+		t.Fatalf("expected no task over the global send rate, got one for %s", task.Target)
+	}
+}
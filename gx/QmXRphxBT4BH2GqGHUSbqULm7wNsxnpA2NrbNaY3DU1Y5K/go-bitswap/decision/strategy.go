@@ -0,0 +1,66 @@
+package decision
+
+import (
+	"fmt"
+	"math"
+)
+
+// Strategy scores the peers of the engine from their ledger. Within a peer
+// class, the requests of the peers with a higher score are served first.
+type Strategy interface {
+	// Name identifies the strategy, e.g. in 'bitswap strategy'
+	Name() string
+
+	// Score returns the score of the peer of r, between 0 and 1
+	Score(r *Receipt) float64
+}
+
+// scoreLevels is the number of levels the scores are rounded down to when
+// ordering peers, so that peers with close scores are still served in turn
+const scoreLevels = 10
+
+func scoreLevel(score float64) int {
+	l := int(score * scoreLevels)
+	if l >= scoreLevels {
+		l = scoreLevels - 1
+	}
+	if l < 0 {
+		l = 0
+	}
+	return l
+}
+
+type nice struct{}
+
+// Nice is the strategy serving all peers alike
+var Nice Strategy = nice{}
+
+func (nice) Name() string { return "nice" }
+
+func (nice) Score(r *Receipt) float64 { return 1 }
+
+// DebtRatio is the strategy deprioritising the peers that take more than
+// they give. The debt ratio of a peer is the data sent to it over the data
+// received from it plus Grace bytes, so that new peers aren't punished for
+// their first requests. The score drops from 1 for a ratio of 0 to 0.5 for a
+// ratio of 2 and about 0 past 4.
+type DebtRatio struct {
+	Grace uint64
+}
+
+// DefaultDebtRatioGrace is the grace given to new peers by the debt ratio
+// strategy
+const DefaultDebtRatioGrace = 1 << 20
+
+func (s *DebtRatio) Name() string {
+	return fmt.Sprintf("debt-ratio (grace %d bytes)", s.Grace)
+}
+
+// Ratio returns the debt ratio of the peer of r
+func (s *DebtRatio) Ratio(r *Receipt) float64 {
+	return float64(r.Sent) / float64(r.Recv+s.Grace+1)
+}
+
+func (s *DebtRatio) Score(r *Receipt) float64 {
+	return 1 - 1/(1+math.Exp(6-3*s.Ratio(r)))
+}
@@ -0,0 +1,45 @@
+package bitswap
+
+import "context"
+
+const (
+	// MinPriority and MaxPriority bound the priorities given to the block
+	// requests with ContextWithPriority. The default priority is 0.
+	MinPriority = -10
+	MaxPriority = 10
+
+	// every priority gets its own range of wantlist priorities, so that the
+	// wants of a higher priority come before all the others while the order
+	// of the blocks within a request is kept
+	prioritySpan = kMaxPriority / (MaxPriority - MinPriority + 1)
+)
+
+type priorityKey struct{}
+
+// ContextWithPriority returns a context whose block requests are sent with
+// the given priority, clamped to [MinPriority, MaxPriority]. Peers serve the
+// wants of a higher priority first.
+func ContextWithPriority(ctx context.Context, priority int) context.Context {
+	if priority < MinPriority {
+		priority = MinPriority
+	}
+	if priority > MaxPriority {
+		priority = MaxPriority
+	}
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority set with ContextWithPriority, or 0
+func PriorityFromContext(ctx context.Context) int {
+	p, _ := ctx.Value(priorityKey{}).(int)
+	return p
+}
+
+// wantPriority returns the wantlist priority of the i-th block of a request
+// made with ctx
+func wantPriority(ctx context.Context, i int) int {
+	if i > prioritySpan-1 {
+		i = prioritySpan - 1
+	}
+	return (PriorityFromContext(ctx)-MinPriority+1)*prioritySpan - i
+}
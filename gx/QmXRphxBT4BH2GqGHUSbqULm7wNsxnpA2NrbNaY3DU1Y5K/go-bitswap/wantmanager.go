@@ -96,7 +96,7 @@ func (pm *WantManager) addEntries(ctx context.Context, ks []cid.Cid, targets []p
 	for i, k := range ks {
 		entries = append(entries, &bsmsg.Entry{
 			Cancel: cancel,
-			Entry:  wantlist.NewRefEntry(k, kMaxPriority-i),
+			Entry:  wantlist.NewRefEntry(k, wantPriority(ctx, i)),
 		})
 	}
 	select {
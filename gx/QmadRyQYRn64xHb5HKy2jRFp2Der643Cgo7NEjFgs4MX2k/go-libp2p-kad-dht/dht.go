@@ -273,6 +273,11 @@ func (dht *IpfsDHT) Update(ctx context.Context, p peer.ID) {
 	dht.routingTable.Update(p)
 }
 
+// RoutingTable returns the routing table of the dht.
+func (dht *IpfsDHT) RoutingTable() *kb.RoutingTable {
+	return dht.routingTable
+}
+
 // FindLocal looks for a peer with a given ID connected to this dht and returns the peer and the table it was found in.
 func (dht *IpfsDHT) FindLocal(id peer.ID) pstore.PeerInfo {
 	switch dht.host.Network().Connectedness(id) {
@@ -0,0 +1,40 @@
+package config
+
+// Bitswap configures how bitswap shares the bandwidth between peers. Rates
+// are in bytes per second, e.g. "1MB", and an empty rate means no limit.
+type Bitswap struct {
+	// SendRate and RecvRate bound the bandwidth used with all peers.
+	SendRate string `json:",omitempty"`
+	RecvRate string `json:",omitempty"`
+
+	// PeerSendRate and PeerRecvRate bound the bandwidth used with each
+	// peer missing from the Classes.
+	PeerSendRate string `json:",omitempty"`
+	PeerRecvRate string `json:",omitempty"`
+
+	// Classes group peers sharing a priority and per-peer rates.
+	Classes []BitswapPeerClass `json:",omitempty"`
+
+	// Strategy decides which peers of a class are served first: "nice",
+	// the default, serves all peers alike while "debt-ratio" serves last
+	// the peers taking more than they give.
+	Strategy string `json:",omitempty"`
+
+	// DebtRatioGrace is the data a peer may take before the debt ratio
+	// strategy deprioritises it, e.g. "1MB" (the default).
+	DebtRatioGrace string `json:",omitempty"`
+}
+
+// BitswapPeerClass is a group of peers sharing a priority and per-peer rates
+type BitswapPeerClass struct {
+	Name  string
+	Peers []string
+
+	// Priority orders the classes: the requests of the peers of a class
+	// with a higher priority are served first. Peers missing from the
+	// classes have priority 0.
+	Priority int `json:",omitempty"`
+
+	SendRate string `json:",omitempty"`
+	RecvRate string `json:",omitempty"`
+}
@@ -26,6 +26,11 @@ type Config struct {
 	API       API       // local node's API settings
 	Swarm     SwarmConfig
 	Pubsub    PubsubConfig
+	P2P       P2P
+	Urlstore  Urlstore
+	Bitswap   Bitswap
+	DNS       DNS
+	Peering   Peering // peers the node stays connected to
 
 	Reprovider   Reprovider
 	Experimental Experiments
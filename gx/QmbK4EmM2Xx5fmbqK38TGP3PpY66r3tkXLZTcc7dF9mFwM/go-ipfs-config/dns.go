@@ -0,0 +1,19 @@
+package config
+
+// DNS configures how DNS names, such as DNSLink domains, are resolved.
+type DNS struct {
+	// Resolvers maps domain suffixes, e.g. "eth" or "example.com", to the
+	// resolver of the names under them: "system" for the resolver of the
+	// system, "udp://host[:port]" or "tcp://host[:port]" for a DNS server,
+	// or an https URL for a DNS-over-HTTPS endpoint. The "." suffix sets the
+	// resolver of the other names, the system one by default.
+	Resolvers map[string]string `json:",omitempty"`
+
+	// CacheSize is the number of answers cached for their TTL, 256 by
+	// default. A negative size disables the cache.
+	CacheSize int `json:",omitempty"`
+
+	// MaxCacheTTL caps how long an answer is cached, e.g. "5m". No cap by
+	// default.
+	MaxCacheTTL string `json:",omitempty"`
+}
@@ -2,6 +2,7 @@ package config
 
 type Experiments struct {
 	FilestoreEnabled     bool
+	FilestoreWatch       bool
 	UrlstoreEnabled      bool
 	ShardingEnabled      bool
 	Libp2pStreamMounting bool
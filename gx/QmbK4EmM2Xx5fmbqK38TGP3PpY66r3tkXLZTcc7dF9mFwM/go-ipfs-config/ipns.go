@@ -4,5 +4,13 @@ type Ipns struct {
 	RepublishPeriod string
 	RecordLifetime  string
 
+	// RepublishKeys overrides RepublishPeriod for the keys it names, e.g.
+	// {"self": "1h"}.
+	RepublishKeys map[string]string `json:",omitempty"`
+
+	// RepublishExclude lists the names of the keys whose records aren't
+	// republished.
+	RepublishExclude []string `json:",omitempty"`
+
 	ResolveCacheSize int
 }
@@ -0,0 +1,28 @@
+package config
+
+// P2P holds the libp2p stream mounting listeners and forwards that are
+// restored when the node starts
+type P2P struct {
+	Listeners []P2PListener
+	Forwards  []P2PForward
+}
+
+// P2PListener is a persisted 'ipfs p2p listen'
+type P2PListener struct {
+	Protocol      string
+	TargetAddress string
+
+	AllowedPeers []string `json:",omitempty"`
+	MaxStreams   int      `json:",omitempty"`
+	MaxBytes     int64    `json:",omitempty"`
+}
+
+// P2PForward is a persisted 'ipfs p2p forward'
+type P2PForward struct {
+	Protocol      string
+	ListenAddress string
+	TargetAddress string
+
+	MaxStreams int   `json:",omitempty"`
+	MaxBytes   int64 `json:",omitempty"`
+}
@@ -0,0 +1,9 @@
+package config
+
+// Peering configures the peers the node stays connected to, whatever the
+// number of its connections.
+type Peering struct {
+	// Peers are the multiaddrs of the peers, ending with /ipfs/<peer ID>. A
+	// peer can be listed with several addresses.
+	Peers []string `json:",omitempty"`
+}
@@ -103,12 +103,10 @@ badger datastore.
 
 If you apply this profile after ipfs init, you will need
 to convert your datastore to the new configuration.
-You can do this using ipfs-ds-convert.
+You can do this using 'ipfs repo migrate-datastore'.
 
-For more on ipfs-ds-convert see
-$ ipfs-ds-convert --help
-and
-$ ipfs-ds-convert convert --help
+For more on datastore migrations see
+$ ipfs repo migrate-datastore --help
 
 WARNING: badger datastore is experimental.
 Make sure to backup your data frequently.`,
@@ -131,12 +129,10 @@ Make sure to backup your data frequently.`,
 
 If you apply this profile after ipfs init, you will need
 to convert your datastore to the new configuration.
-You can do this using ipfs-ds-convert.
+You can do this using 'ipfs repo migrate-datastore'.
 
-For more on ipfs-ds-convert see
-$ ipfs-ds-convert --help
-and
-$ ipfs-ds-convert convert --help
+For more on datastore migrations see
+$ ipfs repo migrate-datastore --help
 `,
 
 		Transform: func(c *Config) error {
@@ -8,6 +8,21 @@ type SwarmConfig struct {
 	EnableRelayHop          bool
 
 	ConnMgr ConnMgr
+
+	// ConnGater lists the peers and addresses allowed and denied to connect
+	ConnGater ConnGater
+
+	// RelayService makes the node a relay limited to the peers holding a
+	// reservation, and RelayReservations lists the relays, as multiaddrs
+	// ending with their peer ID, the node keeps a reservation with
+	RelayService      RelayService
+	RelayReservations []string `json:",omitempty"`
+
+	// AutoNAT configures the reachability probes, where peers dial the node
+	// back, and DisableHolePunching stops the node from upgrading its
+	// relayed connections to direct ones through the NAT
+	AutoNAT             AutoNAT
+	DisableHolePunching bool `json:",omitempty"`
 }
 
 // ConnMgr defines configuration options for the libp2p connection manager
@@ -16,4 +31,51 @@ type ConnMgr struct {
 	LowWater    int
 	HighWater   int
 	GracePeriod string
+
+	// The limits of the "resource" connection manager, besides the water
+	// marks, zero for no limit: the open streams, in total and by protocol,
+	// the connections from a /24 IPv4 or /48 IPv6 subnet, and the memory
+	// counted for the connections and streams, e.g. "256MB"
+	MaxStreams            int            `json:",omitempty"`
+	MaxStreamsPerProtocol map[string]int `json:",omitempty"`
+	MaxConnsPerSubnet     int            `json:",omitempty"`
+	MemoryBudget          string         `json:",omitempty"`
+}
+
+// ConnGater lists the peer IDs and the IP ranges, in CIDR notation, allowed
+// and denied to connect, in either direction. A connection is refused if its
+// peer or its remote address is denied. Otherwise, as long as something is
+// allowed, it is refused unless its peer or its remote address is allowed.
+type ConnGater struct {
+	Allow []string `json:",omitempty"`
+	Deny  []string `json:",omitempty"`
+}
+
+// RelayService configures the limited relay: it relays the circuits to the
+// peers holding a reservation, for a limited time and data. The durations
+// and sizes are strings, e.g. "2m" and "128KiB", and the empty strings and
+// zeros stand for the defaults.
+type RelayService struct {
+	Enabled bool
+
+	// ReservationTTL is how long a reservation lasts before it is renewed,
+	// MaxReservations the number of peers holding one, and MaxCircuits the
+	// number of circuits open at once to a peer holding one
+	ReservationTTL  string `json:",omitempty"`
+	MaxReservations int    `json:",omitempty"`
+	MaxCircuits     int    `json:",omitempty"`
+
+	// CircuitDuration and CircuitData limit how long a circuit stays open
+	// and the data it relays, in both directions
+	CircuitDuration string `json:",omitempty"`
+	CircuitData     string `json:",omitempty"`
+}
+
+// AutoNAT configures the reachability service: the node asks its peers to dial
+// it back to tell whether it is reachable from the internet, and dials back
+// the peers asking, unless DisableService is set. Interval is the time between
+// the probes, e.g. "15m", the empty string standing for the default.
+type AutoNAT struct {
+	DisableService bool   `json:",omitempty"`
+	Interval       string `json:",omitempty"`
 }
@@ -0,0 +1,30 @@
+package config
+
+// Urlstore configures how the urlstore fetches the data of its blocks
+type Urlstore struct {
+	// Credentials are added to the requests for URLs starting with their
+	// Prefix. The longest matching prefix is used.
+	Credentials []UrlstoreCredential `json:",omitempty"`
+
+	// MaxConnsPerHost bounds the connections kept to a single host.
+	MaxConnsPerHost int `json:",omitempty"`
+
+	// Retries is the number of times a failed request is retried before
+	// moving on to the next mirror, the default if nil. Zero disables the
+	// retries.
+	Retries *int `json:",omitempty"`
+
+	// Timeout bounds the time a request takes to be answered with its
+	// headers, e.g. "30s". The download of the body is not bounded.
+	Timeout string `json:",omitempty"`
+}
+
+// UrlstoreCredential authenticates the requests to a URL prefix
+type UrlstoreCredential struct {
+	Prefix string
+
+	BearerToken string            `json:",omitempty"`
+	Username    string            `json:",omitempty"`
+	Password    string            `json:",omitempty"`
+	Headers     map[string]string `json:",omitempty"`
+}
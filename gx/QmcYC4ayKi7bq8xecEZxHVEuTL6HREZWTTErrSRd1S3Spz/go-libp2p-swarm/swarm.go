@@ -333,14 +333,15 @@ func (s *Swarm) ConnsToPeer(p peer.ID) []inet.Conn {
 
 // bestConnToPeer returns the best connection to peer.
 func (s *Swarm) bestConnToPeer(p peer.ID) *Conn {
-	// Selects the best connection we have to the peer.
-	// TODO: Prefer some transports over others. Currently, we just select
-	// the newest non-closed connection with the most streams.
+	// Selects the best connection we have to the peer: a direct connection
+	// over one through a proxy, like a relay, and then the newest non-closed
+	// connection with the most streams.
 	s.conns.RLock()
 	defer s.conns.RUnlock()
 
 	var best *Conn
 	bestLen := 0
+	bestProxied := false
 	for _, c := range s.conns.m[p] {
 		if c.conn.IsClosed() {
 			// We *will* garbage collect this soon anyways.
@@ -350,9 +351,14 @@ func (s *Swarm) bestConnToPeer(p peer.ID) *Conn {
 		cLen := len(c.streams.m)
 		c.streams.Unlock()
 
-		if cLen >= bestLen {
+		proxied := c.conn.Transport().Proxy()
+		if best != nil && proxied && !bestProxied {
+			continue
+		}
+		if best == nil || (bestProxied && !proxied) || cLen >= bestLen {
 			best = c
 			bestLen = cLen
+			bestProxied = proxied
 		}
 
 	}
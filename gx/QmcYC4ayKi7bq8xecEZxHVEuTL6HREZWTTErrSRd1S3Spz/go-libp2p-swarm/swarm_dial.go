@@ -220,6 +220,33 @@ func (s *Swarm) dialPeer(ctx context.Context, p peer.ID) (*Conn, error) {
 	return conn, err
 }
 
+// DialAddrs dials the peer at the given addresses, even if the swarm is
+// connected to it already, e.g. through a relay, and adds the connection to
+// the swarm. It upgrades a connection relayed to a direct one.
+func (s *Swarm) DialAddrs(ctx context.Context, p peer.ID, addrs []ma.Multiaddr) (inet.Conn, error) {
+	if p == s.local {
+		return nil, ErrDialToSelf
+	}
+
+	goodAddrs := s.filterKnownUndialables(addrs)
+	goodAddrsChan := make(chan ma.Multiaddr, len(goodAddrs))
+	for _, a := range goodAddrs {
+		goodAddrsChan <- a
+	}
+	close(goodAddrsChan)
+
+	connC, err := s.dialAddrs(ctx, p, goodAddrsChan)
+	if err != nil {
+		return nil, err
+	}
+	swarmC, err := s.addConn(connC, inet.DirOutbound)
+	if err != nil {
+		connC.Close()
+		return nil, err
+	}
+	return swarmC, nil
+}
+
 // doDial is an ugly shim method to retain all the logging and backoff logic
 // of the old dialsync code
 func (s *Swarm) doDial(ctx context.Context, p peer.ID) (*Conn, error) {
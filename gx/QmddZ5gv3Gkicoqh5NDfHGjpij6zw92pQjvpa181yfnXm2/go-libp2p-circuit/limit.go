@@ -0,0 +1,88 @@
+package relay
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+)
+
+// ErrCircuitLimit is the error of the copy of a circuit reset over its limit
+var ErrCircuitLimit = errors.New("relay circuit limit reached")
+
+// hopLimit enforces the limits of a circuit admitted by the limiter
+type hopLimit struct {
+	circ    HopCircuit
+	data    int64
+	relayed int64
+	timer   *time.Timer
+
+	once sync.Once
+}
+
+func newHopLimit(circ HopCircuit, s, bs inet.Stream) *hopLimit {
+	d, data := circ.Limit()
+	l := &hopLimit{circ: circ, data: data}
+	if d > 0 {
+		l.timer = time.AfterFunc(d, func() {
+			log.Debugf("relay circuit open for %s, closing", d)
+			s.Reset()
+			bs.Reset()
+		})
+	}
+	return l
+}
+
+// copy copies from src to dst, up to the data limit of the circuit, shared by
+// both directions, which can be exceeded by a buffer as they copy
+// concurrently. A nil hopLimit copies without limit.
+func (l *hopLimit) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if l == nil {
+		return io.Copy(dst, src)
+	}
+
+	buf := make([]byte, 4096)
+	var count int64
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if l.data > 0 {
+				left := l.data - atomic.LoadInt64(&l.relayed)
+				if left <= 0 {
+					return count, ErrCircuitLimit
+				}
+				if int64(n) > left {
+					n = int(left)
+				}
+			}
+			w, werr := dst.Write(buf[:n])
+			count += int64(w)
+			atomic.AddInt64(&l.relayed, int64(w))
+			l.circ.Relayed(int64(w))
+			if werr != nil {
+				return count, werr
+			}
+			if l.data > 0 && atomic.LoadInt64(&l.relayed) >= l.data {
+				return count, ErrCircuitLimit
+			}
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+func (l *hopLimit) close() {
+	l.once.Do(func() {
+		if l.timer != nil {
+			l.timer.Stop()
+		}
+		l.circ.Close()
+	})
+}
@@ -3,7 +3,6 @@ package relay
 import (
 	"context"
 	"fmt"
-	"io"
 	"sync"
 	"time"
 
@@ -45,6 +44,28 @@ type Relay struct {
 	liveHops map[peer.ID]map[peer.ID]int
 	lhCount  uint64
 	lhLk     sync.Mutex
+
+	limiter HopLimiter
+}
+
+// HopLimiter admits and limits the circuits of a hop relay.
+type HopLimiter interface {
+	// OpenCircuit admits a circuit from src to dst, or refuses it with an
+	// error.
+	OpenCircuit(src, dst peer.ID) (HopCircuit, error)
+}
+
+// HopCircuit is a circuit admitted by a HopLimiter.
+type HopCircuit interface {
+	// Limit returns how long the circuit may stay open and how many bytes
+	// it may relay, in both directions, zero for no limit.
+	Limit() (time.Duration, int64)
+
+	// Relayed counts n more bytes relayed.
+	Relayed(n int64)
+
+	// Close is called once the circuit is closed.
+	Close()
 }
 
 type RelayOpt int
@@ -96,6 +117,12 @@ func NewRelay(ctx context.Context, h host.Host, upgrader *tptu.Upgrader, opts ..
 	return r, nil
 }
 
+// SetHopLimiter makes l admit and limit the circuits relayed as a hop. It is
+// to be set before the relay starts relaying.
+func (r *Relay) SetHopLimiter(l HopLimiter) {
+	r.limiter = l
+}
+
 func (r *Relay) addLiveHop(from, to peer.ID) {
 	r.lhLk.Lock()
 	defer r.lhLk.Unlock()
@@ -293,6 +320,22 @@ func (r *Relay) handleHopStream(s inet.Stream, msg *pb.CircuitRelay) {
 		return
 	}
 
+	var circ HopCircuit
+	if r.limiter != nil {
+		circ, err = r.limiter.OpenCircuit(src.ID, dst.ID)
+		if err != nil {
+			log.Debugf("refusing to relay from %s to %s: %s", src.ID.Pretty(), dst.ID.Pretty(), err)
+			r.handleError(s, pb.CircuitRelay_HOP_CANT_SPEAK_RELAY)
+			return
+		}
+		// closed here until the circuit is relaying
+		defer func() {
+			if circ != nil {
+				circ.Close()
+			}
+		}()
+	}
+
 	if len(dst.Addrs) > 0 {
 		r.host.Peerstore().AddAddrs(dst.ID, dst.Addrs, pstore.TempAddrTTL)
 	}
@@ -358,13 +401,33 @@ func (r *Relay) handleHopStream(s inet.Stream, msg *pb.CircuitRelay) {
 
 	r.addLiveHop(src.ID, dst.ID)
 
+	var lim *hopLimit
+	if circ != nil {
+		lim = newHopLimit(circ, s, bs)
+		circ = nil
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		wg.Wait()
+		r.rmLiveHop(src.ID, dst.ID)
+		if lim != nil {
+			lim.close()
+		}
+	}()
+
 	// Don't reset streams after finishing or the other side will get an
 	// error, not an EOF.
 	go func() {
-		defer r.rmLiveHop(src.ID, dst.ID)
+		defer wg.Done()
 
-		count, err := io.Copy(s, bs)
-		if err != nil {
+		count, err := lim.copy(s, bs)
+		if err == ErrCircuitLimit {
+			// close both, for the data relayed to reach the peers
+			log.Debugf("closing relay circuit: %s", err)
+			s.Close()
+			bs.Close()
+		} else if err != nil {
 			log.Debugf("relay copy error: %s", err)
 			// Reset both.
 			s.Reset()
@@ -377,8 +440,15 @@ func (r *Relay) handleHopStream(s inet.Stream, msg *pb.CircuitRelay) {
 	}()
 
 	go func() {
-		count, err := io.Copy(bs, s)
-		if err != nil {
+		defer wg.Done()
+
+		count, err := lim.copy(bs, s)
+		if err == ErrCircuitLimit {
+			// close both, for the data relayed to reach the peers
+			log.Debugf("closing relay circuit: %s", err)
+			s.Close()
+			bs.Close()
+		} else if err != nil {
 			log.Debugf("relay copy error: %s", err)
 			// Reset both.
 			bs.Reset()
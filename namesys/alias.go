@@ -0,0 +1,193 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	opts "mbfs/go-mbfs/namesys/opts"
+
+	path "mbfs/go-mbfs/gx/QmRG3XuGwT7GYuAqgWDJBKTzdaHMwAnc1x7J2KHEXNHxzG/go-path"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dsq "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/query"
+)
+
+// AliasPrefix marks the alias names in /ipns/ paths, e.g. /ipns/@team/dataset
+const AliasPrefix = "@"
+
+// aliasPrefix is where the aliases are kept in the datastore, by name
+var aliasPrefix = ds.NewKey("/aliases")
+
+var validAlias = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ErrNoAlias is returned for a name without alias
+var ErrNoAlias = errors.New("no such alias")
+
+// AliasStore keeps local aliases, friendly names for paths such as CIDs,
+// IPNS names or other aliases, in a datastore
+type AliasStore struct {
+	ds ds.Datastore
+}
+
+// NewAliasStore constructs an alias store keeping its aliases in d
+func NewAliasStore(d ds.Datastore) *AliasStore {
+	return &AliasStore{ds: d}
+}
+
+// AliasName returns the name of an alias without its @ prefix, or an error
+// if it isn't a valid alias name
+func AliasName(name string) (string, error) {
+	name = strings.TrimPrefix(name, AliasPrefix)
+	if !validAlias.MatchString(name) {
+		return "", fmt.Errorf("invalid alias name %q: expected letters, digits, '.', '_' and '-'", name)
+	}
+	return name, nil
+}
+
+// AliasTarget parses the target of an alias: a path, a CID, or the name of
+// another alias starting with @
+func AliasTarget(s string) (path.Path, error) {
+	if strings.HasPrefix(s, AliasPrefix) {
+		name, err := AliasName(s)
+		if err != nil {
+			return "", err
+		}
+		return path.FromString(ipnsPrefix + AliasPrefix + name), nil
+	}
+	return path.ParsePath(s)
+}
+
+// aliasOf returns the name of the alias p is the /ipns/ path of, if any
+func aliasOf(p path.Path) (string, bool) {
+	segs := p.Segments()
+	if len(segs) < 2 || segs[0] != "ipns" || !strings.HasPrefix(segs[1], AliasPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(segs[1], AliasPrefix), true
+}
+
+func aliasKey(name string) ds.Key {
+	return aliasPrefix.ChildString(name)
+}
+
+// Get returns the target of the alias name
+func (s *AliasStore) Get(name string) (path.Path, error) {
+	name, err := AliasName(name)
+	if err != nil {
+		return "", err
+	}
+	v, err := s.ds.Get(aliasKey(name))
+	if err == ds.ErrNotFound {
+		return "", ErrNoAlias
+	}
+	if err != nil {
+		return "", err
+	}
+	return path.Path(v), nil
+}
+
+// Set makes name an alias for target, replacing its previous target. An
+// alias can't lead back to itself through other aliases.
+func (s *AliasStore) Set(name string, target path.Path) error {
+	name, err := AliasName(name)
+	if err != nil {
+		return err
+	}
+	if _, err := path.ParsePath(target.String()); err != nil {
+		return err
+	}
+
+	// follow the aliases the target leads to, looking for a cycle
+	seen := map[string]bool{name: true}
+	for p := target; ; {
+		next, ok := aliasOf(p)
+		if !ok {
+			break
+		}
+		if seen[next] {
+			return fmt.Errorf("alias %s would lead back to itself through @%s", name, next)
+		}
+		seen[next] = true
+		p, err = s.Get(next)
+		if err == ErrNoAlias {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.ds.Put(aliasKey(name), []byte(target))
+}
+
+// Remove removes the alias name
+func (s *AliasStore) Remove(name string) error {
+	name, err := AliasName(name)
+	if err != nil {
+		return err
+	}
+	has, err := s.ds.Has(aliasKey(name))
+	if err != nil {
+		return err
+	}
+	if !has {
+		return ErrNoAlias
+	}
+	return s.ds.Delete(aliasKey(name))
+}
+
+// List returns all the aliases and their targets
+func (s *AliasStore) List() (map[string]path.Path, error) {
+	res, err := s.ds.Query(dsq.Query{Prefix: aliasPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	aliases := make(map[string]path.Path)
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		aliases[ds.RawKey(r.Key).BaseNamespace()] = path.Path(r.Value)
+	}
+	return aliases, nil
+}
+
+// AliasResolver implements a Resolver on the local aliases
+type AliasResolver struct {
+	store *AliasStore
+}
+
+// NewAliasResolver constructs a name resolver using the aliases of store.
+func NewAliasResolver(store *AliasStore) *AliasResolver {
+	return &AliasResolver{store: store}
+}
+
+// Resolve implements Resolver.
+func (r *AliasResolver) Resolve(ctx context.Context, name string, options ...opts.ResolveOpt) (path.Path, error) {
+	return resolve(ctx, r, name, opts.ProcessOpts(options))
+}
+
+// ResolveAsync implements Resolver.
+func (r *AliasResolver) ResolveAsync(ctx context.Context, name string, options ...opts.ResolveOpt) <-chan Result {
+	return resolveAsync(ctx, r, name, opts.ProcessOpts(options))
+}
+
+// resolveOnceAsync implements resolver. Looks the alias up in the store.
+func (r *AliasResolver) resolveOnceAsync(ctx context.Context, name string, options opts.ResolveOpts) <-chan onceResult {
+	out := make(chan onceResult, 1)
+	defer close(out)
+
+	name = strings.TrimPrefix(name, ipnsPrefix)
+	p, err := r.store.Get(name)
+	if err != nil {
+		out <- onceResult{err: fmt.Errorf("could not resolve %s: %s", name, err)}
+		return out
+	}
+	// Return a 0 TTL so that changes to the alias show up at once.
+	out <- onceResult{value: p}
+	return out
+}
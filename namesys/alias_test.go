@@ -0,0 +1,99 @@
+package namesys
+
+import (
+	"context"
+	"testing"
+
+	offroute "mbfs/go-mbfs/gx/QmNuVissmH2ftUd4ADvhm9WER3351wTYduY1EeDDGtP1tM/go-ipfs-routing/offline"
+	path "mbfs/go-mbfs/gx/QmRG3XuGwT7GYuAqgWDJBKTzdaHMwAnc1x7J2KHEXNHxzG/go-path"
+	pstoremem "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore/pstoremem"
+	ipns "mbfs/go-mbfs/gx/QmZMJfrt7fU33oFQ9WvWnovhiiZ8T6qkWkFXNCFreJTzgT/go-ipns"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dssync "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/sync"
+)
+
+func TestAliasStore(t *testing.T) {
+	store := NewAliasStore(dssync.MutexWrap(ds.NewMapDatastore()))
+	target := path.FromString("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+
+	for _, name := range []string{"", "@", "a/b", "-x", "a b"} {
+		if err := store.Set(name, target); err == nil {
+			t.Errorf("expected alias name %q to be rejected", name)
+		}
+	}
+
+	if err := store.Set("team", target); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("@latest", path.FromString("/ipns/@team")); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := store.Get("@team"); err != nil || p != target {
+		t.Fatalf("expected %s, got %s (%v)", target, p, err)
+	}
+
+	// team -> latest -> team
+	if err := store.Set("team", path.FromString("/ipns/@latest")); err == nil {
+		t.Fatal("expected the alias cycle to be rejected")
+	}
+	if err := store.Set("self-loop", path.FromString("/ipns/@self-loop/a")); err == nil {
+		t.Fatal("expected the alias to itself to be rejected")
+	}
+
+	aliases, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 2 || aliases["team"] != target || aliases["latest"] != "/ipns/@team" {
+		t.Fatalf("unexpected aliases %v", aliases)
+	}
+
+	if err := store.Remove("latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove("latest"); err != ErrNoAlias {
+		t.Fatalf("expected ErrNoAlias, got %v", err)
+	}
+	if _, err := store.Get("latest"); err != ErrNoAlias {
+		t.Fatalf("expected ErrNoAlias, got %v", err)
+	}
+}
+
+func TestAliasResolution(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := offroute.NewOfflineRouter(dstore, ipns.Validator{KeyBook: pstoremem.NewPeerstore()})
+	ns := NewNameSystem(r, dstore, 128)
+
+	store := NewAliasStore(dstore)
+	if err := store.Set("team", path.FromString("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn/data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("latest", path.FromString("/ipns/@team")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	p, err := ns.Resolve(ctx, "/ipns/@latest/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn/data/sub" {
+		t.Fatalf("unexpected resolution %s", p)
+	}
+
+	// changes show up at once, without waiting for the cache
+	if err := store.Set("team", path.FromString("/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD")); err != nil {
+		t.Fatal(err)
+	}
+	p, err = ns.Resolve(ctx, "/ipns/@latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD" {
+		t.Fatalf("unexpected resolution %s", p)
+	}
+
+	if _, err := ns.Resolve(ctx, "/ipns/@missing"); err == nil {
+		t.Fatal("expected an unknown alias to fail")
+	}
+}
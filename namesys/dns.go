@@ -3,27 +3,89 @@ package namesys
 import (
 	"context"
 	"errors"
-	"net"
 	"strings"
+	"time"
 
 	opts "mbfs/go-mbfs/namesys/opts"
 
+	lru "mbfs/go-mbfs/gx/QmQjMHF8ptRgx4E57UFMiT4YM6kqaJeYxZ1MCDX23aw4rK/golang-lru"
 	path "mbfs/go-mbfs/gx/QmRG3XuGwT7GYuAqgWDJBKTzdaHMwAnc1x7J2KHEXNHxzG/go-path"
 	isd "mbfs/go-mbfs/gx/QmZmmuAXgX73UQmX1jRKjTGmjzq24Jinqkq8vzkBtno4uX/go-is-domain"
 )
 
 type LookupTXTFunc func(name string) (txt []string, err error)
 
+// DefaultDNSCacheTTL is how long the answers without a TTL, such as those of
+// the system resolver, are cached
+const DefaultDNSCacheTTL = time.Minute
+
 // DNSResolver implements a Resolver on DNS domains
 type DNSResolver struct {
-	lookupTXT LookupTXTFunc
-	// TODO: maybe some sort of caching?
-	// cache would need a timeout
+	lookup TXTLookup
+
+	// cache holds the answers of lookup until their TTL runs out, capped
+	// to maxTTL
+	cache  *lru.Cache
+	maxTTL time.Duration
 }
 
 // NewDNSResolver constructs a name resolver using DNS TXT records.
 func NewDNSResolver() *DNSResolver {
-	return &DNSResolver{lookupTXT: net.LookupTXT}
+	return &DNSResolver{lookup: SystemLookup}
+}
+
+// NewCachedDNSResolver constructs a name resolver using the TXT records given
+// by lookup, caching up to cacheSize answers for their TTL, capped to maxTTL
+// when it is positive.
+func NewCachedDNSResolver(lookup TXTLookup, cacheSize int, maxTTL time.Duration) *DNSResolver {
+	r := &DNSResolver{lookup: lookup, maxTTL: maxTTL}
+	if cacheSize > 0 {
+		r.cache, _ = lru.New(cacheSize)
+	}
+	return r
+}
+
+// Uncached returns a resolver using the same lookup as r, without its cache
+func (r *DNSResolver) Uncached() *DNSResolver {
+	return &DNSResolver{lookup: r.lookup}
+}
+
+type cachedTXT struct {
+	txt []string
+	err error
+	eol time.Time
+}
+
+// lookupTXT looks up the TXT records of name, through the cache when there is
+// one, and returns how long they may be cached
+func (r *DNSResolver) lookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	if r.cache != nil {
+		if v, ok := r.cache.Get(name); ok {
+			c := v.(cachedTXT)
+			if ttl := time.Until(c.eol); ttl > 0 {
+				return c.txt, ttl, c.err
+			}
+			r.cache.Remove(name)
+		}
+	}
+
+	txt, ttl, err := r.lookup.LookupTXT(ctx, name)
+	if nerr, ok := err.(*NoTXTError); ok {
+		ttl = nerr.TTL
+	} else if err != nil {
+		// failures other than a missing record aren't cached
+		return nil, 0, err
+	}
+	if ttl <= 0 {
+		ttl = DefaultDNSCacheTTL
+	}
+	if r.maxTTL > 0 && ttl > r.maxTTL {
+		ttl = r.maxTTL
+	}
+	if r.cache != nil {
+		r.cache.Add(name, cachedTXT{txt: txt, err: err, eol: time.Now().Add(ttl)})
+	}
+	return txt, ttl, err
 }
 
 // Resolve implements Resolver.
@@ -38,6 +100,7 @@ func (r *DNSResolver) ResolveAsync(ctx context.Context, name string, options ...
 
 type lookupRes struct {
 	path  path.Path
+	ttl   time.Duration
 	error error
 }
 
@@ -57,10 +120,10 @@ func (r *DNSResolver) resolveOnceAsync(ctx context.Context, name string, options
 	log.Debugf("DNSResolver resolving %s", domain)
 
 	rootChan := make(chan lookupRes, 1)
-	go workDomain(r, domain, rootChan)
+	go workDomain(ctx, r, domain, rootChan)
 
 	subChan := make(chan lookupRes, 1)
-	go workDomain(r, "_dnslink."+domain, subChan)
+	go workDomain(ctx, r, "_dnslink."+domain, subChan)
 
 	appendPath := func(p path.Path) (path.Path, error) {
 		if len(segments) > 1 {
@@ -80,7 +143,7 @@ func (r *DNSResolver) resolveOnceAsync(ctx context.Context, name string, options
 				}
 				if subRes.error == nil {
 					p, err := appendPath(subRes.path)
-					emitOnceResult(ctx, out, onceResult{value: p, err: err})
+					emitOnceResult(ctx, out, onceResult{value: p, ttl: subRes.ttl, err: err})
 					return
 				}
 			case rootRes, ok := <-rootChan:
@@ -90,7 +153,7 @@ func (r *DNSResolver) resolveOnceAsync(ctx context.Context, name string, options
 				}
 				if rootRes.error == nil {
 					p, err := appendPath(rootRes.path)
-					emitOnceResult(ctx, out, onceResult{value: p, err: err})
+					emitOnceResult(ctx, out, onceResult{value: p, ttl: rootRes.ttl, err: err})
 				}
 			case <-ctx.Done():
 				return
@@ -104,24 +167,24 @@ func (r *DNSResolver) resolveOnceAsync(ctx context.Context, name string, options
 	return out
 }
 
-func workDomain(r *DNSResolver, name string, res chan lookupRes) {
+func workDomain(ctx context.Context, r *DNSResolver, name string, res chan lookupRes) {
 	defer close(res)
 
-	txt, err := r.lookupTXT(name)
+	txt, ttl, err := r.lookupTXT(ctx, name)
 	if err != nil {
 		// Error is != nil
-		res <- lookupRes{"", err}
+		res <- lookupRes{"", 0, err}
 		return
 	}
 
 	for _, t := range txt {
 		p, err := parseEntry(t)
 		if err == nil {
-			res <- lookupRes{p, nil}
+			res <- lookupRes{p, ttl, nil}
 			return
 		}
 	}
-	res <- lookupRes{"", ErrResolveFailed}
+	res <- lookupRes{"", 0, ErrResolveFailed}
 }
 
 func parseEntry(txt string) (path.Path, error) {
@@ -129,7 +129,7 @@ func newMockDNS() *mockDNS {
 
 func TestDNSResolution(t *testing.T) {
 	mock := newMockDNS()
-	r := &DNSResolver{lookupTXT: mock.lookupTXT}
+	r := &DNSResolver{lookup: LookupTXTFunc(mock.lookupTXT)}
 	testResolution(t, r, "multihash.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
 	testResolution(t, r, "ipfs.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
 	testResolution(t, r, "dipfs.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
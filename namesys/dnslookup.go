@@ -0,0 +1,179 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	dns "mbfs/go-mbfs/gx/QmV3bVtkAhSZqWncYGonUmsVcJcV6cpzWztsFwc3A9so5m/dns"
+)
+
+// TXTLookup looks up the TXT records of DNS names
+type TXTLookup interface {
+	// LookupTXT returns the TXT records of name and how long they may be
+	// cached, 0 when unknown. A name without TXT records gives a
+	// *NoTXTError.
+	LookupTXT(ctx context.Context, name string) (txt []string, ttl time.Duration, err error)
+}
+
+// NoTXTError is returned by a TXTLookup for a name without TXT records. TTL
+// is how long this answer may be cached, 0 when unknown.
+type NoTXTError struct {
+	Name string
+	TTL  time.Duration
+}
+
+func (e *NoTXTError) Error() string {
+	return fmt.Sprintf("no TXT record for %s", e.Name)
+}
+
+// LookupTXT implements TXTLookup, without TTLs
+func (f LookupTXTFunc) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	txt, err := f(name)
+	return txt, 0, err
+}
+
+// dnsTimeout bounds a query to a DNS server
+const dnsTimeout = 5 * time.Second
+
+type systemLookup struct{}
+
+// SystemLookup looks up names with the resolver of the system, which
+// doesn't give TTLs
+var SystemLookup TXTLookup = systemLookup{}
+
+func (systemLookup) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	txt, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return nil, 0, &NoTXTError{Name: name}
+	}
+	return txt, 0, err
+}
+
+// serverLookup sends the queries to a DNS server, over UDP (falling back to
+// TCP for truncated answers), TCP or HTTPS (RFC 8484)
+type serverLookup struct {
+	net  string
+	addr string
+}
+
+func (s *serverLookup) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.RecursionDesired = true
+
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	c := &dns.Client{Net: s.net}
+	r, _, err := c.ExchangeContext(ctx, m, s.addr)
+	if err == nil && r.Truncated && s.net == "udp" {
+		c = &dns.Client{Net: "tcp"}
+		r, _, err = c.ExchangeContext(ctx, m, s.addr)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch r.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, 0, &NoTXTError{Name: name, TTL: negativeTTL(r)}
+	default:
+		return nil, 0, fmt.Errorf("looking up %s: %s", name, dns.RcodeToString[r.Rcode])
+	}
+
+	var txt []string
+	var ttl uint32
+	for _, rr := range r.Answer {
+		// the CNAMEs leading to the records count for their TTL
+		if ttl == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+		if t, ok := rr.(*dns.TXT); ok {
+			txt = append(txt, strings.Join(t.Txt, ""))
+		}
+	}
+	if len(txt) == 0 {
+		return nil, 0, &NoTXTError{Name: name, TTL: negativeTTL(r)}
+	}
+	return txt, time.Duration(ttl) * time.Second, nil
+}
+
+// negativeTTL returns how long a negative answer may be cached, from the SOA
+// record of its authority section (RFC 2308)
+func negativeTTL(r *dns.Msg) time.Duration {
+	for _, rr := range r.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Hdr.Ttl
+			if soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+			return time.Duration(ttl) * time.Second
+		}
+	}
+	return 0
+}
+
+// ParseTXTLookup parses the description of a TXT lookup: "system" for the
+// resolver of the system, "udp://host[:port]" or "tcp://host[:port]" for a
+// DNS server, or an https URL for a DNS-over-HTTPS endpoint.
+func ParseTXTLookup(s string) (TXTLookup, error) {
+	if s == "system" {
+		return SystemLookup, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS resolver %q: %s", s, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" || u.Path != "" {
+			return nil, fmt.Errorf("invalid DNS resolver %q: expected %s://host[:port]", s, u.Scheme)
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "53")
+		}
+		return &serverLookup{net: u.Scheme, addr: addr}, nil
+	case "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid DNS resolver %q: missing host", s)
+		}
+		return &serverLookup{net: "https", addr: s}, nil
+	default:
+		return nil, fmt.Errorf("invalid DNS resolver %q: expected system, udp://, tcp:// or https://", s)
+	}
+}
+
+// SuffixLookup sends the lookup of a name to the TXTLookup of its longest
+// matching domain suffix, e.g. "eth" or "example.com", or to Default
+type SuffixLookup struct {
+	Default  TXTLookup
+	Suffixes map[string]TXTLookup
+}
+
+func (s *SuffixLookup) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	return s.lookupFor(name).LookupTXT(ctx, name)
+}
+
+func (s *SuffixLookup) lookupFor(name string) TXTLookup {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for {
+		if l, ok := s.Suffixes[name]; ok {
+			return l
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	if s.Default != nil {
+		return s.Default
+	}
+	return SystemLookup
+}
@@ -0,0 +1,157 @@
+package namesys
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	dns "mbfs/go-mbfs/gx/QmV3bVtkAhSZqWncYGonUmsVcJcV6cpzWztsFwc3A9so5m/dns"
+)
+
+// stubDNS is a DNS server answering TXT queries from a fixed zone
+type stubDNS struct {
+	mu      sync.Mutex
+	records map[string]string
+	queries int
+
+	addr string
+	srv  *dns.Server
+}
+
+func startStubDNS(t *testing.T, records map[string]string) *stubDNS {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubDNS{records: records, addr: pc.LocalAddr().String()}
+	s.srv = &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(s.serve)}
+	go s.srv.ActivateAndServe()
+	return s
+}
+
+func (s *stubDNS) serve(w dns.ResponseWriter, req *dns.Msg) {
+	s.mu.Lock()
+	s.queries++
+	txt, ok := s.records[req.Question[0].Name]
+	s.mu.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	if ok {
+		rr, _ := dns.NewRR(req.Question[0].Name + ` 300 IN TXT "` + txt + `"`)
+		m.Answer = append(m.Answer, rr)
+	} else {
+		m.Rcode = dns.RcodeNameError
+		soa, _ := dns.NewRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 7200 900 1209600 30")
+		m.Ns = append(m.Ns, soa)
+	}
+	w.WriteMsg(m)
+}
+
+func (s *stubDNS) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func TestServerLookup(t *testing.T) {
+	s := startStubDNS(t, map[string]string{
+		"_dnslink.example.com.": "dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
+	})
+	defer s.srv.Shutdown()
+
+	l, err := ParseTXTLookup("udp://" + s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	txt, ttl, err := l.LookupTXT(ctx, "_dnslink.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txt) != 1 || ttl != 300*time.Second {
+		t.Fatalf("unexpected answer %v with ttl %s", txt, ttl)
+	}
+
+	_, _, err = l.LookupTXT(ctx, "missing.example.com")
+	nerr, ok := err.(*NoTXTError)
+	if !ok {
+		t.Fatalf("expected a NoTXTError, got %v", err)
+	}
+	if nerr.TTL != 30*time.Second {
+		t.Fatalf("expected the negative ttl of the SOA, got %s", nerr.TTL)
+	}
+}
+
+func TestCachedDNSResolver(t *testing.T) {
+	s := startStubDNS(t, map[string]string{
+		"_dnslink.example.com.": "dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
+	})
+	defer s.srv.Shutdown()
+
+	l, err := ParseTXTLookup("udp://" + s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewCachedDNSResolver(l, 16, 0)
+	testResolution(t, r, "example.com", 1, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	// the root and _dnslink names were both looked up
+	if n := s.count(); n != 2 {
+		t.Fatalf("expected 2 queries, got %d", n)
+	}
+	testResolution(t, r, "example.com", 1, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	if n := s.count(); n != 2 {
+		t.Fatalf("expected cached answers, got %d queries", n)
+	}
+	testResolution(t, r.Uncached(), "example.com", 1, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	if n := s.count(); n != 4 {
+		t.Fatalf("expected uncached answers, got %d queries", n)
+	}
+
+	// answers expire after maxTTL
+	r = NewCachedDNSResolver(l, 16, 10*time.Millisecond)
+	testResolution(t, r, "example.com", 1, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	time.Sleep(20 * time.Millisecond)
+	testResolution(t, r, "example.com", 1, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	if n := s.count(); n != 8 {
+		t.Fatalf("expected the cached answers to expire, got %d queries", n)
+	}
+}
+
+func TestSuffixLookup(t *testing.T) {
+	eth := LookupTXTFunc(func(name string) ([]string, error) { return []string{"eth"}, nil })
+	other := LookupTXTFunc(func(name string) ([]string, error) { return []string{"other"}, nil })
+	l := &SuffixLookup{Default: other, Suffixes: map[string]TXTLookup{"eth": eth}}
+
+	for name, expected := range map[string]string{
+		"_dnslink.foo.eth":  "eth",
+		"foo.eth.":          "eth",
+		"FOO.ETH":           "eth",
+		"foo.ethereum":      "other",
+		"_dnslink.ipfs.io.": "other",
+	} {
+		txt, _, err := l.LookupTXT(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txt[0] != expected {
+			t.Fatalf("%s: expected the %s lookup, got %s", name, expected, txt[0])
+		}
+	}
+}
+
+func TestParseTXTLookup(t *testing.T) {
+	for _, s := range []string{"system", "udp://1.1.1.1", "tcp://[::1]:5353", "https://cloudflare-dns.com/dns-query"} {
+		if _, err := ParseTXTLookup(s); err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+	}
+	for _, s := range []string{"", "1.1.1.1", "udp://", "udp://1.1.1.1/path", "ftp://example.com", "https://"} {
+		if _, err := ParseTXTLookup(s); err == nil {
+			t.Fatalf("%q should be invalid", s)
+		}
+	}
+}
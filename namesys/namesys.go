@@ -24,30 +24,47 @@ import (
 // (a) IPFS routing naming: SFS-like PKI names.
 // (b) dns domains: resolves using links in DNS TXT records
 // (c) proquints: interprets string as the raw byte data.
+// (d) aliases: local names starting with @, kept in the datastore.
 //
 // It can only publish to: (a) IPFS routing naming.
 //
 type mpns struct {
-	dnsResolver, proquintResolver, ipnsResolver resolver
-	ipnsPublisher                               Publisher
+	dnsResolver, proquintResolver, ipnsResolver, aliasResolver resolver
+	ipnsPublisher                                              Publisher
 
 	cache *lru.Cache
 }
 
+// Option configures the naming system built by NewNameSystem
+type Option func(*mpns)
+
+// WithDNSResolver makes the naming system resolve domain names with r
+// instead of a resolver using the system DNS settings
+func WithDNSResolver(r *DNSResolver) Option {
+	return func(ns *mpns) {
+		ns.dnsResolver = r
+	}
+}
+
 // NewNameSystem will construct the IPFS naming system based on Routing
-func NewNameSystem(r routing.ValueStore, ds ds.Datastore, cachesize int) NameSystem {
+func NewNameSystem(r routing.ValueStore, ds ds.Datastore, cachesize int, options ...Option) NameSystem {
 	var cache *lru.Cache
 	if cachesize > 0 {
 		cache, _ = lru.New(cachesize)
 	}
 
-	return &mpns{
+	ns := &mpns{
 		dnsResolver:      NewDNSResolver(),
 		proquintResolver: new(ProquintResolver),
 		ipnsResolver:     NewIpnsResolver(r),
+		aliasResolver:    NewAliasResolver(NewAliasStore(ds)),
 		ipnsPublisher:    NewIpnsPublisher(r, ds),
 		cache:            cache,
 	}
+	for _, o := range options {
+		o(ns)
+	}
+	return ns
 }
 
 const DefaultResolverCacheTTL = time.Minute
@@ -116,10 +133,13 @@ func (ns *mpns) resolveOnceAsync(ctx context.Context, name string, options opts.
 	// Resolver selection:
 	// 1. if it is a multihash resolve through "ipns".
 	// 2. if it is a domain name, resolve through "dns"
-	// 3. otherwise resolve through the "proquint" resolver
+	// 3. if it starts with @, resolve through the local "alias" resolver
+	// 4. otherwise resolve through the "proquint" resolver
 
 	var res resolver
-	if _, err := mh.FromB58String(key); err == nil {
+	if strings.HasPrefix(key, AliasPrefix) {
+		res = ns.aliasResolver
+	} else if _, err := mh.FromB58String(key); err == nil {
 		res = ns.ipnsResolver
 	} else if isd.IsDomain(key) {
 		res = ns.dnsResolver
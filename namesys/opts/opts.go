@@ -1,6 +1,7 @@
 package nsopts
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -15,6 +16,31 @@ const (
 	UnlimitedDepth = 0
 )
 
+// ResolveStrategy is how IPNS records are looked up
+type ResolveStrategy int
+
+const (
+	// Sequential looks the records up through the routing system, which
+	// asks pubsub first and then the DHT.
+	Sequential ResolveStrategy = iota
+
+	// Parallel queries the local records, pubsub and the DHT at once,
+	// and returns the valid record with the highest sequence number once
+	// Quorum sources gave one or QuorumTimeout passed.
+	Parallel
+)
+
+func (s ResolveStrategy) String() string {
+	switch s {
+	case Sequential:
+		return "sequential"
+	case Parallel:
+		return "parallel"
+	default:
+		return fmt.Sprintf("ResolveStrategy(%d)", int(s))
+	}
+}
+
 // ResolveOpts specifies options for resolving an IPNS path
 type ResolveOpts struct {
 	// Recursion depth limit
@@ -27,6 +53,13 @@ type ResolveOpts struct {
 	// timeout (although there is an implicit timeout due to dial
 	// timeouts within the DHT)
 	DhtTimeout time.Duration
+	// How the records are looked up
+	Strategy ResolveStrategy
+	// The number of sources the Parallel strategy waits for a record from
+	Quorum uint
+	// The amount of time the Parallel strategy waits for the quorum before
+	// returning the best record found so far
+	QuorumTimeout time.Duration
 }
 
 // DefaultResolveOpts returns the default options for resolving
@@ -36,6 +69,9 @@ func DefaultResolveOpts() ResolveOpts {
 		Depth:          DefaultDepthLimit,
 		DhtRecordCount: 16,
 		DhtTimeout:     time.Minute,
+		Strategy:       Sequential,
+		Quorum:         2,
+		QuorumTimeout:  5 * time.Second,
 	}
 }
 
@@ -64,6 +100,29 @@ func DhtTimeout(timeout time.Duration) ResolveOpt {
 	}
 }
 
+// Strategy is how the records are looked up
+func Strategy(s ResolveStrategy) ResolveOpt {
+	return func(o *ResolveOpts) {
+		o.Strategy = s
+	}
+}
+
+// Quorum is the number of sources the Parallel strategy waits for a record
+// from
+func Quorum(quorum uint) ResolveOpt {
+	return func(o *ResolveOpts) {
+		o.Quorum = quorum
+	}
+}
+
+// QuorumTimeout is the amount of time the Parallel strategy waits for the
+// quorum before returning the best record found so far
+func QuorumTimeout(timeout time.Duration) ResolveOpt {
+	return func(o *ResolveOpts) {
+		o.QuorumTimeout = timeout
+	}
+}
+
 // ProcessOpts converts an array of ResolveOpt into a ResolveOpts object
 func ProcessOpts(opts []ResolveOpt) ResolveOpts {
 	rsopts := DefaultResolveOpts()
@@ -0,0 +1,163 @@
+package namesys
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	opts "mbfs/go-mbfs/namesys/opts"
+
+	routing "mbfs/go-mbfs/gx/QmYyg3UnyiQubxjs4uhKixPxR7eeKrhJ5Vyz6Et4Tet18B/go-libp2p-routing"
+	ipns "mbfs/go-mbfs/gx/QmZMJfrt7fU33oFQ9WvWnovhiiZ8T6qkWkFXNCFreJTzgT/go-ipns"
+	pb "mbfs/go-mbfs/gx/QmZMJfrt7fU33oFQ9WvWnovhiiZ8T6qkWkFXNCFreJTzgT/go-ipns/pb"
+	dht "mbfs/go-mbfs/gx/QmadRyQYRn64xHb5HKy2jRFp2Der643Cgo7NEjFgs4MX2k/go-libp2p-kad-dht"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+	proto "mbfs/go-mbfs/gx/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+// RecordSource is a routing system the Parallel strategy looks IPNS records
+// up from, e.g. the local records, pubsub or the DHT. The records are
+// validated by the source.
+type RecordSource struct {
+	Name  string
+	Store routing.ValueStore
+}
+
+// WithRecordSources sets the sources queried by the Parallel resolution
+// strategy, instead of the routing system alone
+func WithRecordSources(sources ...RecordSource) Option {
+	return func(ns *mpns) {
+		if r, ok := ns.ipnsResolver.(*IpnsResolver); ok {
+			r.sources = sources
+		}
+	}
+}
+
+// WithFastRouting sets where the records published with a SkipDHT context are
+// put, e.g. the local datastore and pubsub
+func WithFastRouting(r routing.ValueStore) Option {
+	return func(ns *mpns) {
+		if p, ok := ns.ipnsPublisher.(*IpnsPublisher); ok {
+			p.fast = r
+		}
+	}
+}
+
+type skipDHTKey struct{}
+
+// SkipDHT returns a context making the publishers put the records only to
+// their fast routing, skipping the slow DHT put. The DHT gets the records at
+// their next republication.
+func SkipDHT(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipDHTKey{}, true)
+}
+
+func skipsDHT(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipDHTKey{}).(bool)
+	return skip
+}
+
+// sourceRecord is a record given by a source
+type sourceRecord struct {
+	source int
+	entry  *pb.IpnsEntry
+}
+
+// resolveParallel queries all the sources of r at once for the record of
+// pid, and returns the one with the highest sequence number once
+// options.Quorum sources gave one, or options.QuorumTimeout passed and one
+// source did. It owns cancel, which cancels ctx.
+func (r *IpnsResolver) resolveParallel(ctx context.Context, cancel context.CancelFunc, pid peer.ID, options opts.ResolveOpts) <-chan onceResult {
+	out := make(chan onceResult, 1)
+
+	sources := r.sources
+	if len(sources) == 0 {
+		sources = []RecordSource{{Name: "routing", Store: r.routing}}
+	}
+	quorum := int(options.Quorum)
+	if quorum <= 0 || quorum > len(sources) {
+		quorum = len(sources)
+	}
+
+	key := ipns.RecordKey(pid)
+	records := make(chan sourceRecord)
+	var wg sync.WaitGroup
+	for i, s := range sources {
+		wg.Add(1)
+		go func(i int, s RecordSource) {
+			defer wg.Done()
+			vals, err := s.Store.SearchValue(ctx, key, dht.Quorum(int(options.DhtRecordCount)))
+			if err != nil {
+				log.Debugf("RoutingResolver: %s search for %s failed: %s", s.Name, pid, err)
+				return
+			}
+			for val := range vals {
+				entry := new(pb.IpnsEntry)
+				if err := proto.Unmarshal(val, entry); err != nil {
+					log.Debugf("RoutingResolver: could not unmarshal value from %s for %s: %s", s.Name, pid, err)
+					continue
+				}
+				select {
+				case records <- sourceRecord{source: i, entry: entry}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i, s)
+	}
+	go func() {
+		wg.Wait()
+		close(records)
+	}()
+
+	go func() {
+		defer cancel()
+		defer close(out)
+
+		var timeout <-chan time.Time
+		if options.QuorumTimeout > 0 {
+			timer := time.NewTimer(options.QuorumTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		var best *pb.IpnsEntry
+		answered := make(map[int]bool)
+		timedOut := false
+		for {
+			select {
+			case rec, ok := <-records:
+				if !ok {
+					if best != nil {
+						out <- entryResult(best)
+					}
+					return
+				}
+				answered[rec.source] = true
+				if best == nil {
+					best = rec.entry
+				} else if c, err := ipns.Compare(rec.entry, best); err == nil && c > 0 {
+					best = rec.entry
+				}
+				if len(answered) >= quorum || timedOut {
+					out <- entryResult(best)
+					return
+				}
+			case <-timeout:
+				timedOut = true
+				if best != nil {
+					out <- entryResult(best)
+					return
+				}
+			case <-ctx.Done():
+				// the DHT timeout passed before the quorum
+				if best != nil {
+					out <- entryResult(best)
+				}
+				return
+			}
+		}
+	}()
+
+	return out
+}
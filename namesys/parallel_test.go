@@ -0,0 +1,139 @@
+package namesys
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	opts "mbfs/go-mbfs/namesys/opts"
+
+	ci "mbfs/go-mbfs/gx/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	offroute "mbfs/go-mbfs/gx/QmNuVissmH2ftUd4ADvhm9WER3351wTYduY1EeDDGtP1tM/go-ipfs-routing/offline"
+	path "mbfs/go-mbfs/gx/QmRG3XuGwT7GYuAqgWDJBKTzdaHMwAnc1x7J2KHEXNHxzG/go-path"
+	pstoremem "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore/pstoremem"
+	ropts "mbfs/go-mbfs/gx/QmYyg3UnyiQubxjs4uhKixPxR7eeKrhJ5Vyz6Et4Tet18B/go-libp2p-routing/options"
+	ipns "mbfs/go-mbfs/gx/QmZMJfrt7fU33oFQ9WvWnovhiiZ8T6qkWkFXNCFreJTzgT/go-ipns"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dssync "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/sync"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+	proto "mbfs/go-mbfs/gx/QmdxUuburamoF6zF9qjeQC4WYcWGbWuRmdLacMEsW8ioD8/gogo-protobuf/proto"
+)
+
+// stuckStore is a sluggish DHT, never answering
+type stuckStore struct{}
+
+func (stuckStore) PutValue(ctx context.Context, k string, d []byte, opts ...ropts.Option) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (stuckStore) GetValue(ctx context.Context, k string, opts ...ropts.Option) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (stuckStore) SearchValue(ctx context.Context, k string, opts ...ropts.Option) (<-chan []byte, error) {
+	out := make(chan []byte)
+	go func() {
+		<-ctx.Done()
+		close(out)
+	}()
+	return out, nil
+}
+
+func newRecordStore(t *testing.T, priv ci.PrivKey, value string, seq uint64) RecordSource {
+	store := offroute.NewOfflineRouter(dssync.MutexWrap(ds.NewMapDatastore()), ipns.Validator{KeyBook: pstoremem.NewPeerstore()})
+	if value != "" {
+		entry, err := ipns.Create(priv, []byte(value), seq, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := proto.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pid, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.PutValue(context.Background(), ipns.RecordKey(pid), data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return RecordSource{Name: value, Store: store}
+}
+
+func TestParallelResolution(t *testing.T) {
+	priv, _, err := ci.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "/ipns/" + pid.Pretty()
+	older := "/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+	newer := "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"
+
+	resolve := func(sources []RecordSource, options ...opts.ResolveOpt) (path.Path, time.Duration) {
+		t.Helper()
+		r := NewIpnsResolver(stuckStore{})
+		r.sources = sources
+		start := time.Now()
+		options = append(options, opts.Strategy(opts.Parallel), opts.Depth(1))
+		p, err := r.Resolve(context.Background(), name, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p, time.Since(start)
+	}
+
+	stuck := RecordSource{Name: "dht", Store: stuckStore{}}
+
+	// the newest record wins once the quorum is reached
+	sources := []RecordSource{newRecordStore(t, priv, older, 1), newRecordStore(t, priv, newer, 2), stuck}
+	if p, _ := resolve(sources, opts.Quorum(2)); p.String() != newer {
+		t.Fatalf("expected the newest record, got %s", p)
+	}
+
+	// a stuck DHT holds the resolution up to the quorum timeout only
+	p, took := resolve(sources, opts.Quorum(3), opts.QuorumTimeout(100*time.Millisecond))
+	if p.String() != newer {
+		t.Fatalf("expected the newest record, got %s", p)
+	}
+	if took > 2*time.Second {
+		t.Fatalf("resolution took %s despite the quorum timeout", took)
+	}
+
+	// with a single answer, the timeout returns it
+	sources = []RecordSource{newRecordStore(t, priv, older, 1), newRecordStore(t, priv, "", 0), stuck}
+	if p, _ := resolve(sources, opts.QuorumTimeout(100*time.Millisecond)); p.String() != older {
+		t.Fatalf("expected the only record, got %s", p)
+	}
+}
+
+func TestPublishSkipDHT(t *testing.T) {
+	priv, _, err := ci.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fast := newRecordStore(t, priv, "", 0).Store
+	p := NewIpnsPublisher(stuckStore{}, dssync.MutexWrap(ds.NewMapDatastore()))
+	p.fast = fast
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := p.Publish(SkipDHT(ctx), priv, path.FromString("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fast.GetValue(ctx, ipns.RecordKey(pid)); err != nil {
+		t.Fatal("the record was not put to the fast routing:", err)
+	}
+}
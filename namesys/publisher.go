@@ -32,6 +32,10 @@ type IpnsPublisher struct {
 	routing routing.ValueStore
 	ds      ds.Datastore
 
+	// fast is where the records are put with a SkipDHT context, nowhere
+	// when nil
+	fast routing.ValueStore
+
 	// Used to ensure we assign IPNS records *sequential* sequence numbers.
 	mu sync.Mutex
 }
@@ -148,8 +152,9 @@ func (p *IpnsPublisher) updateRecord(ctx context.Context, k ci.PrivKey, value pa
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// get previous records sequence number
-	rec, err := p.GetPublished(ctx, id, true)
+	// get previous records sequence number, from the DHT too unless
+	// skipping it
+	rec, err := p.GetPublished(ctx, id, !skipsDHT(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -194,6 +199,12 @@ func (p *IpnsPublisher) PublishWithEOL(ctx context.Context, k ci.PrivKey, value
 		return err
 	}
 
+	if skipsDHT(ctx) {
+		if p.fast == nil {
+			return nil
+		}
+		return PutRecordToRouting(ctx, p.fast, k.GetPublic(), record)
+	}
 	return PutRecordToRouting(ctx, p.routing, k.GetPublic(), record)
 }
 
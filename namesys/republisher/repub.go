@@ -3,6 +3,8 @@ package republisher
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	keystore "mbfs/go-mbfs/keystore"
@@ -12,6 +14,7 @@ import (
 	ic "mbfs/go-mbfs/gx/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
 	goprocess "mbfs/go-mbfs/gx/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
 	gpctx "mbfs/go-mbfs/gx/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess/context"
+	ipns "mbfs/go-mbfs/gx/QmZMJfrt7fU33oFQ9WvWnovhiiZ8T6qkWkFXNCFreJTzgT/go-ipns"
 	pb "mbfs/go-mbfs/gx/QmZMJfrt7fU33oFQ9WvWnovhiiZ8T6qkWkFXNCFreJTzgT/go-ipns/pb"
 	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
 	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
@@ -35,6 +38,9 @@ var FailureRetryInterval = time.Minute * 5
 // DefaultRecordLifetime is the default lifetime for IPNS records
 const DefaultRecordLifetime = time.Hour * 24
 
+// SelfKey is the name of the key of the node
+const SelfKey = "self"
+
 type Republisher struct {
 	ns   namesys.Publisher
 	ds   ds.Datastore
@@ -45,6 +51,16 @@ type Republisher struct {
 
 	// how long records that are republished should be valid for
 	RecordLifetime time.Duration
+
+	// KeyIntervals overrides Interval for the keys it names
+	KeyIntervals map[string]time.Duration
+
+	// Exclude holds the names of the keys not to republish
+	Exclude map[string]bool
+
+	// lk serialises the publications, so that a key republished by
+	// RepublishNow isn't republished concurrently by Run
+	lk sync.Mutex
 }
 
 // NewRepublisher creates a new Republisher
@@ -69,90 +85,271 @@ func (rp *Republisher) Run(proc goprocess.Process) {
 	for {
 		select {
 		case <-timer.C:
-			timer.Reset(rp.Interval)
-			err := rp.republishEntries(proc)
-			if err != nil {
-				log.Info("republisher failed to republish: ", err)
-				if FailureRetryInterval < rp.Interval {
-					timer.Reset(FailureRetryInterval)
-				}
-			}
+			next := rp.republishEntries(proc)
+			timer.Reset(time.Until(next))
 		case <-proc.Closing():
 			return
 		}
 	}
 }
 
-func (rp *Republisher) republishEntries(p goprocess.Process) error {
+// republishEntries republishes the keys that are due and returns when the
+// next one will be
+func (rp *Republisher) republishEntries(p goprocess.Process) time.Time {
 	ctx, cancel := context.WithCancel(gpctx.OnClosingContext(p))
 	defer cancel()
 
+	// keys published later are picked up by the next round
+	next := time.Now().Add(rp.Interval)
+
 	// TODO: Use rp.ipns.ListPublished(). We can't currently *do* that
 	// because:
 	// 1. There's no way to get keys from the keystore by ID.
 	// 2. We don't actually have access to the IPNS publisher.
-	err := rp.republishEntry(ctx, rp.self)
+	keys, err := rp.keys()
 	if err != nil {
-		return err
+		log.Info("republisher failed to list the keys: ", err)
+		return time.Now().Add(FailureRetryInterval)
 	}
 
-	if rp.ks != nil {
-		keyNames, err := rp.ks.List()
+	for _, k := range keys {
+		if k.err != nil {
+			log.Infof("republisher failed to load key %s: %s", k.name, k.err)
+			continue
+		}
+		if rp.Exclude[k.name] {
+			continue
+		}
+
+		st, err := rp.loadState(k.id)
 		if err != nil {
-			return err
+			log.Infof("republisher failed to load the state of key %s: %s", k.name, err)
+			continue
 		}
-		for _, name := range keyNames {
-			priv, err := rp.ks.Get(name)
-			if err != nil {
-				return err
-			}
-			err = rp.republishEntry(ctx, priv)
-			if err != nil {
-				return err
+		if st.NextPublish.After(time.Now()) {
+			if st.NextPublish.Before(next) {
+				next = st.NextPublish
 			}
+			continue
+		}
 
+		st, err = rp.republishEntry(ctx, k)
+		if err == errNoEntry {
+			continue
+		}
+		if err != nil {
+			log.Infof("republisher failed to republish key %s: %s", k.name, err)
+		}
+		if st != nil && st.NextPublish.Before(next) {
+			next = st.NextPublish
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
 
-	return nil
+	return next
 }
 
-func (rp *Republisher) republishEntry(ctx context.Context, priv ic.PrivKey) error {
-	id, err := peer.IDFromPrivateKey(priv)
+// key is a key of the node that may have a published record
+type key struct {
+	name string
+	priv ic.PrivKey
+	id   peer.ID
+	err  error
+}
+
+// keys returns the node key and those of the keystore. A keystore key that
+// can't be loaded has its error set.
+func (rp *Republisher) keys() ([]key, error) {
+	keys := []key{{name: SelfKey, priv: rp.self}}
+	if rp.ks != nil {
+		names, err := rp.ks.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			priv, err := rp.ks.Get(name)
+			keys = append(keys, key{name: name, priv: priv, err: err})
+		}
+	}
+
+	for i := range keys {
+		if keys[i].err != nil {
+			continue
+		}
+		keys[i].id, keys[i].err = peer.IDFromPrivateKey(keys[i].priv)
+	}
+	return keys, nil
+}
+
+func (rp *Republisher) key(name string) (key, error) {
+	keys, err := rp.keys()
 	if err != nil {
-		return err
+		return key{}, err
 	}
+	for _, k := range keys {
+		if k.name == name {
+			return k, k.err
+		}
+	}
+	return key{}, fmt.Errorf("no key named %s", name)
+}
+
+// interval returns how often the key named name is republished
+func (rp *Republisher) interval(name string) time.Duration {
+	if d, ok := rp.KeyIntervals[name]; ok {
+		return d
+	}
+	return rp.Interval
+}
+
+// backoff returns how long to wait before retrying a key republished every
+// interval after failures failed attempts in a row
+func backoff(failures int, interval time.Duration) time.Duration {
+	d := FailureRetryInterval
+	for i := 1; i < failures && d < interval; i++ {
+		d *= 2
+	}
+	if d > interval {
+		d = interval
+	}
+	return d
+}
 
-	log.Debugf("republishing ipns entry for %s", id)
+// republishEntry republishes the last record of k and records the outcome in
+// the state of k
+func (rp *Republisher) republishEntry(ctx context.Context, k key) (*keyState, error) {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+
+	log.Debugf("republishing ipns entry for %s", k.id)
 
 	// Look for it locally only
-	p, err := rp.getLastVal(id)
+	e, err := rp.getLastEntry(k.id)
 	if err != nil {
-		if err == errNoEntry {
-			return nil
-		}
-		return err
+		return nil, err
+	}
+
+	st, err := rp.loadState(k.id)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the TTL of the record
+	if e.Ttl != nil {
+		ctx = context.WithValue(ctx, "ipns-publish-ttl", time.Duration(e.GetTtl()))
 	}
 
 	// update record with same sequence number
-	eol := time.Now().Add(rp.RecordLifetime)
-	return rp.ns.PublishWithEOL(ctx, priv, p, eol)
+	now := time.Now()
+	eol := now.Add(rp.RecordLifetime)
+	err = rp.ns.PublishWithEOL(ctx, k.priv, path.Path(e.Value), eol)
+	if err != nil {
+		st.Failures++
+		st.LastError = err.Error()
+		st.NextPublish = now.Add(backoff(st.Failures, rp.interval(k.name)))
+	} else {
+		st.Failures = 0
+		st.LastError = ""
+		st.LastPublish = now
+		st.NextPublish = now.Add(rp.interval(k.name))
+		st.EOL = eol
+		st.TTL = time.Duration(e.GetTtl())
+	}
+
+	if serr := rp.saveState(k.id, st); serr != nil {
+		log.Errorf("failed to save the republisher state of %s: %s", k.name, serr)
+	}
+	return st, err
+}
+
+// RepublishNow republishes the last record of the key named name right away,
+// even if it is excluded from the republications
+func (rp *Republisher) RepublishNow(ctx context.Context, name string) (*KeyStatus, error) {
+	k, err := rp.key(name)
+	if err != nil {
+		return nil, err
+	}
+	_, err = rp.republishEntry(ctx, k)
+	if err == errNoEntry {
+		return nil, fmt.Errorf("key %s has no published record", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rp.status(k)
+}
+
+// Status returns the republication status of every key
+func (rp *Republisher) Status() ([]*KeyStatus, error) {
+	keys, err := rp.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*KeyStatus, 0, len(keys))
+	for _, k := range keys {
+		if k.err != nil {
+			out = append(out, &KeyStatus{Name: k.name, LastError: k.err.Error()})
+			continue
+		}
+		s, err := rp.status(k)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (rp *Republisher) status(k key) (*KeyStatus, error) {
+	s := &KeyStatus{
+		Name:     k.name,
+		ID:       k.id.Pretty(),
+		Interval: rp.interval(k.name),
+		Excluded: rp.Exclude[k.name],
+	}
+
+	e, err := rp.getLastEntry(k.id)
+	switch err {
+	case nil:
+		s.Published = true
+		s.Value = string(e.Value)
+	case errNoEntry:
+		return s, nil
+	default:
+		return nil, err
+	}
+
+	st, err := rp.loadState(k.id)
+	if err != nil {
+		return nil, err
+	}
+	s.keyState = *st
+
+	// the record may have been published since its last republication
+	if eol, err := ipns.GetEOL(e); err == nil {
+		s.EOL = eol
+	}
+	s.TTL = time.Duration(e.GetTtl())
+	return s, nil
 }
 
-func (rp *Republisher) getLastVal(id peer.ID) (path.Path, error) {
+func (rp *Republisher) getLastEntry(id peer.ID) (*pb.IpnsEntry, error) {
 	// Look for it locally only
 	val, err := rp.ds.Get(namesys.IpnsDsKey(id))
 	switch err {
 	case nil:
 	case ds.ErrNotFound:
-		return "", errNoEntry
+		return nil, errNoEntry
 	default:
-		return "", err
+		return nil, err
 	}
 
 	e := new(pb.IpnsEntry)
 	if err := proto.Unmarshal(val, e); err != nil {
-		return "", err
+		return nil, err
 	}
-	return path.Path(e.Value), nil
+	return e, nil
 }
@@ -2,19 +2,27 @@ package republisher_test
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"testing"
 	"time"
 
 	"mbfs/go-mbfs/core"
 	mock "mbfs/go-mbfs/core/mock"
+	keystore "mbfs/go-mbfs/keystore"
 	namesys "mbfs/go-mbfs/namesys"
 	. "mbfs/go-mbfs/namesys/republisher"
 	path "mbfs/go-mbfs/gx/QmRG3XuGwT7GYuAqgWDJBKTzdaHMwAnc1x7J2KHEXNHxzG/go-path"
 
+	ci "mbfs/go-mbfs/gx/QmNiJiXwWE3kRhZrC5ej3kSjWHm337pYfhjLGSCDNKJP2s/go-libp2p-crypto"
+	offroute "mbfs/go-mbfs/gx/QmNuVissmH2ftUd4ADvhm9WER3351wTYduY1EeDDGtP1tM/go-ipfs-routing/offline"
 	goprocess "mbfs/go-mbfs/gx/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
 	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
+	pstoremem "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore/pstoremem"
 	mocknet "mbfs/go-mbfs/gx/QmXnpYYg2onGLXVxM4Q5PEFcx29k8zeJQkPeLAk9h9naxg/go-libp2p/p2p/net/mock"
+	ipns "mbfs/go-mbfs/gx/QmZMJfrt7fU33oFQ9WvWnovhiiZ8T6qkWkFXNCFreJTzgT/go-ipns"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dssync "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/sync"
 )
 
 func TestRepublish(t *testing.T) {
@@ -136,3 +144,111 @@ func verifyResolutionFails(nodes []*core.IpfsNode, key string) error {
 	}
 	return nil
 }
+
+// failingPublisher fails to publish the records of one key
+type failingPublisher struct {
+	namesys.Publisher
+	broken ci.PrivKey
+}
+
+func (p *failingPublisher) PublishWithEOL(ctx context.Context, k ci.PrivKey, value path.Path, eol time.Time) error {
+	if k.Equals(p.broken) {
+		return errors.New("broken key")
+	}
+	return p.Publisher.PublishWithEOL(ctx, k, value, eol)
+}
+
+func TestRepublishSchedule(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	ks := keystore.NewMemKeystore()
+	pub := namesys.NewIpnsPublisher(offroute.NewOfflineRouter(d, ipns.Validator{KeyBook: pstoremem.NewPeerstore()}), d)
+	p := path.FromString("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+
+	keys := make(map[string]ci.PrivKey)
+	for _, name := range []string{"self", "good", "broken", "excluded", "none"} {
+		priv, _, err := ci.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = priv
+		if name != "self" {
+			if err := ks.Put(name, priv); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if name != "none" {
+			if err := pub.Publish(ctx, priv, p); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	newRepublisher := func() *Republisher {
+		repub := NewRepublisher(&failingPublisher{pub, keys["broken"]}, d, keys["self"], ks)
+		repub.Interval = time.Hour
+		repub.KeyIntervals = map[string]time.Duration{"good": 2 * time.Hour}
+		repub.Exclude = map[string]bool{"excluded": true}
+		return repub
+	}
+
+	status := func(repub *Republisher) map[string]*KeyStatus {
+		t.Helper()
+		keys, err := repub.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]*KeyStatus)
+		for _, k := range keys {
+			out[k.Name] = k
+		}
+		return out
+	}
+
+	defer func(d time.Duration) { InitialRebroadcastDelay = d }(InitialRebroadcastDelay)
+	InitialRebroadcastDelay = 10 * time.Millisecond
+
+	repub := newRepublisher()
+	proc := goprocess.Go(repub.Run)
+	time.Sleep(200 * time.Millisecond)
+	proc.Close()
+
+	start := time.Now()
+	st := status(repub)
+	if st["self"].LastPublish.IsZero() || st["self"].NextPublish.Sub(start) < 59*time.Minute {
+		t.Fatalf("self should have been republished for an hour: %+v", st["self"])
+	}
+	// the broken key didn't keep the good one from being republished
+	if st["good"].LastPublish.IsZero() || st["good"].NextPublish.Sub(start) < 119*time.Minute {
+		t.Fatalf("good should have been republished for two hours: %+v", st["good"])
+	}
+	if st["broken"].Failures != 1 || st["broken"].LastError != "broken key" || st["broken"].NextPublish.Sub(start) > FailureRetryInterval {
+		t.Fatalf("broken should be retried after a backoff: %+v", st["broken"])
+	}
+	if !st["excluded"].Excluded || !st["excluded"].LastPublish.IsZero() {
+		t.Fatalf("excluded should not have been republished: %+v", st["excluded"])
+	}
+	if st["none"].Published {
+		t.Fatalf("none has no record: %+v", st["none"])
+	}
+
+	if _, err := repub.RepublishNow(ctx, "broken"); err == nil {
+		t.Fatal("expected the broken key to fail")
+	}
+	if _, err := repub.RepublishNow(ctx, "none"); err == nil {
+		t.Fatal("expected a key without record to fail")
+	}
+	s, err := repub.RepublishNow(ctx, "excluded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.LastPublish.IsZero() {
+		t.Fatalf("excluded should have been republished: %+v", s)
+	}
+
+	// the state is kept in the datastore
+	st = status(newRepublisher())
+	if st["broken"].Failures != 2 || st["good"].LastPublish.IsZero() {
+		t.Fatalf("the state was not kept: %+v %+v", st["broken"], st["good"])
+	}
+}
@@ -0,0 +1,77 @@
+package republisher
+
+import (
+	"encoding/json"
+	"time"
+
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// statePrefix is where the republication state of the keys is kept in the
+// datastore, by key ID
+var statePrefix = ds.NewKey("/ipns-republisher")
+
+// keyState is the republication state of a key, kept across restarts
+type keyState struct {
+	// LastPublish is when the record of the key was last republished
+	LastPublish time.Time
+
+	// NextPublish is when it will be republished next, the zero time for
+	// the first round
+	NextPublish time.Time
+
+	// EOL and TTL are those of the last republished record
+	EOL time.Time
+	TTL time.Duration
+
+	// LastError is the error of the last attempt, and Failures the number
+	// of failed attempts in a row
+	LastError string `json:",omitempty"`
+	Failures  int    `json:",omitempty"`
+}
+
+// KeyStatus is the republication status of a key
+type KeyStatus struct {
+	Name string
+	ID   string `json:",omitempty"`
+
+	// Published tells whether the key has a record to republish, with
+	// the given Value
+	Published bool
+	Value     string `json:",omitempty"`
+
+	Interval time.Duration
+	Excluded bool
+
+	keyState
+}
+
+func stateKey(id peer.ID) ds.Key {
+	return statePrefix.ChildString(id.Pretty())
+}
+
+func (rp *Republisher) loadState(id peer.ID) (*keyState, error) {
+	st := new(keyState)
+	data, err := rp.ds.Get(stateKey(id))
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return st, nil
+	default:
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		log.Errorf("invalid republisher state for %s, resetting it: %s", id, err)
+		return new(keyState), nil
+	}
+	return st, nil
+}
+
+func (rp *Republisher) saveState(id peer.ID, st *keyState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return rp.ds.Put(stateKey(id), data)
+}
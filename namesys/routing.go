@@ -25,6 +25,10 @@ var log = logging.Logger("namesys")
 // IpnsResolver implements NSResolver for the main IPFS SFS-like naming
 type IpnsResolver struct {
 	routing routing.ValueStore
+
+	// sources are queried by the Parallel strategy, routing alone when
+	// empty
+	sources []RecordSource
 }
 
 // NewIpnsResolver constructs a name resolver using the IPFS Routing system
@@ -70,6 +74,10 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, option
 		return out
 	}
 
+	if options.Strategy == opts.Parallel {
+		return r.resolveParallel(ctx, cancel, pid, options)
+	}
+
 	// Name should be the hash of a public key retrievable from ipfs.
 	// We retrieve the public key here to make certain that it's in the peer
 	// store before calling GetValue() on the DHT - the DHT will call the
@@ -116,43 +124,11 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, option
 					return
 				}
 
-				var p path.Path
-				// check for old style record:
-				if valh, err := mh.Cast(entry.GetValue()); err == nil {
-					// Its an old style multihash record
-					log.Debugf("encountered CIDv0 ipns entry: %s", valh)
-					p = path.FromCid(cid.NewCidV0(valh))
-				} else {
-					// Not a multihash, probably a new style record
-					p, err = path.ParsePath(string(entry.GetValue()))
-					if err != nil {
-						emitOnceResult(ctx, out, onceResult{err: err})
-						return
-					}
-				}
-
-				ttl := DefaultResolverCacheTTL
-				if entry.Ttl != nil {
-					ttl = time.Duration(*entry.Ttl)
-				}
-				switch eol, err := ipns.GetEOL(entry); err {
-				case ipns.ErrUnrecognizedValidity:
-					// No EOL.
-				case nil:
-					ttEol := eol.Sub(time.Now())
-					if ttEol < 0 {
-						// It *was* valid when we first resolved it.
-						ttl = 0
-					} else if ttEol < ttl {
-						ttl = ttEol
-					}
-				default:
-					log.Errorf("encountered error when parsing EOL: %s", err)
-					emitOnceResult(ctx, out, onceResult{err: err})
+				res := entryResult(entry)
+				emitOnceResult(ctx, out, res)
+				if res.err != nil {
 					return
 				}
-
-				emitOnceResult(ctx, out, onceResult{value: p, ttl: ttl})
 			case <-ctx.Done():
 				return
 			}
@@ -161,3 +137,43 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, option
 
 	return out
 }
+
+// entryResult returns the path of an IPNS entry, and how long it may be
+// cached
+func entryResult(entry *pb.IpnsEntry) onceResult {
+	var p path.Path
+	// check for old style record:
+	if valh, err := mh.Cast(entry.GetValue()); err == nil {
+		// Its an old style multihash record
+		log.Debugf("encountered CIDv0 ipns entry: %s", valh)
+		p = path.FromCid(cid.NewCidV0(valh))
+	} else {
+		// Not a multihash, probably a new style record
+		p, err = path.ParsePath(string(entry.GetValue()))
+		if err != nil {
+			return onceResult{err: err}
+		}
+	}
+
+	ttl := DefaultResolverCacheTTL
+	if entry.Ttl != nil {
+		ttl = time.Duration(*entry.Ttl)
+	}
+	switch eol, err := ipns.GetEOL(entry); err {
+	case ipns.ErrUnrecognizedValidity:
+		// No EOL.
+	case nil:
+		ttEol := eol.Sub(time.Now())
+		if ttEol < 0 {
+			// It *was* valid when we first resolved it.
+			ttl = 0
+		} else if ttEol < ttl {
+			ttl = ttEol
+		}
+	default:
+		log.Errorf("encountered error when parsing EOL: %s", err)
+		return onceResult{err: err}
+	}
+
+	return onceResult{value: p, ttl: ttl}
+}
@@ -0,0 +1,550 @@
+// Package nat implements the NAT traversal of the node: the reachability
+// service, where peers dial the node back to tell whether it is reachable
+// from the internet, and the hole punching upgrading the relayed connections
+// to direct ones.
+//
+// A reachability probe asks a few connected peers to dial the node back at
+// its addresses. A peer dials back, on a fresh connection, the addresses
+// sharing the IP address it sees the node connect from only, so that it can't
+// be made to dial third parties. The node is public once a peer reaches it,
+// and private once the peers fail to, a single contrary probe not being
+// enough to change a confirmed reachability.
+package nat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	host "mbfs/go-mbfs/gx/QmVrjR2KMe57y4YyfHdYa3yKD278gN8W7CTiqSuYmxjA7F/go-libp2p-host"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+	logging "mbfs/go-mbfs/gx/QmcuXC5cxs79ro2cUuHs4HQ2bkDLJUYokwL8aivcX6HW3C/go-log"
+	circuit "mbfs/go-mbfs/gx/QmddZ5gv3Gkicoqh5NDfHGjpij6zw92pQjvpa181yfnXm2/go-libp2p-circuit"
+)
+
+var log = logging.Logger("nat")
+
+// AutoNATProtocol is the protocol of the reachability probes
+const AutoNATProtocol = "/mbfs/autonat/1.0.0"
+
+const (
+	// maxMessageSize bounds the messages of the protocols
+	maxMessageSize = 4096
+	// maxDialAddrs bounds the addresses dialed back for a probe
+	maxDialAddrs = 8
+	// maxDialBacks bounds the probes served at once
+	maxDialBacks = 4
+	// maxResults bounds the probes and hole punches kept for the status
+	maxResults = 16
+	// maxConfidence is the number of contrary probes it takes to change a
+	// reachability confirmed by as many probes
+	maxConfidence = 3
+)
+
+var (
+	// DefaultProbeInterval is the time between two probes by default
+	DefaultProbeInterval = 15 * time.Minute
+
+	// probeDelay is the delay before the first probe, for the node to
+	// connect to peers, and probeRetry the delay before the next one when no
+	// peer answered
+	probeDelay = 15 * time.Second
+	probeRetry = time.Minute
+	// probePeers is the number of peers asked in a probe
+	probePeers = 3
+	// dialTimeout bounds a dial back, and streamTimeout a probe
+	dialTimeout   = 15 * time.Second
+	streamTimeout = time.Minute
+	// dialBackLinger is how long the connection of a dial back stays open,
+	// for the peer to identify the node on it: a host forgets the protocols
+	// of a peer on a new connection until it is identified
+	dialBackLinger = 10 * time.Second
+)
+
+// Reachability is whether the node is reachable from the internet
+type Reachability int
+
+const (
+	// ReachabilityUnknown is the reachability until a peer answers a probe
+	ReachabilityUnknown Reachability = iota
+	// ReachabilityPublic is the reachability of a node a peer dialed back
+	ReachabilityPublic
+	// ReachabilityPrivate is the reachability of a node the peers failed
+	// to dial back, typically behind a NAT
+	ReachabilityPrivate
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case ReachabilityPublic:
+		return "public"
+	case ReachabilityPrivate:
+		return "private"
+	default:
+		return "unknown"
+	}
+}
+
+// the statuses of the answers to a probe
+const (
+	statusOK        = "ok"
+	statusDialError = "dial-error"
+	statusRefused   = "refused"
+)
+
+// dialRequest asks a peer to dial the node back at Addrs
+type dialRequest struct {
+	Addrs []string
+}
+
+// dialResponse is the answer of a peer to a probe: the address it reached the
+// node at, or why it couldn't or wouldn't
+type dialResponse struct {
+	Status string
+	Addr   string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// ProbeResult is the answer of a peer to a probe
+type ProbeResult struct {
+	Peer peer.ID
+	Time time.Time
+
+	// Reachability is public if the peer reached the node at Addr, private
+	// if it failed to, with Error, and unknown if it refused, with Error
+	Reachability Reachability
+	Addr         ma.Multiaddr
+	Error        string
+}
+
+// ErrNoPeers is the error of a probe without a peer to ask
+var ErrNoPeers = errors.New("no connected peer to probe the reachability")
+
+// AutoNAT probes the reachability of the node, and dials back the peers
+// probing theirs.
+type AutoNAT struct {
+	host     host.Host
+	interval time.Duration
+	serve    bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// poke asks for a probe now, and for its outcome
+	poke chan chan error
+
+	// dialBacks limits the probes served at once
+	dialBacks chan struct{}
+
+	lk           sync.Mutex
+	reachability Reachability
+	confidence   int
+	publicAddrs  []ma.Multiaddr
+	lastProbe    time.Time
+	nextProbe    time.Time
+	results      []ProbeResult
+	served       uint64
+	refused      uint64
+}
+
+// NewAutoNAT constructs the reachability service of h, probing every
+// interval once it is started. It dials back the peers probing theirs if
+// serve is set.
+func NewAutoNAT(h host.Host, interval time.Duration, serve bool) *AutoNAT {
+	as := &AutoNAT{
+		host:      h,
+		interval:  interval,
+		serve:     serve,
+		poke:      make(chan chan error),
+		dialBacks: make(chan struct{}, maxDialBacks),
+	}
+	as.ctx, as.cancel = context.WithCancel(context.Background())
+	if serve {
+		h.SetStreamHandler(AutoNATProtocol, as.handleProbe)
+	}
+	return as
+}
+
+// Start starts probing the reachability.
+func (as *AutoNAT) Start() {
+	as.wg.Add(1)
+	go as.probeLoop()
+}
+
+// Close stops probing the reachability and dialing back the peers.
+func (as *AutoNAT) Close() error {
+	if as.serve {
+		as.host.RemoveStreamHandler(AutoNATProtocol)
+	}
+	as.cancel()
+	as.wg.Wait()
+	return nil
+}
+
+// Reachability returns the reachability of the node.
+func (as *AutoNAT) Reachability() Reachability {
+	as.lk.Lock()
+	defer as.lk.Unlock()
+	return as.reachability
+}
+
+// Probe probes the reachability now rather than at the next interval, and
+// returns once the probe is over, with its error.
+func (as *AutoNAT) Probe(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case as.poke <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-as.ctx.Done():
+		return as.ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AutoNATStatus is the state of the reachability service
+type AutoNATStatus struct {
+	Reachability Reachability
+	// Confidence is the number of probes confirming the reachability, up to
+	// maxConfidence
+	Confidence int
+	// PublicAddrs are the addresses the peers reached the node at
+	PublicAddrs []ma.Multiaddr
+
+	LastProbe time.Time
+	NextProbe time.Time
+	// Results are the answers to the last probes, the newest last
+	Results []ProbeResult
+
+	// Serving tells whether the node dials back the peers, Served counts the
+	// probes it answered and Refused the ones it refused
+	Serving bool
+	Served  uint64
+	Refused uint64
+}
+
+// Status returns the state of the service.
+func (as *AutoNAT) Status() *AutoNATStatus {
+	as.lk.Lock()
+	defer as.lk.Unlock()
+	return &AutoNATStatus{
+		Reachability: as.reachability,
+		Confidence:   as.confidence,
+		PublicAddrs:  append([]ma.Multiaddr(nil), as.publicAddrs...),
+		LastProbe:    as.lastProbe,
+		NextProbe:    as.nextProbe,
+		Results:      append([]ProbeResult(nil), as.results...),
+		Serving:      as.serve,
+		Served:       as.served,
+		Refused:      as.refused,
+	}
+}
+
+func (as *AutoNAT) probeLoop() {
+	defer as.wg.Done()
+
+	wait := probeDelay
+	for {
+		as.lk.Lock()
+		as.nextProbe = time.Now().Add(wait)
+		as.lk.Unlock()
+
+		var done chan error
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case done = <-as.poke:
+			t.Stop()
+		case <-as.ctx.Done():
+			t.Stop()
+			return
+		}
+
+		wait = as.interval
+		err := as.probe()
+		if err != nil {
+			log.Debugf("reachability probe failed, retrying in %s: %s", probeRetry, err)
+			wait = probeRetry
+		}
+		if done != nil {
+			done <- err
+		}
+		if as.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// probe asks up to probePeers peers to dial the node back, and updates the
+// reachability with their answers
+func (as *AutoNAT) probe() error {
+	peers := as.probePeers()
+	if len(peers) == 0 {
+		return ErrNoPeers
+	}
+
+	results := make([]ProbeResult, len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Add(1)
+		go func(i int, p peer.ID) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(as.ctx, streamTimeout)
+			defer cancel()
+			results[i] = as.probePeer(ctx, p)
+		}(i, p)
+	}
+	wg.Wait()
+	if as.ctx.Err() != nil {
+		return as.ctx.Err()
+	}
+
+	reachability := ReachabilityUnknown
+	var addrs []ma.Multiaddr
+	for _, r := range results {
+		switch r.Reachability {
+		case ReachabilityPublic:
+			reachability = ReachabilityPublic
+			addrs = appendAddr(addrs, r.Addr)
+		case ReachabilityPrivate:
+			if reachability == ReachabilityUnknown {
+				reachability = ReachabilityPrivate
+			}
+		}
+	}
+
+	as.lk.Lock()
+	defer as.lk.Unlock()
+	as.lastProbe = time.Now()
+	as.results = append(as.results, results...)
+	if len(as.results) > maxResults {
+		as.results = as.results[len(as.results)-maxResults:]
+	}
+	if reachability == ReachabilityUnknown {
+		return errors.New("no peer dialed the node back")
+	}
+	as.update(reachability, addrs)
+	return nil
+}
+
+// update records the reachability found by a probe, changing the one of the
+// node once it is no longer confirmed by earlier probes. NB: as.lk must be
+// held
+func (as *AutoNAT) update(r Reachability, addrs []ma.Multiaddr) {
+	switch {
+	case r == as.reachability:
+		if as.confidence < maxConfidence {
+			as.confidence++
+		}
+	case as.confidence > 0:
+		as.confidence--
+		return
+	default:
+		log.Infof("the node is now %s", r)
+		as.reachability = r
+	}
+	if r == ReachabilityPublic {
+		as.publicAddrs = addrs
+	} else {
+		as.publicAddrs = nil
+	}
+}
+
+// probePeers returns up to probePeers connected peers speaking the protocol,
+// at random, reached directly
+func (as *AutoNAT) probePeers() []peer.ID {
+	var peers []peer.ID
+	for _, p := range as.host.Network().Peers() {
+		protos, err := as.host.Peerstore().SupportsProtocols(p, AutoNATProtocol)
+		if err != nil || len(protos) == 0 || directConn(as.host.Network(), p) == nil {
+			continue
+		}
+		peers = append(peers, p)
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > probePeers {
+		peers = peers[:probePeers]
+	}
+	return peers
+}
+
+// probePeer asks p to dial the node back
+func (as *AutoNAT) probePeer(ctx context.Context, p peer.ID) ProbeResult {
+	res := ProbeResult{Peer: p, Time: time.Now()}
+	resp, err := as.requestDialBack(ctx, p)
+	switch {
+	case err != nil:
+		res.Error = err.Error()
+	case resp.Status == statusOK:
+		a, err := ma.NewMultiaddr(resp.Addr)
+		if err != nil {
+			res.Error = fmt.Sprintf("invalid address dialed back: %s", err)
+			break
+		}
+		res.Reachability, res.Addr = ReachabilityPublic, a
+	case resp.Status == statusDialError:
+		res.Reachability, res.Error = ReachabilityPrivate, resp.Error
+	default:
+		res.Error = fmt.Sprintf("%s: %s", resp.Status, resp.Error)
+	}
+	log.Debugf("reachability probe by %s: %s %s", p.Pretty(), res.Reachability, res.Error)
+	return res
+}
+
+func (as *AutoNAT) requestDialBack(ctx context.Context, p peer.ID) (*dialResponse, error) {
+	var req dialRequest
+	for _, a := range directAddrs(as.host.Addrs()) {
+		req.Addrs = append(req.Addrs, a.String())
+	}
+
+	s, err := as.host.NewStream(ctx, p, AutoNATProtocol)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(s).Encode(&req); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	var resp dialResponse
+	if err := readMessage(s, &resp); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	inet.FullClose(s)
+	return &resp, nil
+}
+
+func (as *AutoNAT) handleProbe(s inet.Stream) {
+	defer inet.FullClose(s)
+	s.SetDeadline(time.Now().Add(streamTimeout))
+
+	p := s.Conn().RemotePeer()
+	var req dialRequest
+	if err := readMessage(s, &req); err != nil {
+		log.Debugf("error reading the probe of %s: %s", p.Pretty(), err)
+		s.Reset()
+		return
+	}
+
+	resp := as.dialBack(p, s.Conn().RemoteMultiaddr(), req.Addrs)
+	as.lk.Lock()
+	if resp.Status == statusRefused {
+		as.refused++
+	} else {
+		as.served++
+	}
+	as.lk.Unlock()
+	if err := json.NewEncoder(s).Encode(resp); err != nil {
+		log.Debugf("error answering the probe of %s: %s", p.Pretty(), err)
+		s.Reset()
+	}
+}
+
+// dialBack dials p back, on a fresh connection, at the addresses of addrs
+// sharing the IP address of observed, the remote address of the connection
+// of the probe
+func (as *AutoNAT) dialBack(p peer.ID, observed ma.Multiaddr, addrs []string) *dialResponse {
+	refuse := func(why string) *dialResponse {
+		log.Debugf("refusing the probe of %s: %s", p.Pretty(), why)
+		return &dialResponse{Status: statusRefused, Error: why}
+	}
+
+	d, ok := as.host.Network().(directDialer)
+	if !ok {
+		return refuse("cannot dial back")
+	}
+	if isRelayed(observed) {
+		return refuse("probe through a relay")
+	}
+	ip, ok := ipOf(observed)
+	if !ok {
+		return refuse("unknown observed address")
+	}
+
+	var dial []ma.Multiaddr
+	for _, s := range addrs {
+		a, err := ma.NewMultiaddr(s)
+		if err != nil || isRelayed(a) {
+			continue
+		}
+		if aip, ok := ipOf(a); ok && aip == ip && len(dial) < maxDialAddrs {
+			dial = append(dial, a)
+		}
+	}
+	if len(dial) == 0 {
+		return &dialResponse{Status: statusDialError, Error: fmt.Sprintf("no address at %s to dial", ip)}
+	}
+
+	select {
+	case as.dialBacks <- struct{}{}:
+		defer func() { <-as.dialBacks }()
+	default:
+		return refuse("too many probes")
+	}
+
+	ctx, cancel := context.WithTimeout(as.ctx, dialTimeout)
+	defer cancel()
+	c, err := d.DialAddrs(ctx, p, dial)
+	if err != nil {
+		return &dialResponse{Status: statusDialError, Error: err.Error()}
+	}
+	time.AfterFunc(dialBackLinger, func() { c.Close() })
+	return &dialResponse{Status: statusOK, Addr: c.RemoteMultiaddr().String()}
+}
+
+// readMessage reads a message of the protocols into v
+func readMessage(r io.Reader, v interface{}) error {
+	return json.NewDecoder(io.LimitReader(r, maxMessageSize)).Decode(v)
+}
+
+// isRelayed tells whether a is an address through a relay
+func isRelayed(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(circuit.P_CIRCUIT)
+	return err == nil
+}
+
+// directAddrs returns the addresses of addrs not through a relay
+func directAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	var out []ma.Multiaddr
+	for _, a := range addrs {
+		if !isRelayed(a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// ipOf returns the IP address of a
+func ipOf(a ma.Multiaddr) (string, bool) {
+	if ip, err := a.ValueForProtocol(ma.P_IP4); err == nil {
+		return ip, true
+	}
+	if ip, err := a.ValueForProtocol(ma.P_IP6); err == nil {
+		return ip, true
+	}
+	return "", false
+}
+
+func appendAddr(addrs []ma.Multiaddr, a ma.Multiaddr) []ma.Multiaddr {
+	for _, b := range addrs {
+		if b.Equal(a) {
+			return addrs
+		}
+	}
+	return append(addrs, a)
+}
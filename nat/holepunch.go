@@ -0,0 +1,453 @@
+package nat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	manet "mbfs/go-mbfs/gx/QmQVUtnrNGtCRkCMpXgpApfzQjc8FDaDVxHqWH8cnZQeh5/go-multiaddr-net"
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	host "mbfs/go-mbfs/gx/QmVrjR2KMe57y4YyfHdYa3yKD278gN8W7CTiqSuYmxjA7F/go-libp2p-host"
+	reuseport "mbfs/go-mbfs/gx/QmXD921xzL9EDRpD6gRm1cb7Khm8VEpZ3NT3nPK7uTX6Fq/go-reuseport"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// HolePunchProtocol is the protocol coordinating the hole punches
+const HolePunchProtocol = "/mbfs/holepunch/1.0.0"
+
+var (
+	// holePunchAttempts is the number of rounds of a hole punch before it is
+	// given up
+	holePunchAttempts = 3
+	// punchTimeout bounds the dials opening the NAT of the responder, below
+	// the first retransmission of their SYN
+	punchTimeout = 200 * time.Millisecond
+	// directConnWait is how long the responder waits for the connection of
+	// the initiator to show up once the initiator is done
+	directConnWait = time.Second
+)
+
+// the types of the messages of a hole punch
+const (
+	msgConnect = "connect"
+	msgSync    = "sync"
+)
+
+// holePunchMsg is a message of a hole punch: the direct addresses of a peer,
+// or the signal to dial
+type holePunchMsg struct {
+	Type  string
+	Addrs []string `json:",omitempty"`
+}
+
+// directDialer is the network dialing a peer directly while connected to it
+// through a relay, i.e. the swarm
+type directDialer interface {
+	DialAddrs(ctx context.Context, p peer.ID, addrs []ma.Multiaddr) (inet.Conn, error)
+}
+
+// HolePunchResult is the outcome of a hole punch with a peer
+type HolePunchResult struct {
+	Peer peer.ID
+	Time time.Time
+
+	// Initiator tells whether the node started the hole punch, which took
+	// Attempts rounds and a round trip of RTT through the relay, the punch
+	// of the responder included for the initiator
+	Initiator bool
+	Attempts  int
+	RTT       time.Duration
+
+	// Addr is the remote address of the direct connection, or Error why the
+	// hole punch failed
+	Addr  ma.Multiaddr
+	Error string
+}
+
+// HolePuncher upgrades the relayed connections to direct ones. The peer
+// receiving a connection through a relay, typically behind a NAT, starts the
+// hole punch: the two peers exchange their direct addresses over the relayed
+// connection, measuring its round trip. Before answering, the responder sends
+// bare TCP dials from its listening ports to the addresses of the initiator,
+// closed right away, which open its NAT to them; the initiator then dials
+// the responder, which only accepts. Only one side dialing, the peers never
+// open the connection at once, in which case both would act as the client of
+// the stream multiplexer.
+type HolePuncher struct {
+	host host.Host
+	nb   *inet.NotifyBundle
+
+	// punch opens the NAT of the node to the addresses of the initiator
+	punch func(addrs []ma.Multiaddr)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// wg waits for the hole punches started by the node
+	wg sync.WaitGroup
+
+	lk        sync.Mutex
+	active    map[peer.ID]struct{}
+	attempts  uint64
+	successes uint64
+	results   []HolePunchResult
+}
+
+// NewHolePuncher constructs the hole puncher of h, answering the hole
+// punches of the peers, and starting its own once it is started.
+func NewHolePuncher(h host.Host) *HolePuncher {
+	hp := &HolePuncher{
+		host:   h,
+		active: make(map[peer.ID]struct{}),
+	}
+	hp.ctx, hp.cancel = context.WithCancel(context.Background())
+	hp.punch = hp.openNAT
+	hp.nb = &inet.NotifyBundle{ConnectedF: hp.connected}
+	h.SetStreamHandler(HolePunchProtocol, hp.handleHolePunch)
+	return hp
+}
+
+// Start starts upgrading the connections relayed to the node.
+func (hp *HolePuncher) Start() {
+	hp.host.Network().Notify(hp.nb)
+}
+
+// Close stops the hole punching.
+func (hp *HolePuncher) Close() error {
+	hp.host.RemoveStreamHandler(HolePunchProtocol)
+	hp.host.Network().StopNotify(hp.nb)
+	hp.lk.Lock()
+	hp.cancel()
+	hp.lk.Unlock()
+	hp.wg.Wait()
+	return nil
+}
+
+// HolePunchStatus is the state of the hole puncher
+type HolePunchStatus struct {
+	// Attempts counts the hole punches, Successes the ones that connected
+	// the peers directly
+	Attempts  uint64
+	Successes uint64
+	// Active are the peers of the hole punches in progress
+	Active []peer.ID
+	// Results are the outcomes of the last hole punches, the newest last
+	Results []HolePunchResult
+}
+
+// Status returns the state of the hole puncher.
+func (hp *HolePuncher) Status() *HolePunchStatus {
+	hp.lk.Lock()
+	defer hp.lk.Unlock()
+	st := &HolePunchStatus{
+		Attempts:  hp.attempts,
+		Successes: hp.successes,
+		Results:   append([]HolePunchResult(nil), hp.results...),
+	}
+	for p := range hp.active {
+		st.Active = append(st.Active, p)
+	}
+	return st
+}
+
+// connected starts a hole punch with the peers connecting through a relay
+func (hp *HolePuncher) connected(n inet.Network, c inet.Conn) {
+	if c.Stat().Direction != inet.DirInbound || !isRelayed(c.RemoteMultiaddr()) {
+		return
+	}
+	hp.lk.Lock()
+	defer hp.lk.Unlock()
+	if hp.ctx.Err() != nil {
+		return
+	}
+	hp.wg.Add(1)
+	go func() {
+		defer hp.wg.Done()
+		hp.holePunch(c.RemotePeer())
+	}()
+}
+
+// begin marks a hole punch with p in progress, unless there is one already
+// or the node is connected to p directly
+func (hp *HolePuncher) begin(p peer.ID) bool {
+	if directConn(hp.host.Network(), p) != nil {
+		return false
+	}
+	hp.lk.Lock()
+	defer hp.lk.Unlock()
+	if _, ok := hp.active[p]; ok {
+		return false
+	}
+	hp.active[p] = struct{}{}
+	hp.attempts++
+	return true
+}
+
+// end records the outcome of a hole punch
+func (hp *HolePuncher) end(res HolePunchResult, err error) {
+	if err != nil {
+		res.Error = err.Error()
+		log.Debugf("hole punch with %s failed after %d attempts: %s", res.Peer.Pretty(), res.Attempts, err)
+	} else {
+		log.Debugf("hole punch with %s connected directly at %s", res.Peer.Pretty(), res.Addr)
+	}
+
+	hp.lk.Lock()
+	defer hp.lk.Unlock()
+	delete(hp.active, res.Peer)
+	if err == nil {
+		hp.successes++
+	}
+	hp.results = append(hp.results, res)
+	if len(hp.results) > maxResults {
+		hp.results = hp.results[len(hp.results)-maxResults:]
+	}
+}
+
+// holePunch starts a hole punch with p, connected through a relay
+func (hp *HolePuncher) holePunch(p peer.ID) {
+	if !hp.begin(p) {
+		return
+	}
+	res := HolePunchResult{Peer: p, Time: time.Now(), Initiator: true}
+	err := hp.initiate(p, &res)
+	hp.end(res, err)
+}
+
+func (hp *HolePuncher) initiate(p peer.ID, res *HolePunchResult) error {
+	ctx, cancel := context.WithTimeout(hp.ctx, streamTimeout)
+	defer cancel()
+	s, err := hp.host.NewStream(ctx, p, HolePunchProtocol)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	s.SetDeadline(deadline)
+	enc, dec := json.NewEncoder(s), newMsgDecoder(s)
+
+	var lastErr error
+	for res.Attempts < holePunchAttempts {
+		res.Attempts++
+		start := time.Now()
+		if err := enc.Encode(&holePunchMsg{Type: msgConnect, Addrs: hp.addrs()}); err != nil {
+			s.Reset()
+			return err
+		}
+		addrs, err := readConnect(dec)
+		if err != nil {
+			s.Reset()
+			return err
+		}
+		res.RTT = time.Since(start)
+		if err := enc.Encode(&holePunchMsg{Type: msgSync}); err != nil {
+			s.Reset()
+			return err
+		}
+
+		// the peer opened its NAT before answering
+		c, err := hp.dial(ctx, p, addrs)
+		if err == nil {
+			res.Addr = c.RemoteMultiaddr()
+			inet.FullClose(s)
+			return nil
+		}
+		lastErr = err
+	}
+	s.Reset()
+	return lastErr
+}
+
+func (hp *HolePuncher) handleHolePunch(s inet.Stream) {
+	p := s.Conn().RemotePeer()
+	if !isRelayed(s.Conn().RemoteMultiaddr()) || !hp.begin(p) {
+		s.Reset()
+		return
+	}
+	res := HolePunchResult{Peer: p, Time: time.Now()}
+	err := hp.respond(s, &res)
+	hp.end(res, err)
+}
+
+// respond answers the rounds of a hole punch, opening the NAT of the node to
+// the peer before answering, until the peer connects or gives up
+func (hp *HolePuncher) respond(s inet.Stream, res *HolePunchResult) error {
+	ctx, cancel := context.WithTimeout(hp.ctx, streamTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	s.SetDeadline(deadline)
+	enc, dec := json.NewEncoder(s), newMsgDecoder(s)
+
+	for {
+		addrs, err := readConnect(dec)
+		if err == io.EOF {
+			// the peer is done, having connected if it could
+			if c := hp.waitDirectConn(ctx, res.Peer); c != nil {
+				res.Addr = c.RemoteMultiaddr()
+				inet.FullClose(s)
+				return nil
+			}
+			s.Close()
+			return errors.New("the peer could not connect")
+		}
+		if err != nil {
+			s.Reset()
+			return err
+		}
+		if res.Attempts == holePunchAttempts {
+			s.Reset()
+			return errors.New("too many attempts")
+		}
+		res.Attempts++
+		// bounded by punchTimeout, the punch delays the answer, so that the
+		// NAT is open by the time the initiator dials
+		hp.punch(addrs)
+		start := time.Now()
+		if err := enc.Encode(&holePunchMsg{Type: msgConnect, Addrs: hp.addrs()}); err != nil {
+			s.Reset()
+			return err
+		}
+		var msg holePunchMsg
+		if err := dec.Decode(&msg); err != nil {
+			s.Reset()
+			return err
+		}
+		if msg.Type != msgSync {
+			s.Reset()
+			return fmt.Errorf("unexpected %q message", msg.Type)
+		}
+		res.RTT = time.Since(start)
+	}
+}
+
+// waitDirectConn waits for a direct connection to p, for directConnWait at
+// most
+func (hp *HolePuncher) waitDirectConn(ctx context.Context, p peer.ID) inet.Conn {
+	ctx, cancel := context.WithTimeout(ctx, directConnWait)
+	defer cancel()
+	for {
+		if c := directConn(hp.host.Network(), p); c != nil {
+			return c
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// openNAT dials the TCP addresses of addrs from the listening ports of the
+// node, closing the connections at once: the dials only open the mappings of
+// a NAT, for the connection of the peer to go through it.
+func (hp *HolePuncher) openNAT(addrs []ma.Multiaddr) {
+	if !reuseport.Available() {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, raddr := range addrs {
+		network, rhost, err := manet.DialArgs(raddr)
+		if err != nil || (network != "tcp4" && network != "tcp6") {
+			continue
+		}
+		for _, a := range hp.host.Network().ListenAddresses() {
+			laddr, err := manet.ToNetAddr(a)
+			if err != nil {
+				continue
+			}
+			tcp, ok := laddr.(*net.TCPAddr)
+			if !ok || (tcp.IP.To4() != nil) != (network == "tcp4") {
+				continue
+			}
+			wg.Add(1)
+			go func(network string, laddr *net.TCPAddr, rhost string) {
+				defer wg.Done()
+				d := reuseport.Dialer{D: net.Dialer{LocalAddr: laddr, Timeout: punchTimeout}}
+				c, err := d.Dial(network, rhost)
+				if err != nil {
+					return
+				}
+				// reset rather than close, not to hold the port in TIME_WAIT
+				if tc, ok := c.(*net.TCPConn); ok {
+					tc.SetLinger(0)
+				}
+				c.Close()
+			}(network, tcp, rhost)
+		}
+	}
+	wg.Wait()
+}
+
+// dial dials p directly at addrs, unless the node is connected to it
+// directly already
+func (hp *HolePuncher) dial(ctx context.Context, p peer.ID, addrs []ma.Multiaddr) (inet.Conn, error) {
+	if c := directConn(hp.host.Network(), p); c != nil {
+		return c, nil
+	}
+	d, ok := hp.host.Network().(directDialer)
+	if !ok {
+		return nil, errors.New("the network cannot dial directly")
+	}
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	c, err := d.DialAddrs(ctx, p, addrs)
+	if err != nil {
+		if c := directConn(hp.host.Network(), p); c != nil {
+			return c, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// addrs returns the direct addresses of the node, as strings
+func (hp *HolePuncher) addrs() []string {
+	var out []string
+	for _, a := range directAddrs(hp.host.Addrs()) {
+		out = append(out, a.String())
+	}
+	return out
+}
+
+// newMsgDecoder decodes the messages of a hole punch, bounding their size in
+// total
+func newMsgDecoder(r io.Reader) *json.Decoder {
+	return json.NewDecoder(io.LimitReader(r, 2*maxMessageSize*int64(holePunchAttempts)))
+}
+
+// readConnect reads a connect message, and returns the direct addresses of
+// the peer in it
+func readConnect(dec *json.Decoder) ([]ma.Multiaddr, error) {
+	var msg holePunchMsg
+	if err := dec.Decode(&msg); err != nil {
+		return nil, err
+	}
+	if msg.Type != msgConnect {
+		return nil, fmt.Errorf("unexpected %q message", msg.Type)
+	}
+	var addrs []ma.Multiaddr
+	for _, s := range msg.Addrs {
+		if a, err := ma.NewMultiaddr(s); err == nil && !isRelayed(a) {
+			addrs = append(addrs, a)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no direct address of the peer")
+	}
+	return addrs, nil
+}
+
+// directConn returns a connection of the node to p not through a relay, if
+// any
+func directConn(n inet.Network, p peer.ID) inet.Conn {
+	for _, c := range n.ConnsToPeer(p) {
+		if !isRelayed(c.RemoteMultiaddr()) {
+			return c
+		}
+	}
+	return nil
+}
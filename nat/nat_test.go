@@ -0,0 +1,318 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	testutil "mbfs/go-mbfs/thirdparty/testutil"
+
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
+	host "mbfs/go-mbfs/gx/QmVrjR2KMe57y4YyfHdYa3yKD278gN8W7CTiqSuYmxjA7F/go-libp2p-host"
+	bhost "mbfs/go-mbfs/gx/QmXnpYYg2onGLXVxM4Q5PEFcx29k8zeJQkPeLAk9h9naxg/go-libp2p/p2p/host/basic"
+	swarmt "mbfs/go-mbfs/gx/QmcYC4ayKi7bq8xecEZxHVEuTL6HREZWTTErrSRd1S3Spz/go-libp2p-swarm/testing"
+	circuit "mbfs/go-mbfs/gx/QmddZ5gv3Gkicoqh5NDfHGjpij6zw92pQjvpa181yfnXm2/go-libp2p-circuit"
+)
+
+const testProtocol = "/nat/test/1.0.0"
+
+// newTestHosts constructs n hosts with the p2p-circuit transport, the second
+// one a relay, and the function closing them
+func newTestHosts(t *testing.T, n int) ([]host.Host, func()) {
+	// the relayed connections are accepted until the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	var hosts []host.Host
+	closeHosts := func() {
+		cancel()
+		for _, h := range hosts {
+			h.Close()
+		}
+	}
+	for i := 0; i < n; i++ {
+		s := swarmt.GenSwarm(t, ctx)
+		h, err := bhost.NewHost(ctx, s, &bhost.HostOpts{})
+		if err != nil {
+			closeHosts()
+			t.Fatal(err)
+		}
+		hosts = append(hosts, h)
+		var opts []circuit.RelayOpt
+		if i == 1 {
+			opts = append(opts, circuit.OptHop)
+		}
+		if err := circuit.AddRelayTransport(ctx, h, swarmt.GenUpgrader(s), opts...); err != nil {
+			closeHosts()
+			t.Fatal(err)
+		}
+	}
+	return hosts, closeHosts
+}
+
+// connect connects the hosts but the relay to it
+func connect(t *testing.T, ctx context.Context, hosts []host.Host) {
+	for i, h := range hosts {
+		if i != 1 {
+			if err := h.Connect(ctx, hosts[1].Peerstore().PeerInfo(hosts[1].ID())); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// addrsHost is a host announcing other addresses than its own
+type addrsHost struct {
+	host.Host
+	addrs []ma.Multiaddr
+}
+
+func (h *addrsHost) Addrs() []ma.Multiaddr { return h.addrs }
+
+// waitForProtocol waits for h to know that p speaks proto
+func waitForProtocol(t *testing.T, h host.Host, p host.Host, proto string) {
+	testutil.WaitFor(t, 5*time.Second, "the protocols of the peer", func() bool {
+		protos, err := h.Peerstore().SupportsProtocols(p.ID(), proto)
+		return err == nil && len(protos) > 0
+	})
+}
+
+func TestAutoNATPublic(t *testing.T) {
+	defer func(d time.Duration) { probeDelay = d }(probeDelay)
+	probeDelay = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts, closeHosts := newTestHosts(t, 3)
+	defer closeHosts()
+
+	server := NewAutoNAT(hosts[1], time.Hour, true)
+	defer server.Close()
+	connect(t, ctx, hosts)
+	waitForProtocol(t, hosts[0], hosts[1], AutoNATProtocol)
+	// the other peer doesn't dial back
+	other := NewAutoNAT(hosts[2], time.Hour, false)
+	defer other.Close()
+	if err := hosts[0].Connect(ctx, hosts[2].Peerstore().PeerInfo(hosts[2].ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	as := NewAutoNAT(hosts[0], time.Hour, false)
+	as.Start()
+	defer as.Close()
+	testutil.WaitFor(t, 5*time.Second, "the node to be public", func() bool {
+		return as.Reachability() == ReachabilityPublic
+	})
+
+	st := as.Status()
+	if len(st.PublicAddrs) != 1 || len(st.Results) != 1 || st.Results[0].Peer != hosts[1].ID() || st.Serving {
+		t.Fatalf("unexpected status %+v", st)
+	}
+	found := false
+	for _, a := range hosts[0].Addrs() {
+		found = found || a.Equal(st.PublicAddrs[0])
+	}
+	if !found {
+		t.Fatalf("expected %s among the addresses of the node", st.PublicAddrs[0])
+	}
+	if st := server.Status(); st.Served != 1 || st.Refused != 0 {
+		t.Fatalf("unexpected status of the server %+v", st)
+	}
+
+	// probing again confirms the reachability
+	waitForProtocol(t, hosts[0], hosts[1], AutoNATProtocol)
+	if err := as.Probe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := as.Status(); st.Reachability != ReachabilityPublic || st.Confidence != 1 || len(st.Results) != 2 {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestAutoNATPrivate(t *testing.T) {
+	defer func(d time.Duration) { probeDelay = d }(probeDelay)
+	probeDelay = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts, closeHosts := newTestHosts(t, 3)
+	defer closeHosts()
+
+	server := NewAutoNAT(hosts[1], time.Hour, true)
+	defer server.Close()
+	connect(t, ctx, hosts)
+	waitForProtocol(t, hosts[0], hosts[1], AutoNATProtocol)
+
+	// the node announces an address nothing listens at, and an address of
+	// another host, which isn't dialed
+	h := &addrsHost{Host: hosts[0], addrs: []ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/1"),
+		ma.StringCast("/ip4/10.1.2.3/tcp/4001"),
+	}}
+	as := NewAutoNAT(h, time.Hour, false)
+	as.Start()
+	defer as.Close()
+	testutil.WaitFor(t, 5*time.Second, "the node to be private", func() bool {
+		return as.Reachability() == ReachabilityPrivate
+	})
+	if st := as.Status(); len(st.PublicAddrs) != 0 || st.Results[0].Error == "" {
+		t.Fatalf("unexpected status %+v", st)
+	}
+}
+
+func TestReachabilityConfidence(t *testing.T) {
+	as := &AutoNAT{}
+	public := []ma.Multiaddr{ma.StringCast("/ip4/1.2.3.4/tcp/4001")}
+
+	as.update(ReachabilityPublic, public)
+	as.update(ReachabilityPublic, public)
+	if as.reachability != ReachabilityPublic || as.confidence != 1 || len(as.publicAddrs) != 1 {
+		t.Fatalf("unexpected reachability %s, confidence %d", as.reachability, as.confidence)
+	}
+
+	// a probe failing once doesn't make the node private, twice does
+	as.update(ReachabilityPrivate, nil)
+	if as.reachability != ReachabilityPublic || as.confidence != 0 {
+		t.Fatalf("unexpected reachability %s, confidence %d", as.reachability, as.confidence)
+	}
+	as.update(ReachabilityPrivate, nil)
+	if as.reachability != ReachabilityPrivate || len(as.publicAddrs) != 0 {
+		t.Fatalf("unexpected reachability %s", as.reachability)
+	}
+
+	for i := 0; i < 5; i++ {
+		as.update(ReachabilityPrivate, nil)
+	}
+	if as.confidence != maxConfidence {
+		t.Fatalf("expected the confidence to stop at %d, got %d", maxConfidence, as.confidence)
+	}
+}
+
+func TestHolePunch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts, closeHosts := newTestHosts(t, 3)
+	defer closeHosts()
+
+	var hps []*HolePuncher
+	for _, h := range hosts {
+		hp := NewHolePuncher(h)
+		hp.Start()
+		defer hp.Close()
+		hps = append(hps, hp)
+	}
+	connect(t, ctx, hosts)
+	hosts[2].SetStreamHandler(testProtocol, func(s inet.Stream) { s.Close() })
+
+	// the first host connects to the last one through the relay, knowing
+	// its relayed address only
+	relayed := ma.StringCast(fmt.Sprintf("/ipfs/%s/p2p-circuit", hosts[1].ID().Pretty()))
+	src, dst := hosts[0], hosts[2]
+	if err := src.Connect(ctx, pstore.PeerInfo{ID: dst.ID(), Addrs: []ma.Multiaddr{relayed}}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, 5*time.Second, "the direct connection", func() bool {
+		return directConn(src.Network(), dst.ID()) != nil && directConn(dst.Network(), src.ID()) != nil
+	})
+
+	// the peer behind the relay started the hole punch
+	testutil.WaitFor(t, 5*time.Second, "the hole punches to end", func() bool {
+		return len(hps[0].Status().Results) == 1 && len(hps[2].Status().Results) == 1
+	})
+	for i, initiator := range map[int]bool{0: false, 2: true} {
+		st := hps[i].Status()
+		res := st.Results[0]
+		if st.Attempts != 1 || st.Successes != 1 || res.Initiator != initiator || res.Error != "" || isRelayed(res.Addr) {
+			t.Fatalf("unexpected status of host %d: %+v %+v", i, st, res)
+		}
+	}
+
+	// only the initiator dialed
+	if directConn(src.Network(), dst.ID()).Stat().Direction != inet.DirInbound {
+		t.Fatal("expected the responder to accept the direct connection")
+	}
+
+	// the new streams go through the direct connection
+	s, err := src.NewStream(ctx, dst.ID(), testProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if isRelayed(s.Conn().RemoteMultiaddr()) {
+		t.Fatal("expected the stream to go through the direct connection")
+	}
+}
+
+func TestHolePunchUndialable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts, closeHosts := newTestHosts(t, 3)
+	defer closeHosts()
+
+	// the last host announces an address nobody listens on, as if it were
+	// behind a NAT
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", l.Addr().(*net.TCPAddr).Port))
+	l.Close()
+
+	var hps []*HolePuncher
+	for i, h := range hosts {
+		if i == 2 {
+			h = &addrsHost{Host: h, addrs: []ma.Multiaddr{closed}}
+		}
+		hp := NewHolePuncher(h)
+		hps = append(hps, hp)
+	}
+	punched := make(chan []ma.Multiaddr, 1)
+	const punchDelay = 100 * time.Millisecond
+	hps[0].punch = func(addrs []ma.Multiaddr) {
+		hps[0].openNAT(addrs)
+		time.Sleep(punchDelay)
+		punched <- addrs
+	}
+	for _, hp := range hps {
+		hp.Start()
+		defer hp.Close()
+	}
+	connect(t, ctx, hosts)
+
+	relayed := ma.StringCast(fmt.Sprintf("/ipfs/%s/p2p-circuit", hosts[1].ID().Pretty()))
+	src, dst := hosts[0], hosts[2]
+	if err := src.Connect(ctx, pstore.PeerInfo{ID: dst.ID(), Addrs: []ma.Multiaddr{relayed}}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, 5*time.Second, "the hole punches to end", func() bool {
+		return len(hps[0].Status().Results) == 1 && len(hps[2].Status().Results) == 1
+	})
+	for _, i := range []int{0, 2} {
+		st := hps[i].Status()
+		if res := st.Results[0]; st.Successes != 1 || res.Attempts != 1 || res.Error != "" {
+			t.Fatalf("unexpected status of host %d: %+v %+v", i, st, res)
+		}
+	}
+
+	// the responder answered once done punching
+	if rtt := hps[2].Status().Results[0].RTT; rtt < punchDelay {
+		t.Fatalf("expected the answer to wait for the punch, got a round trip of %s", rtt)
+	}
+
+	// the responder punched the address it could not dial, and accepted the
+	// connection of the initiator
+	select {
+	case addrs := <-punched:
+		if len(addrs) != 1 || !addrs[0].Equal(closed) {
+			t.Fatalf("unexpected punched addresses %v", addrs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the responder did not punch")
+	}
+	c := directConn(src.Network(), dst.ID())
+	if c == nil || c.Stat().Direction != inet.DirInbound {
+		t.Fatal("expected the responder to accept the direct connection")
+	}
+}
@@ -0,0 +1,357 @@
+package p2p
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	gonet "net"
+	"sync"
+	"time"
+
+	manet "mbfs/go-mbfs/gx/QmQVUtnrNGtCRkCMpXgpApfzQjc8FDaDVxHqWH8cnZQeh5/go-multiaddr-net"
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	net "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	protocol "mbfs/go-mbfs/gx/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// maxDatagramSize is the largest UDP payload
+const maxDatagramSize = 65535
+
+// udpSessionTimeout is how long a UDP session may stay idle before its
+// stream is closed
+var udpSessionTimeout = 2 * time.Minute
+
+// errSessionClosed is returned by operations on a closed UDP session
+var errSessionClosed = errors.New("udp session closed")
+
+func isUDP(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_UDP)
+	return err == nil
+}
+
+// datagramStream carries datagrams over a libp2p stream. Every Write sends
+// one datagram, prefixed with its length; every Read returns one datagram.
+type datagramStream struct {
+	net.Stream
+
+	rlk sync.Mutex
+	wlk sync.Mutex
+}
+
+func newDatagramStream(s net.Stream) *datagramStream {
+	return &datagramStream{Stream: s}
+}
+
+func (d *datagramStream) Read(b []byte) (int, error) {
+	d.rlk.Lock()
+	defer d.rlk.Unlock()
+
+	var hdr [2]byte
+	if _, err := io.ReadFull(d.Stream, hdr[:]); err != nil {
+		return 0, err
+	}
+	size := int(binary.BigEndian.Uint16(hdr[:]))
+	if size > len(b) {
+		// drop the datagram rather than split it
+		if _, err := io.CopyN(ioutil.Discard, d.Stream, int64(size)); err != nil {
+			return 0, err
+		}
+		return 0, io.ErrShortBuffer
+	}
+	return io.ReadFull(d.Stream, b[:size])
+}
+
+func (d *datagramStream) Write(b []byte) (int, error) {
+	if len(b) > maxDatagramSize {
+		return 0, errors.New("datagram too large")
+	}
+
+	d.wlk.Lock()
+	defer d.wlk.Unlock()
+
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+	if _, err := d.Stream.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// localUDPListener receives datagrams on a local UDP socket and forwards
+// each source address as a separate session over its own libp2p stream
+type localUDPListener struct {
+	ctx context.Context
+
+	p2p *P2P
+
+	proto protocol.ID
+	laddr ma.Multiaddr
+	peer  peer.ID
+
+	conn manet.PacketConn
+
+	lk       sync.Mutex
+	sessions map[string]*udpSession
+
+	quota *quota
+
+	done chan struct{}
+}
+
+// forwardLocalUDP forwards datagrams sent to bindAddr to a remote listener
+// whose target is a UDP address
+func (p2p *P2P) forwardLocalUDP(ctx context.Context, peer peer.ID, proto protocol.ID, bindAddr ma.Multiaddr, limits ListenerLimits) (Listener, error) {
+	conn, err := manet.ListenPacket(bindAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := &localUDPListener{
+		ctx:   ctx,
+		p2p:   p2p,
+		proto: proto,
+		peer:  peer,
+		laddr: conn.Multiaddr(),
+		conn:  conn,
+
+		sessions: make(map[string]*udpSession),
+
+		quota: newQuota(limits),
+
+		done: make(chan struct{}),
+	}
+
+	if err := p2p.ListenersLocal.Register(listener); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go listener.readDatagrams()
+
+	return listener, nil
+}
+
+func (l *localUDPListener) readDatagrams() {
+	pc := l.conn.Connection()
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if nerr, ok := err.(gonet.Error); ok && nerr.Temporary() {
+				continue
+			}
+			return
+		}
+
+		l.lk.Lock()
+		s, ok := l.sessions[addr.String()]
+		if !ok {
+			s, err = l.newSession(addr)
+			if err != nil {
+				l.lk.Unlock()
+				log.Warningf("dropping datagram from %s: %s", addr, err)
+				continue
+			}
+			l.sessions[addr.String()] = s
+			go l.setupStream(s)
+		}
+		l.lk.Unlock()
+
+		s.deliver(buf[:n])
+	}
+}
+
+func (l *localUDPListener) newSession(addr gonet.Addr) (*udpSession, error) {
+	raddr, err := manet.FromNetAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpSession{
+		l:      l,
+		addr:   addr,
+		raddr:  raddr,
+		in:     make(chan []byte, 64),
+		closed: make(chan struct{}),
+	}, nil
+}
+
+func (l *localUDPListener) setupStream(s *udpSession) {
+	if err := l.quota.acquire(); err != nil {
+		s.Close()
+		log.Warningf("refusing datagrams to %s/%s: %s", l.peer.Pretty(), l.proto, err)
+		return
+	}
+
+	cctx, cancel := context.WithTimeout(l.ctx, time.Second*30)
+	remote, err := l.p2p.peerHost.NewStream(cctx, l.peer, l.proto)
+	cancel()
+	if err != nil {
+		l.quota.release()
+		s.Close()
+		log.Warningf("failed to dial to remote %s/%s", l.peer.Pretty(), l.proto)
+		return
+	}
+
+	stream := &Stream{
+		Protocol: l.proto,
+
+		OriginAddr: s.raddr,
+		TargetAddr: l.TargetAddress(),
+		peer:       l.peer,
+
+		Local:  s,
+		Remote: newDatagramStream(remote),
+
+		Registry: l.p2p.Streams,
+
+		quota: l.quota,
+	}
+
+	l.p2p.Streams.Register(stream)
+}
+
+func (l *localUDPListener) removeSession(s *udpSession) {
+	l.lk.Lock()
+	if l.sessions[s.addr.String()] == s {
+		delete(l.sessions, s.addr.String())
+	}
+	l.lk.Unlock()
+}
+
+func (l *localUDPListener) close() {
+	l.conn.Close()
+	close(l.done)
+
+	l.lk.Lock()
+	sessions := make([]*udpSession, 0, len(l.sessions))
+	for _, s := range l.sessions {
+		sessions = append(sessions, s)
+	}
+	l.lk.Unlock()
+
+	for _, s := range sessions {
+		s.Close()
+	}
+}
+
+func (l *localUDPListener) Protocol() protocol.ID {
+	return l.proto
+}
+
+func (l *localUDPListener) ListenAddress() ma.Multiaddr {
+	return l.laddr
+}
+
+func (l *localUDPListener) TargetAddress() ma.Multiaddr {
+	addr, err := ma.NewMultiaddr(maPrefix + l.peer.Pretty())
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+func (l *localUDPListener) targetPeer() peer.ID {
+	return l.peer
+}
+
+func (l *localUDPListener) context() context.Context {
+	return l.ctx
+}
+
+func (l *localUDPListener) closed() <-chan struct{} {
+	return l.done
+}
+
+func (l *localUDPListener) Limits() ListenerLimits {
+	return l.quota.limits
+}
+
+func (l *localUDPListener) Usage() (int, int64) {
+	return l.quota.usage()
+}
+
+func (l *localUDPListener) key() string {
+	return l.ListenAddress().String()
+}
+
+// udpSession is the manet.Conn side of a forwarded UDP source address.
+// Reads return the datagrams received from that address; writes send
+// datagrams back to it. A session ends after udpSessionTimeout without
+// incoming datagrams.
+type udpSession struct {
+	l     *localUDPListener
+	addr  gonet.Addr
+	raddr ma.Multiaddr
+
+	in        chan []byte
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// deliver queues a datagram for Read, dropping it if the session is backed
+// up, as UDP would
+func (s *udpSession) deliver(b []byte) {
+	d := make([]byte, len(b))
+	copy(d, b)
+	select {
+	case s.in <- d:
+	case <-s.closed:
+	default:
+	}
+}
+
+func (s *udpSession) Read(b []byte) (int, error) {
+	timer := time.NewTimer(udpSessionTimeout)
+	defer timer.Stop()
+
+	select {
+	case d := <-s.in:
+		return copy(b, d), nil
+	case <-timer.C:
+		s.Close()
+		return 0, io.EOF
+	case <-s.closed:
+		return 0, io.EOF
+	}
+}
+
+func (s *udpSession) Write(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, errSessionClosed
+	default:
+	}
+	return s.l.conn.Connection().WriteTo(b, s.addr)
+}
+
+func (s *udpSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.l.removeSession(s)
+	})
+	return nil
+}
+
+func (s *udpSession) LocalAddr() gonet.Addr {
+	return s.l.conn.Connection().LocalAddr()
+}
+
+func (s *udpSession) RemoteAddr() gonet.Addr {
+	return s.addr
+}
+
+func (s *udpSession) LocalMultiaddr() ma.Multiaddr {
+	return s.l.laddr
+}
+
+func (s *udpSession) RemoteMultiaddr() ma.Multiaddr {
+	return s.raddr
+}
+
+func (s *udpSession) SetDeadline(t time.Time) error      { return nil }
+func (s *udpSession) SetReadDeadline(t time.Time) error  { return nil }
+func (s *udpSession) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,114 @@
+package p2p
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+// ErrStreamQuota is returned when a listener has reached its stream limit
+var ErrStreamQuota = errors.New("listener stream quota exceeded")
+
+// ErrByteQuota is returned when a listener has forwarded its byte limit
+var ErrByteQuota = errors.New("listener byte quota exceeded")
+
+// ListenerLimits restricts which peers may use a listener and how much
+// traffic it may carry
+type ListenerLimits struct {
+	// AllowedPeers lists the peers permitted to open streams to a p2p
+	// listener. An empty list allows every peer.
+	AllowedPeers []peer.ID
+
+	// MaxStreams caps the number of concurrently open streams, 0 is unlimited
+	MaxStreams int
+
+	// MaxBytes caps the total number of bytes forwarded in both directions
+	// over the lifetime of the listener, 0 is unlimited
+	MaxBytes int64
+}
+
+// quota tracks usage of a single listener against its limits
+type quota struct {
+	limits  ListenerLimits
+	allowed map[peer.ID]struct{}
+
+	lk      sync.Mutex
+	streams int
+	bytes   int64
+}
+
+func newQuota(limits ListenerLimits) *quota {
+	q := &quota{limits: limits}
+	if len(limits.AllowedPeers) > 0 {
+		q.allowed = make(map[peer.ID]struct{}, len(limits.AllowedPeers))
+		for _, p := range limits.AllowedPeers {
+			q.allowed[p] = struct{}{}
+		}
+	}
+	return q
+}
+
+// allows reports whether p may open streams
+func (q *quota) allows(p peer.ID) bool {
+	if q.allowed == nil {
+		return true
+	}
+	_, ok := q.allowed[p]
+	return ok
+}
+
+// acquire reserves a stream slot
+func (q *quota) acquire() error {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	if q.limits.MaxBytes > 0 && q.bytes >= q.limits.MaxBytes {
+		return ErrByteQuota
+	}
+	if q.limits.MaxStreams > 0 && q.streams >= q.limits.MaxStreams {
+		return ErrStreamQuota
+	}
+	q.streams++
+	return nil
+}
+
+// release frees a stream slot taken with acquire
+func (q *quota) release() {
+	q.lk.Lock()
+	q.streams--
+	q.lk.Unlock()
+}
+
+// consume accounts n forwarded bytes, failing once the byte limit is passed
+func (q *quota) consume(n int) error {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	q.bytes += int64(n)
+	if q.limits.MaxBytes > 0 && q.bytes > q.limits.MaxBytes {
+		return ErrByteQuota
+	}
+	return nil
+}
+
+// usage returns the number of open streams and bytes forwarded so far
+func (q *quota) usage() (int, int64) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	return q.streams, q.bytes
+}
+
+// quotaWriter charges everything written through it to a quota
+type quotaWriter struct {
+	w io.Writer
+	q *quota
+}
+
+func (w *quotaWriter) Write(b []byte) (int, error) {
+	if err := w.q.consume(len(b)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(b)
+}
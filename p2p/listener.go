@@ -16,6 +16,13 @@ type Listener interface {
 	ListenAddress() ma.Multiaddr
 	TargetAddress() ma.Multiaddr
 
+	// Limits returns the access and usage limits of the listener
+	Limits() ListenerLimits
+
+	// Usage returns the number of open streams and the bytes forwarded
+	// through the listener so far
+	Usage() (streams int, bytes int64)
+
 	key() string
 
 	// close closes the listener. Does not affect child streams
@@ -52,9 +59,24 @@ func newListenersP2P(host p2phost.Host) *Listeners {
 		defer reg.RUnlock()
 
 		l := reg.Listeners[string(stream.Protocol())]
-		if l != nil {
-			go l.(*remoteListener).handleStream(stream)
+		if l == nil {
+			return
+		}
+
+		rl := l.(*remoteListener)
+		remote := stream.Conn().RemotePeer()
+		if !rl.quota.allows(remote) {
+			log.Infof("rejecting %s stream from %s: peer not allowed", rl.proto, remote.Pretty())
+			stream.Reset()
+			return
+		}
+		if err := rl.quota.acquire(); err != nil {
+			log.Infof("rejecting %s stream from %s: %s", rl.proto, remote.Pretty(), err)
+			stream.Reset()
+			return
 		}
+
+		go rl.handleStream(stream)
 	})
 
 	return reg
@@ -23,15 +23,29 @@ type localListener struct {
 	peer  peer.ID
 
 	listener manet.Listener
+
+	quota *quota
+
+	done chan struct{}
 }
 
-// ForwardLocal creates new P2P stream to a remote listener
-func (p2p *P2P) ForwardLocal(ctx context.Context, peer peer.ID, proto protocol.ID, bindAddr ma.Multiaddr) (Listener, error) {
+// ForwardLocal creates new P2P stream to a remote listener. The stream and
+// byte limits apply to the connections accepted on bindAddr. If bindAddr is
+// a UDP address, datagrams are forwarded instead, see forwardLocalUDP.
+func (p2p *P2P) ForwardLocal(ctx context.Context, peer peer.ID, proto protocol.ID, bindAddr ma.Multiaddr, limits ListenerLimits) (Listener, error) {
+	if isUDP(bindAddr) {
+		return p2p.forwardLocalUDP(ctx, peer, proto, bindAddr, limits)
+	}
+
 	listener := &localListener{
 		ctx:   ctx,
 		p2p:   p2p,
 		proto: proto,
 		peer:  peer,
+
+		quota: newQuota(limits),
+
+		done: make(chan struct{}),
 	}
 
 	maListener, err := manet.Listen(bindAddr)
@@ -73,8 +87,15 @@ func (l *localListener) acceptConns() {
 }
 
 func (l *localListener) setupStream(local manet.Conn) {
+	if err := l.quota.acquire(); err != nil {
+		local.Close()
+		log.Warningf("refusing connection to %s/%s: %s", l.peer.Pretty(), l.proto, err)
+		return
+	}
+
 	remote, err := l.dial(l.ctx)
 	if err != nil {
+		l.quota.release()
 		local.Close()
 		log.Warningf("failed to dial to remote %s/%s", l.peer.Pretty(), l.proto)
 		return
@@ -91,13 +112,36 @@ func (l *localListener) setupStream(local manet.Conn) {
 		Remote: remote,
 
 		Registry: l.p2p.Streams,
+
+		quota: l.quota,
 	}
 
 	l.p2p.Streams.Register(stream)
 }
 
+func (l *localListener) targetPeer() peer.ID {
+	return l.peer
+}
+
+func (l *localListener) context() context.Context {
+	return l.ctx
+}
+
+func (l *localListener) closed() <-chan struct{} {
+	return l.done
+}
+
+func (l *localListener) Limits() ListenerLimits {
+	return l.quota.limits
+}
+
+func (l *localListener) Usage() (int, int64) {
+	return l.quota.usage()
+}
+
 func (l *localListener) close() {
 	l.listener.Close()
+	close(l.done)
 }
 
 func (l *localListener) Protocol() protocol.ID {
@@ -0,0 +1,312 @@
+package p2p
+
+import (
+	"context"
+	"io"
+	gonet "net"
+	"strconv"
+	"testing"
+	"time"
+
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	mocknet "mbfs/go-mbfs/gx/QmXnpYYg2onGLXVxM4Q5PEFcx29k8zeJQkPeLAk9h9naxg/go-libp2p/p2p/net/mock"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+func setupPeers(t *testing.T, ctx context.Context, n int) []*P2P {
+	mn, err := mocknet.FullMeshConnected(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out []*P2P
+	for _, h := range mn.Hosts() {
+		out = append(out, NewP2P(h.ID(), h, h.Peerstore()))
+	}
+	return out
+}
+
+func tcpEcho(t *testing.T) (ma.Multiaddr, func()) {
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return tcpAddr(t, l.Addr().String()), func() { l.Close() }
+}
+
+func tcpAddr(t *testing.T, hostport string) ma.Multiaddr {
+	addr, err := gonet.ResolveTCPAddr("tcp", hostport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/" + strconv.Itoa(addr.Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func forward(t *testing.T, ctx context.Context, p *P2P, target peer.ID, limits ListenerLimits) gonet.Addr {
+	bind, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	l, err := p.ForwardLocal(ctx, target, "/x/test", bind, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := l.ListenAddress().ValueForProtocol(ma.P_TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := gonet.ResolveTCPAddr("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// roundTrip reports whether a message sent through addr comes back
+func roundTrip(addr gonet.Addr) bool {
+	c, err := gonet.DialTimeout("tcp", addr.String(), time.Second)
+	if err != nil {
+		return false
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := c.Write([]byte("hello")); err != nil {
+		return false
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return false
+	}
+	return string(buf) == "hello"
+}
+
+func TestListenerAllowedPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers := setupPeers(t, ctx, 3)
+	target, done := tcpEcho(t)
+	defer done()
+
+	server := peers[0]
+	_, err := server.ForwardRemote(ctx, "/x/test", target, ListenerLimits{
+		AllowedPeers: []peer.ID{peers[1].identity},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := forward(t, ctx, peers[1], server.identity, ListenerLimits{})
+	denied := forward(t, ctx, peers[2], server.identity, ListenerLimits{})
+
+	if !roundTrip(allowed) {
+		t.Fatal("allowed peer could not use the listener")
+	}
+	if roundTrip(denied) {
+		t.Fatal("peer outside the allow list could use the listener")
+	}
+}
+
+func TestListenerStreamQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers := setupPeers(t, ctx, 2)
+	target, done := tcpEcho(t)
+	defer done()
+
+	l, err := peers[0].ForwardRemote(ctx, "/x/test", target, ListenerLimits{MaxStreams: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := forward(t, ctx, peers[1], peers[0].identity, ListenerLimits{})
+
+	// hold one stream open
+	c, err := gonet.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if streams, _ := l.Usage(); streams != 1 {
+		t.Fatalf("expected one open stream, got %d", streams)
+	}
+
+	if roundTrip(addr) {
+		t.Fatal("second stream should have been refused")
+	}
+
+	c.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if streams, _ := l.Usage(); streams == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream slot was not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !roundTrip(addr) {
+		t.Fatal("stream should be accepted once the first one closed")
+	}
+}
+
+func TestListenerByteQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers := setupPeers(t, ctx, 2)
+	target, done := tcpEcho(t)
+	defer done()
+
+	// one round trip is 5 bytes each way
+	_, err := peers[0].ForwardRemote(ctx, "/x/test", target, ListenerLimits{MaxBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := forward(t, ctx, peers[1], peers[0].identity, ListenerLimits{})
+
+	if !roundTrip(addr) {
+		t.Fatal("first round trip should fit the quota")
+	}
+	if roundTrip(addr) {
+		t.Fatal("byte quota was not enforced")
+	}
+}
+
+func TestForwardUDP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers := setupPeers(t, ctx, 2)
+
+	echo, err := gonet.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	target, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/" + strconv.Itoa(echo.LocalAddr().(*gonet.UDPAddr).Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peers[0].ForwardRemote(ctx, "/x/udp", target, ListenerLimits{}); err != nil {
+		t.Fatal(err)
+	}
+
+	bind, _ := ma.NewMultiaddr("/ip4/127.0.0.1/udp/0")
+	l, err := peers[1].ForwardLocal(ctx, peers[0].identity, "/x/udp", bind, ListenerLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := l.ListenAddress().ValueForProtocol(ma.P_UDP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := gonet.Dial("udp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, msg := range []string{"first", "second datagram"} {
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 100)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != msg {
+			t.Fatalf("expected %q, got %q", msg, buf[:n])
+		}
+	}
+}
+
+func TestKeepConnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	oldMin, oldCheck := reconnectMinBackoff, reconnectCheckInterval
+	reconnectMinBackoff, reconnectCheckInterval = 10*time.Millisecond, 50*time.Millisecond
+	defer func() {
+		reconnectMinBackoff, reconnectCheckInterval = oldMin, oldCheck
+	}()
+
+	mn, err := mocknet.FullMeshConnected(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := mn.Hosts()
+	client := NewP2P(hosts[0].ID(), hosts[0], hosts[0].Peerstore())
+
+	bind, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	l, err := client.ForwardLocal(ctx, hosts[1].ID(), "/x/test", bind, ListenerLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.KeepConnected(l); err != nil {
+		t.Fatal(err)
+	}
+
+	waitConnected := func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for hosts[0].Network().Connectedness(hosts[1].ID()) != inet.Connected {
+			if time.Now().After(deadline) {
+				t.Fatal("forward target was not reconnected")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := mn.DisconnectPeers(hosts[0].ID(), hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	waitConnected()
+
+	// once the forward is closed, the peer is left alone
+	client.ListenersLocal.Close(func(Listener) bool { return true })
+	time.Sleep(20 * time.Millisecond)
+	if err := mn.DisconnectPeers(hosts[0].ID(), hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if hosts[0].Network().Connectedness(hosts[1].ID()) == inet.Connected {
+		t.Fatal("peer reconnected after the forward was closed")
+	}
+}
@@ -0,0 +1,97 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	net "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+var (
+	// reconnectMinBackoff is the delay before the first redial of a lost peer
+	reconnectMinBackoff = time.Second
+	// reconnectMaxBackoff caps the delay between redials
+	reconnectMaxBackoff = 5 * time.Minute
+	// reconnectCheckInterval is how often a connected peer is checked
+	reconnectCheckInterval = 30 * time.Second
+	// reconnectDialTimeout bounds a single redial
+	reconnectDialTimeout = 30 * time.Second
+)
+
+// forwarder is a local listener forwarding to a single remote peer
+type forwarder interface {
+	Listener
+
+	targetPeer() peer.ID
+	context() context.Context
+	closed() <-chan struct{}
+}
+
+// KeepConnected keeps the remote peer of a forward created with ForwardLocal
+// connected for as long as the forward is open. When the connection drops,
+// the peer is redialed with exponential backoff, so the forward works again
+// as soon as the peer comes back.
+func (p2p *P2P) KeepConnected(l Listener) error {
+	f, ok := l.(forwarder)
+	if !ok {
+		return errors.New("listener is not a forward")
+	}
+
+	go p2p.keepConnected(f.context(), f.targetPeer(), f.closed())
+	return nil
+}
+
+func (p2p *P2P) keepConnected(ctx context.Context, p peer.ID, done <-chan struct{}) {
+	disconnected := make(chan struct{}, 1)
+	nb := &net.NotifyBundle{
+		DisconnectedF: func(_ net.Network, c net.Conn) {
+			if c.RemotePeer() != p {
+				return
+			}
+			select {
+			case disconnected <- struct{}{}:
+			default:
+			}
+		},
+	}
+	p2p.peerHost.Network().Notify(nb)
+	defer p2p.peerHost.Network().StopNotify(nb)
+
+	backoff := reconnectMinBackoff
+	for {
+		wait := reconnectCheckInterval
+		if p2p.peerHost.Network().Connectedness(p) != net.Connected {
+			cctx, cancel := context.WithTimeout(ctx, reconnectDialTimeout)
+			err := p2p.peerHost.Connect(cctx, pstore.PeerInfo{ID: p})
+			cancel()
+
+			if err != nil {
+				log.Debugf("failed to reconnect to %s, retrying in %s: %s", p.Pretty(), backoff, err)
+				wait = backoff
+				backoff *= 2
+				if backoff > reconnectMaxBackoff {
+					backoff = reconnectMaxBackoff
+				}
+			} else {
+				log.Infof("reconnected to forward target %s", p.Pretty())
+				backoff = reconnectMinBackoff
+			}
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-disconnected:
+			t.Stop()
+		case <-done:
+			t.Stop()
+			return
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
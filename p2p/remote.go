@@ -20,15 +20,21 @@ type remoteListener struct {
 
 	// Address to proxy the incoming connections to
 	addr ma.Multiaddr
+
+	quota *quota
 }
 
-// ForwardRemote creates new p2p listener
-func (p2p *P2P) ForwardRemote(ctx context.Context, proto protocol.ID, addr ma.Multiaddr) (Listener, error) {
+// ForwardRemote creates new p2p listener. Only peers permitted by limits
+// may open streams to it. If addr is a UDP address, each stream carries
+// datagrams framed by a remote ForwardLocal bound to a UDP address.
+func (p2p *P2P) ForwardRemote(ctx context.Context, proto protocol.ID, addr ma.Multiaddr, limits ListenerLimits) (Listener, error) {
 	listener := &remoteListener{
 		p2p: p2p,
 
 		proto: proto,
 		addr:  addr,
+
+		quota: newQuota(limits),
 	}
 
 	if err := p2p.ListenersP2P.Register(listener); err != nil {
@@ -38,9 +44,12 @@ func (p2p *P2P) ForwardRemote(ctx context.Context, proto protocol.ID, addr ma.Mu
 	return listener, nil
 }
 
+// handleStream proxies remote to the target address. The caller must have
+// acquired a stream slot from the listener quota.
 func (l *remoteListener) handleStream(remote net.Stream) {
 	local, err := manet.Dial(l.addr)
 	if err != nil {
+		l.quota.release()
 		remote.Reset()
 		return
 	}
@@ -49,10 +58,16 @@ func (l *remoteListener) handleStream(remote net.Stream) {
 
 	peerMa, err := ma.NewMultiaddr(maPrefix + peer.Pretty())
 	if err != nil {
+		l.quota.release()
+		local.Close()
 		remote.Reset()
 		return
 	}
 
+	if isUDP(l.addr) {
+		remote = newDatagramStream(remote)
+	}
+
 	stream := &Stream{
 		Protocol: l.proto,
 
@@ -64,6 +79,8 @@ func (l *remoteListener) handleStream(remote net.Stream) {
 		Remote: remote,
 
 		Registry: l.p2p.Streams,
+
+		quota: l.quota,
 	}
 
 	l.p2p.Streams.Register(stream)
@@ -85,6 +102,14 @@ func (l *remoteListener) TargetAddress() ma.Multiaddr {
 	return l.addr
 }
 
+func (l *remoteListener) Limits() ListenerLimits {
+	return l.quota.limits
+}
+
+func (l *remoteListener) Usage() (int, int64) {
+	return l.quota.usage()
+}
+
 func (l *remoteListener) close() {}
 
 func (l *remoteListener) key() string {
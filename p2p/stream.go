@@ -28,6 +28,9 @@ type Stream struct {
 	Remote net.Stream
 
 	Registry *StreamRegistry
+
+	// quota of the listener the stream belongs to, if any
+	quota *quota
 }
 
 // close stream endpoints and deregister it
@@ -44,7 +47,7 @@ func (s *Stream) reset() error {
 
 func (s *Stream) startStreaming() {
 	go func() {
-		_, err := io.Copy(s.Local, s.Remote)
+		err := s.copy(s.Local, s.Remote)
 		if err != nil {
 			s.reset()
 		} else {
@@ -53,7 +56,7 @@ func (s *Stream) startStreaming() {
 	}()
 
 	go func() {
-		_, err := io.Copy(s.Remote, s.Local)
+		err := s.copy(s.Remote, s.Local)
 		if err != nil {
 			s.reset()
 		} else {
@@ -62,6 +65,23 @@ func (s *Stream) startStreaming() {
 	}()
 }
 
+// copy forwards data from src to dst, charging it to the listener quota.
+// Datagrams must be copied whole, so they get a buffer large enough for
+// any UDP payload.
+func (s *Stream) copy(dst io.Writer, src io.Reader) error {
+	if s.quota != nil {
+		dst = &quotaWriter{w: dst, q: s.quota}
+	}
+
+	var buf []byte
+	if _, ok := s.Remote.(*datagramStream); ok {
+		buf = make([]byte, maxDatagramSize)
+	}
+
+	_, err := io.CopyBuffer(dst, src, buf)
+	return err
+}
+
 // StreamRegistry is a collection of active incoming and outgoing proto app streams.
 type StreamRegistry struct {
 	sync.Mutex
@@ -97,6 +117,10 @@ func (r *StreamRegistry) Deregister(streamID uint64) {
 	if !ok {
 		return
 	}
+	if s.quota != nil {
+		s.quota.release()
+	}
+
 	p := s.peer
 	r.conns[p]--
 	if r.conns[p] < 1 {
@@ -504,6 +504,60 @@ func LoadPinner(d ds.Datastore, dserv, internal ipld.DAGService) (Pinner, error)
 	return p, nil
 }
 
+// RecoverPinner loads the pin set like LoadPinner, but skips the parts of
+// it which cannot be read instead of failing; the pins they held are lost.
+// It returns the errors met, one per unreadable part. Flushing the pinner
+// writes a sound pin set holding the pins which could be read.
+func RecoverPinner(d ds.Datastore, dserv, internal ipld.DAGService) (Pinner, []error) {
+	p := NewPinner(d, dserv, internal).(*pinner)
+
+	rootKey, err := d.Get(pinDatastoreKey)
+	if err == ds.ErrNotFound {
+		// nothing was ever pinned
+		return p, nil
+	}
+	if err != nil {
+		return p, []error{fmt.Errorf("cannot load pin state: %v", err)}
+	}
+	rootCid, err := cid.Cast(rootKey)
+	if err != nil {
+		return p, []error{fmt.Errorf("invalid pinning root: %v", err)}
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*5)
+	defer cancel()
+
+	root, err := internal.Get(ctx, rootCid)
+	if err != nil {
+		return p, []error{fmt.Errorf("cannot find pinning root object %s: %v", rootCid, err)}
+	}
+	rootpb, ok := root.(*mdag.ProtoNode)
+	if !ok {
+		return p, []error{fmt.Errorf("pinning root object %s: %v", rootCid, mdag.ErrNotProtobuf)}
+	}
+
+	var errs []error
+	recordInternal := p.internalPin.Add
+	recordInternal(rootCid)
+	for _, set := range []struct {
+		name string
+		pins *cid.Set
+	}{{linkRecursive, p.recursePin}, {linkDirect, p.directPin}} {
+		broken := func(c cid.Cid, err error) error {
+			errs = append(errs, fmt.Errorf("%s pins: cannot read %s: %v", set.name, c, err))
+			return nil
+		}
+		keys, err := salvageSet(ctx, internal, rootpb, set.name, recordInternal, broken)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s pins: %v", set.name, err))
+		}
+		for _, c := range keys {
+			set.pins.Add(c)
+		}
+	}
+	return p, errs
+}
+
 // DirectKeys returns a slice containing the directly pinned keys
 func (p *pinner) DirectKeys() []cid.Cid {
 	return p.directPin.Keys()
@@ -406,3 +406,62 @@ func TestPinUpdate(t *testing.T) {
 	assertPinned(t, p, c2, "c2 should be pinned still")
 	assertPinned(t, p, c1, "c1 should be pinned now")
 }
+
+func TestRecoverPinner(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv := bs.New(bstore, offline.Exchange(bstore))
+	dserv := mdag.NewDAGService(bserv)
+
+	p, errs := RecoverPinner(dstore, dserv, dserv)
+	if len(errs) != 0 || len(p.RecursiveKeys()) != 0 {
+		t.Fatal("expected an empty pinner without errors, got", errs)
+	}
+
+	_, rk := randNode()
+	_, dk := randNode()
+	p.PinWithMode(rk, Recursive)
+	p.PinWithMode(dk, Direct)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// lose the direct set
+	rootKey, err := dstore.Get(pinDatastoreKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCid, _ := cid.Cast(rootKey)
+	root, err := dserv.Get(context.Background(), rootCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, _, err := root.ResolveLink([]string{linkDirect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bstore.DeleteBlock(l.Cid); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadPinner(dstore, dserv, dserv); err == nil {
+		t.Fatal("expected loading the broken pin set to fail")
+	}
+	p, errs = RecoverPinner(dstore, dserv, dserv)
+	if len(errs) != 1 {
+		t.Fatal("expected one error, got", errs)
+	}
+	assertPinned(t, p, rk, "recursive pin was not recovered")
+	if len(p.DirectKeys()) != 0 {
+		t.Fatal("unexpected direct pins")
+	}
+
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err = LoadPinner(dstore, dserv, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, p, rk, "recursive pin was not kept")
+}
@@ -203,7 +203,11 @@ func writeHdr(n *merkledag.ProtoNode, hdr *pb.Set) error {
 
 type walkerFunc func(idx int, link *ipld.Link) error
 
-func walkItems(ctx context.Context, dag ipld.DAGService, n *merkledag.ProtoNode, fn walkerFunc, children keyObserver) error {
+// brokenObserver is told about the subtrees of a set which cannot be read.
+// They are skipped if it returns nil.
+type brokenObserver func(c cid.Cid, err error) error
+
+func walkItems(ctx context.Context, dag ipld.DAGService, n *merkledag.ProtoNode, fn walkerFunc, children keyObserver, broken brokenObserver) error {
 	hdr, err := readHdr(n)
 	if err != nil {
 		return err
@@ -221,24 +225,51 @@ func walkItems(ctx context.Context, dag ipld.DAGService, n *merkledag.ProtoNode,
 		if c.Equals(emptyKey) {
 			continue
 		}
-		subtree, err := l.GetNode(ctx, dag)
+		stpb, err := getSubtree(ctx, dag, l)
 		if err != nil {
-			return err
-		}
-
-		stpb, ok := subtree.(*merkledag.ProtoNode)
-		if !ok {
-			return merkledag.ErrNotProtobuf
+			if broken == nil {
+				return err
+			}
+			if err := broken(c, err); err != nil {
+				return err
+			}
+			continue
 		}
 
-		if err := walkItems(ctx, dag, stpb, fn, children); err != nil {
+		if err := walkItems(ctx, dag, stpb, fn, children, broken); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func getSubtree(ctx context.Context, dag ipld.DAGService, l *ipld.Link) (*merkledag.ProtoNode, error) {
+	n, err := l.GetNode(ctx, dag)
+	if err != nil {
+		return nil, err
+	}
+
+	pbn, ok := n.(*merkledag.ProtoNode)
+	if !ok {
+		return nil, merkledag.ErrNotProtobuf
+	}
+	if _, err := readHdr(pbn); err != nil {
+		return nil, err
+	}
+	return pbn, nil
+}
+
 func loadSet(ctx context.Context, dag ipld.DAGService, root *merkledag.ProtoNode, name string, internalKeys keyObserver) ([]cid.Cid, error) {
+	return readSet(ctx, dag, root, name, internalKeys, nil)
+}
+
+// salvageSet loads a set like loadSet, but skips the parts of it which
+// cannot be read, reporting them to broken
+func salvageSet(ctx context.Context, dag ipld.DAGService, root *merkledag.ProtoNode, name string, internalKeys keyObserver, broken brokenObserver) ([]cid.Cid, error) {
+	return readSet(ctx, dag, root, name, internalKeys, broken)
+}
+
+func readSet(ctx context.Context, dag ipld.DAGService, root *merkledag.ProtoNode, name string, internalKeys keyObserver, broken brokenObserver) ([]cid.Cid, error) {
 	l, err := root.GetNodeLink(name)
 	if err != nil {
 		return nil, err
@@ -247,14 +278,12 @@ func loadSet(ctx context.Context, dag ipld.DAGService, root *merkledag.ProtoNode
 	lnkc := l.Cid
 	internalKeys(lnkc)
 
-	n, err := l.GetNode(ctx, dag)
+	pbn, err := getSubtree(ctx, dag, l)
 	if err != nil {
-		return nil, err
-	}
-
-	pbn, ok := n.(*merkledag.ProtoNode)
-	if !ok {
-		return nil, merkledag.ErrNotProtobuf
+		if broken == nil {
+			return nil, err
+		}
+		return nil, broken(lnkc, err)
 	}
 
 	var res []cid.Cid
@@ -263,7 +292,7 @@ func loadSet(ctx context.Context, dag ipld.DAGService, root *merkledag.ProtoNode
 		return nil
 	}
 
-	if err := walkItems(ctx, dag, pbn, walk, internalKeys); err != nil {
+	if err := walkItems(ctx, dag, pbn, walk, internalKeys, broken); err != nil {
 		return nil, err
 	}
 	return res, nil
@@ -6,6 +6,7 @@ import (
 	pluginflatfs "mbfs/go-mbfs/plugin/plugins/flatfs"
 	pluginipldgit "mbfs/go-mbfs/plugin/plugins/git"
 	pluginlevelds "mbfs/go-mbfs/plugin/plugins/levelds"
+	plugins3ds "mbfs/go-mbfs/plugin/plugins/s3ds"
 )
 
 // DO NOT EDIT THIS FILE
@@ -17,4 +18,5 @@ var preloadPlugins = []plugin.Plugin{
 	pluginbadgerds.Plugins[0],
 	pluginflatfs.Plugins[0],
 	pluginlevelds.Plugins[0],
+	plugins3ds.Plugins[0],
 }
@@ -0,0 +1,146 @@
+package s3ds
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"mbfs/go-mbfs/plugin"
+	"mbfs/go-mbfs/repo"
+	"mbfs/go-mbfs/repo/fsrepo"
+	s3ds "mbfs/go-mbfs/thirdparty/s3ds"
+
+	flatfs "mbfs/go-mbfs/gx/QmVFboKxbVJZMJAoFdvX6q4hzvXFkbWCE8DejnqrQV4ZtN/go-ds-flatfs"
+)
+
+// Plugins is exported list of plugins that will be loaded
+var Plugins = []plugin.Plugin{
+	&s3dsPlugin{},
+}
+
+type s3dsPlugin struct{}
+
+var _ plugin.PluginDatastore = (*s3dsPlugin)(nil)
+
+func (*s3dsPlugin) Name() string {
+	return "ds-s3"
+}
+
+func (*s3dsPlugin) Version() string {
+	return "0.1.0"
+}
+
+func (*s3dsPlugin) Init() error {
+	return nil
+}
+
+func (*s3dsPlugin) DatastoreTypeName() string {
+	return "s3ds"
+}
+
+type datastoreConfig struct {
+	cfg s3ds.Config
+}
+
+// DatastoreConfigParser returns a configuration stub for an s3 datastore
+// from the given parameters. The access keys are read from the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables when
+// they are not in the config.
+func (*s3dsPlugin) DatastoreConfigParser() fsrepo.ConfigFromMap {
+	return func(params map[string]interface{}) (fsrepo.DatastoreConfig, error) {
+		var c datastoreConfig
+		var ok bool
+
+		c.cfg.Endpoint, ok = params["endpoint"].(string)
+		if !ok {
+			return nil, fmt.Errorf("'endpoint' field is missing or not string")
+		}
+		c.cfg.Bucket, ok = params["bucket"].(string)
+		if !ok {
+			return nil, fmt.Errorf("'bucket' field is missing or not string")
+		}
+
+		strs := map[string]*string{
+			"region":        &c.cfg.Region,
+			"rootDirectory": &c.cfg.RootDirectory,
+			"accessKey":     &c.cfg.AccessKey,
+			"secretKey":     &c.cfg.SecretKey,
+		}
+		for name, dst := range strs {
+			v, present := params[name]
+			if !present {
+				continue
+			}
+			if *dst, ok = v.(string); !ok {
+				return nil, fmt.Errorf("'%s' field was not a string", name)
+			}
+		}
+		if c.cfg.AccessKey == "" {
+			c.cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if c.cfg.SecretKey == "" {
+			c.cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+
+		if v, present := params["shardFunc"]; present {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("'shardFunc' field was not a string")
+			}
+			shard, err := flatfs.ParseShardFunc(s)
+			if err != nil {
+				return nil, err
+			}
+			c.cfg.ShardFunc = shard
+		}
+
+		ints := map[string]*int{
+			"cacheSize": &c.cfg.CacheSize,
+			"workers":   &c.cfg.Workers,
+		}
+		for name, dst := range ints {
+			v, present := params[name]
+			if !present {
+				continue
+			}
+			f, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("'%s' field was not a number", name)
+			}
+			*dst = int(f)
+		}
+
+		if v, present := params["timeout"]; present {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("'timeout' field was not a string")
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, err
+			}
+			c.cfg.Timeout = d
+		}
+
+		return &c, nil
+	}
+}
+
+// DiskSpec identifies the objects of the datastore, the credentials and
+// tuning parameters can change without invalidating it.
+func (c *datastoreConfig) DiskSpec() fsrepo.DiskSpec {
+	spec := map[string]interface{}{
+		"type":          "s3ds",
+		"endpoint":      c.cfg.Endpoint,
+		"bucket":        c.cfg.Bucket,
+		"rootDirectory": c.cfg.RootDirectory,
+	}
+	if c.cfg.ShardFunc != nil {
+		spec["shardFunc"] = c.cfg.ShardFunc.String()
+	}
+	return spec
+}
+
+func (c *datastoreConfig) Create(path string) (repo.Datastore, error) {
+	return s3ds.New(c.cfg)
+}
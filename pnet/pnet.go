@@ -0,0 +1,325 @@
+// Package pnet protects the connections of a private network with a pre-shared
+// swarm key, accepting a second key while the key of the network is rotated.
+//
+// Connections are encrypted as by go-libp2p-pnet, so that nodes with a single
+// key interoperate. Each side encrypts what it sends with its primary key, and
+// the key of the remote side is found from the start of what it sends, the
+// multistream header of the security negotiation.
+//
+// A key is rotated in three steps, each of them waiting for the previous one
+// to reach all the nodes:
+//
+//  1. the new key is added as the secondary key of every node, which keeps
+//     sending with the old one;
+//  2. the keys are switched, every node sending with the new key and still
+//     accepting the old one;
+//  3. the old key is revoked.
+package pnet
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	salsa20 "mbfs/go-mbfs/gx/QmNi5J1mEQKAKWbPRBEMKKYVNok9EN4MsGM4YUqPvraPEX/go-crypto-dav/salsa20"
+	pool "mbfs/go-mbfs/gx/QmQDvJoB6aJWN3sjr3xsgXqKCXf4jU5zdMXpDMsBkYVNqa/go-buffer-pool"
+	ipnet "mbfs/go-mbfs/gx/QmW7Ump7YyBMr712Ta3iEVh3ZYcfVvJaPryfbCnyE826b4/go-libp2p-interface-pnet"
+	gxpnet "mbfs/go-mbfs/gx/QmY4Q5JC4vxLEi8EpVxJM4rcRryEVtH1zRKVTAm6BKV1pg/go-libp2p-pnet"
+	mc "mbfs/go-mbfs/gx/QmYMiyZRYDmhMr2phMc4FGrYbsyzvR751BgeobnWroiq2z/go-multicodec"
+	bmux "mbfs/go-mbfs/gx/QmYMiyZRYDmhMr2phMc4FGrYbsyzvR751BgeobnWroiq2z/go-multicodec/base/mux"
+	logging "mbfs/go-mbfs/gx/QmcuXC5cxs79ro2cUuHs4HQ2bkDLJUYokwL8aivcX6HW3C/go-log"
+)
+
+var log = logging.Logger("pnet")
+
+var headerPSKv1 = mc.Header([]byte("/key/swarm/psk/1.0.0/"))
+
+// probe is what the remote side sends first once the connection is
+// protected: the multistream header of the security negotiation
+var probe = []byte("\x13/multistream/1.0.0\n")
+
+const nonceSize = 24
+
+var (
+	errShortNonce = ipnet.NewError("could not read full nonce")
+	errNoKey      = ipnet.NewError("the remote peer uses none of the swarm keys")
+)
+
+// Decode decodes a swarm key in the format of the swarm.key file
+func Decode(data []byte) (*[32]byte, error) {
+	in, err := mc.WrapTransformPathToHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := mc.ConsumeHeader(in, headerPSKv1); err != nil {
+		return nil, fmt.Errorf("malformed private network key: psk header error: %s", err)
+	}
+	in, err = mc.WrapTransformPathToHeader(in)
+	if err != nil {
+		return nil, fmt.Errorf("malformed private network key: %s", err)
+	}
+	psk := new([32]byte)
+	if err := bmux.AllBasesMux().Decoder(in).Decode(psk[:]); err != nil {
+		return nil, fmt.Errorf("malformed private network key: %s", err)
+	}
+	return psk, nil
+}
+
+// Generate returns a new random swarm key in the format of the swarm.key file
+func Generate() ([]byte, error) {
+	r, err := gxpnet.GenerateV1PSK()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Fingerprint returns the fingerprint of a key, safe to expose, in hex
+func Fingerprint(k *[32]byte) string {
+	p, err := gxpnet.NewV1ProtectorFromBytes(k)
+	if err != nil {
+		// never happens, the protector is made from the bytes as they are
+		panic(err)
+	}
+	return hex.EncodeToString(p.Fingerprint())
+}
+
+// Protector protects the connections with the primary swarm key, also
+// accepting the connections of the peers sending with the secondary key
+type Protector struct {
+	lk                 sync.Mutex
+	primary, secondary *[32]byte
+
+	// conns counts the open connections by fingerprint of the key of the
+	// remote side, and rejected the connections sending with no known key
+	conns    map[string]int
+	rejected uint64
+}
+
+var _ ipnet.Protector = (*Protector)(nil)
+
+// NewProtector constructs a protector from the swarm keys, in the format of
+// the swarm.key file. The secondary key can be nil.
+func NewProtector(primary, secondary []byte) (*Protector, error) {
+	p := &Protector{conns: make(map[string]int)}
+	if err := p.SetKeys(primary, secondary); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetKeys replaces the swarm keys. The open connections keep the keys they
+// were protected with.
+func (p *Protector) SetKeys(primary, secondary []byte) error {
+	pk, err := Decode(primary)
+	if err != nil {
+		return err
+	}
+	var sk *[32]byte
+	if secondary != nil {
+		if sk, err = Decode(secondary); err != nil {
+			return err
+		}
+		if *sk == *pk {
+			sk = nil
+		}
+	}
+
+	p.lk.Lock()
+	p.primary, p.secondary = pk, sk
+	p.lk.Unlock()
+	return nil
+}
+
+// Status is the state of the swarm keys of a protector
+type Status struct {
+	// Fingerprint is the one of the primary key, the key the node sends
+	// with, and Secondary the one of the key also accepted
+	Fingerprint string
+	Secondary   string `json:",omitempty"`
+
+	// Conns counts the open connections by the fingerprint of the key the
+	// remote peer sends with
+	Conns map[string]int
+
+	// Rejected counts the connections of the peers sending with none of
+	// the keys
+	Rejected uint64
+}
+
+// Status returns the state of the swarm keys
+func (p *Protector) Status() *Status {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	st := &Status{
+		Fingerprint: Fingerprint(p.primary),
+		Conns:       make(map[string]int, len(p.conns)),
+		Rejected:    p.rejected,
+	}
+	if p.secondary != nil {
+		st.Secondary = Fingerprint(p.secondary)
+	}
+	for fp, n := range p.conns {
+		st.Conns[fp] = n
+	}
+	return st
+}
+
+// Fingerprint implements ipnet.Protector, returning the fingerprint of the
+// primary key
+func (p *Protector) Fingerprint() []byte {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	fp, _ := hex.DecodeString(Fingerprint(p.primary))
+	return fp
+}
+
+// Protect implements ipnet.Protector
+func (p *Protector) Protect(in net.Conn) (net.Conn, error) {
+	if in == nil {
+		return nil, ipnet.NewError("insecure is nil")
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	keys := [][32]byte{*p.primary}
+	if p.secondary != nil {
+		keys = append(keys, *p.secondary)
+	}
+	return &pskConn{Conn: in, p: p, keys: keys}, nil
+}
+
+// opened counts c as a connection of a peer sending with k
+func (p *Protector) opened(c *pskConn, k *[32]byte) {
+	fp := Fingerprint(k)
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if c.closed {
+		return
+	}
+	c.fp = fp
+	p.conns[fp]++
+}
+
+func (p *Protector) closed(c *pskConn) {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	if c.fp == "" {
+		return
+	}
+	if p.conns[c.fp]--; p.conns[c.fp] <= 0 {
+		delete(p.conns, c.fp)
+	}
+}
+
+func (p *Protector) reject() {
+	p.lk.Lock()
+	p.rejected++
+	p.lk.Unlock()
+}
+
+// pskConn is a connection sending with the first of its keys and reading
+// with the one the remote side sends with
+type pskConn struct {
+	net.Conn
+	p    *Protector
+	keys [][32]byte
+
+	writeS20 cipher.Stream
+	readS20  cipher.Stream
+
+	// buf holds what was decrypted while looking for the key of the remote
+	// side, and not read yet
+	buf []byte
+
+	// fp is the fingerprint of the key of the remote side, once known,
+	// and closed tells whether the connection was closed, both guarded by
+	// the lock of the protector
+	fp     string
+	closed bool
+}
+
+func (c *pskConn) Read(out []byte) (int, error) {
+	if c.readS20 == nil {
+		if err := c.readKey(); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(c.buf) > 0 {
+		n := copy(out, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+
+	n, err := c.Conn.Read(out)
+	if n > 0 {
+		c.readS20.XORKeyStream(out[:n], out[:n])
+	}
+	return n, err
+}
+
+// readKey reads the nonce of the remote side, and finds its key
+func (c *pskConn) readKey() error {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(c.Conn, nonce); err != nil {
+		return errShortNonce
+	}
+
+	enc := make([]byte, len(probe))
+	if _, err := io.ReadFull(c.Conn, enc); err != nil {
+		return err
+	}
+	for i := range c.keys {
+		s := salsa20.New(&c.keys[i], nonce)
+		dec := make([]byte, len(enc))
+		s.XORKeyStream(dec, enc)
+		if bytes.Equal(dec, probe) {
+			c.readS20, c.buf = s, dec
+			c.p.opened(c, &c.keys[i])
+			return nil
+		}
+	}
+
+	log.Debugf("rejecting %s: none of the swarm keys matches", c.Conn.RemoteAddr())
+	c.p.reject()
+	return errNoKey
+}
+
+func (c *pskConn) Write(in []byte) (int, error) {
+	if c.writeS20 == nil {
+		nonce := make([]byte, nonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return 0, err
+		}
+		if _, err := c.Conn.Write(nonce); err != nil {
+			return 0, err
+		}
+		c.writeS20 = salsa20.New(&c.keys[0], nonce)
+	}
+
+	out := pool.Get(len(in))
+	defer pool.Put(out)
+	c.writeS20.XORKeyStream(out, in)
+	return c.Conn.Write(out)
+}
+
+func (c *pskConn) Close() error {
+	c.p.closed(c)
+	return c.Conn.Close()
+}
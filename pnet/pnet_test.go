@@ -0,0 +1,168 @@
+package pnet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	ipnet "mbfs/go-mbfs/gx/QmW7Ump7YyBMr712Ta3iEVh3ZYcfVvJaPryfbCnyE826b4/go-libp2p-interface-pnet"
+	gxpnet "mbfs/go-mbfs/gx/QmY4Q5JC4vxLEi8EpVxJM4rcRryEVtH1zRKVTAm6BKV1pg/go-libp2p-pnet"
+)
+
+func genKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newProtector(t *testing.T, primary, secondary []byte) *Protector {
+	t.Helper()
+	p, err := NewProtector(primary, secondary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// exchange sends a multistream header and a message each way between two
+// protected ends of a pipe, and returns the error of each side
+func exchange(t *testing.T, a, b ipnet.Protector) (error, error) {
+	t.Helper()
+	ca, cb := net.Pipe()
+	pa, err := a.Protect(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := b.Protect(cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := append(append([]byte{}, probe...), "hello"...)
+	talk := func(c net.Conn, errc chan<- error) {
+		go c.Write(msg)
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(c, buf); err != nil {
+			errc <- err
+			return
+		}
+		if !bytes.Equal(buf, msg) {
+			errc <- io.ErrUnexpectedEOF
+			return
+		}
+		errc <- nil
+	}
+	erra, errb := make(chan error, 1), make(chan error, 1)
+	go talk(pa, erra)
+	go talk(pb, errb)
+
+	var ea, eb error
+	for i := 0; i < 2; i++ {
+		select {
+		case ea = <-erra:
+			erra = nil
+		case eb = <-errb:
+			errb = nil
+		}
+		if ea != nil || eb != nil {
+			// unblock the other side
+			ca.Close()
+			cb.Close()
+		}
+	}
+	pa.Close()
+	pb.Close()
+	return ea, eb
+}
+
+func TestInterop(t *testing.T) {
+	key := genKey(t)
+	gx, err := gxpnet.NewProtector(bytes.NewReader(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProtector(t, key, genKey(t))
+
+	if !bytes.Equal(p.Fingerprint(), gx.Fingerprint()) {
+		t.Fatal("fingerprint differs from the one of go-libp2p-pnet")
+	}
+	if ea, eb := exchange(t, p, gx); ea != nil || eb != nil {
+		t.Fatal(ea, eb)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldKey, newKey := genKey(t), genKey(t)
+
+	// a node which got the new key, and one which switched to it
+	added := newProtector(t, oldKey, newKey)
+	switched := newProtector(t, newKey, oldKey)
+	if ea, eb := exchange(t, added, switched); ea != nil || eb != nil {
+		t.Fatal(ea, eb)
+	}
+
+	// a node which didn't get the new key yet only talks with the first
+	old := newProtector(t, oldKey, nil)
+	if ea, eb := exchange(t, added, old); ea != nil || eb != nil {
+		t.Fatal(ea, eb)
+	}
+
+	// once the old key is revoked, only the nodes which switched get in
+	revoked := newProtector(t, newKey, nil)
+	if ea, eb := exchange(t, switched, revoked); ea != nil || eb != nil {
+		t.Fatal(ea, eb)
+	}
+	if ea, _ := exchange(t, revoked, old); ea == nil {
+		t.Fatal("expected the node sending with the old key to be cut off")
+	}
+
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	pa, _ := added.Protect(ca)
+	pb, _ := newProtector(t, genKey(t), nil).Protect(cb)
+	go pb.Write(probe)
+	if _, err := pa.Read(make([]byte, 1)); err != errNoKey {
+		t.Fatalf("expected the unknown key to be rejected, got %v", err)
+	}
+	if st := added.Status(); st.Rejected != 1 {
+		t.Fatalf("expected 1 rejected connection, got %d", st.Rejected)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	oldKey, newKey := genKey(t), genKey(t)
+	p := newProtector(t, oldKey, newKey)
+
+	st := p.Status()
+	if st.Fingerprint == "" || st.Secondary == "" || st.Fingerprint == st.Secondary {
+		t.Fatalf("unexpected fingerprints %+v", st)
+	}
+
+	ca, cb := net.Pipe()
+	pa, _ := p.Protect(ca)
+	pb, _ := newProtector(t, newKey, nil).Protect(cb)
+	go pb.Write(probe)
+	if _, err := io.ReadFull(pa, make([]byte, len(probe))); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Status().Conns[st.Secondary]; n != 1 {
+		t.Fatalf("expected 1 connection with the secondary key, got %d", n)
+	}
+	pa.Close()
+	pb.Close()
+	if n := len(p.Status().Conns); n != 0 {
+		t.Fatalf("expected no connections, got %d", n)
+	}
+
+	// switching keys
+	if err := p.SetKeys(newKey, oldKey); err != nil {
+		t.Fatal(err)
+	}
+	if sw := p.Status(); sw.Fingerprint != st.Secondary || sw.Secondary != st.Fingerprint {
+		t.Fatalf("keys not switched: %+v", sw)
+	}
+}
@@ -0,0 +1,768 @@
+// Package rcmgr implements a resource-aware connection manager.
+//
+// Besides the water marks of the basic connection manager, it limits the open
+// streams, in total and by protocol, the connections from a single subnet, and
+// the memory counted for the connections and streams. The connections and
+// streams over a limit are closed starting with the ones of the least useful
+// peers: the peers of the lowest score, the sum of the values of their tags
+// and of the scores given by the scorers, e.g. for their bitswap activity.
+package rcmgr
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	ifconnmgr "mbfs/go-mbfs/gx/QmR8DgkC3Xnc1TnfH1DvZtLRzPKJBrWfeDKseeXnUY6CN5/go-libp2p-interface-connmgr"
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+	logging "mbfs/go-mbfs/gx/QmcuXC5cxs79ro2cUuHs4HQ2bkDLJUYokwL8aivcX6HW3C/go-log"
+)
+
+var log = logging.Logger("rcmgr")
+
+const (
+	// ConnMemory and StreamMemory are the memory counted against the budget
+	// for a connection and for a stream, estimates of their buffers
+	ConnMemory   = 64 << 10
+	StreamMemory = 16 << 10
+)
+
+var (
+	// silencePeriod is the minimum time between two trims of the
+	// connections
+	silencePeriod = 10 * time.Second
+	// sweepDelay is how long after a stream opens the streams are checked
+	// against the limits by protocol, for the protocol of the stream to be
+	// negotiated, and sweepInterval how often they are checked anyway
+	sweepDelay    = 500 * time.Millisecond
+	sweepInterval = 10 * time.Second
+)
+
+// Limits are the limits of a connection manager. A zero limit is no limit.
+type Limits struct {
+	// Above HighWater connections, the connections are trimmed down to
+	// LowWater, but the ones of the peers connected for less than
+	// GracePeriod.
+	LowWater    int
+	HighWater   int
+	GracePeriod time.Duration
+
+	// MaxStreams limits the open streams, and MaxStreamsPerProtocol the
+	// open streams of each protocol.
+	MaxStreams            int
+	MaxStreamsPerProtocol map[string]int
+
+	// MaxConnsPerSubnet limits the connections from a /24 IPv4 or /48 IPv6
+	// subnet. The loopback and relayed connections are not limited, and
+	// neither are the ones of the peers in their grace period, until the next
+	// connection from the subnet.
+	MaxConnsPerSubnet int
+
+	// MemoryBudget limits the memory counted for the open connections and
+	// streams, ConnMemory and StreamMemory each, in bytes.
+	MemoryBudget int64
+}
+
+// Scorer scores the usefulness of a peer. The scores of all the scorers are
+// added to the values of the tags of the peer.
+type Scorer func(peer.ID) int
+
+// Counters count what the connection manager closed.
+type Counters struct {
+	// Trimmed counts the connections closed to get back under the water
+	// marks or the memory budget, and Refused the ones closed over the
+	// limit of their subnet
+	Trimmed uint64
+	Refused uint64
+
+	// Reset counts the streams reset over a stream limit
+	Reset uint64
+}
+
+// ConnManager is a resource-aware connection manager.
+type ConnManager struct {
+	limits Limits
+	ctx    context.Context
+	sweep  chan struct{}
+
+	// limitLk serializes the closing of connections and streams over the
+	// limits, for each to count the ones the others closed
+	limitLk sync.Mutex
+
+	lk       sync.Mutex
+	scorers  map[string]Scorer
+	peers    map[peer.ID]*peerInfo
+	subnets  map[string]int
+	conns    int
+	streams  int
+	lastTrim time.Time
+	counters Counters
+}
+
+var _ ifconnmgr.ConnManager = (*ConnManager)(nil)
+
+// peerInfo is what the connection manager knows of a peer, tagged, protected
+// or connected. idleSince is when the peer was last disconnected, or when it
+// was first tagged if it never connected.
+type peerInfo struct {
+	id        peer.ID
+	firstSeen time.Time
+	idleSince time.Time
+	tags      map[string]int
+	value     int
+	protected map[string]struct{}
+	conns     map[inet.Conn]*connInfo
+	streams   map[inet.Stream]*streamInfo
+}
+
+// connInfo and streamInfo are the state of a connection and of a stream. The
+// ones closing are no longer counted.
+type connInfo struct {
+	opened  time.Time
+	subnet  string
+	closing bool
+}
+
+type streamInfo struct {
+	opened  time.Time
+	closing bool
+}
+
+// NewConnManager constructs a connection manager with the limits l, checking
+// the streams by protocol until ctx is done.
+func NewConnManager(ctx context.Context, l Limits) *ConnManager {
+	cm := &ConnManager{
+		limits:  l,
+		ctx:     ctx,
+		sweep:   make(chan struct{}, 1),
+		scorers: make(map[string]Scorer),
+		peers:   make(map[peer.ID]*peerInfo),
+		subnets: make(map[string]int),
+	}
+	if len(l.MaxStreamsPerProtocol) > 0 {
+		go cm.sweeper()
+	}
+	return cm
+}
+
+// Limits returns the limits of the connection manager.
+func (cm *ConnManager) Limits() Limits {
+	return cm.limits
+}
+
+// SetScorer sets the scorer named name, or removes it if s is nil.
+func (cm *ConnManager) SetScorer(name string, s Scorer) {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+	if s == nil {
+		delete(cm.scorers, name)
+	} else {
+		cm.scorers[name] = s
+	}
+}
+
+// getOrCreate returns the info of p. NB: cm.lk must be held
+func (cm *ConnManager) getOrCreate(p peer.ID) *peerInfo {
+	inf, ok := cm.peers[p]
+	if !ok {
+		now := time.Now()
+		inf = &peerInfo{
+			id:        p,
+			firstSeen: now,
+			idleSince: now,
+			tags:      make(map[string]int),
+			protected: make(map[string]struct{}),
+			conns:     make(map[inet.Conn]*connInfo),
+			streams:   make(map[inet.Stream]*streamInfo),
+		}
+		cm.peers[p] = inf
+	}
+	return inf
+}
+
+// forget forgets inf once it has neither tags, protections nor connections.
+// NB: cm.lk must be held
+func (cm *ConnManager) forget(inf *peerInfo) {
+	if len(inf.tags) == 0 && len(inf.protected) == 0 && len(inf.conns) == 0 {
+		delete(cm.peers, inf.id)
+	}
+}
+
+// prune forgets the peers which are neither connected nor protected, and
+// either untagged or idle for more than the grace period. NB: cm.lk must be
+// held
+func (cm *ConnManager) prune(now time.Time) {
+	for p, inf := range cm.peers {
+		if len(inf.conns) > 0 || len(inf.protected) > 0 {
+			continue
+		}
+		if len(inf.tags) == 0 || now.Sub(inf.idleSince) > cm.limits.GracePeriod {
+			delete(cm.peers, p)
+		}
+	}
+}
+
+// TagPeer implements ifconnmgr.ConnManager. Unlike the basic connection
+// manager, the tags of a peer are kept while it isn't connected, until it has
+// been idle for the grace period and the connections are trimmed.
+func (cm *ConnManager) TagPeer(p peer.ID, tag string, val int) {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+	inf := cm.getOrCreate(p)
+	inf.value += val - inf.tags[tag]
+	inf.tags[tag] = val
+}
+
+// UntagPeer implements ifconnmgr.ConnManager
+func (cm *ConnManager) UntagPeer(p peer.ID, tag string) {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+	inf, ok := cm.peers[p]
+	if !ok {
+		return
+	}
+	inf.value -= inf.tags[tag]
+	delete(inf.tags, tag)
+	cm.forget(inf)
+}
+
+// Protect keeps the connections of p from being closed by the connection
+// manager, until Unprotect is called with the same tag.
+func (cm *ConnManager) Protect(p peer.ID, tag string) {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+	cm.getOrCreate(p).protected[tag] = struct{}{}
+}
+
+// Unprotect removes the protection of p by tag, and tells whether p is still
+// protected by other tags.
+func (cm *ConnManager) Unprotect(p peer.ID, tag string) bool {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+	inf, ok := cm.peers[p]
+	if !ok {
+		return false
+	}
+	delete(inf.protected, tag)
+	cm.forget(inf)
+	return len(inf.protected) > 0
+}
+
+// GetTagInfo implements ifconnmgr.ConnManager
+func (cm *ConnManager) GetTagInfo(p peer.ID) *ifconnmgr.TagInfo {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+	inf, ok := cm.peers[p]
+	if !ok {
+		return nil
+	}
+	out := &ifconnmgr.TagInfo{
+		FirstSeen: inf.firstSeen,
+		Value:     inf.value,
+		Tags:      make(map[string]int, len(inf.tags)),
+		Conns:     make(map[string]time.Time, len(inf.conns)),
+	}
+	for t, v := range inf.tags {
+		out.Tags[t] = v
+	}
+	for c, ci := range inf.conns {
+		out.Conns[c.LocalMultiaddr().String()+"-"+c.RemoteMultiaddr().String()] = ci.opened
+	}
+	return out
+}
+
+// memory returns the memory counted for the open connections and streams.
+// NB: cm.lk must be held
+func (cm *ConnManager) memory() int64 {
+	return int64(cm.conns)*ConnMemory + int64(cm.streams)*StreamMemory
+}
+
+// needTrim tells whether the connections are to be trimmed. NB: cm.lk must
+// be held
+func (cm *ConnManager) needTrim() bool {
+	if time.Since(cm.lastTrim) < silencePeriod {
+		return false
+	}
+	over := cm.limits.HighWater > 0 && cm.conns > cm.limits.HighWater
+	return over || cm.limits.MemoryBudget > 0 && cm.memory() > cm.limits.MemoryBudget
+}
+
+// TrimOpenConns implements ifconnmgr.ConnManager, closing the connections of
+// the least useful peers, but the protected ones and the ones in their grace
+// period, down to the low water mark and the memory budget. The idle peers are
+// forgotten.
+func (cm *ConnManager) TrimOpenConns(ctx context.Context) {
+	cm.limitLk.Lock()
+	defer cm.limitLk.Unlock()
+
+	cm.lk.Lock()
+	cm.lastTrim = time.Now()
+	cm.prune(cm.lastTrim)
+	excess := 0
+	if cm.limits.LowWater > 0 && cm.conns > cm.limits.LowWater {
+		excess = cm.conns - cm.limits.LowWater
+	}
+	if over := cm.memory() - cm.limits.MemoryBudget; cm.limits.MemoryBudget > 0 && over > 0 {
+		if n := int((over + ConnMemory - 1) / ConnMemory); n > excess {
+			excess = n
+		}
+	}
+	cm.lk.Unlock()
+	if excess == 0 {
+		return
+	}
+
+	conns := cm.pickConns(cm.scores(nil), excess, nil)
+	log.Infof("trimming %d connections", len(conns))
+	cm.closeConns(conns, &cm.counters.Trimmed)
+}
+
+// limitSubnet closes the connections from subnet over its limit, but the
+// ones of the protected peers and of the peers in their grace period
+func (cm *ConnManager) limitSubnet(subnet string) {
+	cm.limitLk.Lock()
+	defer cm.limitLk.Unlock()
+
+	inSubnet := func(ci *connInfo) bool { return ci.subnet == subnet }
+
+	cm.lk.Lock()
+	excess := cm.subnets[subnet] - cm.limits.MaxConnsPerSubnet
+	cm.lk.Unlock()
+	if excess <= 0 {
+		return
+	}
+
+	conns := cm.pickConns(cm.scores(inSubnet), excess, inSubnet)
+	log.Debugf("closing %d connections from %s, over its limit", len(conns), subnet)
+	cm.closeConns(conns, &cm.counters.Refused)
+}
+
+// limitStreams resets the streams over the limit of the open streams
+func (cm *ConnManager) limitStreams() {
+	cm.limitLk.Lock()
+	defer cm.limitLk.Unlock()
+
+	cm.lk.Lock()
+	excess := cm.streams - cm.limits.MaxStreams
+	cm.lk.Unlock()
+	if excess <= 0 {
+		return
+	}
+	cm.resetStreams(cm.pickStreams(cm.scores(nil), excess, nil))
+}
+
+// sweepProtocols resets the streams over the limits of their protocols
+func (cm *ConnManager) sweepProtocols() {
+	cm.limitLk.Lock()
+	defer cm.limitLk.Unlock()
+
+	cm.lk.Lock()
+	counts := cm.protocols()
+	cm.lk.Unlock()
+
+	var scores map[peer.ID]int
+	for proto, n := range counts {
+		limit, ok := cm.limits.MaxStreamsPerProtocol[proto]
+		if !ok || n <= limit {
+			continue
+		}
+		if scores == nil {
+			scores = cm.scores(nil)
+		}
+		proto := proto
+		streams := cm.pickStreams(scores, n-limit, func(s inet.Stream) bool {
+			return string(s.Protocol()) == proto
+		})
+		log.Debugf("resetting %d %s streams, over the limit of the protocol", len(streams), proto)
+		cm.resetStreams(streams)
+	}
+}
+
+func (cm *ConnManager) sweeper() {
+	t := time.NewTicker(sweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-cm.sweep:
+			select {
+			case <-time.After(sweepDelay):
+			case <-cm.ctx.Done():
+				return
+			}
+		case <-t.C:
+		case <-cm.ctx.Done():
+			return
+		}
+		cm.sweepProtocols()
+	}
+}
+
+// protocols counts the open streams by protocol. NB: cm.lk must be held
+func (cm *ConnManager) protocols() map[string]int {
+	counts := make(map[string]int)
+	for _, inf := range cm.peers {
+		for s, si := range inf.streams {
+			if !si.closing {
+				counts[string(s.Protocol())]++
+			}
+		}
+	}
+	return counts
+}
+
+// scores returns the scores of the connected peers having a connection keep
+// accepts, or all of them if keep is nil. The scorers are called without the
+// lock held, as they can call into the connection manager, e.g. to tag peers.
+func (cm *ConnManager) scores(keep func(*connInfo) bool) map[peer.ID]int {
+	cm.lk.Lock()
+	scores := make(map[peer.ID]int)
+	for p, inf := range cm.peers {
+		for _, ci := range inf.conns {
+			if keep == nil || keep(ci) {
+				scores[p] = inf.value
+				break
+			}
+		}
+	}
+	scorers := make([]Scorer, 0, len(cm.scorers))
+	for _, s := range cm.scorers {
+		scorers = append(scorers, s)
+	}
+	cm.lk.Unlock()
+
+	for p := range scores {
+		for _, s := range scorers {
+			scores[p] += s(p)
+		}
+	}
+	return scores
+}
+
+// pickConns marks as closing, and returns, n of the open connections keep
+// accepts, or of all of them if keep is nil, the ones of the peers of the
+// lowest scores first, and the newest first for a peer. The connections of the
+// protected peers and of the peers in their grace period are left alone.
+func (cm *ConnManager) pickConns(scores map[peer.ID]int, n int, keep func(*connInfo) bool) []inet.Conn {
+	type candidate struct {
+		c     inet.Conn
+		ci    *connInfo
+		score int
+	}
+
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	now := time.Now()
+	var cands []candidate
+	for p, inf := range cm.peers {
+		if len(inf.protected) > 0 || now.Sub(inf.firstSeen) < cm.limits.GracePeriod {
+			continue
+		}
+		for c, ci := range inf.conns {
+			if !ci.closing && (keep == nil || keep(ci)) {
+				cands = append(cands, candidate{c, ci, scores[p]})
+			}
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].score != cands[j].score {
+			return cands[i].score < cands[j].score
+		}
+		return cands[i].ci.opened.After(cands[j].ci.opened)
+	})
+
+	if n > len(cands) {
+		n = len(cands)
+	}
+	out := make([]inet.Conn, 0, n)
+	for _, cand := range cands[:n] {
+		cand.ci.closing = true
+		cm.conns--
+		cm.decSubnet(cand.ci.subnet)
+		out = append(out, cand.c)
+	}
+	return out
+}
+
+// pickStreams marks as closing, and returns, n of the open streams keep
+// accepts, or of all of them if keep is nil, the ones of the peers of the
+// lowest scores first, and the newest first for a peer
+func (cm *ConnManager) pickStreams(scores map[peer.ID]int, n int, keep func(inet.Stream) bool) []inet.Stream {
+	type candidate struct {
+		s     inet.Stream
+		si    *streamInfo
+		score int
+	}
+
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	var cands []candidate
+	for p, inf := range cm.peers {
+		for s, si := range inf.streams {
+			if !si.closing && (keep == nil || keep(s)) {
+				cands = append(cands, candidate{s, si, scores[p]})
+			}
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].score != cands[j].score {
+			return cands[i].score < cands[j].score
+		}
+		return cands[i].si.opened.After(cands[j].si.opened)
+	})
+
+	if n > len(cands) {
+		n = len(cands)
+	}
+	out := make([]inet.Stream, 0, n)
+	for _, cand := range cands[:n] {
+		cand.si.closing = true
+		cm.streams--
+		out = append(out, cand.s)
+	}
+	return out
+}
+
+// closeConns closes conns in the background: the network notifies their
+// closing, which would wait for the notification that picked them.
+func (cm *ConnManager) closeConns(conns []inet.Conn, counter *uint64) {
+	cm.lk.Lock()
+	*counter += uint64(len(conns))
+	cm.lk.Unlock()
+	go func() {
+		for _, c := range conns {
+			if err := c.Close(); err != nil {
+				log.Warning("error while closing connection: ", err)
+			}
+		}
+	}()
+}
+
+// resetStreams resets streams in the background, as closeConns closes
+// connections
+func (cm *ConnManager) resetStreams(streams []inet.Stream) {
+	cm.lk.Lock()
+	cm.counters.Reset += uint64(len(streams))
+	cm.lk.Unlock()
+	go func() {
+		for _, s := range streams {
+			s.Reset()
+		}
+	}()
+}
+
+// decSubnet uncounts a connection from subnet. NB: cm.lk must be held
+func (cm *ConnManager) decSubnet(subnet string) {
+	if subnet == "" {
+		return
+	}
+	if cm.subnets[subnet]--; cm.subnets[subnet] <= 0 {
+		delete(cm.subnets, subnet)
+	}
+}
+
+// Stat is the state of a connection manager
+type Stat struct {
+	Limits Limits
+
+	// Peers is the number of connected peers, Conns and Streams the
+	// numbers of open connections and streams, and Memory the memory
+	// counted for them
+	Peers   int
+	Conns   int
+	Streams int
+	Memory  int64
+
+	// Protocols counts the open streams by protocol, and Subnets the
+	// connections by subnet
+	Protocols map[string]int
+	Subnets   map[string]int
+
+	Counters
+}
+
+// Stat returns the state of the connection manager.
+func (cm *ConnManager) Stat() *Stat {
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	st := &Stat{
+		Limits:    cm.limits,
+		Conns:     cm.conns,
+		Streams:   cm.streams,
+		Memory:    cm.memory(),
+		Protocols: cm.protocols(),
+		Subnets:   make(map[string]int, len(cm.subnets)),
+		Counters:  cm.counters,
+	}
+	for _, inf := range cm.peers {
+		if len(inf.conns) > 0 {
+			st.Peers++
+		}
+	}
+	for s, n := range cm.subnets {
+		st.Subnets[s] = n
+	}
+	return st
+}
+
+// Notifee implements ifconnmgr.ConnManager
+func (cm *ConnManager) Notifee() inet.Notifiee {
+	return (*cmNotifee)(cm)
+}
+
+type cmNotifee ConnManager
+
+func (nn *cmNotifee) cm() *ConnManager {
+	return (*ConnManager)(nn)
+}
+
+func (nn *cmNotifee) Connected(n inet.Network, c inet.Conn) {
+	cm := nn.cm()
+	subnet := Subnet(c.RemoteMultiaddr())
+
+	cm.lk.Lock()
+	inf := cm.getOrCreate(c.RemotePeer())
+	if len(inf.conns) == 0 {
+		inf.firstSeen = time.Now()
+	}
+	inf.conns[c] = &connInfo{opened: time.Now(), subnet: subnet}
+	cm.conns++
+	if subnet != "" {
+		cm.subnets[subnet]++
+	}
+	overSubnet := cm.limits.MaxConnsPerSubnet > 0 && subnet != "" && cm.subnets[subnet] > cm.limits.MaxConnsPerSubnet
+	trim := cm.needTrim()
+	if trim {
+		cm.lastTrim = time.Now()
+	}
+	cm.lk.Unlock()
+
+	if overSubnet {
+		cm.limitSubnet(subnet)
+	}
+	if trim {
+		go cm.TrimOpenConns(cm.ctx)
+	}
+}
+
+func (nn *cmNotifee) Disconnected(n inet.Network, c inet.Conn) {
+	cm := nn.cm()
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	inf, ok := cm.peers[c.RemotePeer()]
+	if !ok {
+		return
+	}
+	ci, ok := inf.conns[c]
+	if !ok {
+		return
+	}
+	delete(inf.conns, c)
+	if !ci.closing {
+		cm.conns--
+		cm.decSubnet(ci.subnet)
+	}
+	if len(inf.conns) == 0 {
+		// the streams closed with the connections
+		for _, si := range inf.streams {
+			if !si.closing {
+				cm.streams--
+			}
+		}
+		inf.streams = make(map[inet.Stream]*streamInfo)
+		inf.idleSince = time.Now()
+		cm.forget(inf)
+	}
+}
+
+func (nn *cmNotifee) OpenedStream(n inet.Network, s inet.Stream) {
+	cm := nn.cm()
+
+	cm.lk.Lock()
+	inf := cm.getOrCreate(s.Conn().RemotePeer())
+	inf.streams[s] = &streamInfo{opened: time.Now()}
+	cm.streams++
+	over := cm.limits.MaxStreams > 0 && cm.streams > cm.limits.MaxStreams
+	trim := cm.needTrim()
+	if trim {
+		cm.lastTrim = time.Now()
+	}
+	cm.lk.Unlock()
+
+	if over {
+		cm.limitStreams()
+	}
+	if len(cm.limits.MaxStreamsPerProtocol) > 0 {
+		select {
+		case cm.sweep <- struct{}{}:
+		default:
+		}
+	}
+	if trim {
+		go cm.TrimOpenConns(cm.ctx)
+	}
+}
+
+func (nn *cmNotifee) ClosedStream(n inet.Network, s inet.Stream) {
+	cm := nn.cm()
+	cm.lk.Lock()
+	defer cm.lk.Unlock()
+
+	inf, ok := cm.peers[s.Conn().RemotePeer()]
+	if !ok {
+		return
+	}
+	si, ok := inf.streams[s]
+	if !ok {
+		return
+	}
+	delete(inf.streams, s)
+	if !si.closing {
+		cm.streams--
+	}
+}
+
+func (nn *cmNotifee) Listen(n inet.Network, addr ma.Multiaddr)      {}
+func (nn *cmNotifee) ListenClose(n inet.Network, addr ma.Multiaddr) {}
+
+// Subnet returns the /24 IPv4 or /48 IPv6 subnet of a remote address, in CIDR
+// notation, or "" for a loopback or relayed address.
+func Subnet(a ma.Multiaddr) string {
+	if a == nil {
+		return ""
+	}
+	var ip net.IP
+	for _, p := range a.Protocols() {
+		switch p.Code {
+		case ma.P_IP4, ma.P_IP6:
+			if ip == nil {
+				s, err := a.ValueForProtocol(p.Code)
+				if err != nil {
+					return ""
+				}
+				ip = net.ParseIP(s)
+			}
+		default:
+			if p.Name == "p2p-circuit" {
+				return ""
+			}
+		}
+	}
+	if ip == nil || ip.IsLoopback() {
+		return ""
+	}
+
+	bits := 48
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 24
+	}
+	n := &net.IPNet{IP: ip.Mask(net.CIDRMask(bits, 8*len(ip))), Mask: net.CIDRMask(bits, 8*len(ip))}
+	return n.String()
+}
@@ -0,0 +1,306 @@
+package rcmgr
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	testutil "mbfs/go-mbfs/thirdparty/testutil"
+
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	protocol "mbfs/go-mbfs/gx/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+const testProtocol = "/rcmgr/test/1.0.0"
+
+func TestSubnet(t *testing.T) {
+	for addr, subnet := range map[string]string{
+		"/ip4/10.1.2.3/tcp/4001":          "10.1.2.0/24",
+		"/ip4/10.1.2.200/udp/4001/utp":    "10.1.2.0/24",
+		"/ip6/2001:db8:aa:bb::1/tcp/4001": "2001:db8:aa::/48",
+		"/ip4/127.0.0.1/tcp/4001":         "",
+		"/ip6/::1/tcp/4001":               "",
+	} {
+		a, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := Subnet(a); s != subnet {
+			t.Errorf("expected %s in %q, got %q", addr, subnet, s)
+		}
+	}
+}
+
+func TestTagsOfUnconnectedPeers(t *testing.T) {
+	cm := NewConnManager(context.Background(), Limits{})
+	p := peer.ID("peer")
+
+	cm.TagPeer(p, "a", 5)
+	cm.TagPeer(p, "b", 3)
+	cm.TagPeer(p, "a", 2)
+	info := cm.GetTagInfo(p)
+	if info == nil || info.Value != 5 || info.Tags["a"] != 2 {
+		t.Fatalf("unexpected tag info %+v", info)
+	}
+
+	cm.UntagPeer(p, "a")
+	cm.UntagPeer(p, "b")
+	if info := cm.GetTagInfo(p); info != nil {
+		t.Fatalf("expected an untagged peer to be forgotten, got %+v", info)
+	}
+}
+
+func TestPruneIdlePeers(t *testing.T) {
+	cm := NewConnManager(context.Background(), Limits{GracePeriod: 50 * time.Millisecond})
+	nn := cm.Notifee()
+
+	// a tagged peer which never connects, a tagged peer which disconnects, a
+	// protected one, and an untagged one only seen through a stream
+	cm.TagPeer("never", "test", 10)
+	gone := newTestConn("gone", "/ip4/10.0.1.2/tcp/4001")
+	cm.TagPeer(gone.p, "test", 10)
+	nn.Connected(nil, gone)
+	nn.Disconnected(nil, gone)
+	cm.Protect("protected", "test")
+	nn.OpenedStream(nil, newTestStream(newTestConn("stream", "/ip4/10.0.2.2/tcp/4001"), testProtocol))
+
+	cm.TrimOpenConns(context.Background())
+	if cm.GetTagInfo("stream") != nil {
+		t.Fatal("expected the untagged peer to be forgotten")
+	}
+	if cm.GetTagInfo("never") == nil || cm.GetTagInfo(gone.p) == nil {
+		t.Fatal("expected the tags to be kept for the grace period")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	cm.TrimOpenConns(context.Background())
+	if cm.GetTagInfo("never") != nil || cm.GetTagInfo(gone.p) != nil {
+		t.Fatal("expected the idle peers to be forgotten")
+	}
+	if cm.GetTagInfo("protected") == nil {
+		t.Fatal("expected the protected peer to be kept")
+	}
+	if cm.Unprotect("protected", "test") || cm.GetTagInfo("protected") != nil {
+		t.Fatal("expected the unprotected peer to be forgotten")
+	}
+}
+
+func TestSubnetLimit(t *testing.T) {
+	cm := NewConnManager(context.Background(), Limits{MaxConnsPerSubnet: 2})
+	nn := cm.Notifee()
+	b := newTestConn("b", "/ip4/10.0.1.2/tcp/4001")
+	c := newTestConn("c", "/ip4/10.0.1.3/tcp/4001")
+	d := newTestConn("d", "/ip4/10.0.1.4/tcp/4001")
+	e := newTestConn("e", "/ip4/10.0.2.5/tcp/4001")
+	local := newTestConn("local", "/ip4/127.0.0.1/tcp/4001")
+
+	// c is the most useful peer of the subnet, d the newest of the least
+	// useful ones
+	cm.SetScorer("test", func(p peer.ID) int {
+		if p == c.p {
+			return 10
+		}
+		return 0
+	})
+	for _, conn := range []*testConn{c, b, local, e, d} {
+		time.Sleep(time.Millisecond)
+		nn.Connected(nil, conn)
+	}
+
+	testutil.WaitFor(t, 5*time.Second, "the newest connection of the subnet to be closed", d.isClosed)
+	for _, conn := range []*testConn{b, c, e, local} {
+		if conn.isClosed() {
+			t.Fatalf("expected the connection of %s to stay open", conn.p)
+		}
+	}
+	st := cm.Stat()
+	if st.Conns != 4 || st.Refused != 1 || st.Subnets["10.0.1.0/24"] != 2 || st.Subnets["10.0.2.0/24"] != 1 || len(st.Subnets) != 2 {
+		t.Fatalf("unexpected stat %+v", st)
+	}
+
+	// the closed connection is no longer counted
+	nn.Disconnected(nil, d)
+	if st := cm.Stat(); st.Conns != 4 || st.Peers != 4 || st.Subnets["10.0.1.0/24"] != 2 {
+		t.Fatalf("unexpected stat %+v", st)
+	}
+}
+
+func TestSubnetLimitGraceAndProtection(t *testing.T) {
+	grace := 50 * time.Millisecond
+	cm := NewConnManager(context.Background(), Limits{MaxConnsPerSubnet: 1, GracePeriod: grace})
+	nn := cm.Notifee()
+	b := newTestConn("b", "/ip4/10.0.1.2/tcp/4001")
+	c := newTestConn("c", "/ip4/10.0.1.3/tcp/4001")
+	d := newTestConn("d", "/ip4/10.0.1.4/tcp/4001")
+
+	// c is more useful than b, which is protected
+	cm.SetScorer("test", func(p peer.ID) int {
+		if p == c.p {
+			return 10
+		}
+		return 0
+	})
+	cm.Protect(b.p, "test")
+	nn.Connected(nil, b)
+	nn.Connected(nil, c)
+	if st := cm.Stat(); st.Refused != 0 {
+		t.Fatalf("expected the connections in their grace period to be kept, got %+v", st)
+	}
+
+	// only c is neither protected nor in its grace period
+	time.Sleep(2 * grace)
+	nn.Connected(nil, d)
+	testutil.WaitFor(t, 5*time.Second, "the unprotected connection to be closed", c.isClosed)
+	if b.isClosed() || d.isClosed() {
+		t.Fatal("expected the protected and the new connections to stay open")
+	}
+	if st := cm.Stat(); st.Refused != 1 || st.Subnets["10.0.1.0/24"] != 2 {
+		t.Fatalf("unexpected stat %+v", st)
+	}
+}
+
+func TestStreamLimit(t *testing.T) {
+	cm := NewConnManager(context.Background(), Limits{MaxStreams: 2})
+	nn := cm.Notifee()
+	b := newTestConn("b", "/ip4/10.0.1.2/tcp/4001")
+	c := newTestConn("c", "/ip4/10.0.2.3/tcp/4001")
+	nn.Connected(nil, b)
+	nn.Connected(nil, c)
+	cm.TagPeer(b.p, "test", 10)
+
+	sb1, sb2, sc := newTestStream(b, testProtocol), newTestStream(b, testProtocol), newTestStream(c, testProtocol)
+	for _, s := range []*testStream{sb1, sc, sb2} {
+		nn.OpenedStream(nil, s)
+	}
+	testutil.WaitFor(t, 5*time.Second, "the stream of the least useful peer to be reset", sc.isReset)
+	if sb1.isReset() || sb2.isReset() {
+		t.Fatal("expected the streams of the tagged peer to stay open")
+	}
+	if st := cm.Stat(); st.Streams != 2 || st.Reset != 1 {
+		t.Fatalf("unexpected stat %+v", st)
+	}
+
+	// the reset stream is no longer counted
+	nn.ClosedStream(nil, sc)
+	if st := cm.Stat(); st.Streams != 2 {
+		t.Fatalf("expected 2 streams, got %d", st.Streams)
+	}
+}
+
+func TestProtocolLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cm := NewConnManager(ctx, Limits{MaxStreamsPerProtocol: map[string]int{testProtocol: 1}})
+	nn := cm.Notifee()
+	b := newTestConn("b", "/ip4/10.0.1.2/tcp/4001")
+	nn.Connected(nil, b)
+
+	s1, s2, other := newTestStream(b, testProtocol), newTestStream(b, testProtocol), newTestStream(b, "/other/1.0.0")
+	for _, s := range []*testStream{s1, other, s2} {
+		time.Sleep(time.Millisecond)
+		nn.OpenedStream(nil, s)
+	}
+	testutil.WaitFor(t, 5*time.Second, "the newest stream of the protocol to be reset", s2.isReset)
+	if s1.isReset() || other.isReset() {
+		t.Fatal("expected the other streams to stay open")
+	}
+	st := cm.Stat()
+	if st.Protocols[testProtocol] != 1 || st.Protocols["/other/1.0.0"] != 1 || st.Reset != 1 {
+		t.Fatalf("unexpected stat %+v", st)
+	}
+}
+
+func TestTrimOpenConns(t *testing.T) {
+	defer func(d time.Duration) { silencePeriod = d }(silencePeriod)
+	silencePeriod = 0
+
+	cm := NewConnManager(context.Background(), Limits{LowWater: 2, HighWater: 3})
+	nn := cm.Notifee()
+	var conns []*testConn
+	for i := 0; i < 4; i++ {
+		conns = append(conns, newTestConn(peer.ID(fmt.Sprint("p", i)), fmt.Sprintf("/ip4/10.0.%d.2/tcp/4001", i)))
+	}
+	cm.TagPeer(conns[0].p, "test", 10)
+	cm.TagPeer(conns[2].p, "test", 10)
+
+	for _, c := range conns {
+		nn.Connected(nil, c)
+	}
+	testutil.WaitFor(t, 5*time.Second, "the untagged connections to be closed", func() bool {
+		return conns[1].isClosed() && conns[3].isClosed()
+	})
+	if conns[0].isClosed() || conns[2].isClosed() {
+		t.Fatal("expected the connections of the tagged peers to stay open")
+	}
+	if st := cm.Stat(); st.Trimmed != 2 || st.Conns != 2 {
+		t.Fatalf("unexpected stat %+v", st)
+	}
+}
+
+func TestTrimMemoryBudget(t *testing.T) {
+	defer func(d time.Duration) { silencePeriod = d }(silencePeriod)
+	silencePeriod = 0
+
+	cm := NewConnManager(context.Background(), Limits{MemoryBudget: 2*ConnMemory + 2*StreamMemory})
+	nn := cm.Notifee()
+	b := newTestConn("b", "/ip4/10.0.1.2/tcp/4001")
+	c := newTestConn("c", "/ip4/10.0.2.3/tcp/4001")
+	cm.TagPeer(b.p, "test", 10)
+	nn.Connected(nil, b)
+	nn.Connected(nil, c)
+	for i := 0; i < 2; i++ {
+		nn.OpenedStream(nil, newTestStream(b, testProtocol))
+	}
+	if st := cm.Stat(); st.Memory != 2*ConnMemory+2*StreamMemory || c.isClosed() {
+		t.Fatalf("unexpected stat %+v", st)
+	}
+
+	// one more stream is over the budget
+	nn.OpenedStream(nil, newTestStream(c, testProtocol))
+	testutil.WaitFor(t, 5*time.Second, "the connection of the least useful peer to be closed", c.isClosed)
+	if b.isClosed() {
+		t.Fatal("expected the connection of the tagged peer to stay open")
+	}
+	if st := cm.Stat(); st.Trimmed != 1 || st.Memory > cm.Limits().MemoryBudget {
+		t.Fatalf("unexpected stat %+v", st)
+	}
+}
+
+// testConn and testStream are the connections and streams of the tests
+// without a network
+type testConn struct {
+	inet.Conn
+	p      peer.ID
+	addr   ma.Multiaddr
+	closed int32
+}
+
+func newTestConn(p peer.ID, addr string) *testConn {
+	return &testConn{p: p, addr: ma.StringCast(addr)}
+}
+
+func (c *testConn) RemotePeer() peer.ID           { return c.p }
+func (c *testConn) LocalMultiaddr() ma.Multiaddr  { return c.addr }
+func (c *testConn) RemoteMultiaddr() ma.Multiaddr { return c.addr }
+func (c *testConn) Close() error                  { atomic.StoreInt32(&c.closed, 1); return nil }
+func (c *testConn) isClosed() bool                { return atomic.LoadInt32(&c.closed) == 1 }
+
+type testStream struct {
+	inet.Stream
+	conn  *testConn
+	proto protocol.ID
+	reset int32
+}
+
+func newTestStream(c *testConn, proto protocol.ID) *testStream {
+	return &testStream{conn: c, proto: proto}
+}
+
+func (s *testStream) Conn() inet.Conn       { return s.conn }
+func (s *testStream) Protocol() protocol.ID { return s.proto }
+func (s *testStream) Reset() error          { atomic.StoreInt32(&s.reset, 1); return nil }
+func (s *testStream) isReset() bool         { return atomic.LoadInt32(&s.reset) == 1 }
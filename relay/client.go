@@ -0,0 +1,241 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	ma "mbfs/go-mbfs/gx/QmRKLtwMw131aK7ugC3G7ybpumMz78YrJe5dzneyindvG1/go-multiaddr"
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
+	host "mbfs/go-mbfs/gx/QmVrjR2KMe57y4YyfHdYa3yKD278gN8W7CTiqSuYmxjA7F/go-libp2p-host"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+)
+
+var (
+	// minBackoff is the delay before a reservation refused or failed is
+	// retried, doubled on each failure up to maxBackoff
+	minBackoff = 5 * time.Second
+	maxBackoff = 10 * time.Minute
+)
+
+// Reservation is a reservation held at a relay, and the limits of the
+// circuits the relay relays to the node.
+type Reservation struct {
+	Relay           peer.ID
+	Expires         time.Time
+	CircuitDuration time.Duration
+	CircuitData     int64
+}
+
+// Reserve reserves a slot at the relay p, or renews the reservation of the
+// node, connecting to it if need be.
+func Reserve(ctx context.Context, h host.Host, p peer.ID) (*Reservation, error) {
+	// the reservation is counted from the request, on the clock of the node
+	start := time.Now()
+	s, err := h.NewStream(ctx, p, ReserveProtocol)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	resp, err := readResponse(s)
+	if err != nil {
+		s.Reset()
+		return nil, err
+	}
+	inet.FullClose(s)
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("relay refused the reservation: %s", resp.Error)
+	}
+	return &Reservation{
+		Relay:           p,
+		Expires:         start.Add(resp.TTL),
+		CircuitDuration: resp.CircuitDuration,
+		CircuitData:     resp.CircuitData,
+	}, nil
+}
+
+// Client keeps reservations at relays, renewing them before they expire and
+// as soon as the connection to a relay is lost.
+type Client struct {
+	host host.Host
+	nb   *inet.NotifyBundle
+
+	lk     sync.Mutex
+	relays map[peer.ID]*relayState
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// wg waits for the loops of the relays
+	wg sync.WaitGroup
+}
+
+// relayState is the reservation at a relay. Its fields but id, addrs and
+// poke are guarded by the lock of the client.
+type relayState struct {
+	id    peer.ID
+	addrs []ma.Multiaddr
+	poke  chan struct{}
+
+	res      *Reservation
+	failures int
+	lastErr  string
+	nextTry  time.Time
+}
+
+// RelayStatus is the state of the reservation at a relay
+type RelayStatus struct {
+	Relay peer.ID
+	Addrs []ma.Multiaddr
+
+	// Reservation is the reservation held, if any, and NextTry the time of
+	// its renewal, or of the next try after Failures failures to reserve,
+	// the last one with LastError
+	Reservation *Reservation
+	Failures    int
+	LastError   string
+	NextTry     time.Time
+}
+
+// NewClient constructs a client keeping reservations at relays, once it is
+// started.
+func NewClient(h host.Host, relays []pstore.PeerInfo) *Client {
+	c := &Client{
+		host:   h,
+		relays: make(map[peer.ID]*relayState, len(relays)),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.nb = &inet.NotifyBundle{DisconnectedF: c.disconnected}
+	for _, pi := range relays {
+		h.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.PermanentAddrTTL)
+		c.relays[pi.ID] = &relayState{
+			id:    pi.ID,
+			addrs: pi.Addrs,
+			poke:  make(chan struct{}, 1),
+		}
+	}
+	return c
+}
+
+// Start starts reserving slots at the relays.
+func (c *Client) Start() {
+	c.host.Network().Notify(c.nb)
+	for _, rs := range c.relays {
+		c.wg.Add(1)
+		go c.keepReservation(rs)
+	}
+}
+
+// Close stops renewing the reservations, and untags the relays.
+func (c *Client) Close() error {
+	c.cancel()
+	c.host.Network().StopNotify(c.nb)
+	c.wg.Wait()
+	for _, rs := range c.relays {
+		c.host.ConnManager().UntagPeer(rs.id, reservationTag)
+	}
+	return nil
+}
+
+// Status returns the state of the reservations, sorted by relay.
+func (c *Client) Status() []RelayStatus {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	out := make([]RelayStatus, 0, len(c.relays))
+	for _, rs := range c.relays {
+		st := RelayStatus{
+			Relay:     rs.id,
+			Addrs:     rs.addrs,
+			Failures:  rs.failures,
+			LastError: rs.lastErr,
+			NextTry:   rs.nextTry,
+		}
+		if rs.res != nil && rs.res.Expires.After(time.Now()) {
+			res := *rs.res
+			st.Reservation = &res
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Relay < out[j].Relay })
+	return out
+}
+
+// disconnected renews the reservation at a relay as soon as the node is no
+// longer connected to it, which reconnects
+func (c *Client) disconnected(n inet.Network, conn inet.Conn) {
+	rs, ok := c.relays[conn.RemotePeer()]
+	if !ok || n.Connectedness(rs.id) == inet.Connected {
+		return
+	}
+	select {
+	case rs.poke <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) keepReservation(rs *relayState) {
+	defer c.wg.Done()
+
+	backoff := minBackoff
+	for {
+		ctx, cancel := context.WithTimeout(c.ctx, streamTimeout)
+		res, err := Reserve(ctx, c.host, rs.id)
+		cancel()
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		var wait time.Duration
+		if err != nil {
+			log.Debugf("failed to reserve a slot at relay %s, retrying in %s: %s", rs.id.Pretty(), backoff, err)
+			wait = backoff
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		} else {
+			log.Debugf("reserved a slot at relay %s until %s", rs.id.Pretty(), res.Expires)
+			c.host.ConnManager().TagPeer(rs.id, reservationTag, reservationTagValue)
+			// renew once three quarters of the reservation are over, but
+			// not sooner than minBackoff, should the relay grant too short
+			// a reservation
+			wait = time.Until(res.Expires) * 3 / 4
+			if wait < minBackoff {
+				wait = minBackoff
+			}
+			backoff = minBackoff
+		}
+
+		c.lk.Lock()
+		if err != nil {
+			rs.failures++
+			rs.lastErr = err.Error()
+			// the relay no longer counts once the reservation is over
+			if rs.res != nil && !rs.res.Expires.After(time.Now()) {
+				rs.res = nil
+				c.host.ConnManager().UntagPeer(rs.id, reservationTag)
+			}
+		} else {
+			rs.res, rs.failures, rs.lastErr = res, 0, ""
+		}
+		rs.nextTry = time.Now().Add(wait)
+		c.lk.Unlock()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-rs.poke:
+			t.Stop()
+		case <-c.ctx.Done():
+			t.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Package relay implements a relay limited to the peers holding a
+// reservation.
+//
+// A peer, typically behind a NAT, reserves a slot at the relay with the
+// reservation protocol, for ReservationTTL, and renews it before it expires.
+// The relay then relays the circuits of the p2p-circuit transport to the
+// peers holding a reservation only, up to MaxCircuits at once to each, and
+// closes each circuit after CircuitDuration or once it relayed CircuitData.
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	inet "mbfs/go-mbfs/gx/QmRKbEchaYADxSCyyjhDh4cTrUby8ftXUb8MRLBTHQYupw/go-libp2p-net"
+	host "mbfs/go-mbfs/gx/QmVrjR2KMe57y4YyfHdYa3yKD278gN8W7CTiqSuYmxjA7F/go-libp2p-host"
+	peer "mbfs/go-mbfs/gx/QmcqU6QUDSXprb1518vYDGczrTJTyGwLG9eUa5iNX4xUtS/go-libp2p-peer"
+	logging "mbfs/go-mbfs/gx/QmcuXC5cxs79ro2cUuHs4HQ2bkDLJUYokwL8aivcX6HW3C/go-log"
+	circuit "mbfs/go-mbfs/gx/QmddZ5gv3Gkicoqh5NDfHGjpij6zw92pQjvpa181yfnXm2/go-libp2p-circuit"
+)
+
+var log = logging.Logger("relay")
+
+// ReserveProtocol is the protocol of the reservations
+const ReserveProtocol = "/mbfs/relay/reserve/1.0.0"
+
+const (
+	// reservationTag is the connection manager tag of the peers holding a
+	// reservation, at the relay, and of the relays, at the peers
+	reservationTag      = "relay-reservation"
+	reservationTagValue = 100
+
+	// maxMessageSize bounds the messages of the reservation protocol
+	maxMessageSize = 4096
+)
+
+var (
+	// expireInterval is how often the expired reservations are dropped
+	expireInterval = time.Minute
+	// streamTimeout bounds a reservation exchange
+	streamTimeout = time.Minute
+)
+
+// Limits are the limits of a relay service.
+type Limits struct {
+	// ReservationTTL is how long a reservation lasts, MaxReservations the
+	// number of peers holding one, and MaxCircuits the number of circuits
+	// open at once to a peer holding one.
+	ReservationTTL  time.Duration
+	MaxReservations int
+	MaxCircuits     int
+
+	// CircuitDuration and CircuitData limit how long a circuit stays open
+	// and the bytes it relays, in both directions.
+	CircuitDuration time.Duration
+	CircuitData     int64
+}
+
+// DefaultLimits are the limits of a relay service by default.
+var DefaultLimits = Limits{
+	ReservationTTL:  time.Hour,
+	MaxReservations: 128,
+	MaxCircuits:     16,
+	CircuitDuration: 2 * time.Minute,
+	CircuitData:     128 << 10,
+}
+
+// reserveResponse is the answer of the relay to a reservation, how long it
+// lasts and the limits of the circuits if it is granted, or why it is
+// refused. The TTL is relative, as the clocks of the peers may differ.
+type reserveResponse struct {
+	TTL             time.Duration `json:",omitempty"`
+	CircuitDuration time.Duration `json:",omitempty"`
+	CircuitData     int64         `json:",omitempty"`
+	Error           string        `json:",omitempty"`
+}
+
+// Service is a relay limited to the peers holding a reservation. It admits
+// the circuits of the relay of the p2p-circuit transport as its HopLimiter.
+type Service struct {
+	host   host.Host
+	limits Limits
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lk           sync.Mutex
+	reservations map[peer.ID]*reservation
+	circuits     map[*hopCircuit]struct{}
+	refused      uint64
+}
+
+var _ circuit.HopLimiter = (*Service)(nil)
+
+type reservation struct {
+	expires  time.Time
+	circuits int
+}
+
+// hopCircuit is a circuit admitted by the service
+type hopCircuit struct {
+	relayed int64 // first, for its atomic accesses
+
+	svc      *Service
+	res      *reservation
+	src, dst peer.ID
+	opened   time.Time
+}
+
+// NewService constructs a relay service with the limits l, handling the
+// reservations of the peers of h. It is to be set as the HopLimiter of the
+// relay of h.
+func NewService(h host.Host, l Limits) *Service {
+	svc := &Service{
+		host:         h,
+		limits:       l,
+		reservations: make(map[peer.ID]*reservation),
+		circuits:     make(map[*hopCircuit]struct{}),
+	}
+	svc.ctx, svc.cancel = context.WithCancel(context.Background())
+	h.SetStreamHandler(ReserveProtocol, svc.handleReserve)
+	go svc.expireLoop()
+	return svc
+}
+
+// Close stops handling the reservations. The open circuits are left open.
+func (svc *Service) Close() error {
+	svc.host.RemoveStreamHandler(ReserveProtocol)
+	svc.cancel()
+	return nil
+}
+
+// Limits returns the limits of the service.
+func (svc *Service) Limits() Limits {
+	return svc.limits
+}
+
+func (svc *Service) handleReserve(s inet.Stream) {
+	defer inet.FullClose(s)
+	s.SetDeadline(time.Now().Add(streamTimeout))
+
+	p := s.Conn().RemotePeer()
+	var resp reserveResponse
+	expires, err := svc.reserve(p)
+	if err != nil {
+		log.Debugf("refusing the reservation of %s: %s", p.Pretty(), err)
+		resp.Error = err.Error()
+	} else {
+		log.Debugf("reserved a slot for %s until %s", p.Pretty(), expires)
+		resp.TTL = time.Until(expires)
+		resp.CircuitDuration = svc.limits.CircuitDuration
+		resp.CircuitData = svc.limits.CircuitData
+	}
+	if err := json.NewEncoder(s).Encode(&resp); err != nil {
+		log.Debugf("error writing the reservation of %s: %s", p.Pretty(), err)
+		s.Reset()
+	}
+}
+
+// reserve reserves a slot for p, or renews its reservation
+func (svc *Service) reserve(p peer.ID) (time.Time, error) {
+	svc.lk.Lock()
+	defer svc.lk.Unlock()
+
+	now := time.Now()
+	svc.expire(now)
+	r, ok := svc.reservations[p]
+	if !ok {
+		if svc.limits.MaxReservations > 0 && len(svc.reservations) >= svc.limits.MaxReservations {
+			svc.refused++
+			return time.Time{}, fmt.Errorf("all %d reservations are taken", svc.limits.MaxReservations)
+		}
+		r = &reservation{}
+		svc.reservations[p] = r
+	}
+	r.expires = now.Add(svc.limits.ReservationTTL)
+	svc.host.ConnManager().TagPeer(p, reservationTag, reservationTagValue)
+	return r.expires, nil
+}
+
+// expire drops the reservations expired at now. NB: svc.lk must be held
+func (svc *Service) expire(now time.Time) {
+	for p, r := range svc.reservations {
+		if r.expires.Before(now) {
+			delete(svc.reservations, p)
+			svc.host.ConnManager().UntagPeer(p, reservationTag)
+		}
+	}
+}
+
+func (svc *Service) expireLoop() {
+	t := time.NewTicker(expireInterval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			svc.lk.Lock()
+			svc.expire(now)
+			svc.lk.Unlock()
+		case <-svc.ctx.Done():
+			return
+		}
+	}
+}
+
+// ErrNoReservation is the error of the circuits to the peers without a
+// reservation
+var ErrNoReservation = errors.New("no reservation for the destination")
+
+// OpenCircuit implements circuit.HopLimiter, admitting the circuits to the
+// peers holding a reservation, up to MaxCircuits at once.
+func (svc *Service) OpenCircuit(src, dst peer.ID) (circuit.HopCircuit, error) {
+	svc.lk.Lock()
+	defer svc.lk.Unlock()
+
+	r, ok := svc.reservations[dst]
+	if !ok || r.expires.Before(time.Now()) {
+		svc.refused++
+		return nil, ErrNoReservation
+	}
+	if svc.limits.MaxCircuits > 0 && r.circuits >= svc.limits.MaxCircuits {
+		svc.refused++
+		return nil, fmt.Errorf("%d circuits open to the destination already", r.circuits)
+	}
+	r.circuits++
+	c := &hopCircuit{svc: svc, res: r, src: src, dst: dst, opened: time.Now()}
+	svc.circuits[c] = struct{}{}
+	return c, nil
+}
+
+func (c *hopCircuit) Limit() (time.Duration, int64) {
+	return c.svc.limits.CircuitDuration, c.svc.limits.CircuitData
+}
+
+func (c *hopCircuit) Relayed(n int64) {
+	atomic.AddInt64(&c.relayed, n)
+}
+
+func (c *hopCircuit) Close() {
+	svc := c.svc
+	svc.lk.Lock()
+	defer svc.lk.Unlock()
+	delete(svc.circuits, c)
+	c.res.circuits--
+}
+
+// ReservationStat is a reservation held at a relay service
+type ReservationStat struct {
+	Peer     peer.ID
+	Expires  time.Time
+	Circuits int
+}
+
+// CircuitStat is a circuit open through a relay service
+type CircuitStat struct {
+	Src, Dst peer.ID
+	Opened   time.Time
+	Relayed  int64
+}
+
+// Stat is the state of a relay service
+type Stat struct {
+	Limits       Limits
+	Reservations []ReservationStat
+	Circuits     []CircuitStat
+
+	// Refused counts the reservations and circuits refused
+	Refused uint64
+}
+
+// Stat returns the state of the service, the reservations sorted by expiry
+// and the circuits by age.
+func (svc *Service) Stat() *Stat {
+	svc.lk.Lock()
+	defer svc.lk.Unlock()
+
+	svc.expire(time.Now())
+	st := &Stat{
+		Limits:       svc.limits,
+		Reservations: make([]ReservationStat, 0, len(svc.reservations)),
+		Circuits:     make([]CircuitStat, 0, len(svc.circuits)),
+		Refused:      svc.refused,
+	}
+	for p, r := range svc.reservations {
+		st.Reservations = append(st.Reservations, ReservationStat{Peer: p, Expires: r.expires, Circuits: r.circuits})
+	}
+	for c := range svc.circuits {
+		st.Circuits = append(st.Circuits, CircuitStat{
+			Src:     c.src,
+			Dst:     c.dst,
+			Opened:  c.opened,
+			Relayed: atomic.LoadInt64(&c.relayed),
+		})
+	}
+	sort.Slice(st.Reservations, func(i, j int) bool {
+		return st.Reservations[i].Expires.Before(st.Reservations[j].Expires)
+	})
+	sort.Slice(st.Circuits, func(i, j int) bool {
+		return st.Circuits[i].Opened.Before(st.Circuits[j].Opened)
+	})
+	return st
+}
+
+// readResponse reads the answer of a relay to a reservation
+func readResponse(r io.Reader) (*reserveResponse, error) {
+	var resp reserveResponse
+	if err := json.NewDecoder(io.LimitReader(r, maxMessageSize)).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,297 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	testutil "mbfs/go-mbfs/thirdparty/testutil"
+
+	ifconnmgr "mbfs/go-mbfs/gx/QmR8DgkC3Xnc1TnfH1DvZtLRzPKJBrWfeDKseeXnUY6CN5/go-libp2p-interface-connmgr"
+	connmgr "mbfs/go-mbfs/gx/QmThq7QRtwjwg1DLQUQkcZcChhVih9Xfsp9m2ZYK9Jw1ri/go-libp2p-connmgr"
+	pstore "mbfs/go-mbfs/gx/QmUymf8fJtideyv3z727BcZUifGBjMZMpCJqu3Gxk5aRUk/go-libp2p-peerstore"
+	host "mbfs/go-mbfs/gx/QmVrjR2KMe57y4YyfHdYa3yKD278gN8W7CTiqSuYmxjA7F/go-libp2p-host"
+	bhost "mbfs/go-mbfs/gx/QmVzYgadebqVb6iCVvjGfgZ7HB9zoCnDgBjMqFLD5aMrFX/go-libp2p-blankhost"
+	swarm "mbfs/go-mbfs/gx/QmcYC4ayKi7bq8xecEZxHVEuTL6HREZWTTErrSRd1S3Spz/go-libp2p-swarm"
+	swarmt "mbfs/go-mbfs/gx/QmcYC4ayKi7bq8xecEZxHVEuTL6HREZWTTErrSRd1S3Spz/go-libp2p-swarm/testing"
+	circuit "mbfs/go-mbfs/gx/QmddZ5gv3Gkicoqh5NDfHGjpij6zw92pQjvpa181yfnXm2/go-libp2p-circuit"
+)
+
+// testNet is a source, a relay and two peers behind it, the relays of the
+// p2p-circuit transport of each and the relay service of the relay
+type testNet struct {
+	hosts  []host.Host
+	relays []*circuit.Relay
+	svc    *Service
+}
+
+func newTestNet(t *testing.T, ctx context.Context, l Limits) *testNet {
+	tn := &testNet{}
+	for i := 0; i < 4; i++ {
+		h := bhost.NewBlankHost(swarmt.GenSwarm(t, ctx))
+		var opts []circuit.RelayOpt
+		if i == 1 {
+			opts = append(opts, circuit.OptHop)
+		}
+		r, err := circuit.NewRelay(ctx, h, swarmt.GenUpgrader(h.Network().(*swarm.Swarm)), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tn.hosts = append(tn.hosts, h)
+		tn.relays = append(tn.relays, r)
+	}
+	tn.svc = NewService(tn.hosts[1], l)
+	tn.relays[1].SetHopLimiter(tn.svc)
+
+	for i, h := range tn.hosts {
+		if i != 1 {
+			if err := h.Connect(ctx, tn.hosts[1].Peerstore().PeerInfo(tn.hosts[1].ID())); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return tn
+}
+
+func (tn *testNet) close() {
+	tn.svc.Close()
+	for _, h := range tn.hosts {
+		h.Close()
+	}
+}
+
+// dial opens a circuit from the source to the peer i through the relay, and
+// returns what the peer i sends on it
+func (tn *testNet) dial(ctx context.Context, i int) (*circuit.Conn, error) {
+	relay := tn.hosts[1].Peerstore().PeerInfo(tn.hosts[1].ID())
+	dst := pstore.PeerInfo{ID: tn.hosts[i].ID()}
+	return tn.relays[0].DialPeer(ctx, relay, dst)
+}
+
+// serve sends msg on the next circuit accepted by the peer i
+func (tn *testNet) serve(t *testing.T, i int, msg []byte) {
+	go func() {
+		c, err := tn.relays[i].Listener().Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		c.Write(msg)
+	}()
+}
+
+func TestReservations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := DefaultLimits
+	l.MaxReservations = 1
+	tn := newTestNet(t, ctx, l)
+	defer tn.close()
+
+	if _, err := tn.dial(ctx, 2); err == nil {
+		t.Fatal("expected a circuit to a peer without a reservation to be refused")
+	}
+
+	res, err := Reserve(ctx, tn.hosts[2], tn.hosts[1].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(res.Expires); until <= 0 || until > l.ReservationTTL || res.CircuitData != l.CircuitData {
+		t.Fatalf("unexpected reservation %+v", res)
+	}
+	// renewing a reservation doesn't take a slot
+	if _, err := Reserve(ctx, tn.hosts[2], tn.hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reserve(ctx, tn.hosts[3], tn.hosts[1].ID()); err == nil {
+		t.Fatal("expected the reservation over the limit to be refused")
+	}
+
+	msg := []byte("relay works!")
+	tn.serve(t, 2, msg)
+	c, err := tn.dial(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, msg) {
+		t.Fatalf("unexpected message %q", data)
+	}
+
+	if _, err := tn.dial(ctx, 3); err == nil {
+		t.Fatal("expected a circuit to the peer refused a reservation to be refused")
+	}
+	st := tn.svc.Stat()
+	if len(st.Reservations) != 1 || st.Reservations[0].Peer != tn.hosts[2].ID() || st.Refused != 3 {
+		t.Fatalf("unexpected stat %+v", st)
+	}
+}
+
+func TestCircuitLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := DefaultLimits
+	l.MaxCircuits = 1
+	l.CircuitData = 1000
+	l.CircuitDuration = 500 * time.Millisecond
+	tn := newTestNet(t, ctx, l)
+	defer tn.close()
+	if _, err := Reserve(ctx, tn.hosts[2], tn.hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data is cut at the limit
+	tn.serve(t, 2, make([]byte, 10000))
+	c, err := tn.dial(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(c)
+	c.Close()
+	if len(data) == 0 || len(data) > 1000 {
+		t.Fatalf("expected up to 1000 bytes relayed, got %d", len(data))
+	}
+	testutil.WaitFor(t, 5*time.Second, "the circuit to close", func() bool {
+		st := tn.svc.Stat()
+		return len(st.Circuits) == 0 && st.Reservations[0].Circuits == 0
+	})
+
+	// the circuit is closed after its duration, and a single one is open
+	// at once
+	go func() {
+		c, err := tn.relays[2].Listener().Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ioutil.ReadAll(c)
+	}()
+	start := time.Now()
+	c, err = tn.dial(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, 5*time.Second, "the circuit to open", func() bool {
+		return len(tn.svc.Stat().Circuits) == 1
+	})
+	if _, err := tn.dial(ctx, 2); err == nil {
+		t.Fatal("expected a second circuit to be refused")
+	}
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, 5*time.Second, "the bytes to be counted", func() bool {
+		st := tn.svc.Stat()
+		return len(st.Circuits) == 1 && st.Circuits[0].Relayed == 5
+	})
+
+	ioutil.ReadAll(c)
+	if d := time.Since(start); d < l.CircuitDuration {
+		t.Fatalf("expected the circuit to stay open for %s, closed after %s", l.CircuitDuration, d)
+	}
+	testutil.WaitFor(t, 5*time.Second, "the circuit to close", func() bool {
+		return len(tn.svc.Stat().Circuits) == 0
+	})
+}
+
+func TestClient(t *testing.T) {
+	defer func(d time.Duration) { minBackoff = d }(minBackoff)
+	minBackoff = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := DefaultLimits
+	l.ReservationTTL = 200 * time.Millisecond
+	tn := newTestNet(t, ctx, l)
+	defer tn.close()
+
+	relay := tn.hosts[1].Peerstore().PeerInfo(tn.hosts[1].ID())
+	c := NewClient(tn.hosts[2], []pstore.PeerInfo{relay})
+	c.Start()
+	defer c.Close()
+
+	reserved := func() bool {
+		st := c.Status()
+		return len(st) == 1 && st[0].Reservation != nil
+	}
+	testutil.WaitFor(t, 5*time.Second, "the reservation", reserved)
+	first := c.Status()[0].Reservation.Expires
+	testutil.WaitFor(t, 5*time.Second, "the renewal", func() bool {
+		st := c.Status()
+		return st[0].Reservation != nil && st[0].Reservation.Expires.After(first)
+	})
+	if st := tn.svc.Stat(); len(st.Reservations) != 1 {
+		t.Fatalf("expected a reservation at the relay, got %+v", st)
+	}
+
+	// the reservation is renewed as soon as the relay is lost
+	for _, conn := range tn.hosts[2].Network().ConnsToPeer(relay.ID) {
+		conn.Close()
+	}
+	testutil.WaitFor(t, 5*time.Second, "the reconnection", func() bool {
+		return len(tn.hosts[2].Network().ConnsToPeer(relay.ID)) > 0
+	})
+}
+
+// cmHost is a host with a connection manager
+type cmHost struct {
+	host.Host
+	cm ifconnmgr.ConnManager
+}
+
+func (h *cmHost) ConnManager() ifconnmgr.ConnManager {
+	return h.cm
+}
+
+func TestClientUntag(t *testing.T) {
+	defer func(d time.Duration) { minBackoff = d }(minBackoff)
+	minBackoff = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := DefaultLimits
+	l.ReservationTTL = 200 * time.Millisecond
+	tn := newTestNet(t, ctx, l)
+	defer tn.close()
+
+	relay := tn.hosts[1].Peerstore().PeerInfo(tn.hosts[1].ID())
+	cm := connmgr.NewConnManager(10, 20, 0)
+	h := &cmHost{Host: tn.hosts[2], cm: cm}
+	// the connection manager only tags the peers it tracks
+	h.Network().Notify(cm.Notifee())
+	for _, conn := range h.Network().Conns() {
+		cm.Notifee().Connected(h.Network(), conn)
+	}
+	tagged := func() bool {
+		info := cm.GetTagInfo(relay.ID)
+		return info != nil && info.Tags[reservationTag] == reservationTagValue
+	}
+
+	// the relay is untagged once the client is closed
+	c := NewClient(h, []pstore.PeerInfo{relay})
+	c.Start()
+	testutil.WaitFor(t, 5*time.Second, "the relay to be tagged", tagged)
+	c.Close()
+	if tagged() {
+		t.Fatal("expected the relay to be untagged once the client is closed")
+	}
+
+	// and once the reservation is over, failing to be renewed
+	c = NewClient(h, []pstore.PeerInfo{relay})
+	c.Start()
+	defer c.Close()
+	testutil.WaitFor(t, 5*time.Second, "the relay to be tagged", tagged)
+	tn.svc.Close()
+	testutil.WaitFor(t, 5*time.Second, "the relay to be untagged", func() bool {
+		return !tagged()
+	})
+	if st := c.Status(); st[0].Reservation != nil || st[0].Failures == 0 {
+		t.Fatalf("expected the reservation to be lost, got %+v", st[0])
+	}
+}
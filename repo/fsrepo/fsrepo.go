@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	filestore "mbfs/go-mbfs/filestore"
 	keystore "mbfs/go-mbfs/keystore"
@@ -66,6 +67,10 @@ func (err NoRepoError) Error() string {
 const apiFile = "api"
 const swarmKeyFile = "swarm.key"
 
+// secondarySwarmKeyFile holds the swarm key also accepted while the swarm key
+// is rotated
+const secondarySwarmKeyFile = "swarm.key.secondary"
+
 const specFn = "datastore_spec"
 
 var (
@@ -167,6 +172,10 @@ func open(repoPath string) (repo.Repo, error) {
 		return nil, err
 	}
 
+	if err := r.finishDatastoreMigration(); err != nil {
+		return nil, err
+	}
+
 	if err := r.openDatastore(); err != nil {
 		return nil, err
 	}
@@ -179,12 +188,49 @@ func open(repoPath string) (repo.Repo, error) {
 		r.filemgr = filestore.NewFileManager(r.ds, filepath.Dir(r.path))
 		r.filemgr.AllowFiles = r.config.Experimental.FilestoreEnabled
 		r.filemgr.AllowUrls = r.config.Experimental.UrlstoreEnabled
+		if r.config.Experimental.UrlstoreEnabled {
+			fetcher, err := newURLFetcher(&r.config.Urlstore)
+			if err != nil {
+				return nil, err
+			}
+			r.filemgr.URLFetcher = fetcher
+		}
 	}
 
 	keepLocked = true
 	return r, nil
 }
 
+// newURLFetcher sets up the urlstore http client from the config
+func newURLFetcher(cfg *config.Urlstore) (*filestore.URLFetcher, error) {
+	var timeout time.Duration
+	if cfg.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Urlstore.Timeout: %s", err)
+		}
+	}
+
+	fetcher := filestore.NewURLFetcher(cfg.MaxConnsPerHost, timeout)
+	if cfg.Retries != nil {
+		if *cfg.Retries < 0 {
+			return nil, fmt.Errorf("invalid Urlstore.Retries: %d", *cfg.Retries)
+		}
+		fetcher.Retries = *cfg.Retries
+	}
+	for _, c := range cfg.Credentials {
+		fetcher.Credentials = append(fetcher.Credentials, filestore.URLCredential{
+			Prefix:      c.Prefix,
+			BearerToken: c.BearerToken,
+			Username:    c.Username,
+			Password:    c.Password,
+			Headers:     c.Headers,
+		})
+	}
+	return fetcher, nil
+}
+
 func newFSRepo(rpath string) (*FSRepo, error) {
 	expPath, err := homedir.Expand(filepath.Clean(rpath))
 	if err != nil {
@@ -299,6 +345,29 @@ func Init(repoPath string, conf *config.Config) error {
 	return nil
 }
 
+// Migrate upgrades the repo at repoPath to RepoVersion with the built-in
+// migrations, see mfsr.Migrate. It fails if the repo is in use.
+func Migrate(repoPath string, opts mfsr.Options) error {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	repoPath, err := homedir.Expand(filepath.Clean(repoPath))
+	if err != nil {
+		return err
+	}
+	if err := checkInitialized(repoPath); err != nil {
+		return err
+	}
+
+	lk, err := lockfile.Lock(repoPath, LockFile)
+	if err != nil {
+		return err
+	}
+	defer lk.Close()
+
+	return mfsr.Migrate(repoPath, RepoVersion, opts)
+}
+
 // LockedByOtherProcess returns true if the FSRepo is locked by another
 // process. If true, then the repo cannot be opened by this process.
 func LockedByOtherProcess(repoPath string) (bool, error) {
@@ -678,8 +747,42 @@ func (r *FSRepo) GetStorageUsage() (uint64, error) {
 }
 
 func (r *FSRepo) SwarmKey() ([]byte, error) {
+	return r.readSwarmKey(swarmKeyFile)
+}
+
+// SecondarySwarmKey returns the swarm key also accepted while the swarm key is
+// rotated, if any
+func (r *FSRepo) SecondarySwarmKey() ([]byte, error) {
+	return r.readSwarmKey(secondarySwarmKeyFile)
+}
+
+// SetSwarmKeys replaces the swarm keys, removing the secondary key if nil
+func (r *FSRepo) SetSwarmKeys(primary, secondary []byte) error {
+	if primary == nil {
+		return errors.New("no primary swarm key")
+	}
+
+	repoPath := filepath.Clean(r.path)
+	spath := filepath.Join(repoPath, secondarySwarmKeyFile)
+	if secondary != nil {
+		if err := writeFileAtomic(spath, secondary); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(repoPath, swarmKeyFile), primary); err != nil {
+		return err
+	}
+	if secondary == nil {
+		if err := os.Remove(spath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *FSRepo) readSwarmKey(name string) ([]byte, error) {
 	repoPath := filepath.Clean(r.path)
-	spath := filepath.Join(repoPath, swarmKeyFile)
+	spath := filepath.Join(repoPath, name)
 
 	f, err := os.Open(spath)
 	if err != nil {
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"mbfs/go-mbfs/filestore"
 	"mbfs/go-mbfs/thirdparty/assert"
 
 	datastore "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
@@ -116,3 +117,22 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestURLFetcherRetries(t *testing.T) {
+	fetcher, err := newURLFetcher(&config.Urlstore{})
+	assert.Nil(err, t, "an empty config should be valid")
+	if fetcher.Retries != filestore.DefaultURLRetries {
+		t.Fatalf("expected the default retries, got %d", fetcher.Retries)
+	}
+
+	none := 0
+	fetcher, err = newURLFetcher(&config.Urlstore{Retries: &none})
+	assert.Nil(err, t, "zero retries should be valid")
+	if fetcher.Retries != 0 {
+		t.Fatalf("expected no retries, got %d", fetcher.Retries)
+	}
+
+	negative := -1
+	_, err = newURLFetcher(&config.Urlstore{Retries: &negative})
+	assert.Err(err, t, "negative retries should be refused")
+}
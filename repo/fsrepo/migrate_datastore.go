@@ -0,0 +1,681 @@
+package fsrepo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	repo "mbfs/go-mbfs/repo"
+
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dsq "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/query"
+	lockfile "mbfs/go-mbfs/gx/QmcWjZkQxyPMkgZRpda4hqWwaD6E1yqCvcxZfxbt98CEAK/go-fs-lock"
+	homedir "mbfs/go-mbfs/gx/QmdcULN1WCzgoQmcCaUAmEhwcxHYsDrbZ2LvRJKCL8dMrK/go-homedir"
+)
+
+// A datastore migration copies the content of the datastore of a repo into a
+// new datastore tree, staged in the migrate-datastore directory of the repo,
+// while the repo is in use. The next time the repo is opened, the keys
+// written since are synced, the directories of the two trees are swapped and
+// the new tree is verified against the old one before the old one is
+// deleted. The switch is rolled back if any of this fails.
+//
+// The switch file exists while the directories are being swapped. It is left
+// behind by a switch that could not be rolled back, with the entries of the
+// old tree in the old directory.
+const (
+	migrationDir        = "migrate-datastore"
+	migrationStateFile  = "state.json"
+	migrationSwitchFile = "switching"
+	migrationNewDir     = "new"
+	migrationOldDir     = "old"
+)
+
+// Phases of a datastore migration
+const (
+	MigrationCopying = "copying"
+	MigrationReady   = "ready"
+	MigrationDone    = "done"
+	MigrationFailed  = "failed"
+)
+
+// DefaultMigrationBatchSize is the number of keys copied per batch.
+const DefaultMigrationBatchSize = 1000
+
+var blocksPrefix = ds.NewKey("/blocks")
+
+// MigrationState is the progress of a datastore migration, it is saved in
+// the staging directory after every batch so that an interrupted copy
+// resumes where it stopped.
+type MigrationState struct {
+	Profile string
+	Spec    map[string]interface{}
+	Phase   string
+	Copied  int
+	Skipped int
+	Error   string `json:",omitempty"`
+	Updated time.Time
+}
+
+// MigrationStatus returns the state of the datastore migration of the repo,
+// or nil if none was started.
+func MigrationStatus(repoPath string) (*MigrationState, error) {
+	fn := filepath.Join(repoPath, migrationDir, migrationStateFile)
+	b, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st MigrationState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("invalid datastore migration state %s: %s", fn, err)
+	}
+	return &st, nil
+}
+
+func saveMigrationState(repoPath string, st *MigrationState) error {
+	st.Updated = time.Now()
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(repoPath, migrationDir, migrationStateFile), b)
+}
+
+// writeFileAtomic replaces fn by a file with the given content
+func writeFileAtomic(fn string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fn), filepath.Base(fn)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fn)
+}
+
+// AbortDatastoreMigration removes a staged datastore migration. It fails
+// while the repo is in use, as the copy may be in progress, and while the
+// staged datastore is being switched to.
+func AbortDatastoreMigration(repoPath string) error {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	repoPath, err := homedir.Expand(filepath.Clean(repoPath))
+	if err != nil {
+		return err
+	}
+	lk, err := lockfile.Lock(repoPath, LockFile)
+	if err != nil {
+		return fmt.Errorf("cannot abort the datastore migration while the repo is in use: %s", err)
+	}
+	defer lk.Close()
+
+	return removeMigration(repoPath)
+}
+
+// removeMigration removes the staging directory of a datastore migration,
+// unless a switch to the staged datastore was started and not finished
+func removeMigration(repoPath string) error {
+	base := filepath.Join(repoPath, migrationDir)
+	if _, err := os.Stat(filepath.Join(base, migrationSwitchFile)); err == nil {
+		return fmt.Errorf("the switch to the staged datastore did not finish, the old datastore may be in %s", filepath.Join(base, migrationOldDir))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(base)
+}
+
+// StageDatastoreMigration copies src, the datastore of the repo at repoPath,
+// into a new datastore described by spec, in batches of batchSize keys.
+// Keys already present in the staged datastore are skipped, which makes an
+// interrupted copy resume. progress is called after every batch. Once done,
+// the repo switches to the new datastore the next time it is opened.
+func StageDatastoreMigration(ctx context.Context, repoPath string, src ds.Datastore, profile string, spec map[string]interface{}, batchSize int, progress func(*MigrationState)) error {
+	if batchSize <= 0 {
+		batchSize = DefaultMigrationBatchSize
+	}
+
+	cfg, err := ConfigAt(repoPath)
+	if err != nil {
+		return err
+	}
+	oldc, err := AnyDatastoreConfig(cfg.Datastore.Spec)
+	if err != nil {
+		return err
+	}
+	newc, err := AnyDatastoreConfig(spec)
+	if err != nil {
+		return err
+	}
+	if oldc.DiskSpec().String() == newc.DiskSpec().String() {
+		return errors.New("the repo already uses this datastore configuration")
+	}
+	if _, err := specEntries(oldc.DiskSpec(), false); err != nil {
+		return err
+	}
+	if _, err := specEntries(newc.DiskSpec(), true); err != nil {
+		return err
+	}
+
+	st, err := MigrationStatus(repoPath)
+	if err != nil {
+		return err
+	}
+	if st != nil && st.Phase != MigrationDone {
+		staged, err := AnyDatastoreConfig(st.Spec)
+		if err != nil {
+			return err
+		}
+		if staged.DiskSpec().String() != newc.DiskSpec().String() {
+			return fmt.Errorf("a migration to another datastore (%s) is already staged, abort it first", st.Profile)
+		}
+		st.Profile = profile
+		st.Spec = spec
+	} else {
+		if err := removeMigration(repoPath); err != nil {
+			return err
+		}
+		st = &MigrationState{Profile: profile, Spec: spec}
+	}
+	st.Phase = MigrationCopying
+	st.Error = ""
+
+	newPath := filepath.Join(repoPath, migrationDir, migrationNewDir)
+	if err := os.MkdirAll(newPath, 0700); err != nil {
+		return err
+	}
+	if err := saveMigrationState(repoPath, st); err != nil {
+		return err
+	}
+
+	dst, err := newc.Create(newPath)
+	if err != nil {
+		return err
+	}
+	err = copyDatastore(ctx, src, dst, st, batchSize, func() error {
+		if progress != nil {
+			progress(st)
+		}
+		return saveMigrationState(repoPath, st)
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	st.Phase = MigrationReady
+	if progress != nil {
+		progress(st)
+	}
+	return saveMigrationState(repoPath, st)
+}
+
+// copyDatastore puts the keys of src missing from dst in dst, calling
+// checkpoint after every batch
+func copyDatastore(ctx context.Context, src ds.Datastore, dst repo.Datastore, st *MigrationState, batchSize int, checkpoint func() error) error {
+	res, err := src.Query(dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	// some datastores don't allow batches to be reused once committed
+	b, err := dst.Batch()
+	if err != nil {
+		return err
+	}
+	pending := 0
+	commit := func() error {
+		if err := b.Commit(); err != nil {
+			return err
+		}
+		st.Copied += pending
+		pending = 0
+		if b, err = dst.Batch(); err != nil {
+			return err
+		}
+		return checkpoint()
+	}
+
+	for {
+		r, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if r.Error != nil {
+			return r.Error
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := commit(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		default:
+		}
+
+		k := ds.RawKey(r.Key)
+		has, err := dst.Has(k)
+		if err != nil {
+			return err
+		}
+		if has {
+			st.Skipped++
+			continue
+		}
+
+		v, err := src.Get(k)
+		if err == ds.ErrNotFound {
+			// deleted since the query
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := b.Put(k, v); err != nil {
+			return err
+		}
+
+		pending++
+		if pending >= batchSize {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+	return commit()
+}
+
+// syncDatastore makes dst hold the keys of src. Values of blocks are content
+// addressed and only compared by key.
+func syncDatastore(src, dst ds.Datastore) (int, error) {
+	changed := 0
+	err := forEachKey(src, func(k ds.Key) error {
+		v, err := src.Get(k)
+		if err == ds.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if blocksPrefix.IsAncestorOf(k) {
+			has, err := dst.Has(k)
+			if err != nil || has {
+				return err
+			}
+		} else {
+			old, err := dst.Get(k)
+			if err != nil && err != ds.ErrNotFound {
+				return err
+			}
+			if err == nil && bytes.Equal(old, v) {
+				return nil
+			}
+		}
+		changed++
+		return dst.Put(k, v)
+	})
+	if err != nil {
+		return changed, err
+	}
+
+	var removed []ds.Key
+	err = forEachKey(dst, func(k ds.Key) error {
+		has, err := src.Has(k)
+		if err == nil && !has {
+			removed = append(removed, k)
+		}
+		return err
+	})
+	if err != nil {
+		return changed, err
+	}
+	for _, k := range removed {
+		if err := dst.Delete(k); err != nil && err != ds.ErrNotFound {
+			return changed, err
+		}
+	}
+	return changed + len(removed), nil
+}
+
+// verifyDatastore checks that dst holds exactly the keys and values of src.
+// It is a variable for testing.
+var verifyDatastore = func(src, dst ds.Datastore) error {
+	n := 0
+	err := forEachKey(src, func(k ds.Key) error {
+		v, err := src.Get(k)
+		if err != nil {
+			return err
+		}
+		nv, err := dst.Get(k)
+		if err != nil {
+			return fmt.Errorf("%s: %s", k, err)
+		}
+		if !bytes.Equal(v, nv) {
+			return fmt.Errorf("%s: values differ", k)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m := 0
+	err = forEachKey(dst, func(ds.Key) error {
+		m++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if n != m {
+		return fmt.Errorf("the new datastore has %d keys, expected %d", m, n)
+	}
+	return nil
+}
+
+func forEachKey(d ds.Datastore, f func(ds.Key) error) error {
+	res, err := d.Query(dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		if err := f(ds.RawKey(r.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// specEntries returns the entries of the repo directory the datastores of a
+// spec are stored in. Absolute paths are outside the repo, they are skipped
+// unless the spec is to be staged, which they can't be.
+func specEntries(spec interface{}, staged bool) ([]string, error) {
+	var out []string
+	var walk func(v interface{}) error
+	walk = func(v interface{}) error {
+		switch v := v.(type) {
+		case DiskSpec:
+			return walk(map[string]interface{}(v))
+		case map[string]interface{}:
+			for k, c := range v {
+				p, ok := c.(string)
+				if k != "path" || !ok {
+					if err := walk(c); err != nil {
+						return err
+					}
+					continue
+				}
+				if filepath.IsAbs(p) {
+					if staged {
+						return fmt.Errorf("cannot migrate to a datastore outside of the repo (%s)", p)
+					}
+					continue
+				}
+				p = filepath.Clean(p)
+				if p == "." || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+					return fmt.Errorf("datastore path %q is not inside the repo", p)
+				}
+				out = append(out, strings.SplitN(p, string(filepath.Separator), 2)[0])
+			}
+		case []interface{}:
+			for _, c := range v {
+				if err := walk(c); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(spec); err != nil {
+		return nil, err
+	}
+	return uniq(out), nil
+}
+
+func uniq(s []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// moveEntries renames the given entries of from to to, skipping missing
+// ones, and returns the moved ones
+func moveEntries(names []string, from, to string) ([]string, error) {
+	var moved []string
+	for _, name := range names {
+		err := os.Rename(filepath.Join(from, name), filepath.Join(to, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+		moved = append(moved, name)
+	}
+	return moved, nil
+}
+
+// rollbackError is returned when a failed switch could not be undone
+type rollbackError struct {
+	cause, err error
+}
+
+func (e *rollbackError) Error() string {
+	return fmt.Sprintf("datastore migration failed (%s) and could not be rolled back: %s", e.cause, e.err)
+}
+
+// finishDatastoreMigration switches the repo to a staged datastore if there
+// is one ready. A failed switch is rolled back and recorded in the migration
+// state, an error is only returned if the repo could not be restored.
+func (r *FSRepo) finishDatastoreMigration() error {
+	st, err := MigrationStatus(r.path)
+	if err != nil || st == nil || st.Phase != MigrationReady {
+		return err
+	}
+
+	log.Infof("switching the datastore to the one staged by 'repo migrate-datastore'")
+	err = r.switchDatastore(st)
+	if _, ok := err.(*rollbackError); ok {
+		return err
+	}
+	if err != nil {
+		log.Errorf("datastore migration failed, keeping the current datastore: %s", err)
+		st.Phase = MigrationFailed
+		st.Error = err.Error()
+	} else {
+		log.Infof("datastore migration done")
+		st.Phase = MigrationDone
+	}
+	return saveMigrationState(r.path, st)
+}
+
+// switchDatastore syncs the staged datastore, swaps the directories of the
+// old and new datastores, updates the spec and the config, and verifies the
+// result. Once the directories are touched, errors are rolled back.
+func (r *FSRepo) switchDatastore(st *MigrationState) error {
+	oldSpec := r.config.Datastore.Spec
+	oldc, err := AnyDatastoreConfig(oldSpec)
+	if err != nil {
+		return err
+	}
+	newc, err := AnyDatastoreConfig(st.Spec)
+	if err != nil {
+		return err
+	}
+	onDisk, err := r.readSpec()
+	if err != nil {
+		return err
+	}
+	if onDisk != oldc.DiskSpec().String() {
+		return fmt.Errorf("datastore configuration of '%s' does not match what is on disk '%s'",
+			oldc.DiskSpec().String(), onDisk)
+	}
+	oldEntries, err := specEntries(oldc.DiskSpec(), false)
+	if err != nil {
+		return err
+	}
+	newEntries, err := specEntries(newc.DiskSpec(), true)
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Join(r.path, migrationDir)
+	newPath := filepath.Join(base, migrationNewDir)
+	oldPath := filepath.Join(base, migrationOldDir)
+
+	// entries of the new tree must not clobber anything but the old tree
+	for _, name := range newEntries {
+		if contains(oldEntries, name) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(r.path, name)); err == nil {
+			return fmt.Errorf("%s already exists in the repo", name)
+		}
+	}
+
+	// catch up with the writes made since the copy
+	if err := r.withDatastores(oldc, r.path, newc, newPath, func(src, dst ds.Datastore) error {
+		n, err := syncDatastore(src, dst)
+		log.Infof("synced %d keys changed since the copy", n)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(oldPath, 0700); err != nil {
+		return err
+	}
+	switchFile := filepath.Join(base, migrationSwitchFile)
+	if err := writeFileAtomic(switchFile, nil); err != nil {
+		return err
+	}
+	var movedOld, movedNew []string
+	rollback := func(cause error) error {
+		updated := *r.config
+		updated.Datastore.Spec = oldSpec
+		if err := r.setConfigUnsynced(&updated); err != nil {
+			return &rollbackError{cause, err}
+		}
+		if err := r.writeSpec(oldc.DiskSpec()); err != nil {
+			return &rollbackError{cause, err}
+		}
+		if _, err := moveEntries(movedNew, r.path, newPath); err != nil {
+			return &rollbackError{cause, err}
+		}
+		if _, err := moveEntries(movedOld, oldPath, r.path); err != nil {
+			return &rollbackError{cause, err}
+		}
+		os.Remove(switchFile)
+		return cause
+	}
+
+	movedOld, err = moveEntries(oldEntries, r.path, oldPath)
+	if err != nil {
+		return rollback(err)
+	}
+	movedNew, err = moveEntries(newEntries, newPath, r.path)
+	if err != nil {
+		return rollback(err)
+	}
+
+	updated := *r.config
+	updated.Datastore.Spec = st.Spec
+	if err := r.setConfigUnsynced(&updated); err != nil {
+		return rollback(err)
+	}
+	if err := r.writeSpec(newc.DiskSpec()); err != nil {
+		return rollback(err)
+	}
+
+	if err := r.withDatastores(oldc, oldPath, newc, r.path, verifyDatastore); err != nil {
+		return rollback(fmt.Errorf("verify: %s", err))
+	}
+
+	if err := os.Remove(switchFile); err != nil {
+		log.Warningf("could not remove %s: %s", switchFile, err)
+	}
+	if err := os.RemoveAll(oldPath); err != nil {
+		log.Warningf("could not remove the old datastore: %s", err)
+	}
+	os.RemoveAll(newPath)
+	return nil
+}
+
+// withDatastores creates the datastores of two configs and closes them once
+// f returns
+func (r *FSRepo) withDatastores(srcc DatastoreConfig, srcPath string, dstc DatastoreConfig, dstPath string, f func(src, dst ds.Datastore) error) error {
+	src, err := srcc.Create(srcPath)
+	if err != nil {
+		return err
+	}
+	dst, err := dstc.Create(dstPath)
+	if err != nil {
+		src.Close()
+		return err
+	}
+
+	err = f(src, dst)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := src.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeSpec atomically replaces the datastore_spec file
+func (r *FSRepo) writeSpec(spec DiskSpec) error {
+	return writeFileAtomic(filepath.Join(r.path, specFn), spec.Bytes())
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
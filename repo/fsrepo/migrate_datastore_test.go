@@ -0,0 +1,282 @@
+package fsrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	repo "mbfs/go-mbfs/repo"
+
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	config "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config"
+	levelds "mbfs/go-mbfs/gx/QmccqjKZUTqp4ikWNyAbjBuP5HEdqSqRuAr9mcEhYab54a/go-ds-leveldb"
+)
+
+type testLeveldbConfig struct {
+	path string
+}
+
+func (c *testLeveldbConfig) DiskSpec() DiskSpec {
+	return map[string]interface{}{"type": "testleveldb", "path": c.path}
+}
+
+func (c *testLeveldbConfig) Create(path string) (repo.Datastore, error) {
+	return levelds.NewDatastore(filepath.Join(path, c.path), nil)
+}
+
+func init() {
+	AddDatastoreConfigHandler("testleveldb", func(params map[string]interface{}) (DatastoreConfig, error) {
+		return &testLeveldbConfig{params["path"].(string)}, nil
+	})
+}
+
+// testSpec mounts the blocks and the rest of the keys in two leveldbs
+func testSpec(blocks, root string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "mount",
+		"mounts": []interface{}{
+			map[string]interface{}{"mountpoint": "/blocks", "type": "testleveldb", "path": blocks},
+			map[string]interface{}{"mountpoint": "/", "type": "testleveldb", "path": root},
+		},
+	}
+}
+
+func testSpecDisk(blocks, root string) string {
+	dsc, err := AnyDatastoreConfig(testSpec(blocks, root))
+	if err != nil {
+		panic(err)
+	}
+	return dsc.DiskSpec().String()
+}
+
+func initMigrationRepo(t *testing.T, keys int) string {
+	path := testRepoPath("migrate", t)
+	if err := Init(path, &config.Config{Datastore: config.Datastore{Spec: testSpec("blocks", "datastore")}}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i := 0; i < keys; i++ {
+		if err := r.Datastore().Put(ds.NewKey(fmt.Sprintf("/blocks/B%d", i)), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Datastore().Put(ds.NewKey("/local/root"), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func checkValue(t *testing.T, d ds.Datastore, k, v string) {
+	t.Helper()
+	got, err := d.Get(ds.NewKey(k))
+	if err != nil {
+		t.Fatalf("%s: %s", k, err)
+	}
+	if string(got) != v {
+		t.Fatalf("%s: got %q, expected %q", k, got, v)
+	}
+}
+
+func TestMigrateDatastore(t *testing.T) {
+	path := initMigrationRepo(t, 10)
+	defer os.RemoveAll(path)
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rest of the keys shares its path with the old tree
+	newSpec := testSpec("blocks2", "datastore")
+	var batches int
+	err = StageDatastoreMigration(context.Background(), path, r.Datastore(), "test", newSpec, 4, func(*MigrationState) {
+		batches++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batches != 4 { // 11 keys in 3 batches, and the ready state
+		t.Fatalf("expected 4 progress updates, got %d", batches)
+	}
+
+	// writes made after the copy are synced at the switch
+	r.Datastore().Put(ds.NewKey("/local/root"), []byte("v2"))
+	r.Datastore().Put(ds.NewKey("/blocks/NEW"), []byte("new"))
+	r.Datastore().Delete(ds.NewKey("/blocks/B0"))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	st, err := MigrationStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Phase != MigrationDone || st.Copied != 11 {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+	cfg, _ := r.Config()
+	if cfg.Datastore.Spec["mounts"].([]interface{})[0].(map[string]interface{})["path"] != "blocks2" {
+		t.Fatalf("config not updated: %v", cfg.Datastore.Spec)
+	}
+	if _, err := os.Stat(filepath.Join(path, "blocks")); !os.IsNotExist(err) {
+		t.Fatal("the old blocks datastore should have been removed")
+	}
+
+	checkValue(t, r.Datastore(), "/local/root", "v2")
+	checkValue(t, r.Datastore(), "/blocks/NEW", "new")
+	checkValue(t, r.Datastore(), "/blocks/B9", "\x09")
+	if has, _ := r.Datastore().Has(ds.NewKey("/blocks/B0")); has {
+		t.Fatal("deleted key was migrated")
+	}
+}
+
+func TestMigrateDatastoreRollback(t *testing.T) {
+	path := initMigrationRepo(t, 3)
+	defer os.RemoveAll(path)
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = StageDatastoreMigration(context.Background(), path, r.Datastore(), "test", testSpec("blocks2", "datastore"), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	defer func(f func(src, dst ds.Datastore) error) { verifyDatastore = f }(verifyDatastore)
+	verifyDatastore = func(src, dst ds.Datastore) error {
+		return errors.New("mismatch")
+	}
+
+	r, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	st, err := MigrationStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Phase != MigrationFailed || st.Error != "verify: mismatch" {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+	spec, err := ioutil.ReadFile(filepath.Join(path, specFn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(spec) != testSpecDisk("blocks", "datastore") {
+		t.Fatalf("spec not restored: %s", spec)
+	}
+	checkValue(t, r.Datastore(), "/local/root", "v1")
+	checkValue(t, r.Datastore(), "/blocks/B2", "\x02")
+	if _, err := os.Stat(filepath.Join(path, migrationDir, migrationNewDir, "blocks2")); err != nil {
+		t.Fatal("the staged datastore should have been kept:", err)
+	}
+	if _, err := os.Stat(filepath.Join(path, migrationDir, migrationSwitchFile)); !os.IsNotExist(err) {
+		t.Fatal("the switch should be over once rolled back")
+	}
+}
+
+func TestAbortDatastoreMigration(t *testing.T) {
+	path := initMigrationRepo(t, 3)
+	defer os.RemoveAll(path)
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = StageDatastoreMigration(context.Background(), path, r.Datastore(), "test", testSpec("blocks2", "datastore"), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged := filepath.Join(path, migrationDir, migrationNewDir)
+
+	// the copy may be in progress while the repo is open
+	if err := AbortDatastoreMigration(path); err == nil {
+		t.Fatal("expected the abort to be refused while the repo is in use")
+	}
+	r.Close()
+
+	// a switch that did not finish leaves the old datastore behind
+	switchFile := filepath.Join(path, migrationDir, migrationSwitchFile)
+	if err := ioutil.WriteFile(switchFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := AbortDatastoreMigration(path); err == nil {
+		t.Fatal("expected the abort to be refused during a switch")
+	}
+	if _, err := os.Stat(staged); err != nil {
+		t.Fatal("the staged datastore should have been kept:", err)
+	}
+
+	if err := os.Remove(switchFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := AbortDatastoreMigration(path); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := MigrationStatus(path); err != nil || st != nil {
+		t.Fatalf("expected no migration, got %+v, %v", st, err)
+	}
+}
+
+func TestMigrateDatastoreResume(t *testing.T) {
+	path := initMigrationRepo(t, 10)
+	defer os.RemoveAll(path)
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	newSpec := testSpec("blocks2", "datastore2")
+	ctx, cancel := context.WithCancel(context.Background())
+	err = StageDatastoreMigration(ctx, path, r.Datastore(), "test", newSpec, 3, func(*MigrationState) {
+		cancel()
+	})
+	if err != context.Canceled {
+		t.Fatal("expected the copy to be interrupted, got", err)
+	}
+
+	st, err := MigrationStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Phase != MigrationCopying || st.Copied == 0 || st.Copied == 11 {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+	copied := st.Copied
+
+	err = StageDatastoreMigration(context.Background(), path, r.Datastore(), "test", testSpec("other", "datastore2"), 3, nil)
+	if err == nil {
+		t.Fatal("expected staging another datastore to fail")
+	}
+
+	err = StageDatastoreMigration(context.Background(), path, r.Datastore(), "test", newSpec, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err = MigrationStatus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Phase != MigrationReady || st.Copied != 11 || st.Skipped != copied {
+		t.Fatalf("unexpected state: %+v", st)
+	}
+}
@@ -0,0 +1,183 @@
+package mfsr
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Migration upgrades a repo from version From to From+1 in-process.
+type Migration struct {
+	From        int
+	Description string
+
+	// Apply runs the migration on the repo at path. With dryRun set, it
+	// must not modify anything, only check that the migration can run and
+	// report what it would do to w.
+	Apply func(path string, w io.Writer, dryRun bool) error
+}
+
+var builtin = make(map[int]*Migration)
+
+// Register adds a built-in migration. It panics if there already is one for
+// the same version.
+func Register(m *Migration) {
+	if _, ok := builtin[m.From]; ok {
+		panic(fmt.Sprintf("duplicate migration from version %d", m.From))
+	}
+	builtin[m.From] = m
+}
+
+// BackupDir is the directory of the repo the files a migration changes are
+// saved in before it runs.
+const BackupDir = "migration-backup"
+
+// backedUp are the files saved before migrating, the datastore itself is not
+// backed up
+var backedUp = []string{VersionFile, "config", "datastore_spec"}
+
+// Options configure Migrate.
+type Options struct {
+	// DryRun reports the migrations which would run without running them.
+	DryRun bool
+
+	// External allows running the fs-repo-migrations binary, downloaded if
+	// it isn't in the PATH, for the versions there is no built-in migration
+	// for.
+	External bool
+
+	// Out receives the progress of the migrations.
+	Out io.Writer
+}
+
+// Migrate upgrades the repo at path to version to by running the built-in
+// migrations in order. The version, config and datastore_spec files are
+// saved in the migration-backup directory of the repo first, and restored
+// if a migration fails. The caller must hold the repo lock.
+func Migrate(path string, to int, opts Options) error {
+	w := opts.Out
+	if w == nil {
+		w = ioutil.Discard
+	}
+
+	from, err := RepoPath(path).Version()
+	if err != nil {
+		return err
+	}
+	if from > to {
+		return fmt.Errorf("cannot migrate the repo from version %d down to %d", from, to)
+	}
+	if from == to {
+		fmt.Fprintf(w, "  => fs-repo is already at version %d.\n", to)
+		return nil
+	}
+
+	// the versions which have to be migrated by the external binary
+	external := -1
+	for v := from; v < to; v++ {
+		if builtin[v] == nil {
+			if !opts.External {
+				return fmt.Errorf("no built-in migration from fs-repo version %d to %d, the fs-repo-migrations binary is needed", v, v+1)
+			}
+			external = v
+			break
+		}
+	}
+
+	if opts.DryRun {
+		for v := from; v < to; v++ {
+			if v == external {
+				fmt.Fprintf(w, "  => Would run fs-repo-migrations -to %d\n", to)
+				break
+			}
+			m := builtin[v]
+			fmt.Fprintf(w, "  => Would migrate from version %d to %d: %s\n", v, v+1, m.Description)
+			if err := m.Apply(path, w, true); err != nil {
+				return fmt.Errorf("migration from version %d would fail: %s", v, err)
+			}
+		}
+		return nil
+	}
+
+	backup, err := backupRepoFiles(path, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to back up the repo: %s", err)
+	}
+	fmt.Fprintf(w, "  => Saved the repo version, config and datastore_spec in %s\n", backup)
+
+	for v := from; v < to; v++ {
+		if v == external {
+			fmt.Fprintf(w, "  => No built-in migration from version %d, falling back to fs-repo-migrations.\n", v)
+			err = RunMigration(to)
+		} else {
+			m := builtin[v]
+			fmt.Fprintf(w, "  => Migrating from version %d to %d: %s\n", v, v+1, m.Description)
+			err = m.Apply(path, w, false)
+			if err == nil {
+				err = RepoPath(path).WriteVersion(v + 1)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(w, "  => Failed, restoring %s\n", backup)
+			if rerr := restoreRepoFiles(path, backup); rerr != nil {
+				return fmt.Errorf("migration from version %d failed: %s, and the backup could not be restored: %s", v, err, rerr)
+			}
+			return fmt.Errorf("migration from version %d failed: %s", v, err)
+		}
+		if v == external {
+			// RunMigration reports its own success
+			return nil
+		}
+	}
+
+	fmt.Fprintf(w, "  => Success: fs-repo has been migrated to version %d.\n", to)
+	return nil
+}
+
+func backupRepoFiles(path string, from, to int) (string, error) {
+	dir := filepath.Join(path, BackupDir, fmt.Sprintf("%d-to-%d-%s", from, to, time.Now().Format("20060102T150405")))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	for _, name := range backedUp {
+		err := copyFile(filepath.Join(path, name), filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// copyFile copies src to dst, keeping its permissions
+func copyFile(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b, fi.Mode().Perm())
+}
+
+// restoreRepoFiles puts back the files saved by backupRepoFiles, and removes
+// those which didn't exist
+func restoreRepoFiles(path, backup string) error {
+	for _, name := range backedUp {
+		err := copyFile(filepath.Join(backup, name), filepath.Join(path, name))
+		if os.IsNotExist(err) {
+			err = os.Remove(filepath.Join(path, name))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
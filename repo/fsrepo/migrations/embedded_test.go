@@ -0,0 +1,194 @@
+package mfsr
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const v5Config = `{
+  "Datastore": {
+    "StorageMax": "10GB",
+    "Type": "leveldb",
+    "Path": "REPO/datastore",
+    "NoSync": true
+  },
+  "Bootstrap": []
+}`
+
+func testV5Repo(t *testing.T) string {
+	path, err := ioutil.TempDir("", "mfsr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := strings.Replace(v5Config, "REPO", path, 1)
+	if err := ioutil.WriteFile(filepath.Join(path, "config"), []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(path, "blocks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(path, "blocks", "SHARDING"), []byte("/repo/flatfs/shard/v1/next-to-last/3\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RepoPath(path).WriteVersion(5); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestMigrate5to6(t *testing.T) {
+	path := testV5Repo(t)
+	defer os.RemoveAll(path)
+	cfg := readFile(t, filepath.Join(path, "config"))
+
+	var out bytes.Buffer
+	if err := Migrate(path, 6, Options{DryRun: true, Out: &out}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Would migrate from version 5 to 6") {
+		t.Fatalf("unexpected dry run output: %s", out.String())
+	}
+	if readFile(t, filepath.Join(path, "config")) != cfg {
+		t.Fatal("dry run changed the config")
+	}
+	if _, err := os.Stat(filepath.Join(path, BackupDir)); !os.IsNotExist(err) {
+		t.Fatal("dry run made a backup")
+	}
+
+	if err := Migrate(path, 6, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RepoPath(path).CheckVersion(6); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := readFile(t, filepath.Join(path, "datastore_spec"))
+	expected := `{"mounts":[{"mountpoint":"/blocks","path":"blocks","shardFunc":"/repo/flatfs/shard/v1/next-to-last/3","type":"flatfs"},` +
+		`{"mountpoint":"/","path":"datastore","type":"levelds"}],"type":"mount"}`
+	if spec != expected {
+		t.Fatalf("wrong datastore_spec:\n%s\nexpected:\n%s", spec, expected)
+	}
+	newCfg := readFile(t, filepath.Join(path, "config"))
+	if strings.Contains(newCfg, `"Type": "leveldb"`) || !strings.Contains(newCfg, `"sync": false`) {
+		t.Fatalf("config not converted: %s", newCfg)
+	}
+
+	backups, err := filepath.Glob(filepath.Join(path, BackupDir, "5-to-6-*", "config"))
+	if err != nil || len(backups) != 1 {
+		t.Fatal("expected a backup of the config", backups, err)
+	}
+	if readFile(t, backups[0]) != cfg {
+		t.Fatal("wrong backup")
+	}
+}
+
+func TestMigrate6to7(t *testing.T) {
+	path := testV5Repo(t)
+	defer os.RemoveAll(path)
+
+	custom := "/ip4/10.0.0.1/tcp/4001/ipfs/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt"
+	bootstrap := `"Bootstrap": [
+    "` + v6Bootstrap[0] + `",
+    "` + v7RetiredBootstrap[0] + `",
+    "` + custom + `"
+  ]`
+	cfg := strings.Replace(readFile(t, filepath.Join(path, "config")), `"Bootstrap": []`, bootstrap, 1)
+	if err := ioutil.WriteFile(filepath.Join(path, "config"), []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole way from version 5 runs without the external binary
+	if err := Migrate(path, 7, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RepoPath(path).CheckVersion(7); err != nil {
+		t.Fatal(err)
+	}
+
+	newCfg := readFile(t, filepath.Join(path, "config"))
+	for _, p := range append([]string{v6Bootstrap[0], custom}, v7NewBootstrap...) {
+		if !strings.Contains(newCfg, p) {
+			t.Fatalf("expected %s in the bootstrap list: %s", p, newCfg)
+		}
+	}
+	if strings.Contains(newCfg, v7RetiredBootstrap[0]) {
+		t.Fatalf("expected the retired peer to be removed: %s", newCfg)
+	}
+}
+
+func TestMigrate6to7Custom(t *testing.T) {
+	path := testV5Repo(t)
+	defer os.RemoveAll(path)
+	if err := Migrate(path, 6, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := readFile(t, filepath.Join(path, "config"))
+
+	// an empty list, as in a private network, is kept
+	if err := Migrate(path, 7, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, filepath.Join(path, "config")) != cfg {
+		t.Fatal("the custom bootstrap list was changed")
+	}
+}
+
+func TestMigrateRollback(t *testing.T) {
+	path := testV5Repo(t)
+	defer os.RemoveAll(path)
+	cfg := readFile(t, filepath.Join(path, "config"))
+
+	defer func(m map[int]*Migration) { builtin = m }(builtin)
+	builtin = map[int]*Migration{
+		5: builtin[5],
+		6: {
+			From: 6,
+			Apply: func(path string, w io.Writer, dryRun bool) error {
+				ioutil.WriteFile(filepath.Join(path, "config"), []byte("garbage"), 0600)
+				return errors.New("broken")
+			},
+		},
+	}
+
+	err := Migrate(path, 7, Options{})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatal("expected the migration to fail, got", err)
+	}
+	if err := RepoPath(path).CheckVersion(5); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, filepath.Join(path, "config")) != cfg {
+		t.Fatal("config was not restored")
+	}
+	if _, err := os.Stat(filepath.Join(path, "datastore_spec")); !os.IsNotExist(err) {
+		t.Fatal("datastore_spec should have been removed")
+	}
+}
+
+func TestMigrateMissing(t *testing.T) {
+	path := testV5Repo(t)
+	defer os.RemoveAll(path)
+
+	err := Migrate(path, 8, Options{})
+	if err == nil || !strings.Contains(err.Error(), "fs-repo-migrations") {
+		t.Fatal("expected the lack of a built-in migration to be reported, got", err)
+	}
+	if err := RepoPath(path).CheckVersion(5); err != nil {
+		t.Fatal(err)
+	}
+}
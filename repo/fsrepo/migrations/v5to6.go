@@ -0,0 +1,129 @@
+package mfsr
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	serialize "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config/serialize"
+)
+
+// Version 6 replaced the Datastore.Type and Datastore.Path fields of the
+// config, which described the leveldb of the repo, by a Datastore.Spec
+// describing the whole datastore tree, mirrored in the datastore_spec file.
+// The data itself doesn't move: the blocks stay in the blocks flatfs and the
+// rest in the leveldb.
+func init() {
+	Register(&Migration{
+		From:        5,
+		Description: "convert the datastore config to a Datastore.Spec",
+		Apply:       migrate5to6,
+	})
+}
+
+const defaultShardFunc = "/repo/flatfs/shard/v1/next-to-last/2"
+
+func migrate5to6(path string, w io.Writer, dryRun bool) error {
+	cfgFile := filepath.Join(path, "config")
+	var cfg map[string]interface{}
+	if err := serialize.ReadConfigFile(cfgFile, &cfg); err != nil {
+		return err
+	}
+	dsCfg, ok := cfg["Datastore"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("no Datastore section in %s", cfgFile)
+	}
+	if _, ok := dsCfg["Spec"]; ok && dsCfg["Type"] == nil {
+		fmt.Fprintln(w, "     the datastore config is already converted")
+		return nil
+	}
+
+	if typ, _ := dsCfg["Type"].(string); typ != "" && typ != "leveldb" {
+		return fmt.Errorf("unsupported datastore type %q", typ)
+	}
+
+	ldbPath := "datastore"
+	if p, _ := dsCfg["Path"].(string); p != "" {
+		ldbPath = p
+		if rel, err := filepath.Rel(path, p); err == nil && !strings.HasPrefix(rel, "..") {
+			ldbPath = rel
+		}
+	}
+
+	shardFunc := defaultShardFunc
+	b, err := ioutil.ReadFile(filepath.Join(path, "blocks", "SHARDING"))
+	switch {
+	case err == nil:
+		shardFunc = strings.TrimSpace(string(b))
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	noSync, _ := dsCfg["NoSync"].(bool)
+
+	spec := map[string]interface{}{
+		"type": "mount",
+		"mounts": []interface{}{
+			map[string]interface{}{
+				"mountpoint": "/blocks",
+				"type":       "measure",
+				"prefix":     "flatfs.datastore",
+				"child": map[string]interface{}{
+					"type":      "flatfs",
+					"path":      "blocks",
+					"sync":      !noSync,
+					"shardFunc": shardFunc,
+				},
+			},
+			map[string]interface{}{
+				"mountpoint": "/",
+				"type":       "measure",
+				"prefix":     "leveldb.datastore",
+				"child": map[string]interface{}{
+					"type":        "levelds",
+					"path":        ldbPath,
+					"compression": "none",
+				},
+			},
+		},
+	}
+
+	// what fsrepo derives from the spec to identify the datastore
+	diskSpec, err := json.Marshal(map[string]interface{}{
+		"type": "mount",
+		"mounts": []interface{}{
+			map[string]interface{}{
+				"mountpoint": "/blocks",
+				"type":       "flatfs",
+				"path":       "blocks",
+				"shardFunc":  shardFunc,
+			},
+			map[string]interface{}{
+				"mountpoint": "/",
+				"type":       "levelds",
+				"path":       ldbPath,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Fprintf(w, "     blocks: flatfs in blocks (%s), other keys: leveldb in %s\n", shardFunc, ldbPath)
+		return nil
+	}
+
+	for _, k := range []string{"Type", "Path", "NoSync", "Params"} {
+		delete(dsCfg, k)
+	}
+	dsCfg["Spec"] = spec
+	if err := serialize.WriteConfigFile(cfgFile, cfg); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(path, "datastore_spec"), diskSpec, 0600)
+}
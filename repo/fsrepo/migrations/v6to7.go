@@ -0,0 +1,112 @@
+package mfsr
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	serialize "mbfs/go-mbfs/gx/QmbK4EmM2Xx5fmbqK38TGP3PpY66r3tkXLZTcc7dF9mFwM/go-ipfs-config/serialize"
+)
+
+// Version 7 updated the default bootstrap peers: the neptune and uranus
+// nodes were retired, and the libp2p bootstrap nodes, found through
+// /dnsaddr/bootstrap.libp2p.io, were added. Only a list still holding the
+// version 6 defaults is updated, a list of other peers or an empty one, as in
+// a private network, is kept as is.
+func init() {
+	Register(&Migration{
+		From:        6,
+		Description: "update the default bootstrap peers",
+		Apply:       migrate6to7,
+	})
+}
+
+// v6Bootstrap are the default bootstrap peers of version 6
+var v6Bootstrap = []string{
+	"/ip4/104.131.131.82/tcp/4001/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",            // mars.i.ipfs.io
+	"/ip4/104.236.176.52/tcp/4001/ipfs/QmSoLnSGccFuZQJzRadHn95W2CrSFmZuTdDWP8HXaHca9z",            // neptune.i.ipfs.io
+	"/ip4/104.236.179.241/tcp/4001/ipfs/QmSoLPppuBtQSGwKDZT2M73ULpjvfd3aZ6ha4oFGL1KrGM",           // pluto.i.ipfs.io
+	"/ip4/162.243.248.213/tcp/4001/ipfs/QmSoLueR4xBeUbY9WZ9xGUUxunbKWcrNFTDAadQJmocnWm",           // uranus.i.ipfs.io
+	"/ip4/128.199.219.111/tcp/4001/ipfs/QmSoLSafTMBsPKadTEgaXctDQVcqN88CNLHXMkTNwMKPnu",           // saturn.i.ipfs.io
+	"/ip4/104.236.76.40/tcp/4001/ipfs/QmSoLV4Bbm51jM9C4gDYZQ9Cy3U6aXMJDAbzgu2fzaDs64",             // venus.i.ipfs.io
+	"/ip4/178.62.158.247/tcp/4001/ipfs/QmSoLer265NRgSp2LA3dPaeykiS1J6DifTC88f5uVQKNAd",            // earth.i.ipfs.io
+	"/ip6/2604:a880:1:20::203:d001/tcp/4001/ipfs/QmSoLPppuBtQSGwKDZT2M73ULpjvfd3aZ6ha4oFGL1KrGM",  // pluto.i.ipfs.io
+	"/ip6/2400:6180:0:d0::151:6001/tcp/4001/ipfs/QmSoLSafTMBsPKadTEgaXctDQVcqN88CNLHXMkTNwMKPnu",  // saturn.i.ipfs.io
+	"/ip6/2604:a880:800:10::4a:5001/tcp/4001/ipfs/QmSoLV4Bbm51jM9C4gDYZQ9Cy3U6aXMJDAbzgu2fzaDs64", // venus.i.ipfs.io
+	"/ip6/2a03:b0c0:0:1010::23:1001/tcp/4001/ipfs/QmSoLer265NRgSp2LA3dPaeykiS1J6DifTC88f5uVQKNAd", // earth.i.ipfs.io
+}
+
+// v7RetiredBootstrap are the version 6 defaults removed in version 7
+var v7RetiredBootstrap = []string{
+	"/ip4/104.236.176.52/tcp/4001/ipfs/QmSoLnSGccFuZQJzRadHn95W2CrSFmZuTdDWP8HXaHca9z",  // neptune.i.ipfs.io
+	"/ip4/162.243.248.213/tcp/4001/ipfs/QmSoLueR4xBeUbY9WZ9xGUUxunbKWcrNFTDAadQJmocnWm", // uranus.i.ipfs.io
+}
+
+// v7NewBootstrap are the default bootstrap peers added in version 7
+var v7NewBootstrap = []string{
+	"/dnsaddr/bootstrap.libp2p.io/ipfs/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+	"/dnsaddr/bootstrap.libp2p.io/ipfs/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+	"/dnsaddr/bootstrap.libp2p.io/ipfs/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb",
+	"/dnsaddr/bootstrap.libp2p.io/ipfs/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+}
+
+func migrate6to7(path string, w io.Writer, dryRun bool) error {
+	cfgFile := filepath.Join(path, "config")
+	var cfg map[string]interface{}
+	if err := serialize.ReadConfigFile(cfgFile, &cfg); err != nil {
+		return err
+	}
+
+	var peers []string
+	if list, ok := cfg["Bootstrap"].([]interface{}); ok {
+		for _, p := range list {
+			s, ok := p.(string)
+			if !ok {
+				return fmt.Errorf("invalid bootstrap peer %v in %s", p, cfgFile)
+			}
+			peers = append(peers, s)
+		}
+	}
+
+	defaults := false
+	for _, p := range peers {
+		defaults = defaults || contains(v6Bootstrap, p)
+	}
+	if !defaults {
+		fmt.Fprintln(w, "     the bootstrap list holds none of the default peers, keeping it")
+		return nil
+	}
+
+	var updated []interface{}
+	removed, added := 0, 0
+	for _, p := range peers {
+		if contains(v7RetiredBootstrap, p) {
+			removed++
+			continue
+		}
+		updated = append(updated, p)
+	}
+	for _, p := range v7NewBootstrap {
+		if !contains(peers, p) {
+			updated = append(updated, p)
+			added++
+		}
+	}
+
+	if dryRun {
+		fmt.Fprintf(w, "     would remove %d retired bootstrap peers and add %d new ones\n", removed, added)
+		return nil
+	}
+	fmt.Fprintf(w, "     removed %d retired bootstrap peers and added %d new ones\n", removed, added)
+	cfg["Bootstrap"] = updated
+	return serialize.WriteConfigFile(cfgFile, cfg)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
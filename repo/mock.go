@@ -54,4 +54,10 @@ func (m *Mock) SwarmKey() ([]byte, error) {
 	return nil, nil
 }
 
+func (m *Mock) SecondarySwarmKey() ([]byte, error) {
+	return nil, nil
+}
+
+func (m *Mock) SetSwarmKeys(primary, secondary []byte) error { return errTODO }
+
 func (m *Mock) FileManager() *filestore.FileManager { return nil }
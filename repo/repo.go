@@ -53,6 +53,13 @@ type Repo interface {
 	// SwarmKey returns the configured shared symmetric key for the private networks feature.
 	SwarmKey() ([]byte, error)
 
+	// SecondarySwarmKey returns the swarm key also accepted from the peers
+	// while the swarm key is rotated, if any.
+	SecondarySwarmKey() ([]byte, error)
+
+	// SetSwarmKeys replaces the swarm keys, removing the secondary key if nil.
+	SetSwarmKeys(primary, secondary []byte) error
+
 	io.Closer
 }
 
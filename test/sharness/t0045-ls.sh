@@ -38,11 +38,11 @@ EOF
     test_cmp expected_add actual_add
   '
 
-  test_expect_success "'ipfs ls <three dir hashes>' succeeds" '
-    ipfs ls QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj QmR3jhV4XpxxPjPT3Y8vNnWvWNvakdcT3H6vqpRBsX1MLy QmSix55yz8CzWXf5ZVM9vgEvijnEeeXiTSarVtsqiiCJss >actual_ls
+  test_expect_success "'ipfs ls --stream=false <three dir hashes>' succeeds" '
+    ipfs ls --stream=false QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj QmR3jhV4XpxxPjPT3Y8vNnWvWNvakdcT3H6vqpRBsX1MLy QmSix55yz8CzWXf5ZVM9vgEvijnEeeXiTSarVtsqiiCJss >actual_ls
   '
 
-  test_expect_success "'ipfs ls <three dir hashes>' output looks good" '
+  test_expect_success "'ipfs ls --stream=false <three dir hashes>' output looks good" '
     cat <<-\EOF >expected_ls &&
 QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj:
 QmSix55yz8CzWXf5ZVM9vgEvijnEeeXiTSarVtsqiiCJss 246  d1/
@@ -62,11 +62,11 @@ EOF
     test_cmp expected_ls actual_ls
   '
 
-  test_expect_success "'ipfs ls --headers <three dir hashes>' succeeds" '
-    ipfs ls --headers QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj QmR3jhV4XpxxPjPT3Y8vNnWvWNvakdcT3H6vqpRBsX1MLy QmSix55yz8CzWXf5ZVM9vgEvijnEeeXiTSarVtsqiiCJss >actual_ls_headers
+  test_expect_success "'ipfs ls --stream=false --headers <three dir hashes>' succeeds" '
+    ipfs ls --stream=false --headers QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj QmR3jhV4XpxxPjPT3Y8vNnWvWNvakdcT3H6vqpRBsX1MLy QmSix55yz8CzWXf5ZVM9vgEvijnEeeXiTSarVtsqiiCJss >actual_ls_headers
   '
 
-  test_expect_success "'ipfs ls --headers  <three dir hashes>' output looks good" '
+  test_expect_success "'ipfs ls --stream=false --headers <three dir hashes>' output looks good" '
     cat <<-\EOF >expected_ls_headers &&
 QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj:
 Hash                                           Size Name
@@ -88,6 +88,59 @@ QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN 14   a
 EOF
     test_cmp expected_ls_headers actual_ls_headers
   '
+
+  test_expect_success "'ipfs ls --stream=false -r <dir hash>' succeeds" '
+    ipfs ls --stream=false -r QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj >actual_ls_recursive
+  '
+
+  test_expect_success "'ipfs ls --stream=false -r <dir hash>' output looks good" '
+    cat <<-\EOF >expected_ls_recursive &&
+QmSix55yz8CzWXf5ZVM9vgEvijnEeeXiTSarVtsqiiCJss 246  d1/
+QmQNd6ubRXaNG6Prov8o6vk3bn6eWsj9FxLGrAVDUAGkGe 139  d1/128
+QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN 14   d1/a
+QmR3jhV4XpxxPjPT3Y8vNnWvWNvakdcT3H6vqpRBsX1MLy 1143 d2/
+QmbQBUSRL9raZtNXfpTDeaxQapibJEG6qEY8WqAN22aUzd 1035 d2/1024
+QmaRGe7bVmVaLmxbrMiVNXqW4pRNNp3xq7hFtyRKA3mtJL 14   d2/a
+QmeomffUNfmQy76CQGy9NdmqEnnHU9soCexBnGU3ezPHVH 13   f1
+QmNtocSs7MoDkJMc1RkyisCSKvLadujPsfJfSdJ3e1eA1M 13   f2
+EOF
+    test_cmp expected_ls_recursive actual_ls_recursive
+  '
+
+  test_expect_success "'ipfs ls -r --depth 1' lists only direct entries" '
+    ipfs ls -r --depth 1 QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj >actual_ls_depth &&
+    ipfs ls QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj >expected_ls_depth &&
+    test_cmp expected_ls_depth actual_ls_depth
+  '
+
+  test_expect_success "'ipfs ls <dir hash>' streams the entries" '
+    ipfs ls QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj >actual_ls_stream &&
+    cat <<-\EOF >expected_ls_stream &&
+QmSix55yz8CzWXf5ZVM9vgEvijnEeeXiTSarVtsqiiCJss 246 d1/
+QmR3jhV4XpxxPjPT3Y8vNnWvWNvakdcT3H6vqpRBsX1MLy 1143 d2/
+QmeomffUNfmQy76CQGy9NdmqEnnHU9soCexBnGU3ezPHVH 13 f1
+QmNtocSs7MoDkJMc1RkyisCSKvLadujPsfJfSdJ3e1eA1M 13 f2
+EOF
+    test_cmp expected_ls_stream actual_ls_stream
+  '
+
+  test_expect_success "'ipfs ls --enc=json' writes one object per line" '
+    ipfs ls --enc=json QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj >actual_ls_jsonl &&
+    test_line_count = 4 actual_ls_jsonl &&
+    grep "\"Name\":\"f1\",\"Hash\":\"QmeomffUNfmQy76CQGy9NdmqEnnHU9soCexBnGU3ezPHVH\",\"Size\":13,\"Type\":2,\"Mode\":33188" actual_ls_jsonl
+  '
+
+  test_expect_success "'ipfs ls --stream=false --enc=json' writes a single object" '
+    ipfs ls --stream=false --enc=json QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj >actual_ls_json &&
+    test_line_count = 1 actual_ls_json &&
+    test $(grep -o "\"Name\"" actual_ls_json | wc -l) -eq 4
+  '
+
+  test_expect_success "'ipfs refs --enc=json' includes edge metadata" '
+    ipfs refs --enc=json QmfNy183bXiRVyrhyWtq3TwHn79yHEkiAGFr18P7YNzESj >actual_refs_jsonl &&
+    test_line_count = 4 actual_refs_jsonl &&
+    grep "\"Name\":\"d1\",\"Size\":246,\"Type\":1" actual_refs_jsonl
+  '
 }
 
 test_ls_cmd_raw_leaves() {
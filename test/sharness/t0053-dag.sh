@@ -186,6 +186,35 @@ test_dag_cmd() {
     test_cmp dag_put_exp dag_put_out
   '
 
+  test_expect_success "dag graph writes dot" '
+    ipfs dag graph $HASH > graph_dot &&
+    grep "^digraph \"$HASH\" {$" graph_dot &&
+    grep "\"$HASH\" \[label=\"$HASH\\\\nprotobuf, 15 bytes\"\];" graph_dot
+  '
+
+  test_expect_success "add a chunked file" '
+    random 600000 42 > bigfile &&
+    BIGHASH=$(ipfs add -q bigfile)
+  '
+
+  test_expect_success "dag graph lists every chunk" '
+    ipfs dag graph -f json $BIGHASH > graph_json &&
+    test $(grep -c "\"Cid\"" graph_json) -eq 4 &&
+    test $(grep -c "\"From\"" graph_json) -eq 3
+  '
+
+  test_expect_success "dag graph --collapse-chunks folds chunks into the file" '
+    ipfs dag graph -f json --collapse-chunks $BIGHASH > graph_collapsed &&
+    test $(grep -c "\"Cid\"" graph_collapsed) -eq 1 &&
+    grep "\"Chunks\": 3" graph_collapsed
+  '
+
+  test_expect_success "dag graph --max-depth stops the walk" '
+    ipfs dag graph -f graphml --max-depth 0 $BIGHASH > graph_graphml &&
+    test $(grep -c "<node " graph_graphml) -eq 1 &&
+    grep "<data key=\"truncated\">true</data>" graph_graphml
+  '
+
   test_expect_success "prepare data for dag resolve" '
     NESTED_HASH=$(echo "{\"data\":123}" | ipfs dag put) &&
     HASH=$(echo "{\"obj\":{\"/\":\"${NESTED_HASH}\"}}" | ipfs dag put)
@@ -0,0 +1,291 @@
+package s3ds
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNotFound = errors.New("object not found")
+
+// client talks to an S3-compatible API with path-style requests signed with
+// AWS signature version 4
+type client struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	http      *http.Client
+
+	now func() time.Time
+}
+
+// statusError is returned for unexpected responses
+type statusError struct {
+	op   string
+	key  string
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	if e.body != "" {
+		return fmt.Sprintf("s3 %s %s: HTTP %d: %s", e.op, e.key, e.code, e.body)
+	}
+	return fmt.Sprintf("s3 %s %s: HTTP %d", e.op, e.key, e.code)
+}
+
+func (c *client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func (c *client) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = nil
+	}
+
+	sum := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(sum[:]))
+	return c.http.Do(req)
+}
+
+// check turns unexpected responses into errors, closing their body
+func check(op, key string, res *http.Response, ok ...int) error {
+	for _, code := range ok {
+		if res.StatusCode == code {
+			return nil
+		}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		io.Copy(ioutil.Discard, res.Body)
+		return errNotFound
+	}
+
+	var s3err struct {
+		Code    string
+		Message string
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	msg := strings.TrimSpace(string(body))
+	if xml.Unmarshal(body, &s3err) == nil && s3err.Code != "" {
+		msg = s3err.Code + ": " + s3err.Message
+	}
+	return &statusError{op, key, res.StatusCode, msg}
+}
+
+func (c *client) put(key string, value []byte) error {
+	res, err := c.do("PUT", key, nil, value)
+	if err != nil {
+		return err
+	}
+	if err := check("put", key, res, http.StatusOK); err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return res.Body.Close()
+}
+
+func (c *client) get(key string) ([]byte, error) {
+	res, err := c.do("GET", key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := check("get", key, res, http.StatusOK); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+// head returns the size of an object
+func (c *client) head(key string) (int, error) {
+	res, err := c.do("HEAD", key, nil, nil)
+	if err != nil {
+		return -1, err
+	}
+	if err := check("head", key, res, http.StatusOK); err != nil {
+		return -1, err
+	}
+	res.Body.Close()
+	return int(res.ContentLength), nil
+}
+
+func (c *client) delete(key string) error {
+	res, err := c.do("DELETE", key, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := check("delete", key, res, http.StatusOK, http.StatusNoContent); err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return res.Body.Close()
+}
+
+type listEntry struct {
+	Key  string
+	Size int
+}
+
+type listResult struct {
+	Contents              []listEntry
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns one page of the objects whose name starts with prefix, and
+// the token of the next page if there is one
+func (c *client) list(prefix, token string, max int) ([]listEntry, string, error) {
+	q := url.Values{}
+	q.Set("list-type", "2")
+	q.Set("prefix", prefix)
+	q.Set("max-keys", strconv.Itoa(max))
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+
+	res, err := c.do("GET", "", q, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := check("list", prefix, res, http.StatusOK); err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	var out listResult
+	if err := xml.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, "", err
+	}
+	if !out.IsTruncated {
+		return out.Contents, "", nil
+	}
+	return out.Contents, out.NextContinuationToken, nil
+}
+
+// sign adds the AWS signature version 4 of the request, covering the host
+// and all the headers set so far
+func (c *client) sign(req *http.Request, payloadHash string) {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonReq := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	reqHash := sha256.Sum256([]byte(canonReq))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(reqHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes a query the way AWS expects it to be signed
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters, and
+// slashes unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch >= 'A' && ch <= 'Z', ch >= 'a' && ch <= 'z', ch >= '0' && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,395 @@
+// Package s3ds implements a datastore storing its values as objects in an
+// S3-compatible object storage.
+//
+// Objects are named <root>/<shard>/<key>, where the shard is derived from the
+// last component of the key with a flatfs shard function, so that writes are
+// spread over many prefixes. A local LRU cache of values sits in front of the
+// bucket.
+package s3ds
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	flatfs "mbfs/go-mbfs/gx/QmVFboKxbVJZMJAoFdvX6q4hzvXFkbWCE8DejnqrQV4ZtN/go-ds-flatfs"
+	ds "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore"
+	dsq "mbfs/go-mbfs/gx/QmaRb5yNXKonhbkpNxNawoydk4N6es6b4fPj19sjEKsh5D/go-datastore/query"
+	lru "mbfs/go-mbfs/gx/QmQjMHF8ptRgx4E57UFMiT4YM6kqaJeYxZ1MCDX23aw4rK/golang-lru"
+)
+
+// These are the defaults used for the zero values of Config.
+const (
+	DefaultRegion  = "us-east-1"
+	DefaultWorkers = 16
+	DefaultTimeout = time.Minute
+)
+
+// listPageSize is the number of objects asked for per list request
+var listPageSize = 1000
+
+// Config describes the bucket a Datastore is stored in.
+type Config struct {
+	Endpoint  string // e.g. https://s3.amazonaws.com
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// RootDirectory is prepended to the name of all objects, so that a
+	// bucket can be shared.
+	RootDirectory string
+
+	// ShardFunc spreads the objects over prefixes, see
+	// flatfs.ParseShardFunc. No sharding is done if nil.
+	ShardFunc *flatfs.ShardIdV1
+
+	// CacheSize is the number of values kept in memory, 0 disables the
+	// cache.
+	CacheSize int
+
+	// Workers is the number of requests a batch commit runs concurrently.
+	Workers int
+
+	// Timeout bounds every request.
+	Timeout time.Duration
+}
+
+// Datastore is a ds.Batching storing values in an S3-compatible bucket.
+type Datastore struct {
+	c       *client
+	root    string
+	shard   flatfs.ShardFunc
+	cache   *lru.Cache
+	workers int
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+
+// New returns a Datastore for the given bucket. It doesn't check that the
+// bucket is reachable.
+func New(cfg Config) (*Datastore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3ds: endpoint and bucket are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, errors.New("s3ds: endpoint must be an http or https URL")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	d := &Datastore{
+		c: &client{
+			endpoint:  endpoint,
+			bucket:    cfg.Bucket,
+			region:    region,
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+			http: &http.Client{
+				Timeout: timeout,
+				Transport: &http.Transport{
+					Proxy:               http.ProxyFromEnvironment,
+					MaxIdleConnsPerHost: workers,
+					IdleConnTimeout:     90 * time.Second,
+				},
+			},
+		},
+		root:    strings.Trim(cfg.RootDirectory, "/"),
+		workers: workers,
+	}
+	if cfg.ShardFunc != nil {
+		d.shard = cfg.ShardFunc.Func()
+	}
+	if cfg.CacheSize > 0 {
+		d.cache, err = lru.New(cfg.CacheSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// prefix is the part of the object names before the shard
+func (d *Datastore) prefix() string {
+	if d.root == "" {
+		return ""
+	}
+	return d.root + "/"
+}
+
+func (d *Datastore) objectName(k ds.Key) string {
+	name := strings.TrimPrefix(k.String(), "/")
+	if d.shard == nil {
+		return d.prefix() + name
+	}
+	return d.prefix() + d.shard(k.BaseNamespace()) + "/" + name
+}
+
+// keyOf is the reverse of objectName
+func (d *Datastore) keyOf(name string) (ds.Key, bool) {
+	if !strings.HasPrefix(name, d.prefix()) {
+		return ds.Key{}, false
+	}
+	name = name[len(d.prefix()):]
+	if d.shard != nil {
+		i := strings.IndexByte(name, '/')
+		if i < 0 {
+			return ds.Key{}, false
+		}
+		name = name[i+1:]
+	}
+	return ds.RawKey("/" + name), true
+}
+
+func (d *Datastore) cached(k ds.Key) ([]byte, bool) {
+	if d.cache == nil {
+		return nil, false
+	}
+	v, ok := d.cache.Get(k.String())
+	if !ok {
+		return nil, false
+	}
+	return v.([]byte), true
+}
+
+func (d *Datastore) remember(k ds.Key, v []byte) {
+	if d.cache != nil {
+		d.cache.Add(k.String(), v)
+	}
+}
+
+func (d *Datastore) forget(k ds.Key) {
+	if d.cache != nil {
+		d.cache.Remove(k.String())
+	}
+}
+
+// Put stores the value as an object.
+func (d *Datastore) Put(k ds.Key, value []byte) error {
+	if err := d.c.put(d.objectName(k), value); err != nil {
+		d.forget(k)
+		return err
+	}
+	d.remember(k, value)
+	return nil
+}
+
+// Get returns the value of k, from the cache if possible.
+func (d *Datastore) Get(k ds.Key) ([]byte, error) {
+	if v, ok := d.cached(k); ok {
+		return v, nil
+	}
+
+	v, err := d.c.get(d.objectName(k))
+	if err == errNotFound {
+		return nil, ds.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.remember(k, v)
+	return v, nil
+}
+
+// Has tells whether k is stored.
+func (d *Datastore) Has(k ds.Key) (bool, error) {
+	if _, ok := d.cached(k); ok {
+		return true, nil
+	}
+
+	_, err := d.c.head(d.objectName(k))
+	switch err {
+	case nil:
+		return true, nil
+	case errNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// GetSize returns the size of the value of k.
+func (d *Datastore) GetSize(k ds.Key) (int, error) {
+	if v, ok := d.cached(k); ok {
+		return len(v), nil
+	}
+
+	size, err := d.c.head(d.objectName(k))
+	if err == errNotFound {
+		return -1, ds.ErrNotFound
+	}
+	return size, err
+}
+
+// Delete removes k. As S3 doesn't tell whether a deleted object existed, this
+// costs an additional request.
+func (d *Datastore) Delete(k ds.Key) error {
+	has, err := d.Has(k)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return ds.ErrNotFound
+	}
+
+	d.forget(k)
+	return d.c.delete(d.objectName(k))
+}
+
+// Query lists the objects of the datastore. Objects are listed in pages, and
+// their values fetched one by one unless the query is KeysOnly. Prefixes
+// don't narrow the listing when sharding is used.
+func (d *Datastore) Query(q dsq.Query) (dsq.Results, error) {
+	listPrefix := d.prefix()
+	if d.shard == nil && q.Prefix != "" {
+		listPrefix += strings.TrimPrefix(q.Prefix, "/")
+	}
+
+	var (
+		page  []listEntry
+		token string
+		done  bool
+	)
+	next := func() (dsq.Result, bool) {
+		for {
+			for len(page) > 0 {
+				e := page[0]
+				page = page[1:]
+
+				k, ok := d.keyOf(e.Key)
+				if !ok || !strings.HasPrefix(k.String(), q.Prefix) {
+					continue
+				}
+
+				entry := dsq.Entry{Key: k.String()}
+				if !q.KeysOnly {
+					v, err := d.Get(k)
+					if err == ds.ErrNotFound {
+						// deleted since the listing
+						continue
+					}
+					if err != nil {
+						return dsq.Result{Error: err}, true
+					}
+					entry.Value = v
+				}
+				return dsq.Result{Entry: entry}, true
+			}
+
+			if done {
+				return dsq.Result{}, false
+			}
+			var err error
+			page, token, err = d.c.list(listPrefix, token, listPageSize)
+			if err != nil {
+				done = true
+				return dsq.Result{Error: err}, true
+			}
+			done = token == ""
+		}
+	}
+
+	qr := dsq.ResultsFromIterator(q, dsq.Iterator{Next: next})
+	for _, f := range q.Filters {
+		qr = dsq.NaiveFilter(qr, f)
+	}
+	for _, o := range q.Orders {
+		qr = dsq.NaiveOrder(qr, o)
+	}
+	if q.Offset != 0 {
+		qr = dsq.NaiveOffset(qr, q.Offset)
+	}
+	if q.Limit != 0 {
+		qr = dsq.NaiveLimit(qr, q.Limit)
+	}
+	return qr, nil
+}
+
+// Batch returns a batch whose operations are sent concurrently on Commit.
+func (d *Datastore) Batch() (ds.Batch, error) {
+	return &batch{d: d, ops: make(map[ds.Key]*op)}, nil
+}
+
+// Close does nothing, there is nothing to flush.
+func (d *Datastore) Close() error {
+	return nil
+}
+
+type op struct {
+	delete bool
+	value  []byte
+}
+
+type batch struct {
+	d   *Datastore
+	ops map[ds.Key]*op
+}
+
+func (b *batch) Put(k ds.Key, value []byte) error {
+	b.ops[k] = &op{value: value}
+	return nil
+}
+
+func (b *batch) Delete(k ds.Key) error {
+	b.ops[k] = &op{delete: true}
+	return nil
+}
+
+// Commit runs the operations of the batch, and returns the first error. The
+// batch is not atomic: operations which succeeded stay done.
+func (b *batch) Commit() error {
+	type job struct {
+		k ds.Key
+		o *op
+	}
+	jobs := make(chan job)
+	errs := make(chan error, len(b.ops))
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.d.workers && i < len(b.ops); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				var err error
+				if j.o.delete {
+					b.d.forget(j.k)
+					err = b.d.c.delete(b.d.objectName(j.k))
+				} else {
+					err = b.d.Put(j.k, j.o.value)
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	for k, o := range b.ops {
+		jobs <- job{k, o}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	b.ops = make(map[ds.Key]*op)
+	return <-errs
+}